@@ -0,0 +1,180 @@
+package carbonapi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// aggregationRuleLine is a rule of carbon-aggregator's aggregation-rules.conf:
+// output_template (frequency) = method input_pattern
+var aggregationRuleLine = regexp.MustCompile(`^(\S+)\s+\((\d+)\)\s*=\s*(\S+)\s+(\S+)$`)
+
+// aggregationField is a field of an aggregation rule, <name> matching a node
+// and <<name>> any number of them.
+var aggregationField = regexp.MustCompile(`<<?([^<>]+)>>?`)
+
+// aggregationMethods are the functions of the aggregation methods of
+// carbon-aggregator, but the percentiles.
+var aggregationMethods = map[string]string{
+	"sum":   "sumSeries",
+	"avg":   "averageSeries",
+	"min":   "minSeries",
+	"max":   "maxSeries",
+	"count": "countSeries",
+}
+
+var aggregationPercentile = regexp.MustCompile(`^p([0-9]{2,3})$`)
+
+// aggregationRule synthesizes the metrics matching output from those matching
+// input, as carbon-aggregator would have written them.
+type aggregationRule struct {
+	output *regexp.Regexp
+	input  string
+	// function aggregates the series of input, with $1 standing for them.
+	function string
+}
+
+// aggregationRules are the rules of an aggregation-rules.conf of
+// carbon-aggregator, applied when rendering: a metric that no backend has
+// but whose name matches the output of a rule is synthesized from the
+// metrics matching its input.
+type aggregationRules []aggregationRule
+
+// loadAggregationRules reads the rules of an aggregation-rules.conf. No file
+// means no rules.
+func loadAggregationRules(file string) (aggregationRules, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules aggregationRules
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		rule, err := parseAggregationRule(l)
+		if err != nil {
+			return nil, fmt.Errorf("bad aggregation rules file '%s', line %d: %v", file, line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func parseAggregationRule(line string) (aggregationRule, error) {
+	m := aggregationRuleLine.FindStringSubmatch(line)
+	if m == nil {
+		return aggregationRule{}, fmt.Errorf("expected 'output (frequency) = method input'")
+	}
+	output, method, input := m[1], m[3], m[4]
+
+	function, ok := aggregationMethods[method]
+	if ok {
+		function += "($1)"
+	} else if p := aggregationPercentile.FindStringSubmatch(method); p != nil {
+		n := p[1][:2]
+		if len(p[1]) > 2 {
+			n += "." + p[1][2:]
+		}
+		function = "percentileOfSeries($1," + n + ")"
+	} else {
+		return aggregationRule{}, fmt.Errorf("unknown aggregation method '%s'", method)
+	}
+
+	// The fields of the output are captured, to fill those of the input.
+	var re strings.Builder
+	re.WriteString("^")
+	outputFields := make(map[string]bool)
+	last := 0
+	for _, loc := range aggregationField.FindAllStringSubmatchIndex(output, -1) {
+		re.WriteString(regexp.QuoteMeta(output[last:loc[0]]))
+		name := output[loc[2]:loc[3]]
+		if outputFields[name] {
+			return aggregationRule{}, fmt.Errorf("field '%s' appears twice in the output", name)
+		}
+		outputFields[name] = true
+		if strings.HasPrefix(output[loc[0]:], "<<") {
+			re.WriteString("(?P<" + name + ">.+)")
+		} else {
+			re.WriteString("(?P<" + name + ">[^.]+)")
+		}
+		last = loc[1]
+	}
+	re.WriteString(regexp.QuoteMeta(output[last:]) + "$")
+
+	outputRe, err := regexp.Compile(re.String())
+	if err != nil {
+		return aggregationRule{}, fmt.Errorf("bad output '%s': %v", output, err)
+	}
+
+	// The fields of the input not in the output are aggregated over.
+	for _, f := range aggregationField.FindAllStringSubmatch(input, -1) {
+		if !outputFields[f[1]] && strings.HasPrefix(f[0], "<<") {
+			return aggregationRule{}, fmt.Errorf("field '%s' of many nodes is not in the output", f[1])
+		}
+	}
+
+	return aggregationRule{output: outputRe, input: input, function: function}, nil
+}
+
+// lookup returns the expression synthesizing the metric name with the first
+// matching rule, aliased to name.
+func (rs aggregationRules) lookup(name string) (string, bool) {
+	for _, r := range rs {
+		m := r.output.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		fields := make(map[string]string)
+		for i, field := range r.output.SubexpNames() {
+			if field != "" {
+				fields[field] = m[i]
+			}
+		}
+		input := aggregationField.ReplaceAllStringFunc(r.input, func(f string) string {
+			if v, ok := fields[strings.Trim(f, "<>")]; ok {
+				return v
+			}
+			return "*"
+		})
+
+		return "alias(" + strings.Replace(r.function, "$1", input, 1) + ",'" + name + "')", true
+	}
+
+	return "", false
+}
+
+// expandTargets returns targets with the metrics the rules synthesize
+// expanded, but those stored, leaving targets itself unchanged. Globs are
+// left to the backends.
+func (rs aggregationRules) expandTargets(targets []string, stored func(name string) bool) ([]string, error) {
+	if len(rs) == 0 {
+		return targets, nil
+	}
+
+	return expandMetricsTargets(targets, func(name string) (string, bool) {
+		if strings.ContainsAny(name, "*?[{") || stored(name) {
+			return "", false
+		}
+		return rs.lookup(name)
+	})
+}
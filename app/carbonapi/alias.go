@@ -0,0 +1,55 @@
+package carbonapi
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// metricAliases maps virtual metric names to the metric paths or globs they
+// stand for, so that queries can use names that don't depend on how the
+// metrics are stored.
+type metricAliases map[string]string
+
+// loadMetricAliases reads the aliases from a YAML file mapping each virtual
+// name to its metric path. No file means no aliases.
+func loadMetricAliases(file string) (metricAliases, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases metricAliases
+	if err := yaml.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("bad alias file '%s': %v", file, err)
+	}
+
+	for name, path := range aliases {
+		if path == "" {
+			return nil, fmt.Errorf("bad alias file '%s': empty path for alias '%s'", file, name)
+		}
+	}
+
+	return aliases, nil
+}
+
+// expand returns the metric path of an alias, or metric itself if it isn't
+// one.
+func (a metricAliases) expand(metric string) string {
+	if path, ok := a[metric]; ok {
+		return path
+	}
+
+	return metric
+}
+
+// resolveMetric returns the metric query sent to the backends for a metric of
+// a query: its alias expanded, then rewritten.
+func (app *App) resolveMetric(metric string) string {
+	return app.targetRewrites.rewrite(app.aliases.expand(metric))
+}
@@ -13,20 +13,23 @@ import (
 
 	"github.com/bookingcom/carbonapi/cache"
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr"
 	"github.com/bookingcom/carbonapi/expr/functions"
 	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
 	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
 	"github.com/bookingcom/carbonapi/expr/rewrite"
+	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/limiter"
 	"github.com/bookingcom/carbonapi/mstats"
 	"github.com/bookingcom/carbonapi/pathcache"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
 	"github.com/bookingcom/carbonapi/pkg/parser"
 	"github.com/bookingcom/carbonapi/util"
 	realZipper "github.com/bookingcom/carbonapi/zipper"
 	"github.com/facebookgo/grace/gracehttp"
 	"github.com/facebookgo/pidfile"
 	"github.com/gorilla/handlers"
-	"github.com/peterbourgon/g2g"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
@@ -34,6 +37,7 @@ import (
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"unicode"
 )
 
@@ -51,6 +55,53 @@ type App struct {
 	zipper CarbonZipper
 	// Limiter limits concurrent zipper requests
 	limiter limiter.ServerLimiter
+	// Handler limiters limit concurrent requests to each handler
+	findLimiter   limiter.ServerLimiter
+	renderLimiter limiter.ServerLimiter
+	infoLimiter   limiter.ServerLimiter
+	// denyList refuses queries before they reach the zipper
+	denyList denyList
+	// targetRewrites rename the metrics of queries
+	targetRewrites targetRewrites
+	// aliases expand virtual metric names in queries
+	aliases metricAliases
+	// functionAliases expand shorthand functions in render targets
+	functionAliases functionAliases
+	// derived expand the metrics defined in the config in render targets
+	derived derivedMetrics
+	// aggregationRules synthesize the metrics of carbon-aggregator rules in
+	// render targets
+	aggregationRules aggregationRules
+	// functionRules refuse expensive functions in render targets
+	functionRules functionRules
+	// parseCache keeps the expressions of recently rendered targets
+	parseCache *parser.Cache
+	// jsonNullPolicy is how null points are written in JSON responses
+	jsonNullPolicy types.NullPolicy
+	// logLevels change the log levels at runtime
+	logLevels *util.LogLevels
+	// slowRequests counts the slow requests, for verboseLog
+	slowRequests int64
+	// backendPools are the connection pool statistics of the zipper
+	backendPools *bnet.Pools
+	// events proxies the graphite events API, if configured
+	events *eventsProxy
+	// queryCost refuses or queues expensive render requests, if configured
+	queryCost *queryCost
+	// topQueries tracks the most frequent and expensive targets, if
+	// configured
+	topQueries *topQueries
+	// warmUp renders popular render requests into the cache, if configured
+	warmUp *warmUp
+	// loadShedder refuses low priority requests while overloaded, if
+	// configured
+	loadShedder *loadShedder
+	// subscriptions serves live render subscriptions, if configured
+	subscriptions *subscriptions
+	// webSockets serves requests over WebSockets, if configured
+	webSockets *webSockets
+	// graphite sends the statistics to graphite, if configured
+	graphite *util.Graphite
 }
 
 var prometheusMetrics = struct {
@@ -99,6 +150,28 @@ var apiMetrics = struct {
 	LimiterUse    expvar.Func
 	LimiterUseMax expvar.Func
 
+	BlockedQueries *expvar.Int
+	// ExpensiveQueries counts the render requests queued, and
+	// RejectedQueries those refused, for their estimated cost
+	ExpensiveQueries *expvar.Int
+	RejectedQueries  *expvar.Int
+	// InfoDisagreements counts the /info requests with diff=1 whose servers
+	// disagree on the metric
+	InfoDisagreements *expvar.Int
+	// ClientDisconnects counts the requests whose client went away before
+	// they were answered
+	ClientDisconnects *expvar.Int
+	// ShedRequests counts the low priority requests refused while
+	// overloaded
+	ShedRequests *expvar.Int
+	// Panics counts the requests answered with a 500 after their handler
+	// panicked
+	Panics *expvar.Int
+
+	FindLimiterUse   expvar.Func
+	RenderLimiterUse expvar.Func
+	InfoLimiterUse   expvar.Func
+
 	// Despite the names, these only count /render requests
 	RenderRequests        *expvar.Int
 	RequestCacheHits      *expvar.Int
@@ -106,14 +179,27 @@ var apiMetrics = struct {
 	RenderCacheOverheadNS *expvar.Int
 
 	FindRequests        *expvar.Int
+	FindsSkipped        *expvar.Int // Finds skipped for render targets whose path is known.
 	FindCacheHits       *expvar.Int
 	FindCacheMisses     *expvar.Int
 	FindCacheOverheadNS *expvar.Int
+	CacheInvalidations  *expvar.Int // Prefixes invalidated in the find caches.
+
+	CacheWarmUps *expvar.Int // Render requests rendered to warm the cache up.
+
+	Subscriptions      *expvar.Int // Live render subscriptions opened.
+	SubscriptionPushes *expvar.Int // Events with new points pushed to them.
+	WebSockets         *expvar.Int // WebSockets opened.
+	WebSocketRequests  *expvar.Int // Messages of WebSocket clients.
 
 	MemcacheTimeouts expvar.Func
 
 	CacheSize  expvar.Func
 	CacheItems expvar.Func
+
+	ParseCacheHits   expvar.Func
+	ParseCacheMisses expvar.Func
+	ParseCacheItems  expvar.Func
 }{
 	Requests:  expvar.NewInt("requests"),
 	Responses: expvar.NewInt("responses"),
@@ -126,10 +212,27 @@ var apiMetrics = struct {
 	RenderCacheOverheadNS: expvar.NewInt("render_cache_overhead_ns"),
 
 	FindRequests: expvar.NewInt("find_requests"),
+	FindsSkipped: expvar.NewInt("finds_skipped"),
+
+	CacheWarmUps: expvar.NewInt("cache_warm_ups"),
+
+	Subscriptions:      expvar.NewInt("subscriptions"),
+	SubscriptionPushes: expvar.NewInt("subscription_pushes"),
+	WebSockets:         expvar.NewInt("websockets"),
+	WebSocketRequests:  expvar.NewInt("websocket_requests"),
+
+	BlockedQueries:    expvar.NewInt("blocked_queries"),
+	ExpensiveQueries:  expvar.NewInt("expensive_queries"),
+	RejectedQueries:   expvar.NewInt("rejected_queries"),
+	InfoDisagreements: expvar.NewInt("info_disagreements"),
+	ClientDisconnects: expvar.NewInt("client_disconnects"),
+	ShedRequests:      expvar.NewInt("shed_requests"),
+	Panics:            expvar.NewInt("panics"),
 
 	FindCacheHits:       expvar.NewInt("find_cache_hits"),
 	FindCacheMisses:     expvar.NewInt("find_cache_misses"),
 	FindCacheOverheadNS: expvar.NewInt("find_cache_overhead_ns"),
+	CacheInvalidations:  expvar.NewInt("cache_invalidations"),
 }
 
 const (
@@ -145,7 +248,8 @@ var zipperMetrics = struct {
 	InfoRequests *expvar.Int
 	InfoErrors   *expvar.Int
 
-	Timeouts *expvar.Int
+	Timeouts          *expvar.Int
+	ResponsesTooLarge *expvar.Int
 
 	CacheSize  expvar.Func
 	CacheItems expvar.Func
@@ -162,7 +266,8 @@ var zipperMetrics = struct {
 	InfoRequests: expvar.NewInt("zipper_info_requests"),
 	InfoErrors:   expvar.NewInt("zipper_info_errors"),
 
-	Timeouts: expvar.NewInt("zipper_timeouts"),
+	Timeouts:          expvar.NewInt("zipper_timeouts"),
+	ResponsesTooLarge: expvar.NewInt("zipper_responses_too_large"),
 
 	CacheHits:   expvar.NewInt("zipper_cache_hits"),
 	CacheMisses: expvar.NewInt("zipper_cache_misses"),
@@ -170,6 +275,7 @@ var zipperMetrics = struct {
 
 func zipperStats(stats *realZipper.Stats) {
 	zipperMetrics.Timeouts.Add(stats.Timeouts)
+	zipperMetrics.ResponsesTooLarge.Add(stats.ResponsesTooLarge)
 
 	zipperMetrics.FindErrors.Add(stats.FindErrors)
 	zipperMetrics.RenderErrors.Add(stats.RenderErrors)
@@ -190,29 +296,50 @@ func New(api cfg.API, logger *zap.Logger, buildVersion string) (*App, error) {
 	loadBlockRuleHeaderConfig(app, logger)
 	setUpConfigUpstreams(logger, app)
 	zipper := newZipper(zipperStats, api.Zipper, logger.With(zap.String("handler", "zipper")))
+	app.backendPools = zipper.z.Pools()
 	setUpConfig(logger, zipper, app)
 	return app, nil
 }
 
 func (app *App) Start() {
+	logger := zapwriter.Logger("carbonapi")
+
 	handler := initHandlers(app)
-	handler = handlers.CompressHandler(handler)
-	handler = handlers.CORS()(handler)
+	// A panic fails its request only.
+	handler = util.RecoverHandler(handler, logger, func(*http.Request) {
+		apiMetrics.Panics.Add(1)
+	})
+	handler = app.loadShedder.handler(handler)
+	handler = compressHandler(handler)
+	handler = util.CORSHandler(handler, app.config.CORS)
 	handler = handlers.ProxyHeaders(handler)
 	handler = util.UUIDHandler(handler)
 
-	logger := zapwriter.Logger("carbonapi")
+	handler, err := util.AccessHandler(handler, app.config.AccessRules)
+	if err != nil {
+		logger.Fatal("Failed to parse the access rules",
+			zap.Error(err),
+		)
+	}
 	app.registerPrometheusMetrics(logger)
 	if app.config.BlockHeaderUpdatePeriod > 0 {
 		ticker := time.NewTicker(app.config.BlockHeaderUpdatePeriod)
 		go loadTickerBlockRuleHeaderConfig(ticker, logger, app)
 	}
-	err := gracehttp.Serve(&http.Server{
-		Addr:         app.config.Listen,
-		Handler:      handler,
-		ReadTimeout:  1 * time.Second,
-		WriteTimeout: app.config.Timeouts.Global,
-	})
+	if app.warmUp != nil {
+		go app.warmUp.run(app, logger)
+	}
+	if app.loadShedder != nil {
+		go app.loadShedder.run(logger)
+	}
+	server := app.config.Server.HTTPServer(app.config.Listen, handler, app.config.Timeouts.Longest())
+	if app.config.Server.DrainTimeout > 0 {
+		err = util.Serve(server, app.config.Server.DrainTimeout, logger, func() {
+			app.flushGraphite(logger)
+		})
+	} else {
+		err = gracehttp.Serve(server)
+	}
 	if err != nil {
 		logger.Fatal("gracehttp failed",
 			zap.Error(err),
@@ -220,6 +347,19 @@ func (app *App) Start() {
 	}
 }
 
+// flushGraphite sends the last values of the statistics to graphite, if
+// it's configured.
+func (app *App) flushGraphite(logger *zap.Logger) {
+	if app.graphite == nil {
+		return
+	}
+	if err := app.graphite.Flush(); err != nil {
+		logger.Warn("Failed to flush the statistics to graphite",
+			zap.Error(err),
+		)
+	}
+}
+
 func (app *App) registerPrometheusMetrics(logger *zap.Logger) {
 	go func() {
 		prometheus.MustRegister(prometheusMetrics.Requests)
@@ -227,14 +367,21 @@ func (app *App) registerPrometheusMetrics(logger *zap.Logger) {
 		prometheus.MustRegister(prometheusMetrics.DurationsExp)
 		prometheus.MustRegister(prometheusMetrics.DurationsLin)
 
-		writeTimeout := app.config.Timeouts.Global
+		writeTimeout := app.config.Timeouts.Longest()
 		if writeTimeout < 30*time.Second {
 			writeTimeout = time.Minute
 		}
 
+		handler, err := util.AccessHandler(initHandlersInternal(app), app.config.AccessRules)
+		if err != nil {
+			logger.Fatal("Failed to parse the access rules",
+				zap.Error(err),
+			)
+		}
+
 		s := &http.Server{
 			Addr:         app.config.ListenInternal,
-			Handler:      initHandlersInternal(app),
+			Handler:      handler,
 			ReadTimeout:  1 * time.Second,
 			WriteTimeout: writeTimeout,
 		}
@@ -276,6 +423,15 @@ func loadBlockRuleConfig(blockHeaderFile string) ([]byte, error) {
 	return fileData, err
 }
 
+// newHandlerLimiter returns a limiter of l concurrent requests to a handler,
+// or one that doesn't limit if l is zero.
+func newHandlerLimiter(l int) limiter.ServerLimiter {
+	if l <= 0 {
+		return limiter.ServerLimiter{}
+	}
+
+	return limiter.NewServerLimiter([]string{localHostName}, l)
+}
 
 func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 	err := zapwriter.ApplyConfig(app.config.Logger)
@@ -285,6 +441,8 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 			zap.Error(err),
 		)
 	}
+	app.logLevels = util.NewLogLevels(app.config.Logger)
+	app.logLevels.ToggleDebugOn(syscall.SIGUSR2)
 
 	for name, color := range app.config.DefaultColors {
 		if err := png.SetColor(name, color); err != nil {
@@ -295,6 +453,8 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 		}
 	}
 
+	png.SetMaxSize(app.config.MaxGraphSize.Width, app.config.MaxGraphSize.Height)
+
 	rewrite.New(app.config.FunctionsConfigs)
 	functions.New(app.config.FunctionsConfigs)
 
@@ -326,6 +486,129 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 	})
 	expvar.Publish("limiter_use_max", apiMetrics.LimiterUseMax)
 
+	denyList, err := newDenyList(app.config.DenyTargets)
+	if err != nil {
+		logger.Fatal("Failed to parse the target deny list",
+			zap.Error(err),
+		)
+	}
+	app.denyList = denyList
+
+	targetRewrites, err := newTargetRewrites(app.config.TargetRewrites)
+	if err != nil {
+		logger.Fatal("Failed to parse the target rewrite rules",
+			zap.Error(err),
+		)
+	}
+	app.targetRewrites = targetRewrites
+
+	aliases, err := loadMetricAliases(app.config.AliasFile)
+	if err != nil {
+		logger.Fatal("Failed to load the metric aliases",
+			zap.String("file", app.config.AliasFile),
+			zap.Error(err),
+		)
+	}
+	app.aliases = aliases
+
+	functionAliases, err := newFunctionAliases(app.config.FunctionAliases)
+	if err != nil {
+		logger.Fatal("Failed to parse the function aliases",
+			zap.Error(err),
+		)
+	}
+	app.functionAliases = functionAliases
+
+	app.derived, err = newDerivedMetrics(app.config.DerivedMetrics)
+	if err != nil {
+		logger.Fatal("Failed to parse the derived metrics",
+			zap.Error(err),
+		)
+	}
+
+	app.aggregationRules, err = loadAggregationRules(app.config.AggregationRulesFile)
+	if err != nil {
+		logger.Fatal("Failed to load the aggregation rules",
+			zap.Error(err),
+		)
+	}
+
+	app.functionRules = newFunctionRules(app.config.FunctionRules, app.config.Tenants.Header)
+
+	app.jsonNullPolicy, err = types.ParseNullPolicy(app.config.JSONNullPolicy)
+	if err != nil {
+		logger.Fatal("Failed to parse the JSON null policy",
+			zap.Error(err),
+		)
+	}
+
+	app.queryCost = newQueryCost(app.config.QueryCost)
+	app.topQueries = newTopQueries(app.config.TopQueries)
+	app.loadShedder = newLoadShedder(app.config.LoadShedding)
+	// Subscriptions must end before the main listener cuts them off.
+	listener := app.config.Server.HTTPServer(app.config.Listen, nil, app.config.Timeouts.Longest())
+	app.subscriptions = newSubscriptions(app.config.Subscriptions, listener.WriteTimeout)
+	app.webSockets = newWebSockets(app.config.WebSocket)
+
+	app.warmUp, err = newWarmUp(app.config.WarmUp, app.config.Cache.DefaultTimeoutSec)
+	if err != nil {
+		logger.Fatal("Failed to configure the cache warm-up",
+			zap.Error(err),
+		)
+	}
+
+	app.events, err = newEventsProxy(app.config.Events)
+	if err != nil {
+		logger.Fatal("Failed to configure the events proxy",
+			zap.Error(err),
+		)
+	}
+
+	app.findLimiter = newHandlerLimiter(app.config.HandlerConcurrency.Find)
+	app.renderLimiter = newHandlerLimiter(app.config.HandlerConcurrency.Render)
+	app.infoLimiter = newHandlerLimiter(app.config.HandlerConcurrency.Info)
+
+	apiMetrics.FindLimiterUse = expvar.Func(func() interface{} {
+		return app.findLimiter.LimiterUse()[localHostName]
+	})
+	expvar.Publish("find_limiter_use", apiMetrics.FindLimiterUse)
+
+	apiMetrics.RenderLimiterUse = expvar.Func(func() interface{} {
+		return app.renderLimiter.LimiterUse()[localHostName]
+	})
+	expvar.Publish("render_limiter_use", apiMetrics.RenderLimiterUse)
+
+	apiMetrics.InfoLimiterUse = expvar.Func(func() interface{} {
+		return app.infoLimiter.LimiterUse()[localHostName]
+	})
+	expvar.Publish("info_limiter_use", apiMetrics.InfoLimiterUse)
+
+	app.parseCache = parser.NewCache(app.config.ParseCacheSize)
+
+	apiMetrics.ParseCacheHits = expvar.Func(func() interface{} {
+		return app.parseCache.Hits()
+	})
+	expvar.Publish("parse_cache_hits", apiMetrics.ParseCacheHits)
+
+	apiMetrics.ParseCacheMisses = expvar.Func(func() interface{} {
+		return app.parseCache.Misses()
+	})
+	expvar.Publish("parse_cache_misses", apiMetrics.ParseCacheMisses)
+
+	apiMetrics.ParseCacheItems = expvar.Func(func() interface{} {
+		return app.parseCache.Len()
+	})
+	expvar.Publish("parse_cache_items", apiMetrics.ParseCacheItems)
+
+	if app.backendPools != nil {
+		// Pools are keyed by address, so make sure every backend shows up
+		// even before it is first dialed.
+		for _, host := range app.config.Backends {
+			app.backendPools.Get(host)
+		}
+		expvar.Publish("backendPools", expvar.Func(app.backendPools.Snapshot))
+	}
+
 	switch app.config.Cache.Type {
 	case "memcache":
 		if len(app.config.Cache.MemcachedServers) == 0 {
@@ -433,7 +716,7 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 
 	if host != "" {
 		// register our metrics with graphite
-		graphite := g2g.NewGraphite(host, app.config.Graphite.Interval, 10*time.Second)
+		graphite := util.NewGraphite(host, app.config.Graphite.Interval, 10*time.Second)
 
 		hostname, _ := os.Hostname()
 		hostname = strings.Replace(hostname, ".", "_", -1)
@@ -459,9 +742,18 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 		graphite.Register(fmt.Sprintf("%s.request_cache_overhead_ns", pattern), apiMetrics.RenderCacheOverheadNS)
 
 		graphite.Register(fmt.Sprintf("%s.find_requests", pattern), apiMetrics.FindRequests)
+		graphite.Register(fmt.Sprintf("%s.finds_skipped", pattern), apiMetrics.FindsSkipped)
 		graphite.Register(fmt.Sprintf("%s.find_cache_hits", pattern), apiMetrics.FindCacheHits)
 		graphite.Register(fmt.Sprintf("%s.find_cache_misses", pattern), apiMetrics.FindCacheMisses)
 		graphite.Register(fmt.Sprintf("%s.find_cache_overhead_ns", pattern), apiMetrics.FindCacheOverheadNS)
+		graphite.Register(fmt.Sprintf("%s.cache_invalidations", pattern), apiMetrics.CacheInvalidations)
+
+		graphite.Register(fmt.Sprintf("%s.cache_warm_ups", pattern), apiMetrics.CacheWarmUps)
+
+		graphite.Register(fmt.Sprintf("%s.subscriptions", pattern), apiMetrics.Subscriptions)
+		graphite.Register(fmt.Sprintf("%s.subscription_pushes", pattern), apiMetrics.SubscriptionPushes)
+		graphite.Register(fmt.Sprintf("%s.websockets", pattern), apiMetrics.WebSockets)
+		graphite.Register(fmt.Sprintf("%s.websocket_requests", pattern), apiMetrics.WebSocketRequests)
 
 		graphite.Register(fmt.Sprintf("%s.render_requests", pattern), apiMetrics.RenderRequests)
 
@@ -484,6 +776,7 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 		graphite.Register(fmt.Sprintf("%s.zipper.info_errors", pattern), zipperMetrics.InfoErrors)
 
 		graphite.Register(fmt.Sprintf("%s.zipper.timeouts", pattern), zipperMetrics.Timeouts)
+		graphite.Register(fmt.Sprintf("%s.zipper.responses_too_large", pattern), zipperMetrics.ResponsesTooLarge)
 
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_size", pattern), zipperMetrics.CacheSize)
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_items", pattern), zipperMetrics.CacheItems)
@@ -491,17 +784,50 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper, app *App) {
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_hits", pattern), zipperMetrics.CacheHits)
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_misses", pattern), zipperMetrics.CacheMisses)
 
+		if app.backendPools != nil {
+			for _, address := range app.backendPools.Addresses() {
+				name := strings.NewReplacer(".", "_", ":", "_").Replace(address)
+				for stat, v := range app.backendPools.Get(address).Vars() {
+					graphite.Register(fmt.Sprintf("%s.zipper.backends.%s.pool_%s", pattern, name, stat), v)
+				}
+			}
+		}
+
 		go mstats.Start(app.config.Graphite.Interval)
 
 		graphite.Register(fmt.Sprintf("%s.goroutines", pattern), apiMetrics.Goroutines)
 		graphite.Register(fmt.Sprintf("%s.uptime", pattern), apiMetrics.Uptime)
 		graphite.Register(fmt.Sprintf("%s.max_limiter_use", pattern), apiMetrics.LimiterUseMax)
 		graphite.Register(fmt.Sprintf("%s.limiter_use", pattern), apiMetrics.LimiterUse)
+		graphite.Register(fmt.Sprintf("%s.blocked_queries", pattern), apiMetrics.BlockedQueries)
+		graphite.Register(fmt.Sprintf("%s.expensive_queries", pattern), apiMetrics.ExpensiveQueries)
+		graphite.Register(fmt.Sprintf("%s.rejected_queries", pattern), apiMetrics.RejectedQueries)
+		graphite.Register(fmt.Sprintf("%s.info_disagreements", pattern), apiMetrics.InfoDisagreements)
+		graphite.Register(fmt.Sprintf("%s.client_disconnects", pattern), apiMetrics.ClientDisconnects)
+		graphite.Register(fmt.Sprintf("%s.shed_requests", pattern), apiMetrics.ShedRequests)
+		graphite.Register(fmt.Sprintf("%s.panics", pattern), apiMetrics.Panics)
+		graphite.Register(fmt.Sprintf("%s.find_limiter_use", pattern), apiMetrics.FindLimiterUse)
+		graphite.Register(fmt.Sprintf("%s.render_limiter_use", pattern), apiMetrics.RenderLimiterUse)
+		graphite.Register(fmt.Sprintf("%s.info_limiter_use", pattern), apiMetrics.InfoLimiterUse)
+		graphite.Register(fmt.Sprintf("%s.parse_cache_hits", pattern), apiMetrics.ParseCacheHits)
+		graphite.Register(fmt.Sprintf("%s.parse_cache_misses", pattern), apiMetrics.ParseCacheMisses)
+		graphite.Register(fmt.Sprintf("%s.parse_cache_items", pattern), apiMetrics.ParseCacheItems)
 		graphite.Register(fmt.Sprintf("%s.alloc", pattern), &mstats.Alloc)
 		graphite.Register(fmt.Sprintf("%s.total_alloc", pattern), &mstats.TotalAlloc)
 		graphite.Register(fmt.Sprintf("%s.num_gc", pattern), &mstats.NumGC)
 		graphite.Register(fmt.Sprintf("%s.pause_ns", pattern), &mstats.PauseNS)
 
+		// functions.New registered all the functions above, so their metadata is complete.
+		metadata.FunctionMD.RLock()
+		for name := range metadata.FunctionMD.Functions {
+			expr.FunctionCalls.Add(name, 0)
+			expr.FunctionTimeNS.Add(name, 0)
+			graphite.Register(fmt.Sprintf("%s.functions.%s.calls", pattern, name), expr.FunctionCalls.Get(name))
+			graphite.Register(fmt.Sprintf("%s.functions.%s.time_ns", pattern, name), expr.FunctionTimeNS.Get(name))
+		}
+		metadata.FunctionMD.RUnlock()
+
+		app.graphite = graphite
 	}
 
 	if app.config.PidFile != "" {
@@ -528,7 +854,7 @@ func setUpConfigUpstreams(logger *zap.Logger, app *App) {
 	}
 
 	// Setup in-memory path cache for carbonzipper requests
-	app.config.PathCache = pathcache.NewPathCache(app.config.ExpireDelaySec)
+	app.config.PathCache = pathcache.NewPathCache(app.config.ExpireDelaySec, app.config.ExpireJitter, app.config.RefreshAheadSec)
 
 	zipperMetrics.CacheSize = expvar.Func(func() interface{} { return app.config.PathCache.ECSize() })
 	expvar.Publish("cacheSize", zipperMetrics.CacheSize)
@@ -542,11 +868,19 @@ func deferredAccessLogging(r *http.Request, accessLogDetails *carbonapipb.Access
 
 	accessLogDetails.Runtime = time.Since(t).Seconds()
 	accessLogDetails.RequestMethod = r.Method
-	if logAsError {
+	if util.ClientGone(r) {
+		// Neither an error of the backends nor a timeout.
+		accessLogDetails.HttpCode = util.StatusClientClosedRequest
+		accessLogDetails.Reason = "client disconnected"
+		accessLogger.Info("request canceled", zap.Any("data", *accessLogDetails))
+		apiMetrics.ClientDisconnects.Add(1)
+	} else if logAsError {
 		accessLogger.Error("request failed", zap.Any("data", *accessLogDetails))
 		apiMetrics.Errors.Add(1)
 	} else {
-		accessLogDetails.HttpCode = http.StatusOK
+		if accessLogDetails.HttpCode == 0 {
+			accessLogDetails.HttpCode = http.StatusOK
+		}
 		accessLogger.Info("request served", zap.Any("data", *accessLogDetails))
 		apiMetrics.Responses.Add(1)
 	}
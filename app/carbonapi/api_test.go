@@ -1,15 +1,27 @@
 package carbonapi
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"github.com/bookingcom/carbonapi/cache"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/bookingcom/carbonapi/carbonapipb"
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/limiter"
+	"github.com/bookingcom/carbonapi/pkg/parser"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 
 	"github.com/lomik/zapwriter"
@@ -37,13 +49,21 @@ func (z mockCarbonZipper) Info(ctx context.Context, metric string) (map[string]p
 	return response, nil
 }
 
-func (z mockCarbonZipper) Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error) {
+func (z mockCarbonZipper) Render(ctx context.Context, metric string, from, until int32, consolidateBy string) ([]*types.MetricData, error) {
 	var result []*types.MetricData
 	multiFetchResponse := getMultiFetchResponse()
 	result = append(result, &types.MetricData{FetchResponse: multiFetchResponse.Metrics[0]})
 	return result, nil
 }
 
+func (z mockCarbonZipper) Backends(metric string) []string {
+	return []string{"http://127.0.0.1:8080"}
+}
+
+func (z mockCarbonZipper) HasPath(metric string) bool {
+	return false
+}
+
 func getMetricGlobResponse(metric string) pb.GlobResponse {
 
 	globResponses := make(map[string]pb.GlobResponse)
@@ -110,6 +130,7 @@ func setUpTestConfig() (*App) {
 	}
 	app.config.Backends = []string{"http://127.0.0.1:8080"}
 	app.config.ConcurrencyLimitPerServer = 1024
+	app.config.LBCheck = cfg.DefaultConfig.LBCheck
 	setUpConfigUpstreams(logger, &app)
 	setUpConfig(logger, newMockCarbonZipper(), &app)
 	initHandlers(&app)
@@ -144,6 +165,228 @@ func TestRenderHandler(t *testing.T) {
 	}
 }
 
+func TestRenderHandlerNDJSON(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&target=foo.bar&from=-10minutes&format=ndjson&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	line := `{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}` + "\n"
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeNDJSON, rr.Header().Get("Content-Type"))
+	assert.Equal(t, line+line, rr.Body.String())
+	assert.True(t, rr.Flushed)
+}
+
+func TestRenderHandlerCompactJSON(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=compactjson&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	expected := `[{"target":"foo.bar","start":1510913280,"step":60,"gaps":[[0,1]],"delta":true,"values":[1510913759,59]}]`
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+	assert.Equal(t, expected, rr.Body.String())
+}
+
+func TestRenderHandlerArrow(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=arrow&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	body := rr.Body.Bytes()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeArrow, rr.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(body, []byte{0xff, 0xff, 0xff, 0xff}), "Response should be an Arrow IPC stream")
+	assert.True(t, bytes.HasSuffix(body, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}), "Response should end with an end-of-stream marker")
+}
+
+func TestSubscribeHandler(t *testing.T) {
+	subscriptions := testApp.subscriptions
+	defer func() { testApp.subscriptions = subscriptions }()
+	testApp.subscriptions = newSubscriptions(cfg.SubscriptionsConfig{
+		MaxSubscriptions: 1,
+		Interval:         time.Hour,
+		MaxDuration:      time.Millisecond,
+	}, 0)
+
+	req, rr := setUpRequest(t, "/subscribe/?target=foo.bar&from=-10minutes")
+	testApp.subscribeHandler(rr, req)
+
+	expected := "retry: 3600000\n\n" +
+		"id: 1510913400\n" +
+		`data: [{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]` + "\n\n"
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeEventStream, rr.Header().Get("Content-Type"))
+	assert.Equal(t, expected, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/subscribe/?target=foo.bar&from=-10minutes&interval=30s")
+	req.Header.Set("Last-Event-ID", "1510913340")
+	testApp.subscribeHandler(rr, req)
+
+	expected = "retry: 30000\n\n" +
+		"id: 1510913400\n" +
+		`data: [{"target":"foo.bar","datapoints":[[1510913818,1510913400]]}]` + "\n\n"
+	assert.Equal(t, expected, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/subscribe/?target=foo.bar&from=-10minutes")
+	req.Header.Set("Last-Event-ID", "1510913400")
+	testApp.subscribeHandler(rr, req)
+	assert.Equal(t, "retry: 3600000\n\n: keep-alive\n\n", rr.Body.String())
+
+	req, rr = setUpRequest(t, "/subscribe/?from=-10minutes")
+	testApp.subscribeHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	testApp.subscriptions.active = 1
+	req, rr = setUpRequest(t, "/subscribe/?target=foo.bar")
+	testApp.subscribeHandler(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	assert.Nil(t, newSubscriptions(cfg.SubscriptionsConfig{}, 0))
+	s := newSubscriptions(cfg.SubscriptionsConfig{MaxSubscriptions: 1}, 10*time.Second)
+	assert.Equal(t, 9*time.Second, s.config.MaxDuration)
+}
+
+// wsDial opens a WebSocket to the server at u.
+func wsDial(t *testing.T, u string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(u, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	return conn, br
+}
+
+// wsWrite writes a text message masked as clients do, of less than 64KB.
+func wsWrite(conn net.Conn, message string) {
+	b := []byte{0x81}
+	if len(message) < 126 {
+		b = append(b, 0x80|byte(len(message)))
+	} else {
+		b = append(b, 0x80|126, byte(len(message)>>8), byte(len(message)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i := range message {
+		b = append(b, message[i]^mask[i%4])
+	}
+
+	conn.Write(b)
+}
+
+func wsRead(t *testing.T, br *bufio.Reader) wsResponse {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		io.ReadFull(br, b[:])
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		io.ReadFull(br, b[:])
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp wsResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("Invalid response %q: %v", payload, err)
+	}
+
+	return resp
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	webSockets, subscriptions := testApp.webSockets, testApp.subscriptions
+	defer func() { testApp.webSockets, testApp.subscriptions = webSockets, subscriptions }()
+	testApp.webSockets = newWebSockets(cfg.WebSocketConfig{MaxConnections: 1})
+	testApp.subscriptions = newSubscriptions(cfg.SubscriptionsConfig{
+		MaxSubscriptions: 1,
+		Interval:         time.Hour,
+	}, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(testApp.webSocketHandler))
+	defer server.Close()
+	conn, br := wsDial(t, server.URL)
+	defer conn.Close()
+
+	wsWrite(conn, `{"id":"r","type":"render","query":"target=foo.bar&from=-10minutes"}`)
+	resp := wsRead(t, br)
+	assert.Equal(t, "r", resp.ID)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	assert.Equal(t, `[{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`, string(resp.Data))
+
+	wsWrite(conn, `{"id":"f","type":"find","query":"query=foo.bar"}`)
+	resp = wsRead(t, br)
+	expected, _ := findTreejson(getMetricGlobResponse("foo.bar"))
+	assert.Equal(t, "f", resp.ID)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	assert.Equal(t, strings.TrimSpace(string(expected)), string(resp.Data))
+
+	wsWrite(conn, `{"id":"s","type":"subscribe","query":"target=foo.bar&from=-10minutes"}`)
+	resp = wsRead(t, br)
+	assert.Equal(t, "s", resp.ID)
+	assert.Equal(t, `[{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`, string(resp.Data))
+
+	wsWrite(conn, `{"id":"t","type":"subscribe","query":"target=foo.bar"}`)
+	resp = wsRead(t, br)
+	assert.Equal(t, "t", resp.ID)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Status)
+
+	wsWrite(conn, `{"id":"s","type":"unsubscribe"}`)
+	resp = wsRead(t, br)
+	assert.Equal(t, wsResponse{ID: "s", Done: true}, resp)
+
+	wsWrite(conn, `{"id":"x","type":"delete"}`)
+	resp = wsRead(t, br)
+	assert.Equal(t, http.StatusBadRequest, resp.Status)
+
+	// A second WebSocket is over the limit.
+	req, rr := setUpRequest(t, "/ws")
+	testApp.webSocketHandler(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	req, rr = setUpRequest(t, "/ws")
+	req.Header.Set("Origin", "http://evil.example.com")
+	testApp.webSocketHandler(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestRenderHandlerETag(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=fallbackSeries(foo.bar,foo.baz)&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	req, rr = setUpRequest(t, "/render/?target=fallbackSeries(foo.bar,foo.baz)&from=-10minutes&format=json&noCache=1")
+	req.Header.Set("If-None-Match", etag)
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+}
+
 func TestFindHandler(t *testing.T) {
 	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=json")
 	testApp.findHandler(rr, req)
@@ -178,6 +421,20 @@ func TestFindHandlerCompleter(t *testing.T) {
 	}
 }
 
+func TestFindHandlerPickleOptions(t *testing.T) {
+	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=pickle&graphite09compat=1&pickleProtocol=2")
+	testApp.findHandler(rr, req)
+
+	body := rr.Body.String()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, strings.HasPrefix(body, "\x80\x02"), "Response should start with a protocol 2 header")
+	assert.Contains(t, body, "metric_path")
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.bar&format=pickle&pickleProtocol=5")
+	testApp.findHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
 func TestInfoHandler(t *testing.T) {
 	req, rr := setUpRequest(t, "/info/?target=foo.bar&format=json")
 	testApp.infoHandler(rr, req)
@@ -199,3 +456,379 @@ func TestInfoHandler(t *testing.T) {
 		t.Error("Http response should be same.")
 	}
 }
+
+func TestInfoHandlerDiff(t *testing.T) {
+	req, rr := setUpRequest(t, "/info/?target=foo.bar&format=json&diff=1")
+	testApp.infoHandler(rr, req)
+
+	expectedJson, _ := json.Marshal(infoDiff{
+		Info:          getMockInfoResponse(),
+		Disagreements: map[string]map[string]string{},
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, string(expectedJson), rr.Body.String())
+}
+
+func TestDiffInfo(t *testing.T) {
+	data := getMockInfoResponse()
+	drifted := data["http://127.0.0.1:8080"]
+	drifted.AggregationMethod = "Sum"
+	drifted.Retentions = []pb.Retention{{SecondsPerPoint: 60, NumberOfPoints: 43200}, {SecondsPerPoint: 3600, NumberOfPoints: 8760}}
+	data["http://127.0.0.1:8081"] = drifted
+
+	diff := diffInfo(data)
+	assert.Equal(t, map[string]map[string]string{
+		"aggregationMethod": {
+			"http://127.0.0.1:8080": "Average",
+			"http://127.0.0.1:8081": "Sum",
+		},
+		"retentions": {
+			"http://127.0.0.1:8080": "60:43200",
+			"http://127.0.0.1:8081": "60:43200,3600:8760",
+		},
+	}, diff.Disagreements)
+}
+
+func TestInfoHandlerJSONP(t *testing.T) {
+	req, rr := setUpRequest(t, "/info/?target=foo.bar&format=json&jsonp=cb")
+	testApp.infoHandler(rr, req)
+
+	expectedJson, _ := json.Marshal(getMockInfoResponse())
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/javascript", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "cb("+string(expectedJson)+")", rr.Body.String())
+}
+
+func TestInvalidJSONPCallback(t *testing.T) {
+	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=json&jsonp=alert(1)")
+	testApp.findHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestFindHandlerConcurrencyLimit(t *testing.T) {
+	defer func(l limiter.ServerLimiter) { testApp.findLimiter = l }(testApp.findLimiter)
+	testApp.findLimiter = newHandlerLimiter(1)
+
+	// Hold the only slot, so the request times out waiting for one.
+	testApp.findLimiter.Enter(localHostName)
+	defer testApp.findLimiter.Leave(localHostName)
+
+	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=json")
+	testApp.findHandler(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// Renders have their own limit.
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRenderHandlerQueryCost(t *testing.T) {
+	defer func() { testApp.queryCost = nil }()
+	testApp.queryCost = newQueryCost(cfg.QueryCostConfig{RejectAbove: 20})
+
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=sumSeries(foo.b*)&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-1h&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
+
+func TestRenderHandlerExplain(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=sumSeries(foo.b*)&target=foo(&from=1500000000&until=1500000600&explain=true")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var e explanation
+	if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(22), e.Points)
+	if assert.Len(t, e.Targets, 2) {
+		assert.NotEmpty(t, e.Targets[1].Error)
+
+		target := e.Targets[0]
+		assert.Equal(t, "sumSeries", target.Expression.Name)
+		assert.Equal(t, "foo.b*", target.Expression.Args[0].Name)
+		if assert.Len(t, target.Metrics, 1) && assert.Len(t, target.Metrics[0].Series, 2) {
+			serie := target.Metrics[0].Series[1]
+			assert.Equal(t, "foo.bat", serie.Name)
+			assert.Equal(t, []string{"http://127.0.0.1:8080"}, serie.Backends)
+			assert.Equal(t, int64(11), serie.Points)
+		}
+	}
+}
+
+// knownPathsZipper knows the backends of every path, and counts its finds.
+type knownPathsZipper struct {
+	mockCarbonZipper
+	finds *int
+}
+
+func (z knownPathsZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	*z.finds++
+	return z.mockCarbonZipper.Find(ctx, metric)
+}
+
+func (z knownPathsZipper) HasPath(metric string) bool {
+	return true
+}
+
+func TestGetRenderRequestsSkipsFind(t *testing.T) {
+	finds := 0
+	zipper := testApp.zipper
+	testApp.zipper = knownPathsZipper{finds: &finds}
+	defer func() { testApp.zipper = zipper }()
+
+	var details carbonapipb.AccessLogDetails
+	series, err := getRenderRequests(context.Background(), parser.MetricRequest{Metric: "foo.bar"}, false, &details, testApp)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"foo.bar"}, series)
+		assert.Equal(t, 0, finds)
+	}
+
+	series, err = getRenderRequests(context.Background(), parser.MetricRequest{Metric: "foo.b*"}, false, &details, testApp)
+	if assert.NoError(t, err) {
+		assert.Len(t, series, 2)
+		assert.Equal(t, 1, finds)
+	}
+}
+
+// rangesZipper records the time ranges of its renders.
+type rangesZipper struct {
+	mockCarbonZipper
+	mu     *sync.Mutex
+	ranges *[][2]int32
+}
+
+func (z rangesZipper) Render(ctx context.Context, metric string, from, until int32, consolidateBy string) ([]*types.MetricData, error) {
+	z.mu.Lock()
+	*z.ranges = append(*z.ranges, [2]int32{from, until})
+	z.mu.Unlock()
+	return z.mockCarbonZipper.Render(ctx, metric, from, until, consolidateBy)
+}
+
+// consolidateByZipper records the consolidation functions of its renders.
+type consolidateByZipper struct {
+	mockCarbonZipper
+	mu             *sync.Mutex
+	consolidations *[]string
+}
+
+func (z consolidateByZipper) Render(ctx context.Context, metric string, from, until int32, consolidateBy string) ([]*types.MetricData, error) {
+	z.mu.Lock()
+	*z.consolidations = append(*z.consolidations, consolidateBy)
+	z.mu.Unlock()
+	return z.mockCarbonZipper.Render(ctx, metric, from, until, consolidateBy)
+}
+
+func TestRenderHandlerConsolidateBy(t *testing.T) {
+	zipper := testApp.zipper
+	defer func() { testApp.zipper = zipper }()
+
+	for target, expected := range map[string]string{
+		"consolidateBy(foo.bar,'sum')":            "sum",
+		"sumSeries(consolidateBy(foo.bar,'max'))": "max",
+		"consolidateBy(foo.bar,'median')":         "",
+		"foo.bar":                                 "",
+	} {
+		var consolidations []string
+		testApp.zipper = consolidateByZipper{mu: &sync.Mutex{}, consolidations: &consolidations}
+
+		req, rr := setUpRequest(t, "/render/?target="+url.QueryEscape(target)+"&from=1510913280&until=1510913880&format=json&noCache=1")
+		testApp.renderHandler(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Equal(t, []string{expected}, consolidations, target)
+	}
+}
+
+func TestRenderHandlerPrimingFetch(t *testing.T) {
+	var ranges [][2]int32
+	zipper := testApp.zipper
+	testApp.zipper = rangesZipper{mu: &sync.Mutex{}, ranges: &ranges}
+	defer func() { testApp.zipper = zipper }()
+
+	req, rr := setUpRequest(t, "/render/?target=exponentialMovingAverage(foo.bar,5)&from=1510913280&until=1510913880&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// The series has a 60s step, so it is fetched again 5 minutes earlier.
+	assert.Equal(t, [][2]int32{{1510913280, 1510913880}, {1510913280 - 300, 1510913880}}, ranges)
+}
+
+func TestQueryCostPoints(t *testing.T) {
+	c := newQueryCost(cfg.QueryCostConfig{QueueAbove: 1, DefaultStep: time.Minute})
+	c.learnSteps([]*types.MetricData{
+		{FetchResponse: pb.FetchResponse{Name: "foo.bar", StepTime: 10}},
+	})
+
+	assert.Equal(t, int64(61+361), c.points([]string{"foo.baz", "foo.bar"}, 0, 3600))
+	assert.Equal(t, int64(0), c.points([]string{"foo.bar"}, 3600, 3600))
+}
+
+func TestTopQueries(t *testing.T) {
+	tq := newTopQueries(cfg.TopQueriesConfig{Capacity: 2, Window: time.Hour})
+	now := time.Unix(1500000000, 0)
+	tq.now = func() time.Time { return now }
+
+	for _, d := range []carbonapipb.AccessLogDetails{
+		{Handler: "render", Targets: []string{"a"}, Metrics: []string{"a"}, Runtime: 1},
+		{Handler: "render", Targets: []string{"a", "sum(b)"}, Metrics: []string{"a", "b"}, Runtime: 10},
+		{Handler: "render", Targets: []string{"sum(b)"}, Metrics: []string{"b"}, Runtime: 10},
+		{Handler: "render", Targets: []string{"a"}, Metrics: []string{"a"}, Runtime: 1},
+		{Handler: "find", Targets: []string{"c"}},
+	} {
+		tq.record(&d)
+	}
+
+	top := tq.top(1)
+	assert.Equal(t, []topEntry{{Name: "a", Value: 3}}, top.FrequentTargets)
+	assert.Equal(t, []topEntry{{Name: "sum(b)", Value: 15}}, top.ExpensiveTargets)
+	assert.Equal(t, []topEntry{{Name: "a", Value: 3}}, top.HotMetrics)
+
+	// The counts of the previous window are kept for another window.
+	now = now.Add(90 * time.Minute)
+	tq.record(&carbonapipb.AccessLogDetails{Handler: "render", Targets: []string{"sum(b)"}, Runtime: 1})
+	assert.Equal(t, []topEntry{{Name: "a", Value: 3}, {Name: "sum(b)", Value: 3}}, tq.top(2).FrequentTargets)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, []topEntry{{Name: "sum(b)", Value: 1}}, tq.top(2).FrequentTargets)
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(2)
+	s.add("a", 5)
+	s.add("b", 1)
+	s.add("c", 2)
+
+	assert.Equal(t, []topEntry{{Name: "a", Value: 5}, {Name: "c", Value: 3, Error: 1}}, topOf(3, s))
+}
+
+func TestNormalizeRenderForm(t *testing.T) {
+	form := url.Values{
+		"target": {"sumSeries( a.b , c.d )", "alias(a.b, 'x  y')"},
+		"from":   {"1500000007"},
+		"until":  {"now"},
+		"now":    {"-1h"},
+	}
+	normalizeRenderForm(form, cfg.CacheConfig{KeyGrid: 10 * time.Second, NormalizeTargets: true})
+
+	assert.Equal(t, url.Values{
+		"target": {"sumSeries(a.b,c.d)", "alias(a.b,'x  y')"},
+		"from":   {"1500000000"},
+		"until":  {"now"},
+		"now":    {"-1h"},
+	}, form)
+
+	form = url.Values{"target": {"sumSeries( a.b )"}, "from": {"1500000007"}}
+	normalizeRenderForm(form, cfg.CacheConfig{})
+	assert.Equal(t, url.Values{"target": {"sumSeries( a.b )"}, "from": {"1500000007"}}, form)
+}
+
+func TestLoadShedder(t *testing.T) {
+	s := newLoadShedder(cfg.LoadSheddingConfig{
+		MaxGoroutines:    1000000,
+		RetryAfter:       5 * time.Second,
+		LowPriorityPaths: []string{"/metrics/find"},
+		PriorityHeader:   "X-Priority",
+	})
+	h := s.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(url, priority string) *httptest.ResponseRecorder {
+		req, rr := setUpRequest(t, url)
+		req.Header.Set("X-Priority", priority)
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/metrics/find/?query=foo", "").Code)
+
+	assert.True(t, s.setOverloaded(true))
+	rr := serve("/metrics/find/?query=foo", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/render/?target=foo", "low").Code)
+	assert.Equal(t, http.StatusOK, serve("/render/?target=foo", "").Code)
+
+	assert.True(t, s.setOverloaded(false))
+	assert.Equal(t, http.StatusOK, serve("/render/?target=foo", "low").Code)
+
+	assert.Nil(t, newLoadShedder(cfg.LoadSheddingConfig{}))
+}
+
+func TestWarmUp(t *testing.T) {
+	queryCache := testApp.queryCache
+	testApp.queryCache = cache.NewExpireCache(1000)
+	defer func() { testApp.queryCache = queryCache }()
+
+	wu, err := newWarmUp(cfg.WarmUpConfig{
+		Queries: []string{"target=foo.bar&from=-10minutes&format=json&noCache=1&cacheTimeout=30"},
+		Learn:   1,
+		Ahead:   10 * time.Second,
+	}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+	wu.now = func() time.Time { return now }
+
+	key := "cacheTimeout=30&format=json&from=-10minutes&target=foo.bar"
+	wu.warm(testApp, zapwriter.Logger("test"))
+	_, err = testApp.queryCache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(20*time.Second), wu.due[key])
+
+	// Warm-up requests aren't learned, others are.
+	wu.seen(context.WithValue(context.Background(), warmUpKey{}, true), "target=a")
+	assert.Equal(t, []string{key}, wu.keys())
+	wu.seen(context.Background(), "target=b")
+	assert.Equal(t, []string{key, "target=b"}, wu.keys())
+
+	_, err = newWarmUp(cfg.WarmUpConfig{Queries: []string{"target=%"}}, 60)
+	assert.Error(t, err)
+
+	wu, err = newWarmUp(cfg.WarmUpConfig{}, 60)
+	assert.NoError(t, err)
+	assert.Nil(t, wu)
+}
+
+func TestFunctionsHandler(t *testing.T) {
+	req, rr := setUpRequest(t, "/functions/")
+	testApp.functionsHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var all map[string]types.FunctionDescription
+	if err := json.Unmarshal(rr.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, all, "sumSeries")
+
+	req, rr = setUpRequest(t, "/functions/sumSeries/")
+	testApp.functionsHandler(rr, req)
+
+	var one types.FunctionDescription
+	if err := json.Unmarshal(rr.Body.Bytes(), &one); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "sumSeries", one.Name)
+	assert.NotEmpty(t, one.Params)
+
+	req, rr = setUpRequest(t, "/functions/noSuchFunction")
+	testApp.functionsHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req, rr = setUpRequest(t, "/functions/sumSeries?jsonp=cb")
+	testApp.functionsHandler(rr, req)
+	assert.Equal(t, "text/javascript", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "cb("))
+}
@@ -0,0 +1,55 @@
+package carbonapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// audit records who queried which targets over what time range to the
+// "audit" logger, if enabled.
+func (app *App) audit(r *http.Request, details *carbonapipb.AccessLogDetails) {
+	c := app.config.Audit
+	if !c.Enabled || len(details.Targets) == 0 {
+		return
+	}
+	if c.SampleRate < 1 && rand.Float64() >= c.SampleRate {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("handler", details.Handler),
+		zap.String("carbonapi_uuid", details.CarbonapiUuid),
+		zap.String("username", details.Username),
+		zap.String("peer_ip", details.PeerIp),
+		zap.Strings("targets", details.Targets),
+		zap.Int32("http_code", details.HttpCode),
+	}
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		fields = append(fields, zap.String("forwarded_for", forwardedFor))
+	}
+	if c.APIKeyHeader != "" {
+		if key := r.Header.Get(c.APIKeyHeader); key != "" {
+			fields = append(fields, zap.String("api_key", apiKeyFingerprint(key)))
+		}
+	}
+	if details.From != 0 || details.Until != 0 {
+		fields = append(fields,
+			zap.Int32("from", details.From),
+			zap.Int32("until", details.Until),
+		)
+	}
+
+	zapwriter.Logger("audit").Info("query", fields...)
+}
+
+// apiKeyFingerprint identifies an API key in logs without disclosing it.
+func apiKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,73 @@
+package carbonapi
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+// normalizeRenderForm rewrites the parameters of a render request so that
+// requests differing only by the drift of their time range, or by the
+// whitespace of their targets, share a response cache key, as configured.
+// The parameters are changed before rendering, so that the cached responses
+// are those of the normalized requests.
+func normalizeRenderForm(form url.Values, config cfg.CacheConfig) {
+	if grid := int64(config.KeyGrid / time.Second); grid > 0 {
+		for _, p := range []string{"from", "until", "now"} {
+			form[p] = roundEpochs(form[p], grid)
+		}
+	}
+
+	if config.NormalizeTargets {
+		for i, target := range form["target"] {
+			form["target"][i] = stripSpaces(target)
+		}
+	}
+}
+
+// roundEpochs rounds the values which are absolute times in seconds since
+// the epoch down to a multiple of grid. Relative and formatted times are
+// kept as they are.
+func roundEpochs(values []string, grid int64) []string {
+	for i, v := range values {
+		epoch, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || epoch <= 0 {
+			continue
+		}
+		values[i] = strconv.FormatInt(epoch-epoch%grid, 10)
+	}
+
+	return values
+}
+
+// stripSpaces removes the whitespace of target outside of quoted strings.
+func stripSpaces(target string) string {
+	var b strings.Builder
+	b.Grow(len(target))
+
+	var quote rune
+	escaped := false
+	for _, c := range target {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case unicode.IsSpace(c):
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
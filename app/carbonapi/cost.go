@@ -0,0 +1,122 @@
+package carbonapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/limiter"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+
+	"github.com/dgryski/go-expirecache"
+)
+
+// queryStepsSize is the number of series whose steps are remembered, and
+// queryStepsExpiry how long for, in seconds.
+const (
+	queryStepsSize   = 1000000
+	queryStepsExpiry = int32(24 * time.Hour / time.Second)
+)
+
+// queryCost estimates the number of points a render request fetches before
+// it is sent to the zipper, so that expensive ones are refused or queued
+// instead of being found out after they are fetched.
+type queryCost struct {
+	config cfg.QueryCostConfig
+	// steps are the steps of the series fetched lately, by name
+	steps *expirecache.Cache
+	// expensive limits the concurrent queries over config.QueueAbove
+	expensive limiter.ServerLimiter
+}
+
+// newQueryCost returns nil if neither queueing nor rejecting is configured.
+func newQueryCost(config cfg.QueryCostConfig) *queryCost {
+	if config.QueueAbove <= 0 && config.RejectAbove <= 0 {
+		return nil
+	}
+	if config.DefaultStep < time.Second {
+		config.DefaultStep = time.Minute
+	}
+	if config.QueueConcurrency <= 0 {
+		config.QueueConcurrency = 1
+	}
+
+	return &queryCost{
+		config:    config,
+		steps:     expirecache.New(queryStepsSize),
+		expensive: newHandlerLimiter(config.QueueConcurrency),
+	}
+}
+
+// learnSteps remembers the steps of fetched series. A nil queryCost does
+// nothing.
+func (c *queryCost) learnSteps(metrics []*types.MetricData) {
+	if c == nil {
+		return
+	}
+
+	for _, m := range metrics {
+		if m.StepTime > 0 {
+			c.steps.Set(m.Name, m.StepTime, 1, queryStepsExpiry)
+		}
+	}
+}
+
+// points returns the estimated number of points of the series from from to
+// until, using DefaultStep for the series never fetched, or for all of them
+// without steps.
+func (c *queryCost) points(series []string, from, until int32) int64 {
+	if until <= from {
+		return 0
+	}
+
+	var points int64
+	for _, s := range series {
+		step := int32(c.config.DefaultStep / time.Second)
+		if c.steps != nil {
+			if v, ok := c.steps.Get(s); ok {
+				step = v.(int32)
+			}
+		}
+		points += int64((until-from)/step) + 1
+	}
+
+	return points
+}
+
+// estimateCost resolves the metrics of targets to series, and returns the
+// number of points fetching them would return, with the series of each
+// metric request so that they aren't resolved again. Globs sent as they are
+// to the zipper count as a single series, so the estimate is a lower bound
+// with sendGlobsAsIs. Targets that can't be parsed or resolved are left for
+// the render handler to report.
+func (app *App) estimateCost(ctx context.Context, targets []string, from, until int32, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails) (int64, map[parser.MetricRequest][]string) {
+	var cost int64
+	resolved := make(map[parser.MetricRequest][]string)
+	for _, target := range targets {
+		exp, e, err := app.parseCache.ParseExpr(target)
+		if err != nil || e != "" {
+			continue
+		}
+
+		for _, m := range exp.Metrics() {
+			mfetch := m
+			mfetch.From += from
+			mfetch.Until += until
+			if _, ok := resolved[mfetch]; ok {
+				continue
+			}
+
+			series, err := getRenderRequests(ctx, m, useCache, accessLogDetails, app)
+			if err != nil {
+				continue
+			}
+			resolved[mfetch] = series
+			cost += app.queryCost.points(series, mfetch.From, mfetch.Until)
+		}
+	}
+
+	return cost, resolved
+}
@@ -0,0 +1,197 @@
+package carbonapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/glob"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// maxDerivedMetricDepth bounds how many times derived metrics are expanded
+// in a target, as a derived metric may be defined over others.
+const maxDerivedMetricDepth = 10
+
+// derivedMetrics maps the names of metrics defined in the config to the
+// expressions over real metrics they stand for, e.g. site.requests.total:
+// "sumSeries(dc*.requests)". They are found and rendered as if they were
+// stored.
+type derivedMetrics map[string]string
+
+func newDerivedMetrics(defs map[string]string) (derivedMetrics, error) {
+	derived := make(derivedMetrics, len(defs))
+	for name, def := range defs {
+		if name == "" || strings.ContainsAny(name, "*?[]{}(),'\" ") {
+			return nil, fmt.Errorf("bad derived metric name '%s'", name)
+		}
+		_, rest, err := parser.ParseExpr(def)
+		if err != nil {
+			return nil, fmt.Errorf("bad derived metric '%s': %v", name, err)
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("bad derived metric '%s': could not parse '%s'", name, rest)
+		}
+		derived[name] = def
+	}
+
+	return derived, nil
+}
+
+// lookup returns the expression of the derived metric name, aliased to it.
+func (d derivedMetrics) lookup(name string) (string, bool) {
+	def, ok := d[name]
+	if !ok {
+		return "", false
+	}
+
+	return "alias(" + def + ",'" + name + "')", true
+}
+
+// expandTargets returns targets with the derived metrics expanded, leaving
+// targets itself unchanged.
+func (d derivedMetrics) expandTargets(targets []string) ([]string, error) {
+	if len(d) == 0 {
+		return targets, nil
+	}
+
+	return expandMetricsTargets(targets, d.lookup)
+}
+
+// expandTarget returns target with the derived metrics replaced by their
+// definition, aliased to their name.
+func (d derivedMetrics) expandTarget(target string) (string, error) {
+	return expandMetrics(target, d.lookup)
+}
+
+// expandMetricsTargets returns targets with the metrics lookup knows
+// expanded, leaving targets itself unchanged.
+func expandMetricsTargets(targets []string, lookup func(name string) (string, bool)) ([]string, error) {
+	expanded := make([]string, 0, len(targets))
+	for _, target := range targets {
+		t, err := expandMetrics(target, lookup)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, t)
+	}
+
+	return expanded, nil
+}
+
+// expandMetrics returns target with the metrics lookup knows replaced by the
+// expressions it returns for them, which may have such metrics too. Targets
+// that don't parse are returned unchanged, for the caller to report.
+func expandMetrics(target string, lookup func(name string) (string, bool)) (string, error) {
+	for depth := 0; ; depth++ {
+		exp, rest, err := parser.ParseExpr(target)
+		if err != nil || rest != "" {
+			return target, nil
+		}
+
+		expanded, found := expandNames(exp, lookup)
+		if !found {
+			return target, nil
+		}
+		if depth == maxDerivedMetricDepth {
+			return "", fmt.Errorf("metrics expanded too deeply in %s", target)
+		}
+
+		target = expanded
+	}
+}
+
+// expandNames returns e as a string with the metrics lookup knows replaced,
+// and whether there were any. Expressions without such metrics keep their
+// original form, as series are named after it.
+func expandNames(e parser.Expr, lookup func(name string) (string, bool)) (string, bool) {
+	if e.IsName() {
+		if expanded, ok := lookup(e.Target()); ok {
+			return expanded, true
+		}
+		return e.ToString(), false
+	}
+	if !e.IsFunc() {
+		return e.ToString(), false
+	}
+
+	var found bool
+	args := make([]string, 0, len(e.Args()))
+	for _, arg := range e.Args() {
+		s, ok := expandNames(arg, lookup)
+		found = found || ok
+		args = append(args, s)
+	}
+	if !found {
+		return e.ToString(), false
+	}
+
+	return callString(e, args), true
+}
+
+// find returns the derived metrics matching the glob query, and the nodes
+// leading to them, as the backends answer find requests.
+func (d derivedMetrics) find(query string) []pb.GlobMatch {
+	if len(d) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	patterns := strings.Split(query, ".")
+	var matches []pb.GlobMatch
+	seen := make(map[pb.GlobMatch]bool)
+	for _, name := range names {
+		nodes := strings.Split(name, ".")
+		if len(nodes) < len(patterns) {
+			continue
+		}
+
+		matched := true
+		for i, p := range patterns {
+			if !glob.MatchNode(p, nodes[i]) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		m := pb.GlobMatch{
+			Path:   strings.Join(nodes[:len(patterns)], "."),
+			IsLeaf: len(nodes) == len(patterns),
+		}
+		if !seen[m] {
+			seen[m] = true
+			matches = append(matches, m)
+		}
+	}
+
+	return matches
+}
+
+// addMatches adds the matches of derived metrics to globs, but those the
+// backends have already.
+func addMatches(globs pb.GlobResponse, derived []pb.GlobMatch) pb.GlobResponse {
+	if len(derived) == 0 {
+		return globs
+	}
+
+	seen := make(map[pb.GlobMatch]bool, len(globs.Matches))
+	for _, m := range globs.Matches {
+		seen[m] = true
+	}
+	for _, m := range derived {
+		if !seen[m] {
+			globs.Matches = append(globs.Matches, m)
+		}
+	}
+
+	return globs
+}
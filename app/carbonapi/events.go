@@ -0,0 +1,94 @@
+package carbonapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+	"github.com/pkg/errors"
+)
+
+// eventsProxy forwards the graphite events API to an events store.
+type eventsProxy struct {
+	director    func(*http.Request)
+	transport   http.RoundTripper
+	timeout     time.Duration
+	allowWrites bool
+}
+
+// newEventsProxy returns the proxy to the events store of config, or nil if
+// there is none.
+func newEventsProxy(config cfg.EventsConfig) (*eventsProxy, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("events store URL '%s' has no scheme or host", config.URL)
+	}
+
+	return &eventsProxy{
+		director:    httputil.NewSingleHostReverseProxy(u).Director,
+		transport:   http.DefaultTransport,
+		timeout:     config.Timeout,
+		allowWrites: config.AllowWrites,
+	}, nil
+}
+
+// eventsHandler proxies event reads, and writes if allowed, to the events
+// store.
+func (app *App) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "events", &app.config)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+		app.verboseLog(w, r, &accessLogDetails)
+	}()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !app.events.allowWrites {
+		util.HTTPError(w, r, "event writes are not allowed", http.StatusMethodNotAllowed)
+		accessLogDetails.HttpCode = http.StatusMethodNotAllowed
+		accessLogDetails.Reason = "event writes are not allowed"
+		logAsError = true
+		return
+	}
+
+	ctx := r.Context()
+	if app.events.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, app.events.timeout)
+		defer cancel()
+	}
+
+	proxy := httputil.ReverseProxy{
+		Director:  app.events.director,
+		Transport: app.events.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			accessLogDetails.HttpCode = int32(resp.StatusCode)
+			logAsError = resp.StatusCode >= 500
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			util.HTTPError(w, r, "events store failed: "+err.Error(), http.StatusBadGateway)
+			accessLogDetails.HttpCode = http.StatusBadGateway
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,135 @@
+package carbonapi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// explanation is the plan of a render request, returned instead of its
+// result with explain=true: what its targets parse to, the series their
+// metrics resolve to, the backends those are fetched from, and the estimated
+// number of points. Nothing is fetched, so it tells why a request is slow or
+// empty without making it.
+type explanation struct {
+	From    int32             `json:"from"`
+	Until   int32             `json:"until"`
+	Targets []explainedTarget `json:"targets"`
+	Points  int64             `json:"points"`
+}
+
+type explainedTarget struct {
+	Target     string            `json:"target"`
+	Error      string            `json:"error,omitempty"`
+	Expression *explainedExpr    `json:"expression,omitempty"`
+	Metrics    []explainedMetric `json:"metrics,omitempty"`
+}
+
+// explainedExpr is a node of the expression tree of a target.
+type explainedExpr struct {
+	Type      string                    `json:"type"`
+	Name      string                    `json:"name,omitempty"`
+	Value     interface{}               `json:"value,omitempty"`
+	Args      []*explainedExpr          `json:"args,omitempty"`
+	NamedArgs map[string]*explainedExpr `json:"namedArgs,omitempty"`
+}
+
+type explainedMetric struct {
+	Metric string `json:"metric"`
+	From   int32  `json:"from"`
+	Until  int32  `json:"until"`
+	// Glob is set if the metric is sent as a glob to the zipper, which
+	// resolves it.
+	Glob   bool             `json:"glob,omitempty"`
+	Error  string           `json:"error,omitempty"`
+	Series []explainedSerie `json:"series"`
+}
+
+type explainedSerie struct {
+	Name     string   `json:"name"`
+	Backends []string `json:"backends"`
+	Points   int64    `json:"points"`
+}
+
+// explain returns the plan of rendering targets from from to until.
+func (app *App) explain(ctx context.Context, targets []string, from, until int32, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails) explanation {
+	cost := app.queryCost
+	if cost == nil {
+		cost = &queryCost{config: cfg.DefaultAPIConfig.QueryCost}
+	}
+
+	e := explanation{
+		From:    from,
+		Until:   until,
+		Targets: make([]explainedTarget, 0, len(targets)),
+	}
+	for _, target := range targets {
+		t := explainedTarget{Target: target}
+
+		exp, rest, err := app.parseCache.ParseExpr(target)
+		if err != nil || rest != "" {
+			t.Error = buildParseErrorString(target, rest, err)
+			e.Targets = append(e.Targets, t)
+			continue
+		}
+		t.Expression = explainExpr(exp)
+
+		for _, m := range exp.Metrics() {
+			em := explainedMetric{
+				Metric: m.Metric,
+				From:   m.From + from,
+				Until:  m.Until + until,
+				Series: []explainedSerie{},
+			}
+
+			series, err := getRenderRequests(ctx, m, useCache, accessLogDetails, app)
+			if err != nil {
+				em.Error = err.Error()
+			}
+			// Resolved series have no wildcards.
+			em.Glob = len(series) == 1 && strings.ContainsAny(series[0], "*?[{")
+			for _, s := range series {
+				points := cost.points([]string{s}, em.From, em.Until)
+				em.Series = append(em.Series, explainedSerie{
+					Name:     s,
+					Backends: app.zipper.Backends(s),
+					Points:   points,
+				})
+				e.Points += points
+			}
+
+			t.Metrics = append(t.Metrics, em)
+		}
+
+		e.Targets = append(e.Targets, t)
+	}
+
+	return e
+}
+
+func explainExpr(exp parser.Expr) *explainedExpr {
+	switch exp.Type() {
+	case parser.EtName:
+		return &explainedExpr{Type: "metric", Name: exp.Target()}
+	case parser.EtConst:
+		return &explainedExpr{Type: "const", Value: exp.FloatValue()}
+	case parser.EtString:
+		return &explainedExpr{Type: "string", Value: exp.StringValue()}
+	}
+
+	e := &explainedExpr{Type: "function", Name: exp.Target()}
+	for _, arg := range exp.Args() {
+		e.Args = append(e.Args, explainExpr(arg))
+	}
+	if named := exp.NamedArgs(); len(named) > 0 {
+		e.NamedArgs = make(map[string]*explainedExpr, len(named))
+		for k, arg := range named {
+			e.NamedArgs[k] = explainExpr(arg)
+		}
+	}
+
+	return e
+}
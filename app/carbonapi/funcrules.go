@@ -0,0 +1,117 @@
+package carbonapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// functionRule refuses calls to functions, following a cfg.FunctionRule.
+type functionRule struct {
+	allow    map[string]bool
+	deny     map[string]bool
+	maxRange map[string]int32
+}
+
+func newFunctionRule(c cfg.FunctionRule) functionRule {
+	rule := functionRule{
+		deny:     make(map[string]bool, len(c.Deny)),
+		maxRange: make(map[string]int32, len(c.MaxRange)),
+	}
+	if len(c.Allow) > 0 {
+		rule.allow = make(map[string]bool, len(c.Allow))
+		for _, f := range c.Allow {
+			rule.allow[f] = true
+		}
+	}
+	for _, f := range c.Deny {
+		rule.deny[f] = true
+	}
+	for f, d := range c.MaxRange {
+		rule.maxRange[f] = int32(d / time.Second)
+	}
+
+	return rule
+}
+
+// check fails if e, or any expression in its arguments, calls a function
+// refused by rule when evaluated between from and until.
+func (rule functionRule) check(e parser.Expr, from, until int32) error {
+	if !e.IsFunc() {
+		return nil
+	}
+
+	name := e.Target()
+	if rule.deny[name] || (rule.allow != nil && !rule.allow[name]) {
+		return fmt.Errorf("function %s is not allowed", name)
+	}
+
+	if maxRange, ok := rule.maxRange[name]; ok {
+		for _, m := range e.Metrics() {
+			if (until+m.Until)-(from+m.From) > maxRange {
+				return fmt.Errorf("function %s is not allowed over more than %s",
+					name, time.Duration(maxRange)*time.Second)
+			}
+		}
+	}
+
+	for _, arg := range e.Args() {
+		if err := rule.check(arg, from, until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// functionRules refuses render targets calling functions that are denied to
+// all requests, or to the tenant of the request.
+type functionRules struct {
+	global functionRule
+	// header names the tenant of requests
+	header  string
+	tenants map[string]functionRule
+}
+
+func newFunctionRules(c cfg.FunctionRules, header string) functionRules {
+	rules := functionRules{
+		global:  newFunctionRule(c.FunctionRule),
+		header:  header,
+		tenants: make(map[string]functionRule, len(c.Tenants)),
+	}
+	for tenant, rule := range c.Tenants {
+		rules.tenants[tenant] = newFunctionRule(rule)
+	}
+
+	return rules
+}
+
+// check returns the error of the first function of targets refused to r when
+// evaluated between from and until. Targets that don't parse are left for
+// the caller to report.
+func (fr functionRules) check(r *http.Request, targets []string, from, until int32) error {
+	rules := []functionRule{fr.global}
+	if fr.header != "" {
+		if rule, ok := fr.tenants[r.Header.Get(fr.header)]; ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	for _, target := range targets {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			continue
+		}
+
+		for _, rule := range rules {
+			if err := rule.check(exp, from, until); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -6,22 +6,31 @@ import (
 	"encoding/json"
 	"expvar"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/date"
 	"github.com/bookingcom/carbonapi/expr"
 	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/intervalset"
+	"github.com/bookingcom/carbonapi/pkg/glob"
 	"github.com/bookingcom/carbonapi/pkg/parser"
+	pkgtypes "github.com/bookingcom/carbonapi/pkg/types"
+	encjson "github.com/bookingcom/carbonapi/pkg/types/encoding/json"
+	pickleenc "github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 	"github.com/bookingcom/carbonapi/util"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 
@@ -46,6 +55,11 @@ const (
 	protobufFormat  = "protobuf"
 	protobuf3Format = "protobuf3"
 	pickleFormat    = "pickle"
+	dygraphFormat   = "dygraph"
+	rickshawFormat  = "rickshaw"
+	ndjsonFormat    = "ndjson"
+	arrowFormat     = "arrow"
+	compactFormat   = "compactjson"
 )
 
 // for testing
@@ -69,6 +83,16 @@ func (app *App) validateRequest(h http.Handler, handler string) http.HandlerFunc
 				deferredAccessLogging(r, &accessLogDetails, t0, true)
 			}()
 			w.WriteHeader(http.StatusForbidden)
+		} else if err := util.ParseForm(r); err != nil {
+			// Parse the form here, so that handlers can use FormValue
+			// with JSON bodies too.
+			accessLogDetails := carbonapipb.NewAccessLogDetails(r, handler, &app.config)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = err.Error()
+			defer func() {
+				deferredAccessLogging(r, &accessLogDetails, t0, true)
+			}()
+			util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
 		} else {
 			h.ServeHTTP(w, r)
 		}
@@ -84,6 +108,17 @@ func initHandlersInternal(app *App) http.Handler {
 	r.HandleFunc("/unblock-headers/", httputil.TimeHandler(app.unblockHeaders, app.bucketRequestTimes))
 	r.HandleFunc("/unblock-headers", httputil.TimeHandler(app.unblockHeaders, app.bucketRequestTimes))
 
+	r.HandleFunc("/rewrite/", httputil.TimeHandler(app.rewriteHandler, app.bucketRequestTimes))
+	r.HandleFunc("/rewrite", httputil.TimeHandler(app.rewriteHandler, app.bucketRequestTimes))
+
+	r.HandleFunc("/cache/invalidate/", httputil.TimeHandler(app.invalidateHandler, app.bucketRequestTimes))
+	r.HandleFunc("/cache/invalidate", httputil.TimeHandler(app.invalidateHandler, app.bucketRequestTimes))
+
+	if app.topQueries != nil {
+		r.HandleFunc("/top-queries/", httputil.TimeHandler(app.topQueriesHandler, app.bucketRequestTimes))
+		r.HandleFunc("/top-queries", httputil.TimeHandler(app.topQueriesHandler, app.bucketRequestTimes))
+	}
+
 	r.HandleFunc("/debug/version", debugVersionHandler)
 
 	r.Handle("/debug/vars", expvar.Handler())
@@ -93,6 +128,8 @@ func initHandlersInternal(app *App) http.Handler {
 	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+	r.Handle("/debug/log-level", app.logLevels)
+
 	r.Handle("/metrics", promhttp.Handler())
 
 	return r
@@ -110,7 +147,23 @@ func initHandlers(app *App) http.Handler {
 	r.HandleFunc("/info/", httputil.TimeHandler(app.validateRequest(http.HandlerFunc(app.infoHandler), "info"), app.bucketRequestTimes))
 	r.HandleFunc("/info", httputil.TimeHandler(app.validateRequest(http.HandlerFunc(app.infoHandler), "info"), app.bucketRequestTimes))
 
-	r.HandleFunc("/lb_check", httputil.TimeHandler(app.lbcheckHandler, app.bucketRequestTimes))
+	if app.subscriptions != nil {
+		// Subscriptions last for minutes, which would skew the request
+		// times.
+		r.Handle("/subscribe/", app.validateRequest(http.HandlerFunc(app.subscribeHandler), "subscribe"))
+		r.Handle("/subscribe", app.validateRequest(http.HandlerFunc(app.subscribeHandler), "subscribe"))
+	}
+
+	if app.webSockets != nil {
+		r.Handle("/ws", app.validateRequest(http.HandlerFunc(app.webSocketHandler), "ws"))
+	}
+
+	if app.events != nil {
+		r.HandleFunc("/events/", httputil.TimeHandler(app.eventsHandler, app.bucketRequestTimes))
+		r.HandleFunc("/events", httputil.TimeHandler(app.eventsHandler, app.bucketRequestTimes))
+	}
+
+	r.HandleFunc(app.config.LBCheck.Path, httputil.TimeHandler(app.lbcheckHandler, app.bucketRequestTimes))
 
 	r.HandleFunc("/version", httputil.TimeHandler(app.versionHandler, app.bucketRequestTimes))
 	r.HandleFunc("/version/", httputil.TimeHandler(app.versionHandler, app.bucketRequestTimes))
@@ -126,7 +179,7 @@ func initHandlers(app *App) http.Handler {
 func writeResponse(w http.ResponseWriter, b []byte, format string, jsonp string) {
 
 	switch format {
-	case jsonFormat:
+	case jsonFormat, dygraphFormat, rickshawFormat, compactFormat:
 		if jsonp != "" {
 			w.Header().Set("Content-Type", contentTypeJavaScript)
 			w.Write([]byte(jsonp))
@@ -155,6 +208,12 @@ func writeResponse(w http.ResponseWriter, b []byte, format string, jsonp string)
 	case svgFormat:
 		w.Header().Set("Content-Type", contentTypeSVG)
 		w.Write(b)
+	case ndjsonFormat:
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.Write(b)
+	case arrowFormat:
+		w.Header().Set("Content-Type", contentTypeArrow)
+		w.Write(b)
 	}
 }
 
@@ -167,6 +226,7 @@ const (
 	contentTypePNG        = "image/png"
 	contentTypeCSV        = "text/csv"
 	contentTypeSVG        = "image/svg+xml"
+	contentTypeArrow      = "application/vnd.apache.arrow.stream"
 )
 
 type renderResponse struct {
@@ -177,8 +237,12 @@ type renderResponse struct {
 func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
 
-	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(r.Context(), util.Timeout(r, app.config.Timeouts.Render))
 	defer cancel()
+	if parser.TruthyBool(r.FormValue("strict")) {
+		// Ask the zippers for all-or-nothing results.
+		ctx = util.WithStrict(ctx)
+	}
 
 	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "render", &app.config)
 	logger := zapwriter.Logger("render").With(
@@ -189,35 +253,143 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	logAsError := false
 	defer func() {
 		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+		app.audit(r, &accessLogDetails)
+		app.verboseLog(w, r, &accessLogDetails)
+		app.topQueries.record(&accessLogDetails)
 	}()
 
 	size := 0
 	apiMetrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
 
+	if err := app.renderLimiter.EnterContext(ctx, localHostName); err != nil {
+		util.HTTPError(w, r, "too many concurrent render requests", http.StatusServiceUnavailable)
+		accessLogDetails.HttpCode = http.StatusServiceUnavailable
+		accessLogDetails.Reason = "too many concurrent render requests"
+		logAsError = true
+		return
+	}
+	defer app.renderLimiter.Leave(localHostName)
+
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
 		accessLogDetails.HttpCode = http.StatusBadRequest
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
+	normalizeRenderForm(r.Form, app.config.Cache)
+
 	targets := r.Form["target"]
 	from := r.FormValue("from")
 	until := r.FormValue("until")
 	format := r.FormValue("format")
 	template := r.FormValue("template")
-	useCache := !parser.TruthyBool(r.FormValue("noCache"))
+	// The cached responses of best-effort requests may be partial.
+	useCache := !parser.TruthyBool(r.FormValue("noCache")) && !util.Strict(ctx)
 
 	var jsonp string
 
-	if format == jsonFormat {
-		// TODO(dgryski): check jsonp only has valid characters
+	if format == jsonFormat || format == dygraphFormat || format == rickshawFormat || format == compactFormat {
 		jsonp = r.FormValue("jsonp")
 	}
 
+	if jsonp != "" && !encjson.ValidCallback(jsonp) {
+		util.HTTPError(w, r, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "invalid jsonp callback"
+		logAsError = true
+		return
+	}
+
+	pickleProtocol, err := pickleenc.ParseProtocol(r.FormValue("pickleProtocol"))
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	var xFilesFactor float32
+	if xff := r.FormValue("xFilesFactor"); xff != "" {
+		f, err := strconv.ParseFloat(xff, 32)
+		if err != nil || f < 0 || f > 1 {
+			msg := "invalid xFilesFactor, must be between 0 and 1"
+			util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+msg, http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = msg
+			logAsError = true
+			return
+		}
+		xFilesFactor = float32(f)
+	}
+
+	nulls := app.jsonNullPolicy
+	if np := r.FormValue("nullPolicy"); np != "" {
+		nulls, err = types.ParseNullPolicy(np)
+		if err != nil {
+			util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+	}
+	if parser.TruthyBool(r.FormValue("noNullPoints")) {
+		nulls = types.NullDrop
+	}
+
+	// The time zone of relative times, day and hour alignment and CSV
+	// timestamps. Without tz, series are aligned to UTC as before.
+	var tz *time.Location
+	if qtz := r.FormValue("tz"); qtz != "" {
+		tz, err = time.LoadLocation(qtz)
+		if err != nil {
+			msg := fmt.Sprintf("invalid tz %q", qtz)
+			util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+msg, http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = msg
+			logAsError = true
+			return
+		}
+	}
+	// The time zone of the dates of function arguments, as of from and
+	// until.
+	dateTZ := app.defaultTimeZone
+	if tz != nil {
+		dateTZ = tz
+	}
+
+	targets = substituteTemplate(targets, templateVariables(r.Form))
+
+	targets, err = app.functionAliases.expandTargets(targets)
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	targets, err = app.derived.expandTargets(targets)
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	targets, err = app.aggregationRules.expandTargets(targets, app.zipper.HasPath)
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
 	if format == "" && (parser.TruthyBool(r.FormValue("rawData")) || parser.TruthyBool(r.FormValue("rawdata"))) {
 		format = rawFormat
 	}
@@ -252,11 +424,20 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	r.Form.Del("_t") // Used by jquery.graphite.js
 
 	cacheKey := r.Form.Encode()
+	app.warmUp.seen(ctx, cacheKey)
 
 	// normalize from and until values
 	qtz := r.FormValue("tz")
-	from32 := date.DateParamToEpoch(from, qtz, timeNow().Add(-24*time.Hour).Unix(), app.defaultTimeZone)
-	until32 := date.DateParamToEpoch(until, qtz, timeNow().Unix(), app.defaultTimeZone)
+	// now pins the current time, e.g. for reproducible renders, relative
+	// times of from, until and the functions being relative to it
+	now := timeNow()
+	var pinnedNow time.Time
+	if qnow := r.FormValue("now"); qnow != "" {
+		pinnedNow = time.Unix(int64(date.DateParamToEpoch(qnow, qtz, now.Unix(), app.defaultTimeZone)), 0)
+		now = pinnedNow
+	}
+	from32 := date.DateParamToEpochAt(from, qtz, now.Add(-24*time.Hour).Unix(), app.defaultTimeZone, now)
+	until32 := date.DateParamToEpochAt(until, qtz, now.Unix(), app.defaultTimeZone, now)
 
 	accessLogDetails.UseCache = useCache
 	accessLogDetails.FromRaw = from
@@ -267,6 +448,39 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	accessLogDetails.CacheTimeout = cacheTimeout
 	accessLogDetails.Format = format
 	accessLogDetails.Targets = targets
+
+	if metric, denied := app.denyList.deniedTarget(targets, app.resolveMetric); denied {
+		apiMetrics.BlockedQueries.Add(1)
+		msg := fmt.Sprintf("query for %s is not allowed", metric)
+		util.HTTPError(w, r, msg, http.StatusForbidden)
+		accessLogDetails.HttpCode = http.StatusForbidden
+		accessLogDetails.Reason = msg
+		logAsError = true
+		return
+	}
+
+	if err := app.functionRules.check(r, targets, from32, until32); err != nil {
+		apiMetrics.BlockedQueries.Add(1)
+		util.HTTPError(w, r, err.Error(), http.StatusForbidden)
+		accessLogDetails.HttpCode = http.StatusForbidden
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	if parser.TruthyBool(r.FormValue("explain")) {
+		b, err := json.Marshal(app.explain(ctx, targets, from32, until32, useCache, &accessLogDetails))
+		if err != nil {
+			util.HTTPError(w, r, err.Error(), http.StatusInternalServerError)
+			accessLogDetails.HttpCode = http.StatusInternalServerError
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+		writeResponse(w, b, jsonFormat, "")
+		return
+	}
+
 	if useCache {
 		tc := time.Now()
 		response, err := app.queryCache.Get(cacheKey)
@@ -278,7 +492,10 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 
 		if err == nil {
 			apiMetrics.RequestCacheHits.Add(1)
-			writeResponse(w, response, format, jsonp)
+			app.setRenderCacheHeaders(w, time.Duration(cacheTimeout)*time.Second)
+			if !writeRenderResponse(w, r, response, format, jsonp) {
+				accessLogDetails.HttpCode = http.StatusNotModified
+			}
 			accessLogDetails.FromCache = true
 			return
 		}
@@ -286,17 +503,108 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if from32 == until32 {
-		http.Error(w, "Invalid empty time range", http.StatusBadRequest)
+		util.HTTPError(w, r, "Invalid empty time range", http.StatusBadRequest)
 		accessLogDetails.HttpCode = http.StatusBadRequest
 		accessLogDetails.Reason = "invalid empty time range"
 		logAsError = true
 		return
 	}
 
+	var resolved map[parser.MetricRequest][]string
+	if app.queryCost != nil {
+		var cost int64
+		cost, resolved = app.estimateCost(ctx, targets, from32, until32, useCache, &accessLogDetails)
+
+		if limit := app.queryCost.config.RejectAbove; limit > 0 && cost > limit {
+			apiMetrics.RejectedQueries.Add(1)
+			msg := fmt.Sprintf("query too expensive: about %d points, at most %d", cost, limit)
+			util.HTTPError(w, r, msg, http.StatusUnprocessableEntity)
+			accessLogDetails.HttpCode = http.StatusUnprocessableEntity
+			accessLogDetails.Reason = msg
+			logAsError = true
+			return
+		}
+
+		if limit := app.queryCost.config.QueueAbove; limit > 0 && cost > limit {
+			apiMetrics.ExpensiveQueries.Add(1)
+			if err := app.queryCost.expensive.EnterContext(ctx, localHostName); err != nil {
+				util.HTTPError(w, r, "too many concurrent expensive render requests", http.StatusServiceUnavailable)
+				accessLogDetails.HttpCode = http.StatusServiceUnavailable
+				accessLogDetails.Reason = "too many concurrent expensive render requests"
+				logAsError = true
+				return
+			}
+			defer app.queryCost.expensive.Leave(localHostName)
+		}
+	}
+
 	var results []*types.MetricData
 	errors := make(map[string]string)
 	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
 
+	// ndjson responses are sent as the series of each target are evaluated.
+	var stream *ndjsonStream
+	if format == ndjsonFormat {
+		maxDataPoints, _ := strconv.Atoi(r.FormValue("maxDataPoints"))
+		stream = newNDJSONStream(w, nulls, maxDataPoints)
+		if !useCache {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	}
+
+	// fetch fetches the series of renderRequests from mfetch.From to
+	// mfetch.Until into metricMap[mfetch], downsampled by the zipper with the
+	// consolidation function consolidateBy, if it's one the zipper knows.
+	fetch := func(mfetch parser.MetricRequest, renderRequests []string, consolidateBy string) {
+		if _, err := pkgtypes.ParseConsolidation(consolidateBy); err != nil {
+			consolidateBy = ""
+		}
+
+		// TODO(dgryski): group the render requests into batches
+		rch := make(chan renderResponse, len(renderRequests))
+		for _, m := range renderRequests {
+			go func(path string, from, until int32) {
+				app.limiter.Enter(localHostName)
+				defer app.limiter.Leave(localHostName)
+
+				apiMetrics.RenderRequests.Add(1)
+				atomic.AddInt64(&accessLogDetails.ZipperRequests, 1)
+
+				r, err := app.zipper.Render(ctx, path, from, until, consolidateBy)
+				rch <- renderResponse{r, err}
+			}(m, mfetch.From, mfetch.Until)
+		}
+
+		errors := make([]error, 0)
+		for i := 0; i < len(renderRequests); i++ {
+			resp := <-rch
+			if resp.error != nil {
+				errors = append(errors, resp.error)
+				continue
+			}
+
+			app.queryCost.learnSteps(resp.data)
+			for _, r := range resp.data {
+				size += r.Size()
+				r.XFilesFactor = xFilesFactor
+				r.TimeZone = tz
+				r.DateTimeZone = dateTZ
+				r.Now = pinnedNow
+				metricMap[mfetch] = append(metricMap[mfetch], r)
+			}
+		}
+		accessLogDetails.CarbonzipperResponseSizeBytes += int64(size)
+		close(rch)
+
+		if len(errors) != 0 {
+			logger.Error("render error occurred while fetching data",
+				zap.Any("errors", errors),
+			)
+		}
+
+		expr.SortMetrics(metricMap[mfetch], mfetch)
+	}
+
 	var metrics []string
 	var targetIdx = 0
 	// TODO(gmagnusson): Put the body of this loop in a select { } and cancel work
@@ -304,16 +612,23 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 		var target = targets[targetIdx]
 		targetIdx++
 
-		exp, e, err := parser.ParseExpr(target)
+		// The fetches of a client that went away fail right away, as they
+		// share its context, so don't bother with the other targets.
+		if util.ClientGone(r) {
+			return
+		}
+
+		exp, e, err := app.parseCache.ParseExpr(target)
 		if err != nil || e != "" {
 			msg := buildParseErrorString(target, e, err)
-			http.Error(w, msg, http.StatusBadRequest)
+			util.HTTPError(w, r, msg, http.StatusBadRequest)
 			accessLogDetails.Reason = msg
 			accessLogDetails.HttpCode = http.StatusBadRequest
 			logAsError = true
 			return
 		}
 
+		consolidations := exp.ConsolidateBy()
 		for _, m := range exp.Metrics() {
 			metrics = append(metrics, m.Metric)
 			mfetch := m
@@ -325,53 +640,50 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			renderRequests, err := getRenderRequests(ctx, m, useCache, &accessLogDetails, app)
-			if err != nil {
-				logger.Error("find error",
-					zap.String("metric", m.Metric),
-					zap.Error(err),
-				)
-				continue
+			renderRequests, ok := resolved[mfetch]
+			if !ok {
+				renderRequests, err = getRenderRequests(ctx, m, useCache, &accessLogDetails, app)
+				if err != nil {
+					logger.Error("find error",
+						zap.String("metric", m.Metric),
+						zap.Error(err),
+					)
+					continue
+				}
 			}
 
-			// TODO(dgryski): group the render requests into batches
-			rch := make(chan renderResponse, len(renderRequests))
-			for _, m := range renderRequests {
-				go func(path string, from, until int32) {
-					app.limiter.Enter(localHostName)
-					defer app.limiter.Leave(localHostName)
-
-					apiMetrics.RenderRequests.Add(1)
-					atomic.AddInt64(&accessLogDetails.ZipperRequests, 1)
-
-					r, err := app.zipper.Render(ctx, path, from, until)
-					rch <- renderResponse{r, err}
-				}(m, mfetch.From, mfetch.Until)
-			}
+			fetch(mfetch, renderRequests, consolidations[m])
+		}
 
-			errors := make([]error, 0)
-			for i := 0; i < len(renderRequests); i++ {
-				resp := <-rch
-				if resp.error != nil {
-					errors = append(errors, resp.error)
-					continue
-				}
+		// Functions of integer windows need points before from, so their
+		// series are fetched again starting that many of their steps
+		// earlier, as graphite-web does.
+		for m, n := range exp.PrimingPoints() {
+			mfetch := m
+			mfetch.From += from32
+			mfetch.Until += until32
 
-				for _, r := range resp.data {
-					size += r.Size()
-					metricMap[mfetch] = append(metricMap[mfetch], r)
+			var step int32
+			for _, s := range metricMap[mfetch] {
+				if s.StepTime > step {
+					step = s.StepTime
 				}
 			}
-			accessLogDetails.CarbonzipperResponseSizeBytes += int64(size)
-			close(rch)
+			if step == 0 {
+				continue
+			}
 
-			if len(errors) != 0 {
-				logger.Error("render error occurred while fetching data",
-					zap.Any("errors", errors),
-				)
+			mprimed := mfetch
+			mprimed.From -= int32(n) * step
+			if _, ok := metricMap[mprimed]; ok {
+				continue
 			}
 
-			expr.SortMetrics(metricMap[mfetch], mfetch)
+			var renderRequests []string
+			for _, s := range metricMap[mfetch] {
+				renderRequests = append(renderRequests, s.Name)
+			}
+			fetch(mprimed, renderRequests, consolidations[m])
 		}
 		accessLogDetails.Metrics = metrics
 
@@ -379,7 +691,7 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 		var newTargets []string
 		rewritten, newTargets, err = expr.RewriteExpr(exp, from32, until32, metricMap)
 		if err != nil && err != parser.ErrSeriesDoesNotExist {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			util.HTTPError(w, r, err.Error(), http.StatusInternalServerError)
 			errors[target] = err.Error()
 			accessLogDetails.Reason = err.Error()
 			logAsError = true
@@ -409,6 +721,16 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 			//
 			// If it walks like a stack, and it quacks like a stack ...
 
+			// The rewritten targets may call functions of their own.
+			if err := app.functionRules.check(r, newTargets, from32, until32); err != nil {
+				apiMetrics.BlockedQueries.Add(1)
+				util.HTTPError(w, r, err.Error(), http.StatusForbidden)
+				accessLogDetails.HttpCode = http.StatusForbidden
+				accessLogDetails.Reason = err.Error()
+				logAsError = true
+				return
+			}
+
 			targets = append(targets, newTargets...)
 			continue
 		}
@@ -438,18 +760,28 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			results = append(results, exprs...)
+			stream.write(exprs)
 		}()
 	}
 
 	var body []byte
 
 	switch format {
-	case jsonFormat:
+	case jsonFormat, dygraphFormat, rickshawFormat, compactFormat:
 		if maxDataPoints, _ := strconv.Atoi(r.FormValue("maxDataPoints")); maxDataPoints != 0 {
 			types.ConsolidateJSON(maxDataPoints, results)
 		}
 
-		body = types.MarshalJSON(results)
+		switch format {
+		case dygraphFormat:
+			body = types.MarshalDygraph(results)
+		case rickshawFormat:
+			body = types.MarshalRickshaw(results)
+		case compactFormat:
+			body = types.MarshalCompactJSON(results)
+		default:
+			body = types.MarshalJSON(results, nulls)
+		}
 	case protobufFormat, protobuf3Format:
 		body, err = types.MarshalProtobuf(results)
 		if err != nil {
@@ -458,7 +790,7 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 				zap.String("reason", err.Error()),
 				zap.Duration("runtime", time.Since(t0)),
 			)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			util.HTTPError(w, r, err.Error(), http.StatusInternalServerError)
 			accessLogDetails.HttpCode = http.StatusInternalServerError
 			logAsError = true
 			return
@@ -466,16 +798,34 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	case rawFormat:
 		body = types.MarshalRaw(results)
 	case csvFormat:
-		body = types.MarshalCSV(results)
+		loc := app.defaultTimeZone
+		if tz != nil {
+			loc = tz
+		}
+		body = types.MarshalCSV(results, loc)
 	case pickleFormat:
-		body = types.MarshalPickle(results)
+		body = pickleenc.WithProtocol(types.MarshalPickle(results), pickleProtocol)
 	case pngFormat:
 		body = png.MarshalPNGRequest(r, results, template)
 	case svgFormat:
 		body = png.MarshalSVGRequest(r, results, template)
+	case ndjsonFormat:
+		body = stream.written
+	case arrowFormat:
+		body = types.MarshalArrow(results)
 	}
 
-	writeResponse(w, body, format, jsonp)
+	if stream == nil {
+		if useCache {
+			app.setRenderCacheHeaders(w, coarsestStep(results))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+
+		if !writeRenderResponse(w, r, body, format, jsonp) {
+			accessLogDetails.HttpCode = http.StatusNotModified
+		}
+	}
 
 	if len(results) != 0 {
 		tc := time.Now()
@@ -487,6 +837,85 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request) {
 	accessLogDetails.HaveNonFatalErrors = len(errors) > 0
 }
 
+// setRenderCacheHeaders lets HTTP caches keep a render response for maxAge,
+// up to the configured maximum.
+func (app *App) setRenderCacheHeaders(w http.ResponseWriter, maxAge time.Duration) {
+	if limit := app.config.HTTPCache.MaxAge; maxAge > limit {
+		maxAge = limit
+	}
+
+	setCacheHeaders(w, maxAge)
+}
+
+// setCacheHeaders lets HTTP caches keep a response for maxAge.
+func setCacheHeaders(w http.ResponseWriter, maxAge time.Duration) {
+	if maxAge < time.Second {
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int64(maxAge/time.Second)))
+	w.Header().Set("Expires", timeNow().Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+// coarsestStep returns the largest step among results: none of the series
+// gets a new point before that.
+func coarsestStep(results []*types.MetricData) time.Duration {
+	var step int32
+	for _, r := range results {
+		if r != nil && r.AggregatedTimeStep() > step {
+			step = r.AggregatedTimeStep()
+		}
+	}
+
+	return time.Duration(step) * time.Second
+}
+
+// writeRenderResponse writes a render response with a weak ETag, or just a
+// 304 if the client already has it. It returns false in the latter case.
+func writeRenderResponse(w http.ResponseWriter, r *http.Request, b []byte, format string, jsonp string) bool {
+	etag := renderETag(b, format, jsonp)
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+
+	writeResponse(w, b, format, jsonp)
+	return true
+}
+
+// renderETag computes a weak ETag for a render response. The body is derived
+// from the targets, the time range aligned to the steps of the series and
+// their values, so its hash fingerprints all of them.
+func renderETag(b []byte, format string, jsonp string) string {
+	h := fnv.New64a()
+	h.Write([]byte(format))
+	h.Write([]byte{0})
+	h.Write([]byte(jsonp))
+	h.Write([]byte{0})
+	h.Write(b)
+
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// etagMatches tells whether an If-None-Match header matches etag, using the
+// weak comparison of RFC 7232.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func sendGlobs(glob pb.GlobResponse, app *App) bool {
 	// Yay globals
 	if app.config.AlwaysSendGlobsAsIs {
@@ -502,7 +931,7 @@ func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDe
 
 	if useCache {
 		tc := time.Now()
-		response, err := config.findCache.Get(metric)
+		response, err := config.findCache.Get(config.config.PathCache.Key(metric))
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.FindCacheOverheadNS.Add(td)
 
@@ -529,7 +958,7 @@ func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDe
 	b, err := glob.Marshal()
 	if err == nil {
 		tc := time.Now()
-		config.findCache.Set(metric, b, 5*60)
+		config.findCache.Set(config.config.PathCache.Key(metric), b, 5*60)
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.FindCacheOverheadNS.Add(td)
 	}
@@ -538,11 +967,20 @@ func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDe
 }
 
 func getRenderRequests(ctx context.Context, m parser.MetricRequest, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails, app *App) ([]string, error) {
+	m.Metric = app.resolveMetric(m.Metric)
+
 	if app.config.AlwaysSendGlobsAsIs {
 		accessLogDetails.SendGlobs = true
 		return []string{m.Metric}, nil
 	}
 
+	// A path with no globs resolves to itself, if it exists, which the
+	// zipper knows it does if it knows its backends.
+	if !strings.ContainsAny(m.Metric, "*?[{") && app.zipper.HasPath(m.Metric) {
+		apiMetrics.FindsSkipped.Add(1)
+		return []string{m.Metric}, nil
+	}
+
 	glob, err := resolveGlobs(ctx, m.Metric, useCache, accessLogDetails, app)
 	if err != nil {
 		return nil, err
@@ -566,8 +1004,12 @@ func getRenderRequests(ctx context.Context, m parser.MetricRequest, useCache boo
 func (app *App) findHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
 
-	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(r.Context(), util.Timeout(r, app.config.Timeouts.Find))
 	defer cancel()
+	if parser.TruthyBool(r.FormValue("strict")) {
+		// Ask the zippers for all-or-nothing results.
+		ctx = util.WithStrict(ctx)
+	}
 
 	apiMetrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
@@ -581,33 +1023,89 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request) {
 	logAsError := false
 	defer func() {
 		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+		app.audit(r, &accessLogDetails)
+		app.verboseLog(w, r, &accessLogDetails)
 	}()
 
+	if err := app.findLimiter.EnterContext(ctx, localHostName); err != nil {
+		util.HTTPError(w, r, "too many concurrent find requests", http.StatusServiceUnavailable)
+		accessLogDetails.HttpCode = http.StatusServiceUnavailable
+		accessLogDetails.Reason = "too many concurrent find requests"
+		logAsError = true
+		return
+	}
+	defer app.findLimiter.Leave(localHostName)
+
 	if format == "completer" {
 		query = getCompleterQuery(query)
 	}
 
 	if query == "" {
-		http.Error(w, "missing parameter `query`", http.StatusBadRequest)
+		util.HTTPError(w, r, "missing parameter `query`", http.StatusBadRequest)
 		accessLogDetails.HttpCode = http.StatusBadRequest
 		accessLogDetails.Reason = "missing parameter `query`"
 		logAsError = true
 		return
 	}
+	accessLogDetails.Targets = []string{query}
+
+	if app.denyList.deniesQuery(query, app.resolveMetric) {
+		apiMetrics.BlockedQueries.Add(1)
+		msg := fmt.Sprintf("query for %s is not allowed", query)
+		util.HTTPError(w, r, msg, http.StatusForbidden)
+		accessLogDetails.HttpCode = http.StatusForbidden
+		accessLogDetails.Reason = msg
+		logAsError = true
+		return
+	}
+
+	derived := app.derived.find(query)
+	query = app.resolveMetric(query)
+
+	if jsonp != "" && !encjson.ValidCallback(jsonp) {
+		util.HTTPError(w, r, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "invalid jsonp callback"
+		logAsError = true
+		return
+	}
+
+	pickleProtocol, err := pickleenc.ParseProtocol(r.FormValue("pickleProtocol"))
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
 
 	if format == "" {
 		format = treejsonFormat
 	}
 
 	globs, err := app.zipper.Find(ctx, query)
+	// Queries only matching derived metrics aren't found by the backends.
+	if err != nil && len(derived) != 0 {
+		globs, err = pb.GlobResponse{Name: query}, nil
+	}
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		util.HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError, pkgtypes.FailingBackends(err)...)
 		accessLogDetails.HttpCode = http.StatusInternalServerError
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
+	globs = addMatches(globs, derived)
+
+	if parser.TruthyBool(r.FormValue("leavesOnly")) {
+		globs = findLeavesOnly(globs)
+	}
+
+	if parser.TruthyBool(r.FormValue("wildcards")) {
+		globs = findWildcards(globs)
+	}
+
 	var b []byte
 	switch format {
 	case treejsonFormat, jsonFormat:
@@ -629,7 +1127,7 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request) {
 		for _, metric := range globs.Matches {
 			// Tell graphite-web that we have everything
 			var mm map[string]interface{}
-			if app.config.GraphiteWeb09Compatibility {
+			if app.graphite09Compat(r) {
 				// graphite-web 0.9.x
 				mm = map[string]interface{}{
 					// graphite-web 0.9.x
@@ -651,19 +1149,32 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request) {
 		p := bytes.NewBuffer(b)
 		pEnc := pickle.NewEncoder(p)
 		err = pEnc.Encode(result)
+		b = pickleenc.WithProtocol(p.Bytes(), pickleProtocol)
 	}
 
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		util.HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		accessLogDetails.HttpCode = http.StatusInternalServerError
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
+	setCacheHeaders(w, app.config.HTTPCache.FindMaxAge)
 	writeResponse(w, b, format, jsonp)
 }
 
+// graphite09Compat tells whether to answer in the format of graphite-web 0.9
+// rather than 1.x. Clients can override the configured default with the
+// graphite09compat parameter.
+func (app *App) graphite09Compat(r *http.Request) bool {
+	if v := r.FormValue("graphite09compat"); v != "" {
+		return parser.TruthyBool(v)
+	}
+
+	return app.config.GraphiteWeb09Compatibility
+}
+
 func getCompleterQuery(query string) string {
 	var replacer = strings.NewReplacer("/", ".")
 	query = replacer.Replace(query)
@@ -720,6 +1231,43 @@ func findCompleter(globs pb.GlobResponse) ([]byte, error) {
 	return b.Bytes(), err
 }
 
+// findLeavesOnly drops branches from a find response, like graphite-web does
+// with leavesOnly=1.
+func findLeavesOnly(globs pb.GlobResponse) pb.GlobResponse {
+	matches := make([]pb.GlobMatch, 0, len(globs.Matches))
+	for _, g := range globs.Matches {
+		if g.IsLeaf {
+			matches = append(matches, g)
+		}
+	}
+	globs.Matches = matches
+
+	return globs
+}
+
+// findWildcards appends a "*" pseudo-node matching all the other nodes, like
+// graphite-web does with wildcards=1. The node is a leaf only if all the
+// matches are leaves.
+func findWildcards(globs pb.GlobResponse) pb.GlobResponse {
+	if len(globs.Matches) < 2 {
+		return globs
+	}
+
+	prefix := ""
+	if i := strings.LastIndex(globs.Name, "."); i != -1 {
+		prefix = globs.Name[:i+1]
+	}
+
+	leaf := true
+	for _, g := range globs.Matches {
+		leaf = leaf && g.IsLeaf
+	}
+
+	globs.Matches = append(globs.Matches, pb.GlobMatch{Path: prefix + "*", IsLeaf: leaf})
+
+	return globs
+}
+
 func findList(globs pb.GlobResponse) ([]byte, error) {
 	var b bytes.Buffer
 
@@ -740,8 +1288,12 @@ func findList(globs pb.GlobResponse) ([]byte, error) {
 func (app *App) infoHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
 
-	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(r.Context(), util.Timeout(r, app.config.Timeouts.Info))
 	defer cancel()
+	if parser.TruthyBool(r.FormValue("strict")) {
+		// Ask the zippers for all-or-nothing results.
+		ctx = util.WithStrict(ctx)
+	}
 
 	format := r.FormValue("format")
 
@@ -758,22 +1310,43 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request) {
 	logAsError := false
 	defer func() {
 		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+		app.audit(r, &accessLogDetails)
+		app.verboseLog(w, r, &accessLogDetails)
 	}()
 
+	if err := app.infoLimiter.EnterContext(ctx, localHostName); err != nil {
+		util.HTTPError(w, r, "too many concurrent info requests", http.StatusServiceUnavailable)
+		accessLogDetails.HttpCode = http.StatusServiceUnavailable
+		accessLogDetails.Reason = "too many concurrent info requests"
+		logAsError = true
+		return
+	}
+	defer app.infoLimiter.Leave(localHostName)
+
 	var data map[string]pb.InfoResponse
 	var err error
 
 	query := r.FormValue("target")
 	if query == "" {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		accessLogDetails.HttpCode = http.StatusBadRequest
 		accessLogDetails.Reason = "no target specified"
 		logAsError = true
 		return
 	}
+	accessLogDetails.Targets = []string{query}
+
+	jsonp := r.FormValue("jsonp")
+	if jsonp != "" && !encjson.ValidCallback(jsonp) {
+		util.HTTPError(w, r, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "invalid jsonp callback"
+		logAsError = true
+		return
+	}
 
 	if data, err = app.zipper.Info(ctx, query); err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		util.HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError, pkgtypes.FailingBackends(err)...)
 		accessLogDetails.HttpCode = http.StatusInternalServerError
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
@@ -783,7 +1356,15 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request) {
 	var b []byte
 	switch format {
 	case jsonFormat:
-		b, err = json.Marshal(data)
+		if parser.TruthyBool(r.FormValue("diff")) {
+			diff := diffInfo(data)
+			if len(diff.Disagreements) != 0 {
+				apiMetrics.InfoDisagreements.Add(1)
+			}
+			b, err = json.Marshal(diff)
+		} else {
+			b, err = json.Marshal(data)
+		}
 	case protobufFormat, protobuf3Format:
 		err = fmt.Errorf("not implemented yet")
 	default:
@@ -791,14 +1372,14 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		util.HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		accessLogDetails.HttpCode = http.StatusInternalServerError
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
-	w.Write(b)
+	writeResponse(w, b, format, jsonp)
 	accessLogDetails.Runtime = time.Since(t0).Seconds()
 	accessLogDetails.HttpCode = http.StatusOK
 }
@@ -808,16 +1389,51 @@ func (app *App) lbcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 	apiMetrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
+
+	check := app.config.LBCheck
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "lbcheck", &app.config)
+	code := http.StatusOK
 	defer func() {
 		apiMetrics.Responses.Add(1)
-		prometheusMetrics.Responses.WithLabelValues("200", "lbcheck").Inc()
+		prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "lbcheck").Inc()
+
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Runtime = time.Since(t0).Seconds()
+		zapwriter.Logger("access").Info("request served", zap.Any("data", accessLogDetails))
 	}()
 
-	w.Write([]byte("Ok\n"))
+	if err := app.findCanary(r, check); err != nil {
+		code = http.StatusServiceUnavailable
+		accessLogDetails.Reason = "canary metric not found: " + err.Error()
+		http.Error(w, "canary metric not found", code)
+		return
+	}
 
-	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "lbcheck", &app.config)
-	accessLogDetails.Runtime = time.Since(t0).Seconds()
-	zapwriter.Logger("access").Info("request served", zap.Any("data", accessLogDetails))
+	for name, value := range check.Headers {
+		w.Header().Set(name, value)
+	}
+	io.WriteString(w, check.Body)
+}
+
+// findCanary finds the canary metric of check, if any, and fails if it isn't
+// found.
+func (app *App) findCanary(r *http.Request, check cfg.LBCheck) error {
+	if check.CanaryMetric == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), check.CanaryTimeout)
+	defer cancel()
+
+	glob, err := app.zipper.Find(ctx, check.CanaryMetric)
+	if err != nil {
+		return err
+	}
+	if len(glob.Matches) == 0 {
+		return errNoMetrics
+	}
+
+	return nil
 }
 
 func (app *App) versionHandler(w http.ResponseWriter, r *http.Request) {
@@ -841,8 +1457,10 @@ func (app *App) versionHandler(w http.ResponseWriter, r *http.Request) {
 	zapwriter.Logger("access").Info("request served", zap.Any("data", accessLogDetails))
 }
 
+// functionsHandler describes the supported functions like graphite-web's
+// /functions API: /functions lists all of them, optionally grouped, and
+// /functions/<name> describes one.
 func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement helper for specific functions
 	t0 := time.Now()
 
 	apiMetrics.Requests.Add(1)
@@ -857,25 +1475,19 @@ func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
 		accessLogDetails.HttpCode = http.StatusBadRequest
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
-	grouped := false
-	nativeOnly := false
-	groupedStr := r.FormValue("grouped")
-	prettyStr := r.FormValue("pretty")
-	nativeOnlyStr := r.FormValue("nativeOnly")
-	var marshaler func(interface{}) ([]byte, error)
-
-	if groupedStr == "1" {
-		grouped = true
-	}
+	grouped := parser.TruthyBool(r.FormValue("grouped"))
+	nativeOnly := parser.TruthyBool(r.FormValue("nativeOnly"))
+	jsonp := r.FormValue("jsonp")
 
-	if prettyStr == "1" {
+	var marshaler func(interface{}) ([]byte, error)
+	if parser.TruthyBool(r.FormValue("pretty")) {
 		marshaler = func(v interface{}) ([]byte, error) {
 			return json.MarshalIndent(v, "", "\t")
 		}
@@ -883,71 +1495,79 @@ func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request) {
 		marshaler = json.Marshal
 	}
 
-	if nativeOnlyStr == "1" {
-		nativeOnly = true
+	if jsonp != "" && !encjson.ValidCallback(jsonp) {
+		util.HTTPError(w, r, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "invalid jsonp callback"
+		logAsError = true
+		return
 	}
 
-	path := strings.Split(r.URL.EscapedPath(), "/")
+	path := strings.Split(strings.TrimSuffix(r.URL.EscapedPath(), "/"), "/")
 	function := ""
 	if len(path) >= 3 {
 		function = path[2]
 	}
 
 	var b []byte
-	if !nativeOnly {
-		metadata.FunctionMD.RLock()
-		if function != "" {
-			b, err = marshaler(metadata.FunctionMD.Descriptions[function])
-		} else if grouped {
-			b, err = marshaler(metadata.FunctionMD.DescriptionsGrouped)
-		} else {
-			b, err = marshaler(metadata.FunctionMD.Descriptions)
+	found := true
+	metadata.FunctionMD.RLock()
+	if function != "" {
+		d, ok := metadata.FunctionMD.Descriptions[function]
+		found = ok && !(nativeOnly && d.Proxied)
+		if found {
+			b, err = marshaler(d)
 		}
-		metadata.FunctionMD.RUnlock()
-	} else {
-		metadata.FunctionMD.RLock()
-		if function != "" {
-			if !metadata.FunctionMD.Descriptions[function].Proxied {
-				b, err = marshaler(metadata.FunctionMD.Descriptions[function])
-			} else {
-				err = fmt.Errorf("%v is proxied to graphite-web and nativeOnly was specified", function)
-			}
-		} else if grouped {
-			descGrouped := make(map[string]map[string]types.FunctionDescription)
-			for groupName, description := range metadata.FunctionMD.DescriptionsGrouped {
-				desc := make(map[string]types.FunctionDescription)
-				for f, d := range description {
-					if d.Proxied {
-						continue
-					}
-					desc[f] = d
-				}
-				if len(desc) > 0 {
-					descGrouped[groupName] = desc
-				}
-			}
-			b, err = marshaler(descGrouped)
-		} else {
+	} else if grouped {
+		descGrouped := make(map[string]map[string]types.FunctionDescription)
+		for groupName, description := range metadata.FunctionMD.DescriptionsGrouped {
 			desc := make(map[string]types.FunctionDescription)
-			for f, d := range metadata.FunctionMD.Descriptions {
-				if d.Proxied {
+			for f, d := range description {
+				if nativeOnly && d.Proxied {
 					continue
 				}
 				desc[f] = d
 			}
-			b, err = marshaler(desc)
+			if len(desc) > 0 {
+				descGrouped[groupName] = desc
+			}
 		}
-		metadata.FunctionMD.RUnlock()
+		b, err = marshaler(descGrouped)
+	} else {
+		desc := make(map[string]types.FunctionDescription)
+		for f, d := range metadata.FunctionMD.Descriptions {
+			if nativeOnly && d.Proxied {
+				continue
+			}
+			desc[f] = d
+		}
+		b, err = marshaler(desc)
+	}
+	metadata.FunctionMD.RUnlock()
+
+	if !found {
+		msg := fmt.Sprintf("function %s not found", function)
+		util.HTTPError(w, r, msg, http.StatusNotFound)
+		accessLogDetails.HttpCode = http.StatusNotFound
+		accessLogDetails.Reason = msg
+		return
 	}
 
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		util.HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		accessLogDetails.HttpCode = http.StatusInternalServerError
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
 	}
 
+	contentType := contentTypeJSON
+	if jsonp != "" {
+		contentType = contentTypeJavaScript
+		b = encjson.WrapJSONP(b, jsonp)
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.Write(b)
 	accessLogDetails.Runtime = time.Since(t0).Seconds()
 	accessLogDetails.HttpCode = http.StatusOK
@@ -1070,6 +1690,194 @@ func shouldBlockRequest(r *http.Request, rules []Rule) bool {
 	return false
 }
 
+// denyList refuses queries matching the rules of cfg.DenyTargets.
+type denyList struct {
+	// globs are the nodes of the glob rules, by rule, with each alternative
+	// of their {} a rule of its own.
+	globs   map[string][]string
+	regexps []*regexp.Regexp
+}
+
+// maxDenyAlternatives bounds the alternatives of the {} of a query that are
+// checked against the rules. Queries with more are refused.
+const maxDenyAlternatives = 1024
+
+func newDenyList(c cfg.DenyTargets) (denyList, error) {
+	d := denyList{globs: make(map[string][]string, len(c.Globs))}
+	for _, g := range c.Globs {
+		for _, alt := range glob.ExpandBraces(g) {
+			d.globs[alt] = strings.Split(alt, ".")
+		}
+	}
+
+	for _, r := range c.Regexps {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return denyList{}, fmt.Errorf("bad deny pattern '%s': %v", r, err)
+		}
+		d.regexps = append(d.regexps, re)
+	}
+
+	return d, nil
+}
+
+// denies tells whether the metric name or glob query is refused. Each
+// alternative of the {} of the query is checked on its own.
+func (d denyList) denies(query string) bool {
+	if len(d.globs) == 0 && len(d.regexps) == 0 {
+		return false
+	}
+
+	isGlob := strings.ContainsAny(query, "*?[{")
+	if glob.Alternatives(query, maxDenyAlternatives) > maxDenyAlternatives {
+		return true
+	}
+	for _, alt := range glob.ExpandBraces(query) {
+		if d.deniesAlternative(alt, isGlob) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deniesAlternative tells whether the query alt, without {}, is refused. Glob
+// rules only refuse it if it's part of a glob query.
+func (d denyList) deniesAlternative(alt string, isGlob bool) bool {
+	if _, ok := d.globs[alt]; ok {
+		return true
+	}
+	if isGlob {
+		nodes := strings.Split(alt, ".")
+		for _, rule := range d.globs {
+			if globCovers(rule, nodes) {
+				return true
+			}
+		}
+	}
+
+	for _, re := range d.regexps {
+		if regexpCovers(re, alt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// regexpCovers reports whether the query alt, without {}, may expand to
+// metrics matched by re. The names of a glob start with its literal prefix,
+// up to its first wildcard: re may match them unless it's anchored at the
+// start with a literal prefix of its own that diverges from the glob's.
+func regexpCovers(re *regexp.Regexp, alt string) bool {
+	if re.MatchString(alt) {
+		return true
+	}
+
+	i := strings.IndexAny(alt, "*?[")
+	if i == -1 {
+		return false
+	}
+	prefix := alt[:i]
+
+	rePrefix, anchored := anchoredPrefix(re)
+	if !anchored {
+		return true
+	}
+
+	return strings.HasPrefix(rePrefix, prefix) || strings.HasPrefix(prefix, rePrefix)
+}
+
+// anchoredPrefix returns the literal prefix of the names re matches, and
+// whether re is anchored at their start.
+func anchoredPrefix(re *regexp.Regexp) (string, bool) {
+	s, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	if s.Op == syntax.OpConcat && len(s.Sub) > 0 {
+		s = s.Sub[0]
+	}
+	if s.Op != syntax.OpBeginText {
+		return "", false
+	}
+
+	prefix, _ := re.LiteralPrefix()
+	return prefix, true
+}
+
+// globCovers reports whether the glob query of the nodes expands to metrics
+// of the rule, the nodes of a glob rule: the query has as many nodes, and
+// each of them may match a name the node of the rule matches. For instance
+// "*.*.*" covers "a.*.*" and "a.b*.c", but not "a.b.c.*".
+func globCovers(rule, nodes []string) bool {
+	if len(rule) != len(nodes) {
+		return false
+	}
+	for i, node := range nodes {
+		if !nodesOverlap(rule[i], node) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodesOverlap reports whether some node name may match both the glob nodes
+// a and b, without {}. Two nodes with wildcards overlap unless their literal
+// prefixes or suffixes differ, e.g. "exp*" and "*ensive" overlap.
+func nodesOverlap(a, b string) bool {
+	if glob.MatchNode(a, b) || glob.MatchNode(b, a) {
+		return true
+	}
+
+	const wildcards = "*?["
+	i, j := strings.IndexAny(a, wildcards), strings.IndexAny(b, wildcards)
+	if i == -1 || j == -1 {
+		return false
+	}
+	if !strings.HasPrefix(a[:i], b[:j]) && !strings.HasPrefix(b[:j], a[:i]) {
+		return false
+	}
+
+	// The literal suffixes follow the last wildcard, or the end of a class.
+	suffix := func(s string) string {
+		return s[strings.LastIndexAny(s, "*?]")+1:]
+	}
+	sa, sb := suffix(a), suffix(b)
+	return strings.HasSuffix(sa, sb) || strings.HasSuffix(sb, sa)
+}
+
+// deniesQuery tells whether the metric name or glob query is refused, as
+// written or as resolve turns it into the query sent to the backends.
+func (d denyList) deniesQuery(query string, resolve func(string) string) bool {
+	return d.denies(query) || d.denies(resolve(query))
+}
+
+// deniedTarget returns the first metric query of targets that is refused, as
+// written or as resolve turns it into the query sent to the backends.
+// Targets that don't parse are left for the caller to report.
+func (d denyList) deniedTarget(targets []string, resolve func(string) string) (string, bool) {
+	if len(d.globs) == 0 && len(d.regexps) == 0 {
+		return "", false
+	}
+
+	for _, target := range targets {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			continue
+		}
+
+		for _, m := range exp.Metrics() {
+			if d.deniesQuery(m.Metric, resolve) {
+				return m.Metric, true
+			}
+		}
+	}
+
+	return "", false
+}
+
 var usageMsg = []byte(`
 supported requests:
 	/render/?target=
@@ -1,9 +1,18 @@
 package carbonapi
 
 import (
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pathcache"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	"github.com/stretchr/testify/assert"
 )
@@ -72,3 +81,620 @@ func TestFindCompleter(t *testing.T) {
 	}
 
 }
+
+func TestFindLeavesOnly(t *testing.T) {
+	globs := pb.GlobResponse{Name: "foo.*", Matches: []pb.GlobMatch{
+		{Path: "foo.bar", IsLeaf: false},
+		{Path: "foo.bat", IsLeaf: true},
+	}}
+
+	got := findLeavesOnly(globs)
+	assert.Equal(t, []pb.GlobMatch{{Path: "foo.bat", IsLeaf: true}}, got.Matches)
+}
+
+func TestFindWildcards(t *testing.T) {
+	globs := pb.GlobResponse{Name: "foo.ba*", Matches: []pb.GlobMatch{
+		{Path: "foo.bar", IsLeaf: true},
+		{Path: "foo.bat", IsLeaf: true},
+	}}
+
+	got := findWildcards(globs)
+	assert.Equal(t, pb.GlobMatch{Path: "foo.*", IsLeaf: true}, got.Matches[len(got.Matches)-1])
+
+	single := pb.GlobResponse{Name: "foo.bar", Matches: []pb.GlobMatch{
+		{Path: "foo.bar", IsLeaf: false},
+	}}
+	assert.Equal(t, single, findWildcards(single), "single match should not get a wildcard")
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag := `W/"0123456789abcdef"`
+
+	assert.False(t, etagMatches("", etag))
+	assert.True(t, etagMatches(etag, etag))
+	assert.True(t, etagMatches(`"0123456789abcdef"`, etag), "weak comparison should ignore W/")
+	assert.True(t, etagMatches(`"other", `+etag, etag))
+	assert.True(t, etagMatches("*", etag))
+	assert.False(t, etagMatches(`W/"fedcba9876543210"`, etag))
+}
+
+func TestRenderCacheHeaders(t *testing.T) {
+	results := []*types.MetricData{
+		types.MakeMetricData("foo", []float64{1, 2}, 60, 0),
+		types.MakeMetricData("bar", []float64{1, 2}, 600, 0),
+	}
+	assert.Equal(t, 10*time.Minute, coarsestStep(results))
+
+	app := &App{}
+	app.config.HTTPCache.MaxAge = 5 * time.Minute
+
+	rr := httptest.NewRecorder()
+	app.setRenderCacheHeaders(rr, coarsestStep(results))
+	assert.Equal(t, "max-age=300", rr.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, rr.Header().Get("Expires"))
+
+	app.config.HTTPCache.MaxAge = 0
+	rr = httptest.NewRecorder()
+	app.setRenderCacheHeaders(rr, coarsestStep(results))
+	assert.Empty(t, rr.Header().Get("Cache-Control"), "a zero maxAge should disable the headers")
+}
+
+func TestDenyList(t *testing.T) {
+	d, err := newDenyList(cfg.DenyTargets{
+		Globs:   []string{"*.*.*.*.*"},
+		Regexps: []string{`^expensive\.tree\.`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, d.denies("*.*.*.*.*"))
+	assert.True(t, d.denies("a.*.*.*.*"), "narrower globs are refused")
+	assert.True(t, d.denies("foo.*.*.*.*"))
+	assert.True(t, d.denies("a.b.{c,d}.d.e*"))
+	assert.False(t, d.denies("a.b.c.d.e"), "metric names aren't refused by globs")
+	assert.False(t, d.denies("a.*.*.*"))
+	assert.False(t, d.denies("a.*.*.*.*.*"))
+	assert.True(t, d.denies("expensive.tree.*"))
+	assert.False(t, d.denies("cheap.tree.*"))
+
+	noRewrite := func(metric string) string { return metric }
+	metric, denied := d.deniedTarget([]string{"foo.bar", "sumSeries(expensive.tree.*.count)"}, noRewrite)
+	assert.True(t, denied)
+	assert.Equal(t, "expensive.tree.*.count", metric)
+
+	_, denied = d.deniedTarget([]string{"sumSeries(foo.*)"}, noRewrite)
+	assert.False(t, denied)
+
+	rs, _ := newTargetRewrites([]cfg.RewriteRule{{Pattern: `^foo\.`, Replacement: "expensive.tree."}})
+	metric, denied = d.deniedTarget([]string{"sumSeries(foo.*)"}, rs.rewrite)
+	assert.True(t, denied, "rewritten metrics are refused")
+	assert.Equal(t, "foo.*", metric)
+	assert.True(t, d.deniesQuery("foo.*", rs.rewrite))
+	assert.False(t, d.deniesQuery("bar.*", rs.rewrite))
+
+	d, _ = newDenyList(cfg.DenyTargets{Globs: []string{"expensive.tree.*"}})
+	assert.True(t, d.denies("expensive.tree.*"))
+	assert.True(t, d.denies("expensive.tree.a*"))
+	assert.True(t, d.denies("expensive.*.*"), "wider globs are refused")
+	assert.False(t, d.denies("cheap.tree.*"))
+	assert.False(t, d.denies("expensive.tree.host1"))
+
+	_, err = newDenyList(cfg.DenyTargets{Regexps: []string{"("}})
+	assert.Error(t, err)
+}
+
+func TestDenyListBypasses(t *testing.T) {
+	d, err := newDenyList(cfg.DenyTargets{Regexps: []string{`^expensive\.tree\.`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, d.denies("expensiv[e].tree.*"), "wildcards before the denied prefix")
+	assert.True(t, d.denies("{expensive}.tree.*"), "braces around the denied prefix")
+	assert.True(t, d.denies("{cheap,expensive}.tree.host1"))
+	assert.True(t, d.denies("exp*"))
+	assert.True(t, d.denies("*.tree.*"))
+	assert.False(t, d.denies("cheap.*"))
+	assert.False(t, d.denies("expensive.other.*"))
+	assert.False(t, d.denies("{cheap,other}.tree.*"))
+
+	d, _ = newDenyList(cfg.DenyTargets{Regexps: []string{`\.secret\.`}})
+	assert.True(t, d.denies("a.*.b"), "unanchored regexps can't be decided on globs")
+	assert.True(t, d.denies("a.{secret}.b"))
+	assert.False(t, d.denies("a.public.b"))
+
+	d, _ = newDenyList(cfg.DenyTargets{Globs: []string{"exp*.x"}})
+	assert.True(t, d.denies("{expensive,a}.x"))
+	assert.True(t, d.denies("*ensive.x"))
+	assert.True(t, d.denies("e?p.x"))
+	assert.False(t, d.denies("{cheap,a}.x"))
+	assert.False(t, d.denies("c*ap.x"))
+	assert.False(t, d.denies("exp*.y"))
+
+	d, _ = newDenyList(cfg.DenyTargets{Globs: []string{"{a,b}.*"}})
+	assert.True(t, d.denies("b.*"))
+	assert.False(t, d.denies("c.*"))
+
+	assert.True(t, d.denies(strings.Repeat("{a,b}", 20)+".c"), "too many alternatives to check")
+}
+
+func TestRenderHandlerDenied(t *testing.T) {
+	defer func(d denyList) { testApp.denyList = d }(testApp.denyList)
+	testApp.denyList, _ = newDenyList(cfg.DenyTargets{Globs: []string{"foo.*"}})
+
+	blocked := apiMetrics.BlockedQueries.Value()
+	req, rr := setUpRequest(t, "/render/?target=sumSeries(foo.*)&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, blocked+1, apiMetrics.BlockedQueries.Value())
+}
+
+func TestFindHandlerDeniedRewrite(t *testing.T) {
+	defer func(d denyList) { testApp.denyList = d }(testApp.denyList)
+	testApp.denyList, _ = newDenyList(cfg.DenyTargets{Globs: []string{"foo.*"}})
+	defer func(rs targetRewrites) { testApp.targetRewrites = rs }(testApp.targetRewrites)
+	testApp.targetRewrites, _ = newTargetRewrites([]cfg.RewriteRule{{Pattern: `^bar\.`, Replacement: "foo."}})
+
+	req, rr := setUpRequest(t, "/metrics/find/?query=bar.*&format=json")
+	testApp.findHandler(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestFunctionRules(t *testing.T) {
+	rules := newFunctionRules(cfg.FunctionRules{
+		FunctionRule: cfg.FunctionRule{
+			Deny:     []string{"removeEmptySeries"},
+			MaxRange: map[string]time.Duration{"timeShift": time.Hour},
+		},
+		Tenants: map[string]cfg.FunctionRule{
+			"small": {Allow: []string{"sumSeries", "timeShift"}},
+		},
+	}, "X-Tenant")
+
+	req, err := http.NewRequest("GET", "/render", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantReq, err := http.NewRequest("GET", "/render", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantReq.Header.Set("X-Tenant", "small")
+
+	assert.NoError(t, rules.check(req, []string{"foo.bar", "sumSeries(foo.*)"}, 0, 7200))
+	assert.Error(t, rules.check(req, []string{"removeEmptySeries(foo.*)"}, 0, 60))
+	assert.Error(t, rules.check(req, []string{"alias(removeEmptySeries(foo.*),'x')"}, 0, 60), "nested calls are checked")
+
+	assert.NoError(t, rules.check(req, []string{"timeShift(foo.*,'7d')"}, 0, 1800))
+	assert.Error(t, rules.check(req, []string{"timeShift(foo.*,'7d')"}, 0, 7200))
+
+	assert.NoError(t, rules.check(req, []string{"alias(foo.*,'x')"}, 0, 60))
+	assert.Error(t, rules.check(tenantReq, []string{"alias(foo.*,'x')"}, 0, 60))
+	assert.NoError(t, rules.check(tenantReq, []string{"sumSeries(foo.*)"}, 0, 60))
+	assert.Error(t, rules.check(tenantReq, []string{"timeShift(foo.*,'7d')"}, 0, 7200), "the global rules apply to tenants")
+}
+
+func TestRenderHandlerFunctionRules(t *testing.T) {
+	defer func(fr functionRules) { testApp.functionRules = fr }(testApp.functionRules)
+	testApp.functionRules = newFunctionRules(cfg.FunctionRules{
+		FunctionRule: cfg.FunctionRule{Deny: []string{"scale"}},
+	}, "")
+
+	blocked := apiMetrics.BlockedQueries.Value()
+	req, rr := setUpRequest(t, "/render/?target=scale(foo.bar,2)&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "function scale is not allowed")
+	assert.Equal(t, blocked+1, apiMetrics.BlockedQueries.Value())
+}
+
+func TestTargetRewrites(t *testing.T) {
+	rs, err := newTargetRewrites([]cfg.RewriteRule{
+		{Pattern: `^old\.prefix\.`, Replacement: "new.prefix."},
+		{Pattern: `^team\.(\w+)\.`, Replacement: "teams.$1."},
+		{Pattern: `^old\.`, Replacement: "never."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "new.prefix.foo.*", rs.rewrite("old.prefix.foo.*"))
+	assert.Equal(t, "teams.a.cpu", rs.rewrite("team.a.cpu"))
+	assert.Equal(t, "other.foo", rs.rewrite("other.foo"))
+
+	_, err = newTargetRewrites([]cfg.RewriteRule{{Pattern: "("}})
+	assert.Error(t, err)
+}
+
+func TestRewriteHandler(t *testing.T) {
+	defer func(rs targetRewrites) { testApp.targetRewrites = rs }(testApp.targetRewrites)
+	testApp.targetRewrites, _ = newTargetRewrites([]cfg.RewriteRule{{Pattern: `^foo\.`, Replacement: "bar."}})
+
+	req, rr := setUpRequest(t, "/rewrite?target=sumSeries(foo.a,baz.b)")
+	testApp.rewriteHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"foo.a":"bar.a","baz.b":"baz.b"}`, rr.Body.String())
+}
+
+func TestMetricAliases(t *testing.T) {
+	f, err := ioutil.TempFile("", "aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("web.latency: \"sys.prod.web*.latency.p99\"\ndb.qps: \"sys.prod.db.qps\"\n")
+	f.Close()
+
+	aliases, err := loadMetricAliases(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "sys.prod.web*.latency.p99", aliases.expand("web.latency"))
+	assert.Equal(t, "sys.prod.db.qps", aliases.expand("db.qps"))
+	assert.Equal(t, "web.latency.p50", aliases.expand("web.latency.p50"), "only whole names are aliases")
+
+	aliases, err = loadMetricAliases("")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", aliases.expand("foo"))
+}
+
+func TestFindHandlerAlias(t *testing.T) {
+	defer func(a metricAliases) { testApp.aliases = a }(testApp.aliases)
+	testApp.aliases = metricAliases{"virtual.name": "foo.bar"}
+
+	req, rr := setUpRequest(t, "/metrics/find/?query=virtual.name&format=json")
+	testApp.findHandler(rr, req)
+
+	expected, _ := findTreejson(getMetricGlobResponse("foo.bar"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, string(expected), rr.Body.String())
+}
+
+func TestRenderHandlerDeniedAlias(t *testing.T) {
+	defer func(d denyList) { testApp.denyList = d }(testApp.denyList)
+	testApp.denyList, _ = newDenyList(cfg.DenyTargets{Regexps: []string{`^expensive\.`}})
+	defer func(a metricAliases) { testApp.aliases = a }(testApp.aliases)
+	testApp.aliases = metricAliases{"virtual.name": "expensive.tree.*"}
+
+	req, rr := setUpRequest(t, "/render/?target=sumSeries(virtual.name)&from=-10minutes&format=json&noCache=1")
+	testApp.renderHandler(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=virtual.name&format=json")
+	testApp.findHandler(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestFunctionAliases(t *testing.T) {
+	aliases, err := newFunctionAliases(map[string]string{
+		"p99":      "percentileOfSeries($1, 99, true)",
+		"ratio":    "asPercent(sumSeries($1),sumSeries($2))",
+		"p99ratio": "ratio(p99($1),p99($2))",
+		"loop":     "loop($1)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target   string
+		expanded string
+	}{
+		{"sumSeries(foo.*)", "sumSeries(foo.*)"},
+		{"p99(foo.*)", "percentileOfSeries(foo.*, 99, true)"},
+		{"alias(p99(foo.*),'bar')", "alias(percentileOfSeries(foo.*, 99, true),'bar')"},
+		{"ratio(foo.ok,foo.*)", "asPercent(sumSeries(foo.ok),sumSeries(foo.*))"},
+		{"p99ratio(a,b)", "asPercent(sumSeries(percentileOfSeries(a, 99, true)),sumSeries(percentileOfSeries(b, 99, true)))"},
+		{"legendValue(p99(foo),valueTypes='avg')", "legendValue(percentileOfSeries(foo, 99, true),valueTypes='avg')"},
+	}
+	for _, tt := range tests {
+		expanded, err := aliases.expandTarget(tt.target)
+		assert.NoError(t, err, tt.target)
+		assert.Equal(t, tt.expanded, expanded, tt.target)
+	}
+
+	_, err = aliases.expandTarget("loop(foo)")
+	assert.Error(t, err, "recursive aliases are refused")
+	_, err = aliases.expandTarget("ratio(foo)")
+	assert.Error(t, err, "missing arguments are refused")
+	_, err = aliases.expandTarget("p99(foo,n=1)")
+	assert.Error(t, err, "named arguments are refused")
+
+	_, err = newFunctionAliases(map[string]string{"bad": "sumSeries($1"})
+	assert.Error(t, err)
+}
+
+func TestRenderHandlerFunctionAliases(t *testing.T) {
+	defer func(fa functionAliases) { testApp.functionAliases = fa }(testApp.functionAliases)
+	testApp.functionAliases, _ = newFunctionAliases(map[string]string{"twice": "scale($1,2)"})
+
+	req, rr := setUpRequest(t, "/render/?target=twice(foo.bar)&format=json")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"scale(foo.bar,2)"`)
+}
+
+func TestDerivedMetrics(t *testing.T) {
+	derived, err := newDerivedMetrics(map[string]string{
+		"site.requests.total": "sumSeries(dc*.requests)",
+		"site.requests.ratio": "divideSeries(site.errors.total,site.requests.total)",
+		"site.errors.total":   "sumSeries(dc*.errors)",
+		"loop":                "scale(loop,2)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target   string
+		expanded string
+	}{
+		{"foo.bar", "foo.bar"},
+		{"site.requests.total", "alias(sumSeries(dc*.requests),'site.requests.total')"},
+		{"scale(site.requests.total,2)", "scale(alias(sumSeries(dc*.requests),'site.requests.total'),2)"},
+		{"site.requests.ratio", "alias(divideSeries(alias(sumSeries(dc*.errors),'site.errors.total'),alias(sumSeries(dc*.requests),'site.requests.total')),'site.requests.ratio')"},
+	}
+	for _, tt := range tests {
+		expanded, err := derived.expandTarget(tt.target)
+		assert.NoError(t, err, tt.target)
+		assert.Equal(t, tt.expanded, expanded, tt.target)
+	}
+
+	_, err = derived.expandTarget("loop")
+	assert.Error(t, err, "recursive derived metrics are refused")
+
+	assert.Equal(t, []pb.GlobMatch{{Path: "site.errors", IsLeaf: false}, {Path: "site.requests", IsLeaf: false}}, derived.find("site.*"))
+	assert.Equal(t, []pb.GlobMatch{{Path: "site.requests.ratio", IsLeaf: true}}, derived.find("site.{foo,requests}.r*"))
+	assert.Empty(t, derived.find("site.requests.total.*"))
+
+	_, err = newDerivedMetrics(map[string]string{"site.*": "foo"})
+	assert.Error(t, err)
+	_, err = newDerivedMetrics(map[string]string{"site.total": "sumSeries(foo"})
+	assert.Error(t, err)
+}
+
+func TestDerivedMetricsHandlers(t *testing.T) {
+	defer func(d derivedMetrics) { testApp.derived = d }(testApp.derived)
+	testApp.derived, _ = newDerivedMetrics(map[string]string{"foo.twice": "scale(foo.bar,2)"})
+
+	req, rr := setUpRequest(t, "/render/?target=foo.twice&format=json")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"foo.twice"`)
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.b*&format=json")
+	testApp.findHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"id":"foo.bat"`)
+	assert.NotContains(t, rr.Body.String(), `foo.twice`)
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.t*&format=raw")
+	testApp.findHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "foo.twice\n", rr.Body.String())
+}
+
+func TestAggregationRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "aggregation-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`# Aggregations of the requests of all hosts.
+<env>.applications.<app>.all.requests (60) = sum <env>.applications.<app>.*.requests
+<env>.latency.<<path>>.p99 (60) = p999 <env>.hosts.<host>.latency.<<path>>
+
+<a>.all (60) = avg <a>.bar
+`)
+	f.Close()
+
+	rules, err := loadAggregationRules(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(name string) bool { return name == "prod.applications.web.all.requests" }
+	tests := []struct {
+		target   string
+		expanded string
+	}{
+		{"prod.applications.api.all.requests", "alias(sumSeries(prod.applications.api.*.requests),'prod.applications.api.all.requests')"},
+		{"prod.applications.web.all.requests", "prod.applications.web.all.requests"},
+		{"prod.applications.*.all.requests", "prod.applications.*.all.requests"},
+		{"scale(prod.latency.db.read.p99,2)", "scale(alias(percentileOfSeries(prod.hosts.*.latency.db.read,99.9),'prod.latency.db.read.p99'),2)"},
+		{"foo.all", "alias(averageSeries(foo.bar),'foo.all')"},
+		{"foo.bar", "foo.bar"},
+	}
+	for _, tt := range tests {
+		expanded, err := rules.expandTargets([]string{tt.target}, stored)
+		assert.NoError(t, err, tt.target)
+		assert.Equal(t, []string{tt.expanded}, expanded, tt.target)
+	}
+
+	for _, line := range []string{
+		"foo.all = sum foo.*",
+		"foo.all (60) = median foo.*",
+		"<a>.<a> (60) = sum <a>.*",
+		"<a>.all (60) = sum <a>.<<rest>>",
+	} {
+		_, err := parseAggregationRule(line)
+		assert.Error(t, err, line)
+	}
+
+	rules, err = loadAggregationRules("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestRenderHandlerAggregationRules(t *testing.T) {
+	defer func(rs aggregationRules) { testApp.aggregationRules = rs }(testApp.aggregationRules)
+	rule, _ := parseAggregationRule("<a>.all (60) = sum <a>.bar")
+	testApp.aggregationRules = aggregationRules{rule}
+
+	req, rr := setUpRequest(t, "/render/?target=foo.all&format=json")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"foo.all"`)
+}
+
+func TestRenderHandlerInvalidTimeZone(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&format=json&tz=Not/AZone")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid tz")
+}
+
+func TestRenderHandlerNullPolicy(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&format=json&nullPolicy=never")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsupported null policy")
+}
+
+func TestTemplateVariables(t *testing.T) {
+	form := url.Values{
+		"template[host]":     {"web01"},
+		"template[hostname]": {"web01.example"},
+		"template[]":         {"ignored"},
+		"template":           {"default"},
+	}
+	vars := templateVariables(form)
+	assert.Equal(t, map[string]string{"host": "web01", "hostname": "web01.example"}, vars)
+
+	targets := []string{"sumSeries(servers.$host.cpu)", "alias(servers.$host.load,'$hostname')", "foo.$other"}
+	assert.Equal(t, []string{
+		"sumSeries(servers.web01.cpu)",
+		"alias(servers.web01.load,'web01.example')",
+		"foo.$other",
+	}, substituteTemplate(targets, vars))
+	assert.Equal(t, "sumSeries(servers.$host.cpu)", targets[0])
+}
+
+func TestRenderHandlerTemplate(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=scale($metric,2)&template[metric]=foo.bar&format=json")
+	testApp.renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"scale(foo.bar,2)"`)
+}
+
+func TestAPIKeyFingerprint(t *testing.T) {
+	fp := apiKeyFingerprint("secret-key")
+	assert.Len(t, fp, 16)
+	assert.NotContains(t, fp, "secret")
+	assert.Equal(t, fp, apiKeyFingerprint("secret-key"))
+	assert.NotEqual(t, fp, apiKeyFingerprint("other-key"))
+}
+
+func TestLoggedHeaders(t *testing.T) {
+	app := &App{}
+	app.config.Audit.APIKeyHeader = "X-API-Key"
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Basic c2VjcmV0")
+	headers.Set("X-API-Key", "secret-key")
+	headers.Set("User-Agent", "Grafana")
+
+	logged := app.loggedHeaders(headers)
+	assert.Equal(t, "", logged.Get("Authorization"))
+	assert.Equal(t, "", logged.Get("X-API-Key"))
+	assert.Equal(t, "Grafana", logged.Get("User-Agent"))
+	assert.Equal(t, "secret-key", headers.Get("X-API-Key"))
+}
+
+func TestEventsHandler(t *testing.T) {
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphite/events/get_data" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"what":"deploy","tags":["` + r.FormValue("tags") + `"],"when":1500000000}]`))
+	}))
+	defer store.Close()
+
+	events, err := newEventsProxy(cfg.EventsConfig{URL: store.URL + "/graphite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := testApp
+	app.events = events
+	defer func() { app.events = nil }()
+
+	req, rr := setUpRequest(t, "/events/get_data?from=-1d&until=now&tags=deploy")
+	app.eventsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `[{"what":"deploy","tags":["deploy"],"when":1500000000}]`, rr.Body.String())
+
+	req, err = http.NewRequest("POST", "/events/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	app.eventsHandler(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	store.Close()
+	req, rr = setUpRequest(t, "/events/get_data")
+	app.eventsHandler(rr, req)
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+}
+
+func TestLBCheckHandler(t *testing.T) {
+	defer func(c cfg.LBCheck) { testApp.config.LBCheck = c }(testApp.config.LBCheck)
+
+	req, rr := setUpRequest(t, "/lb_check")
+	testApp.lbcheckHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Ok\n", rr.Body.String())
+
+	testApp.config.LBCheck = cfg.LBCheck{
+		Body:          "I'm alive",
+		Headers:       map[string]string{"X-Health": "up"},
+		CanaryMetric:  "foo.bar",
+		CanaryTimeout: time.Second,
+	}
+	req, rr = setUpRequest(t, "/lb_check")
+	testApp.lbcheckHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "I'm alive", rr.Body.String())
+	assert.Equal(t, "up", rr.Header().Get("X-Health"))
+
+	testApp.config.LBCheck.CanaryMetric = "no.such.metric"
+	req, rr = setUpRequest(t, "/lb_check")
+	testApp.lbcheckHandler(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Empty(t, rr.Header().Get("X-Health"))
+}
+
+func TestInvalidateHandler(t *testing.T) {
+	defer func(p pathcache.PathCache) { testApp.config.PathCache = p }(testApp.config.PathCache)
+	testApp.config.PathCache = pathcache.NewPathCache(60, 0, 0)
+	testApp.config.PathCache.Set("virt.v1.foo", []string{"search"})
+	testApp.config.PathCache.Set("foo.bar", []string{"store"})
+
+	req, rr := setUpRequest(t, "/cache/invalidate?prefix=virt.v1")
+	testApp.invalidateHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"invalidated":["virt.v1"]}`, rr.Body.String())
+	_, ok := testApp.config.PathCache.Get("virt.v1.foo")
+	assert.False(t, ok, "entries under the prefix are evicted")
+	_, ok = testApp.config.PathCache.Get("foo.bar")
+	assert.True(t, ok, "other entries stay")
+}
@@ -0,0 +1,68 @@
+package carbonapi
+
+import (
+	"strconv"
+	"strings"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// infoDiff is the /info response with diff=1: the info of the metric on
+// every server, and the fields the servers disagree on, with the value of
+// each server. Disagreements show storage schemas drifting between replicas.
+type infoDiff struct {
+	Info          map[string]pb.InfoResponse   `json:"info"`
+	Disagreements map[string]map[string]string `json:"disagreements"`
+}
+
+// infoFields are the fields compared by diffInfo, with their JSON names.
+var infoFields = []struct {
+	name   string
+	format func(pb.InfoResponse) string
+}{
+	{"aggregationMethod", func(i pb.InfoResponse) string {
+		return i.AggregationMethod
+	}},
+	{"xFilesFactor", func(i pb.InfoResponse) string {
+		return strconv.FormatFloat(float64(i.XFilesFactor), 'f', -1, 32)
+	}},
+	{"maxRetention", func(i pb.InfoResponse) string {
+		return strconv.Itoa(int(i.MaxRetention))
+	}},
+	{"retentions", formatRetentions},
+}
+
+// formatRetentions formats the retentions of info like storage-schemas.conf,
+// e.g. "60:43200,3600:8760" for seconds per point and number of points.
+func formatRetentions(info pb.InfoResponse) string {
+	retentions := make([]string, 0, len(info.Retentions))
+	for _, r := range info.Retentions {
+		retentions = append(retentions, strconv.Itoa(int(r.SecondsPerPoint))+":"+strconv.Itoa(int(r.NumberOfPoints)))
+	}
+
+	return strings.Join(retentions, ",")
+}
+
+// diffInfo compares the info of the servers of data.
+func diffInfo(data map[string]pb.InfoResponse) infoDiff {
+	diff := infoDiff{
+		Info:          data,
+		Disagreements: make(map[string]map[string]string),
+	}
+
+	for _, field := range infoFields {
+		values := make(map[string]string, len(data))
+		distinct := make(map[string]struct{})
+		for server, info := range data {
+			v := field.format(info)
+			values[server] = v
+			distinct[v] = struct{}{}
+		}
+
+		if len(distinct) > 1 {
+			diff.Disagreements[field.name] = values
+		}
+	}
+
+	return diff
+}
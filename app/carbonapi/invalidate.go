@@ -0,0 +1,49 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// invalidateHandler evicts the cached find responses and backends of the
+// queries under the top-level domains of the prefix parameters, or of all
+// queries if there is none. Invalidation is per top-level domain:
+// prefix=virt.v1 evicts everything under virt. carbonsearch calls it when its
+// index changes, so that its new results are served without waiting out
+// expireDelaySec.
+func (app *App) invalidateHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	apiMetrics.Requests.Add(1)
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "invalidate", &app.config)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	if err := util.ParseForm(r); err != nil {
+		util.HTTPError(w, r, err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	prefixes := r.Form["prefix"]
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	for _, prefix := range prefixes {
+		app.config.PathCache.Invalidate(prefix)
+	}
+	apiMetrics.CacheInvalidations.Add(int64(len(prefixes)))
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string][]string{"invalidated": prefixes})
+}
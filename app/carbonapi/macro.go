@@ -0,0 +1,152 @@
+package carbonapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// maxFunctionAliasDepth bounds how many times aliases are expanded in a
+// target, as an alias may call other aliases, or itself.
+const maxFunctionAliasDepth = 10
+
+var functionAliasArg = regexp.MustCompile(`\$[0-9]+`)
+
+// functionAliases maps the names of functions defined in the config to the
+// expressions they are expanded to, with $1, $2, ... standing for their
+// arguments.
+type functionAliases map[string]string
+
+func newFunctionAliases(defs map[string]string) (functionAliases, error) {
+	aliases := make(functionAliases, len(defs))
+	for name, def := range defs {
+		// The arguments are replaced by a metric name only to check that
+		// the definition parses.
+		check := functionAliasArg.ReplaceAllString(def, "a")
+		_, rest, err := parser.ParseExpr(check)
+		if err != nil {
+			return nil, fmt.Errorf("bad function alias '%s': %v", name, err)
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("bad function alias '%s': could not parse '%s'", name, rest)
+		}
+		aliases[name] = def
+	}
+
+	return aliases, nil
+}
+
+// expandTargets returns targets with the aliases expanded, leaving targets
+// itself unchanged.
+func (fa functionAliases) expandTargets(targets []string) ([]string, error) {
+	if len(fa) == 0 {
+		return targets, nil
+	}
+
+	expanded := make([]string, 0, len(targets))
+	for _, target := range targets {
+		t, err := fa.expandTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, t)
+	}
+
+	return expanded, nil
+}
+
+// expandTarget returns target with the calls to aliases replaced by their
+// definition. Targets that don't parse are returned unchanged, for the caller
+// to report.
+func (fa functionAliases) expandTarget(target string) (string, error) {
+	if len(fa) == 0 {
+		return target, nil
+	}
+
+	for depth := 0; ; depth++ {
+		exp, rest, err := parser.ParseExpr(target)
+		if err != nil || rest != "" {
+			return target, nil
+		}
+
+		expanded, found, err := fa.expand(exp)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return target, nil
+		}
+		if depth == maxFunctionAliasDepth {
+			return "", fmt.Errorf("function aliases nested too deeply in %s", target)
+		}
+
+		target = expanded
+	}
+}
+
+// expand returns e as a string with the outermost calls to aliases replaced,
+// and whether there were any. Expressions without aliases keep their original
+// form, as series are named after it.
+func (fa functionAliases) expand(e parser.Expr) (string, bool, error) {
+	if !e.IsFunc() {
+		return e.ToString(), false, nil
+	}
+
+	var found bool
+	args := make([]string, 0, len(e.Args()))
+	for _, arg := range e.Args() {
+		s, ok, err := fa.expand(arg)
+		if err != nil {
+			return "", false, err
+		}
+		found = found || ok
+		args = append(args, s)
+	}
+
+	def, isAlias := fa[e.Target()]
+	if !isAlias {
+		if !found {
+			return e.ToString(), false, nil
+		}
+
+		return callString(e, args), true, nil
+	}
+
+	if len(e.NamedArgs()) > 0 {
+		return "", false, fmt.Errorf("function alias %s only takes positional arguments", e.Target())
+	}
+
+	var err error
+	expanded := functionAliasArg.ReplaceAllStringFunc(def, func(arg string) string {
+		n, _ := strconv.Atoi(arg[1:])
+		if n < 1 || n > len(args) {
+			err = fmt.Errorf("function alias %s needs argument %s", e.Target(), arg)
+			return arg
+		}
+		return args[n-1]
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	return expanded, true, nil
+}
+
+// callString returns the call of the function e with the positional
+// arguments args, and the named arguments of e.
+func callString(e parser.Expr, args []string) string {
+	names := make([]string, 0, len(e.NamedArgs()))
+	for name := range e.NamedArgs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, name+"="+e.NamedArgs()[name].ToString())
+	}
+
+	return e.Target() + "(" + strings.Join(args, ",") + ")"
+}
@@ -0,0 +1,50 @@
+package carbonapi
+
+import (
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// ndjsonStream writes the series of an ndjson render response as the series
+// of each target are evaluated, flushing them to the client so that large
+// exports can be consumed incrementally. The status and headers are sent with
+// the first series, so later errors can only be appended to the stream. A nil
+// ndjsonStream does nothing.
+type ndjsonStream struct {
+	w             http.ResponseWriter
+	nulls         types.NullPolicy
+	maxDataPoints int
+	// written is the response so far, to be cached.
+	written []byte
+}
+
+func newNDJSONStream(w http.ResponseWriter, nulls types.NullPolicy, maxDataPoints int) *ndjsonStream {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+
+	return &ndjsonStream{w: w, nulls: nulls, maxDataPoints: maxDataPoints}
+}
+
+// write sends the lines of series.
+func (s *ndjsonStream) write(series []*types.MetricData) {
+	if s == nil || len(series) == 0 {
+		return
+	}
+
+	if s.maxDataPoints != 0 {
+		types.ConsolidateJSON(s.maxDataPoints, series)
+	}
+
+	b := types.MarshalNDJSON(series, s.nulls)
+	if len(b) == 0 {
+		return
+	}
+	s.written = append(s.written, b...)
+
+	s.w.Write(b)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
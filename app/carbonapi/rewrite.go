@@ -0,0 +1,101 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+type targetRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// targetRewrites renames the metrics of incoming queries, following the rules
+// of cfg.API.TargetRewrites.
+type targetRewrites []targetRewrite
+
+func newTargetRewrites(rules []cfg.RewriteRule) (targetRewrites, error) {
+	rs := make(targetRewrites, 0, len(rules))
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad rewrite pattern '%s': %v", r.Pattern, err)
+		}
+		rs = append(rs, targetRewrite{pattern: re, replacement: r.Replacement})
+	}
+
+	return rs, nil
+}
+
+// rewrite returns the metric name or glob query renamed by the first matching
+// rule, or unchanged if none matches.
+func (rs targetRewrites) rewrite(metric string) string {
+	for _, r := range rs {
+		if r.pattern.MatchString(metric) {
+			return r.pattern.ReplaceAllString(metric, r.replacement)
+		}
+	}
+
+	return metric
+}
+
+// rewriteHandler shows how the metrics of the target parameters are expanded
+// and rewritten, to test the aliases and rules.
+func (app *App) rewriteHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	apiMetrics.Requests.Add(1)
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "rewrite", &app.config)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	targets := r.URL.Query()["target"]
+	if len(targets) == 0 {
+		util.HTTPError(w, r, "missing parameter `target`", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "missing parameter `target`"
+		logAsError = true
+		return
+	}
+
+	targets, err := app.functionAliases.expandTargets(targets)
+	if err != nil {
+		util.HTTPError(w, r, err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	rewritten := make(map[string]string)
+	for _, target := range targets {
+		exp, e, err := parser.ParseExpr(target)
+		if err != nil || e != "" {
+			msg := buildParseErrorString(target, e, err)
+			util.HTTPError(w, r, msg, http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = msg
+			logAsError = true
+			return
+		}
+
+		for _, m := range exp.Metrics() {
+			rewritten[m.Metric] = app.resolveMetric(m.Metric)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(rewritten)
+}
@@ -0,0 +1,119 @@
+package carbonapi
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+	"go.uber.org/zap"
+)
+
+// loadShedder refuses the low priority requests while carbonapi is
+// overloaded, so that the latency of interactive requests holds.
+type loadShedder struct {
+	config cfg.LoadSheddingConfig
+
+	// overloaded is set while the goroutines or the heap are over their
+	// thresholds, as of the last check.
+	overloaded int32
+	inFlight   int64
+}
+
+// newLoadShedder returns nil if load shedding isn't configured.
+func newLoadShedder(config cfg.LoadSheddingConfig) *loadShedder {
+	if config.MaxGoroutines <= 0 && config.MaxHeapMB <= 0 && config.MaxInFlight <= 0 {
+		return nil
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = time.Second
+	}
+
+	return &loadShedder{config: config}
+}
+
+// run checks the goroutines and the heap every check interval until the
+// process exits.
+func (s *loadShedder) run(logger *zap.Logger) {
+	if s.config.MaxGoroutines <= 0 && s.config.MaxHeapMB <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.CheckInterval)
+	defer ticker.Stop()
+
+	var stats runtime.MemStats
+	for range ticker.C {
+		goroutines := runtime.NumGoroutine()
+		var heap uint64
+		if s.config.MaxHeapMB > 0 {
+			runtime.ReadMemStats(&stats)
+			heap = stats.HeapAlloc
+		}
+
+		overloaded := (s.config.MaxGoroutines > 0 && goroutines > s.config.MaxGoroutines) ||
+			(s.config.MaxHeapMB > 0 && heap > uint64(s.config.MaxHeapMB)*1024*1024)
+		if s.setOverloaded(overloaded) {
+			logger.Warn("Load shedding state changed",
+				zap.Bool("overloaded", overloaded),
+				zap.Int("goroutines", goroutines),
+				zap.Uint64("heap_bytes", heap),
+			)
+		}
+	}
+}
+
+// setOverloaded sets whether carbonapi is overloaded, and reports whether
+// that changed.
+func (s *loadShedder) setOverloaded(overloaded bool) bool {
+	var v int32
+	if overloaded {
+		v = 1
+	}
+
+	return atomic.SwapInt32(&s.overloaded, v) != v
+}
+
+// lowPriority reports whether r may be refused while overloaded.
+func (s *loadShedder) lowPriority(r *http.Request) bool {
+	if s.config.PriorityHeader != "" && strings.EqualFold(r.Header.Get(s.config.PriorityHeader), "low") {
+		return true
+	}
+	for _, p := range s.config.LowPriorityPaths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handler is middleware refusing the low priority requests with a 503 while
+// carbonapi is overloaded. A nil loadShedder returns h.
+func (s *loadShedder) handler(h http.Handler) http.Handler {
+	if s == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight := atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+
+		overloaded := atomic.LoadInt32(&s.overloaded) == 1 ||
+			(s.config.MaxInFlight > 0 && inFlight > int64(s.config.MaxInFlight))
+		if overloaded && s.lowPriority(r) {
+			apiMetrics.ShedRequests.Add(1)
+			if s.config.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(s.config.RetryAfter.Seconds())))
+			}
+			util.HTTPError(w, r, "overloaded, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,321 @@
+package carbonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// subscriptions serves live render subscriptions: the render request of a
+// subscription is rendered every interval, and the points newer than those
+// already pushed are sent to the client as a Server-Sent Event.
+type subscriptions struct {
+	config cfg.SubscriptionsConfig
+	active int64 // Accessed atomically.
+}
+
+// newSubscriptions returns nil if subscriptions are disabled. They end a
+// second before writeTimeout, if set.
+func newSubscriptions(config cfg.SubscriptionsConfig, writeTimeout time.Duration) *subscriptions {
+	if config.MaxSubscriptions <= 0 {
+		return nil
+	}
+
+	if limit := writeTimeout - time.Second; limit > 0 && (config.MaxDuration <= 0 || config.MaxDuration > limit) {
+		config.MaxDuration = limit
+	}
+
+	return &subscriptions{config: config}
+}
+
+// interval parses the interval of a subscription, a duration such as "30s"
+// or a number of seconds.
+func (s *subscriptions) interval(str string) (time.Duration, error) {
+	interval := s.config.Interval
+	if str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			secs, err := strconv.Atoi(str)
+			if err != nil {
+				return 0, fmt.Errorf("invalid interval %q", str)
+			}
+			d = time.Duration(secs) * time.Second
+		}
+		interval = d
+	}
+
+	if interval < s.config.MinInterval {
+		interval = s.config.MinInterval
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return interval, nil
+}
+
+// enter counts a new subscription, unless there are MaxSubscriptions
+// already.
+func (s *subscriptions) enter() bool {
+	if atomic.AddInt64(&s.active, 1) > int64(s.config.MaxSubscriptions) {
+		atomic.AddInt64(&s.active, -1)
+		return false
+	}
+	apiMetrics.Subscriptions.Add(1)
+
+	return true
+}
+
+func (s *subscriptions) leave() {
+	atomic.AddInt64(&s.active, -1)
+}
+
+// subscriptionSeries is a series of a JSON render response.
+type subscriptionSeries struct {
+	Target     string        `json:"target"`
+	Datapoints [][2]*float64 `json:"datapoints"`
+}
+
+// subscription is the state of a subscription: its render request, and the
+// timestamp of the last point pushed of each series.
+type subscription struct {
+	render *http.Request
+	since  int64
+	last   map[string]int64
+}
+
+// newSubscription returns the subscription of r to the render request with
+// the parameters form, for the points after since.
+func newSubscription(r *http.Request, form url.Values, since int64) *subscription {
+	values := url.Values{}
+	for k, v := range form {
+		values[k] = v
+	}
+	for _, p := range []string{"interval", "jsonp", "maxDataPoints", "nullPolicy", "noNullPoints"} {
+		values.Del(p)
+	}
+	values.Set("format", jsonFormat)
+
+	return &subscription{
+		render: internalRequest(r, "/render/", values),
+		since:  since,
+		last:   make(map[string]int64),
+	}
+}
+
+// internalRequest returns a GET request of path with the parameters values,
+// served on behalf of r, with its context and headers.
+func internalRequest(r *http.Request, path string, values url.Values) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.URL = &url.URL{Path: path, RawQuery: values.Encode()}
+	req.RequestURI = req.URL.RequestURI()
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Form = nil
+	req.PostForm = nil
+	for _, h := range []string{"Content-Type", "If-None-Match", "Last-Event-ID", "Upgrade", "Connection"} {
+		req.Header.Del(h)
+	}
+
+	return req
+}
+
+// renderError is a failed render of a subscription.
+type renderError struct {
+	code    int
+	message string
+}
+
+func (e *renderError) Error() string {
+	return e.message
+}
+
+// poll renders the subscription, and returns the points of its series not
+// pushed yet, up to the last non-null point of each, and the timestamp of
+// the latest of them.
+func (sub *subscription) poll(app *App) ([]subscriptionSeries, int64, error) {
+	rw := &recordResponseWriter{header: make(http.Header), code: http.StatusOK}
+	app.renderHandler(rw, sub.render.Clone(sub.render.Context()))
+
+	if rw.code != http.StatusOK {
+		return nil, 0, &renderError{code: rw.code, message: string(bytes.TrimSpace(rw.body.Bytes()))}
+	}
+
+	var series []subscriptionSeries
+	if err := json.Unmarshal(rw.body.Bytes(), &series); err != nil {
+		return nil, 0, &renderError{code: http.StatusInternalServerError, message: err.Error()}
+	}
+
+	var updates []subscriptionSeries
+	var latest int64
+	for _, s := range series {
+		last, ok := sub.last[s.Target]
+		if !ok {
+			last = sub.since
+		}
+
+		end := -1
+		for i, p := range s.Datapoints {
+			if p[0] != nil && p[1] != nil && int64(*p[1]) > last {
+				end = i
+			}
+		}
+		if end < 0 {
+			continue
+		}
+
+		var points [][2]*float64
+		for _, p := range s.Datapoints[:end+1] {
+			if p[1] != nil && int64(*p[1]) > last {
+				points = append(points, p)
+			}
+		}
+		ts := int64(*s.Datapoints[end][1])
+		sub.last[s.Target] = ts
+		if ts > latest {
+			latest = ts
+		}
+		updates = append(updates, subscriptionSeries{Target: s.Target, Datapoints: points})
+	}
+
+	return updates, latest, nil
+}
+
+// subscribeHandler serves a live render subscription. It takes the
+// parameters of a render request and an interval, and the render request is
+// rendered every interval from then on. The points of each series newer than
+// those already pushed are sent as an event whose data is a JSON render
+// response, and whose id is the timestamp of the latest point. The trailing
+// null points of a series are held back, as the backends may not have
+// received them yet. A client that reconnects with a Last-Event-ID header
+// gets the points after it.
+func (app *App) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	s := app.subscriptions
+
+	if err := r.ParseForm(); err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Form["target"]) == 0 {
+		util.HTTPError(w, r, "missing target", http.StatusBadRequest)
+		return
+	}
+	interval, err := s.interval(r.FormValue("interval"))
+	if err != nil {
+		util.HTTPError(w, r, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		util.HTTPError(w, r, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.enter() {
+		util.HTTPError(w, r, "too many subscriptions", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.leave()
+
+	since, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub := newSubscription(r, r.Form, since)
+	events := &eventStream{w: w, flusher: flusher}
+
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the events.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	events.write([]byte(fmt.Sprintf("retry: %d\n\n", interval.Milliseconds())))
+
+	var end <-chan time.Time
+	if s.config.MaxDuration > 0 {
+		timer := time.NewTimer(s.config.MaxDuration)
+		defer timer.Stop()
+		end = timer.C
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		updates, latest, err := sub.poll(app)
+		if err != nil {
+			events.event("renderError", "", []byte(err.Error()))
+			// Only server errors may go away.
+			if err.(*renderError).code < 500 {
+				return
+			}
+		} else if len(updates) == 0 {
+			events.write([]byte(": keep-alive\n\n"))
+		} else if b, err := json.Marshal(updates); err == nil {
+			events.event("", strconv.FormatInt(latest, 10), b)
+			apiMetrics.SubscriptionPushes.Add(1)
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventStream writes Server-Sent Events.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// event sends an event of type typ, unless empty, with the id, unless
+// empty, and the single-line data.
+func (s *eventStream) event(typ, id string, data []byte) {
+	var b []byte
+	if typ != "" {
+		b = append(b, "event: "+typ+"\n"...)
+	}
+	if id != "" {
+		b = append(b, "id: "+id+"\n"...)
+	}
+	b = append(b, "data: "...)
+	b = append(b, bytes.Replace(data, []byte("\n"), []byte(" "), -1)...)
+	b = append(b, "\n\n"...)
+
+	s.write(b)
+}
+
+func (s *eventStream) write(b []byte) {
+	s.w.Write(b)
+	s.flusher.Flush()
+}
+
+// recordResponseWriter keeps the status code and body of a response.
+type recordResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *recordResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *recordResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *recordResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
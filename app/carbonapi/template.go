@@ -0,0 +1,61 @@
+package carbonapi
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// templateVariables returns the template[name]=value parameters of form, as
+// in graphite-web, keyed by name. The template parameter itself names the
+// graph template of png and svg responses instead.
+func templateVariables(form url.Values) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range form {
+		if len(v) == 0 || !strings.HasPrefix(k, "template[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+
+		name := k[len("template[") : len(k)-1]
+		if name == "" {
+			continue
+		}
+		vars[name] = v[0]
+	}
+
+	return vars
+}
+
+// substituteTemplate returns targets with $name replaced by the value of
+// each template variable, leaving targets itself unchanged.
+func substituteTemplate(targets []string, vars map[string]string) []string {
+	if len(vars) == 0 {
+		return targets
+	}
+
+	// The longest names are tried first, so that $hostname isn't taken for
+	// $host followed by "name".
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		oldnew = append(oldnew, "$"+name, vars[name])
+	}
+	replacer := strings.NewReplacer(oldnew...)
+
+	substituted := make([]string, 0, len(targets))
+	for _, target := range targets {
+		substituted = append(substituted, replacer.Replace(target))
+	}
+
+	return substituted
+}
@@ -0,0 +1,297 @@
+package carbonapi
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// topQueriesDefault is the number of entries of each list returned by the
+// top queries handler, unless asked for another one with n.
+const topQueriesDefault = 20
+
+// topQueries keeps the most frequent and most expensive render targets, and
+// the most fetched metrics, of the current and previous windows, so that
+// capacity planning can tell which dashboards deserve caching or
+// pre-aggregation.
+type topQueries struct {
+	mu        sync.Mutex
+	window    time.Duration
+	frequent  *rollingSketch // Render targets, by requests.
+	expensive *rollingSketch // Render targets, by runtime in seconds.
+	metrics   *rollingSketch // Metrics, by requests.
+	now       func() time.Time
+}
+
+// newTopQueries returns nil if tracking top queries isn't configured.
+func newTopQueries(config cfg.TopQueriesConfig) *topQueries {
+	if config.Capacity <= 0 {
+		return nil
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+
+	return &topQueries{
+		window:    config.Window,
+		frequent:  newRollingSketch(config.Capacity, config.Window),
+		expensive: newRollingSketch(config.Capacity, config.Window),
+		metrics:   newRollingSketch(config.Capacity, config.Window),
+		now:       time.Now,
+	}
+}
+
+// record counts the targets and metrics of a served render request. A nil
+// topQueries does nothing.
+func (t *topQueries) record(details *carbonapipb.AccessLogDetails) {
+	if t == nil || details.Handler != "render" || len(details.Targets) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	// The runtime of a request is shared by its targets.
+	runtime := details.Runtime / float64(len(details.Targets))
+	for _, target := range details.Targets {
+		t.frequent.add(now, target, 1)
+		t.expensive.add(now, target, runtime)
+	}
+	for _, metric := range details.Metrics {
+		t.metrics.add(now, metric, 1)
+	}
+}
+
+type topQueriesResponse struct {
+	Window           string     `json:"window"`
+	Since            int64      `json:"since"`
+	FrequentTargets  []topEntry `json:"frequentTargets"`
+	ExpensiveTargets []topEntry `json:"expensiveTargets"`
+	HotMetrics       []topEntry `json:"hotMetrics"`
+}
+
+// top returns the n most frequent and expensive targets and hot metrics
+// since the start of the previous window.
+func (t *topQueries) top(n int) topQueriesResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	return topQueriesResponse{
+		Window:           t.window.String(),
+		Since:            t.frequent.start(now).Unix(),
+		FrequentTargets:  t.frequent.top(now, n),
+		ExpensiveTargets: t.expensive.top(now, n),
+		HotMetrics:       t.metrics.top(now, n),
+	}
+}
+
+// topQueriesHandler returns the most frequent and expensive render targets
+// and the hottest metrics as JSON.
+func (app *App) topQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	apiMetrics.Requests.Add(1)
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "topQueries", &app.config)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	n := topQueriesDefault
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			util.HTTPError(w, r, "n must be a positive integer", http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = "n must be a positive integer"
+			logAsError = true
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(app.topQueries.top(n))
+}
+
+// topEntry is a key of a sketch with its count, which may be overestimated
+// by up to Error.
+type topEntry struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Error float64 `json:"error,omitempty"`
+}
+
+// topOf returns the n entries with the largest counts summed over sketches.
+func topOf(n int, sketches ...*sketch) []topEntry {
+	sums := make(map[string]*topEntry)
+	for _, s := range sketches {
+		for key, e := range s.entries {
+			sum, ok := sums[key]
+			if !ok {
+				sum = &topEntry{Name: key}
+				sums[key] = sum
+			}
+			sum.Value += e.count
+			sum.Error += e.err
+		}
+	}
+
+	entries := make([]topEntry, 0, len(sums))
+	for _, e := range sums {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	return entries
+}
+
+// rollingSketch counts keys over the current and previous windows, in a
+// sketch each. It isn't safe for concurrent use.
+type rollingSketch struct {
+	capacity int
+	window   time.Duration
+	since    time.Time // The start of the current window, zero until used.
+	current  *sketch
+	previous *sketch
+}
+
+func newRollingSketch(capacity int, window time.Duration) *rollingSketch {
+	return &rollingSketch{
+		capacity: capacity,
+		window:   window,
+		current:  newSketch(capacity),
+		previous: newSketch(capacity),
+	}
+}
+
+// rotate starts a new window if the current one is over at now.
+func (s *rollingSketch) rotate(now time.Time) {
+	if s.since.IsZero() {
+		s.since = now
+		return
+	}
+
+	elapsed := now.Sub(s.since)
+	if elapsed < s.window {
+		return
+	}
+
+	if elapsed < 2*s.window {
+		s.previous = s.current
+	} else {
+		s.previous = newSketch(s.capacity)
+	}
+	s.current = newSketch(s.capacity)
+	s.since = now.Add(-elapsed % s.window)
+}
+
+// add adds weight to the count of key at now.
+func (s *rollingSketch) add(now time.Time, key string, weight float64) {
+	s.rotate(now)
+	s.current.add(key, weight)
+}
+
+// top returns the n keys with the largest counts over both windows at now.
+func (s *rollingSketch) top(now time.Time, n int) []topEntry {
+	s.rotate(now)
+	return topOf(n, s.previous, s.current)
+}
+
+// start returns the start of the previous window at now.
+func (s *rollingSketch) start(now time.Time) time.Time {
+	s.rotate(now)
+	return s.since.Add(-s.window)
+}
+
+// sketch counts the keys with the largest counts in bounded memory, with the
+// space-saving algorithm: once full, a new key replaces the key with the
+// smallest count, and starts from it, which is its error.
+type sketch struct {
+	capacity int
+	entries  map[string]*sketchEntry
+	heap     sketchHeap
+}
+
+type sketchEntry struct {
+	key   string
+	count float64
+	err   float64
+	index int
+}
+
+func newSketch(capacity int) *sketch {
+	return &sketch{
+		capacity: capacity,
+		entries:  make(map[string]*sketchEntry, capacity),
+	}
+}
+
+// add adds weight to the count of key.
+func (s *sketch) add(key string, weight float64) {
+	if e, ok := s.entries[key]; ok {
+		e.count += weight
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		e := &sketchEntry{key: key, count: weight}
+		s.entries[key] = e
+		heap.Push(&s.heap, e)
+		return
+	}
+
+	e := s.heap[0]
+	delete(s.entries, e.key)
+	e.key = key
+	e.err = e.count
+	e.count += weight
+	s.entries[key] = e
+	heap.Fix(&s.heap, 0)
+}
+
+// sketchHeap is a min-heap of sketch entries by count.
+type sketchHeap []*sketchEntry
+
+func (h sketchHeap) Len() int           { return len(h) }
+func (h sketchHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h sketchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *sketchHeap) Push(x interface{}) {
+	e := x.(*sketchEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *sketchHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,64 @@
+package carbonapi
+
+import (
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// verboseLog records the full details of a sample of the requests, and of
+// some of the slow ones, to the "verbose" logger, if enabled. It must be
+// called after deferredAccessLogging, which sets the runtime of details.
+func (app *App) verboseLog(w http.ResponseWriter, r *http.Request, details *carbonapipb.AccessLogDetails) {
+	c := app.config.VerboseLogging
+
+	var reason string
+	if c.SlowThreshold > 0 && details.Runtime >= c.SlowThreshold.Seconds() {
+		every := c.EveryNthSlow
+		if every < 1 {
+			every = 1
+		}
+		if atomic.AddInt64(&app.slowRequests, 1)%every == 0 {
+			reason = "slow"
+		}
+	}
+	if reason == "" && c.SampleRate > 0 && rand.Float64() < c.SampleRate {
+		reason = "sampled"
+	}
+	if reason == "" {
+		return
+	}
+
+	zapwriter.Logger("verbose").Info("request details",
+		zap.String("reason", reason),
+		zap.Any("data", *details),
+		zap.Duration("runtime", time.Duration(details.Runtime*float64(time.Second))),
+		zap.String("request_method", r.Method),
+		zap.String("request_uri", r.URL.RequestURI()),
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.Any("request_headers", app.loggedHeaders(r.Header)),
+		zap.Any("form", r.Form),
+		zap.Any("response_headers", w.Header()),
+	)
+}
+
+// loggedHeaders returns headers without the credentials they may carry.
+func (app *App) loggedHeaders(headers http.Header) http.Header {
+	logged := make(http.Header, len(headers))
+	for k, v := range headers {
+		logged[k] = v
+	}
+
+	logged.Del("Authorization")
+	logged.Del("Cookie")
+	if app.config.Audit.APIKeyHeader != "" {
+		logged.Del(app.config.Audit.APIKeyHeader)
+	}
+
+	return logged
+}
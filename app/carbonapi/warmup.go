@@ -0,0 +1,213 @@
+package carbonapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// warmUpWindow is how long the render requests learned by a warmUp are
+// counted over, and warmUpTick how often it checks for requests to render.
+const (
+	warmUpWindow = time.Hour
+	warmUpTick   = time.Second
+)
+
+// warmUpKey marks the context of the render requests of a warmUp, so that
+// they aren't learned as popular.
+type warmUpKey struct{}
+
+// warmUp renders popular render requests into the response cache shortly
+// before their cached responses expire: those configured, and those learned
+// from the requests served.
+type warmUp struct {
+	queries        []string
+	learn          int
+	ahead          time.Duration
+	defaultTimeout int32
+
+	mu      sync.Mutex
+	learned *rollingSketch // The cache keys of render requests, by requests.
+
+	// due is when each request warmed up is rendered next. It's only used
+	// by run.
+	due map[string]time.Time
+	now func() time.Time
+}
+
+// newWarmUp returns nil if there is nothing to warm up.
+func newWarmUp(config cfg.WarmUpConfig, defaultTimeout int32) (*warmUp, error) {
+	if len(config.Queries) == 0 && config.Learn <= 0 {
+		return nil, nil
+	}
+
+	w := &warmUp{
+		queries:        make([]string, 0, len(config.Queries)),
+		learn:          config.Learn,
+		ahead:          config.Ahead,
+		defaultTimeout: defaultTimeout,
+		due:            make(map[string]time.Time),
+		now:            time.Now,
+	}
+	for _, q := range config.Queries {
+		values, err := url.ParseQuery(q)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad warm-up query '%s'", q)
+		}
+		w.queries = append(w.queries, renderCacheKey(values))
+	}
+	if w.learn > 0 {
+		capacity := 10 * w.learn
+		if capacity < 100 {
+			capacity = 100
+		}
+		w.learned = newRollingSketch(capacity, warmUpWindow)
+	}
+
+	return w, nil
+}
+
+// renderCacheKey returns the response cache key of a render request with
+// the parameters values, which it changes. They're stripped of those that
+// don't change the response, like the render handler does.
+func renderCacheKey(values url.Values) string {
+	for _, p := range []string{"noCache", "jsonp", "_salt", "_ts", "_t"} {
+		values.Del(p)
+	}
+
+	return values.Encode()
+}
+
+// seen counts a render request with the cache key key, unless it's one of
+// those of a warmUp. A nil warmUp does nothing.
+func (w *warmUp) seen(ctx context.Context, key string) {
+	if w == nil || w.learned == nil || ctx.Value(warmUpKey{}) != nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.learned.add(w.now(), key, 1)
+	w.mu.Unlock()
+}
+
+// keys returns the cache keys of the render requests to warm up.
+func (w *warmUp) keys() []string {
+	keys := append([]string{}, w.queries...)
+	if w.learned == nil {
+		return keys
+	}
+
+	w.mu.Lock()
+	top := w.learned.top(w.now(), w.learn)
+	w.mu.Unlock()
+
+	for _, e := range top {
+		keys = append(keys, e.Name)
+	}
+
+	return keys
+}
+
+// run warms up the cache until the process exits.
+func (w *warmUp) run(app *App, logger *zap.Logger) {
+	ticker := time.NewTicker(warmUpTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.warm(app, logger)
+	}
+}
+
+// warm renders the requests to warm up whose cached responses expire
+// within ahead, or which were never rendered.
+func (w *warmUp) warm(app *App, logger *zap.Logger) {
+	keys := w.keys()
+	warmed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		warmed[key] = true
+
+		if due, ok := w.due[key]; ok && w.now().Before(due) {
+			continue
+		}
+
+		w.render(app, logger, key)
+		interval := time.Duration(w.timeout(key))*time.Second - w.ahead
+		if interval < warmUpTick {
+			interval = warmUpTick
+		}
+		w.due[key] = w.now().Add(interval)
+	}
+
+	for key := range w.due {
+		if !warmed[key] {
+			delete(w.due, key)
+		}
+	}
+}
+
+// timeout returns the cache timeout of the render request with the cache key
+// key, in seconds.
+func (w *warmUp) timeout(key string) int32 {
+	values, err := url.ParseQuery(key)
+	if err != nil {
+		return w.defaultTimeout
+	}
+
+	t, err := strconv.Atoi(values.Get("cacheTimeout"))
+	if err != nil {
+		return w.defaultTimeout
+	}
+
+	return int32(t)
+}
+
+// render renders the request with the cache key key into the cache.
+func (w *warmUp) render(app *App, logger *zap.Logger, key string) {
+	req, err := http.NewRequest(http.MethodGet, "/render/?"+key+"&noCache=1", nil)
+	if err != nil {
+		logger.Warn("Cache warm-up failed",
+			zap.String("query", key),
+			zap.Error(err),
+		)
+		return
+	}
+
+	ctx := context.WithValue(util.WithUUID(context.Background()), warmUpKey{}, true)
+	rw := &discardResponseWriter{header: make(http.Header), code: http.StatusOK}
+	app.renderHandler(rw, req.WithContext(ctx))
+	apiMetrics.CacheWarmUps.Add(1)
+
+	if rw.code >= 400 {
+		logger.Warn("Cache warm-up failed",
+			zap.String("query", key),
+			zap.Int("http_code", rw.code),
+		)
+	}
+}
+
+// discardResponseWriter keeps the status code of a response, and discards
+// the rest.
+type discardResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
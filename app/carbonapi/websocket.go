@@ -0,0 +1,326 @@
+package carbonapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/websocket"
+	"github.com/bookingcom/carbonapi/util"
+	"github.com/gorilla/handlers"
+)
+
+// wsRequest is a message of a WebSocket client: a find, render or subscribe
+// request with the parameters of the query string Query, or the
+// unsubscription of the subscription ID. The responses to a request have the
+// ID chosen by the client.
+type wsRequest struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+// wsResponse is a response to a wsRequest: the HTTP status and the JSON
+// response of a find or render request, or the new points of a
+// subscription. A subscription ends with a Done response.
+type wsResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Done   bool            `json:"done,omitempty"`
+}
+
+// webSockets serves the find, render and subscribe requests of WebSocket
+// clients, so that a page issuing many small requests needs one connection.
+type webSockets struct {
+	config cfg.WebSocketConfig
+	active int64 // Accessed atomically.
+}
+
+// newWebSockets returns nil if WebSockets are disabled.
+func newWebSockets(config cfg.WebSocketConfig) *webSockets {
+	if config.MaxConnections <= 0 {
+		return nil
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = websocket.DefaultMaxMessageSize
+	}
+
+	return &webSockets{config: config}
+}
+
+// allowedOrigin reports whether a WebSocket may be opened by a page of the
+// origin of r: one of AllowedOrigins, or the origin of carbonapi. Clients
+// that aren't browsers send no origin.
+func (ws *webSockets) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, o := range ws.config.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// wsConn is a WebSocket of a client, and its subscriptions.
+type wsConn struct {
+	app  *App
+	conn *websocket.Conn
+	// r is the request that opened the connection, whose context ends with
+	// it.
+	r     *http.Request
+	slots chan struct{}
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+}
+
+// webSocketHandler serves the requests of a WebSocket client, each as it
+// comes, until the client closes the connection. Find and render requests
+// are served as the /metrics/find and /render handlers would, and their
+// JSON responses sent back. The points of subscriptions are sent as the
+// /subscribe handler would send them.
+func (app *App) webSocketHandler(w http.ResponseWriter, r *http.Request) {
+	ws := app.webSockets
+	if !ws.allowedOrigin(r) {
+		util.HTTPError(w, r, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if atomic.AddInt64(&ws.active, 1) > int64(ws.config.MaxConnections) {
+		atomic.AddInt64(&ws.active, -1)
+		util.HTTPError(w, r, "too many websockets", http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt64(&ws.active, -1)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.MaxMessageSize = ws.config.MaxMessageSize
+	apiMetrics.WebSockets.Add(1)
+
+	// The context of a hijacked request doesn't end with the connection.
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &wsConn{
+		app:   app,
+		conn:  conn,
+		r:     r.WithContext(ctx),
+		slots: make(chan struct{}, ws.config.MaxInFlight),
+		subs:  make(map[string]context.CancelFunc),
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		apiMetrics.WebSocketRequests.Add(1)
+
+		var req wsRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			c.send(wsResponse{Status: http.StatusBadRequest, Error: "invalid request: " + err.Error()})
+			continue
+		}
+		values, err := url.ParseQuery(req.Query)
+		if err != nil {
+			c.send(wsResponse{ID: req.ID, Status: http.StatusBadRequest, Error: "invalid query: " + err.Error()})
+			continue
+		}
+
+		switch req.Type {
+		case "unsubscribe":
+			c.unsubscribe(req.ID)
+			continue
+		case "subscribe":
+			c.subscribe(&wg, req.ID, values)
+			continue
+		case "find", "render":
+		default:
+			c.send(wsResponse{ID: req.ID, Status: http.StatusBadRequest, Error: "unknown request type " + req.Type})
+			continue
+		}
+
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			c.send(wsResponse{ID: req.ID, Status: http.StatusTooManyRequests, Error: "too many requests in flight"})
+			continue
+		}
+		wg.Add(1)
+		go func(req wsRequest) {
+			defer wg.Done()
+			defer func() { <-c.slots }()
+
+			c.serve(req, values)
+		}(req)
+	}
+}
+
+// serve serves a find or render request, with a JSON response.
+func (c *wsConn) serve(req wsRequest, values url.Values) {
+	values.Del("jsonp")
+
+	rw := &recordResponseWriter{header: make(http.Header), code: http.StatusOK}
+	if req.Type == "find" {
+		if values.Get("format") != "completer" {
+			values.Set("format", treejsonFormat)
+		}
+		c.app.findHandler(rw, internalRequest(c.r, "/metrics/find/", values))
+	} else {
+		values.Set("format", jsonFormat)
+		c.app.renderHandler(rw, internalRequest(c.r, "/render/", values))
+	}
+
+	resp := wsResponse{ID: req.ID, Status: rw.code}
+	body := bytes.TrimSpace(rw.body.Bytes())
+	if json.Valid(body) {
+		resp.Data = body
+	} else {
+		resp.Error = string(body)
+	}
+	c.send(resp)
+}
+
+// subscribe starts the subscription id to the render request with the
+// parameters values, whose points are sent every interval until it's
+// unsubscribed.
+func (c *wsConn) subscribe(wg *sync.WaitGroup, id string, values url.Values) {
+	s := c.app.subscriptions
+	if s == nil {
+		c.send(wsResponse{ID: id, Status: http.StatusNotImplemented, Error: "subscriptions are not enabled"})
+		return
+	}
+	if len(values["target"]) == 0 {
+		c.send(wsResponse{ID: id, Status: http.StatusBadRequest, Error: "missing target"})
+		return
+	}
+	interval, err := s.interval(values.Get("interval"))
+	if err != nil {
+		c.send(wsResponse{ID: id, Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+
+	c.mu.Lock()
+	_, dup := c.subs[id]
+	c.mu.Unlock()
+	if dup {
+		c.send(wsResponse{ID: id, Status: http.StatusBadRequest, Error: "duplicate subscription id"})
+		return
+	}
+	if !s.enter() {
+		c.send(wsResponse{ID: id, Status: http.StatusServiceUnavailable, Error: "too many subscriptions"})
+		return
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.config.MaxDuration > 0 {
+		ctx, cancel = context.WithTimeout(c.r.Context(), s.config.MaxDuration)
+	} else {
+		ctx, cancel = context.WithCancel(c.r.Context())
+	}
+	c.mu.Lock()
+	c.subs[id] = cancel
+	c.mu.Unlock()
+
+	sub := newSubscription(c.r.WithContext(ctx), values, 0)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.leave()
+		defer func() {
+			c.mu.Lock()
+			delete(c.subs, id)
+			c.mu.Unlock()
+			cancel()
+			c.send(wsResponse{ID: id, Done: true})
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			updates, _, err := sub.poll(c.app)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				code := err.(*renderError).code
+				c.send(wsResponse{ID: id, Status: code, Error: err.Error()})
+				// Only server errors may go away.
+				if code < 500 {
+					return
+				}
+			} else if len(updates) != 0 {
+				if b, err := json.Marshal(updates); err == nil {
+					c.send(wsResponse{ID: id, Status: http.StatusOK, Data: b})
+					apiMetrics.SubscriptionPushes.Add(1)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// unsubscribe ends the subscription id.
+func (c *wsConn) unsubscribe(id string) {
+	c.mu.Lock()
+	cancel, ok := c.subs[id]
+	c.mu.Unlock()
+
+	if !ok {
+		c.send(wsResponse{ID: id, Status: http.StatusNotFound, Error: "no such subscription"})
+		return
+	}
+	cancel()
+}
+
+func (c *wsConn) send(resp wsResponse) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		b, _ = json.Marshal(wsResponse{ID: resp.ID, Status: http.StatusInternalServerError, Error: err.Error()})
+	}
+
+	c.conn.WriteMessage(b)
+}
+
+// compressHandler compresses the responses of h as handlers.CompressHandler
+// does, except for WebSocket upgrades, whose connections are hijacked.
+func compressHandler(h http.Handler) http.Handler {
+	compressed := handlers.CompressHandler(h)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsUpgrade(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		compressed.ServeHTTP(w, r)
+	})
+}
@@ -2,14 +2,13 @@ package carbonapi
 
 import (
 	"context"
-	"errors"
-	"fmt"
 
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/expr/types"
 	realZipper "github.com/bookingcom/carbonapi/zipper"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -27,7 +26,14 @@ type zipper struct {
 type CarbonZipper interface {
 	Find(ctx context.Context, metric string) (pb.GlobResponse, error)
 	Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error)
-	Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error)
+	// Render fetches the series of metric, downsampled with the
+	// consolidation function consolidateBy if it's set.
+	Render(ctx context.Context, metric string, from, until int32, consolidateBy string) ([]*types.MetricData, error)
+	// Backends returns the backends a render request for metric is sent to.
+	Backends(metric string) []string
+	// HasPath reports whether the backends of metric are known, so that a
+	// render request for it needs no find first.
+	HasPath(metric string) bool
 }
 
 func newZipper(sender func(*realZipper.Stats), config cfg.Zipper, logger *zap.Logger) *zipper {
@@ -58,7 +64,7 @@ func (z zipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error
 func (z zipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
 	resp, stats, err := z.z.Info(ctx, z.logger, metric)
 	if err != nil {
-		return nil, fmt.Errorf("http.Get: %+v", err)
+		return nil, errors.Wrap(err, "http.Get")
 	}
 
 	z.statsSender(stats)
@@ -66,9 +72,9 @@ func (z zipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResp
 	return resp, nil
 }
 
-func (z zipper) Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error) {
+func (z zipper) Render(ctx context.Context, metric string, from, until int32, consolidateBy string) ([]*types.MetricData, error) {
 	var result []*types.MetricData
-	pbresp, stats, err := z.z.Render(ctx, z.logger, metric, from, until)
+	pbresp, stats, err := z.z.Render(ctx, z.logger, metric, from, until, consolidateBy)
 	if err != nil {
 		return result, err
 	}
@@ -85,3 +91,11 @@ func (z zipper) Render(ctx context.Context, metric string, from, until int32) ([
 
 	return result, nil
 }
+
+func (z zipper) Backends(metric string) []string {
+	return z.z.Backends(metric)
+}
+
+func (z zipper) HasPath(metric string) bool {
+	return z.z.HasPath(metric)
+}
@@ -16,7 +16,6 @@ import (
 	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
 	"github.com/pkg/errors"
 	"os"
-	"github.com/peterbourgon/g2g"
 	"strings"
 	"fmt"
 	"log"
@@ -25,36 +24,247 @@ import (
 	"github.com/facebookgo/grace/gracehttp"
 	"sync/atomic"
 	"github.com/bookingcom/carbonapi/cfg"
-	"net"
+	"github.com/bookingcom/carbonapi/pkg/carbonconf"
+	"net/url"
+	"regexp"
 	"strconv"
+	"syscall"
 )
 
 var BuildVersion string
 type App struct {
-	config   cfg.Zipper
-	backends []backend.Backend
+	config cfg.Zipper
+	// backends are the groups of backends requests are sent to, whose
+	// hostnames may be expanded to their addresses
+	backends *backendSet
+	pools    *bnet.Pools
+	budgets  *bnet.ErrorBudgets
+	// limits adapt the concurrency limits of the backends, if enabled
+	limits *bnet.AdaptiveLimits
+	// sanitizer replaces the NaN and ±Inf points of the backends, if enabled
+	sanitizer *bnet.Sanitizer
+	// logLevels change the log levels at runtime
+	logLevels *util.LogLevels
+	// partialResults is what to do when some backends of a request fail
+	partialResults partialResults
+	// shadow mirrors some requests to a canary backend group, if any
+	shadow *shadow
+	// schemas clamp the ranges of render requests, if set
+	schemas carbonconf.Schemas
+	// readiness tells whether enough backends answer to serve
+	readiness *readiness
 }
 
 func New(config cfg.Zipper,logger *zap.Logger, buildVersion string) (*App, error) {
 	BuildVersion = buildVersion
-	bs, err := initBackends(config, logger)
+	pools := bnet.NewPools()
+	client, err := newClient(config, pools)
 	if err != nil {
 		logger.Fatal("Failed to initialize backends",
 			zap.Error(err),
 		)
 		return nil, err
 	}
-	app := App{config: config, backends:bs}
+	budgets := bnet.NewErrorBudgets(bnet.ErrorBudgetConfig{
+		Window:       config.ErrorBudget.Window,
+		MaxErrorRate: config.ErrorBudget.MaxErrorRate,
+		MinRequests:  config.ErrorBudget.MinRequests,
+		Logger:       logger,
+	})
+	faults := newFaults(config.FaultInjection, logger)
+	limits := newAdaptiveLimits(config)
+	sanitizer := newSanitizer(config.Sanitize)
+	backends := newBackendSet(config, func(hosts []string, zipper bool) ([]backend.Backend, error) {
+		return initBackends(config, hosts, zipper, client, pools, budgets, faults, limits, sanitizer, logger)
+	}, logger)
+	backends.resolve()
+	if _, err := backends.build(); err != nil {
+		logger.Fatal("Failed to initialize backends",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	policy, err := types.ParseMergePolicy(config.Merge.Policy)
+	if err != nil {
+		logger.Fatal("Failed to parse merge policy",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	types.SetMergePolicy(policy, backends.primary()...)
+
+	consolidation, err := types.ParseConsolidation(config.Merge.Consolidation)
+	if err != nil {
+		logger.Fatal("Failed to parse merge consolidation function",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	types.SetStepNormalization(config.Merge.NormalizeSteps, consolidation)
+	types.SetSkewTolerance(int32(config.Merge.Skew / time.Second))
+
+	rules, err := consolidationRules(config.Merge.ConsolidationRules)
+	if err != nil {
+		logger.Fatal("Failed to parse consolidation rules",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	if config.StorageAggregation != "" {
+		aggregations, err := carbonconf.ReadAggregations(config.StorageAggregation)
+		if err != nil {
+			logger.Fatal("Failed to read storage aggregation",
+				zap.String("path", config.StorageAggregation),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		aggregationRules, err := aggregationRules(aggregations)
+		if err != nil {
+			logger.Fatal("Failed to convert storage aggregation",
+				zap.String("path", config.StorageAggregation),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		rules = append(rules, aggregationRules...)
+	}
+	types.SetConsolidationRules(rules)
+
+	var schemas carbonconf.Schemas
+	if config.StorageSchemas != "" {
+		schemas, err = carbonconf.ReadSchemas(config.StorageSchemas)
+		if err != nil {
+			logger.Fatal("Failed to read storage schemas",
+				zap.String("path", config.StorageSchemas),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		types.SetSchemaSteps(func(name string, start int32) int32 {
+			return schemas.Step(name, start, int32(time.Now().Unix()))
+		})
+	}
+
+	var sh *shadow
+	if len(config.Shadow.Backends) > 0 {
+		sbs, err := initBackends(config, config.Shadow.Backends, false, client, pools, budgets, faults, limits, sanitizer, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize shadow backends",
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		sh = &shadow{
+			backends:   sbs,
+			percentage: config.Shadow.Percentage,
+			diff:       config.Shadow.Diff,
+			tolerance:  config.Shadow.Tolerance,
+			timeout:    config.Timeouts.Render,
+			logger:     zapwriter.Logger("shadow"),
+		}
+	}
+
+	partial, err := parsePartialResults(config.PartialResults)
+	if err != nil {
+		logger.Fatal("Failed to parse partial results mode",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	app := App{config: config, backends: backends, pools: pools, budgets: budgets, limits: limits, sanitizer: sanitizer,
+		logLevels: util.NewLogLevels(config.Logger), partialResults: partial, shadow: sh, schemas: schemas,
+		readiness: newReadiness(func() []backend.Backend { return backends.current().serving() }, config.Readiness.Quorum)}
 	return &app, nil
 }
 
+func consolidationRules(config []cfg.ConsolidationRule) ([]types.ConsolidationRule, error) {
+	rules := make([]types.ConsolidationRule, 0, len(config))
+	for _, r := range config {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad pattern '%s'", r.Pattern)
+		}
+
+		c, err := types.ParseConsolidation(r.Function)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, types.ConsolidationRule{
+			Pattern:       pattern,
+			Consolidation: c,
+		})
+	}
+
+	return rules, nil
+}
+
+// aggregationMethods are the consolidation functions of the aggregation
+// methods of carbon. Those missing have no equivalent.
+var aggregationMethods = map[string]types.Consolidation{
+	"average": types.ConsolidateAverage,
+	"sum":     types.ConsolidateSum,
+	"min":     types.ConsolidateMin,
+	"max":     types.ConsolidateMax,
+	"last":    types.ConsolidateLast,
+}
+
+// aggregationRules converts the aggregations of storage-aggregation.conf to
+// consolidation rules, so that points are consolidated as carbon rolls
+// them up.
+func aggregationRules(aggregations carbonconf.Aggregations) ([]types.ConsolidationRule, error) {
+	rules := make([]types.ConsolidationRule, 0, len(aggregations))
+	for _, a := range aggregations {
+		c, ok := aggregationMethods[a.Method]
+		if !ok {
+			return nil, errors.Errorf("unsupported aggregation method '%s' of aggregation '%s'", a.Method, a.Name)
+		}
+
+		rules = append(rules, types.ConsolidationRule{
+			Pattern:       a.Pattern,
+			Consolidation: c,
+		})
+	}
+
+	return rules, nil
+}
+
+// backendHost strips the scheme from a backend address, so that it can be
+// compared to the host recorded by the backend.
+func backendHost(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
 func (app *App) Start() {
-	backends := app.backends
 	logger := zapwriter.Logger("zipper")
 	go func() {
+		// The zipper isn't ready before the first probe, which waits for the
+		// connections to the backends to be open.
+		prewarm(app.backends.current().serving(), app.config.Prewarm.Connections, app.config.Timeouts.Find, logger)
+
+		// The readiness probes the serving backends on its own interval,
+		// short enough for /ready to follow them.
+		go app.readiness.run(app.config.Readiness.Interval)
+
+		// The shadow backends don't serve, so they don't count for the
+		// readiness.
+		if app.shadow == nil {
+			return
+		}
 		probeTicker := time.NewTicker(5 * time.Minute)
 		for {
-			for _, b := range backends {
+			for _, b := range app.shadow.backends {
 				go b.Probe()
 			}
 			<-probeTicker.C
@@ -63,14 +273,7 @@ func (app *App) Start() {
 
 	types.SetCorruptionWatcher(app.config.CorruptionThreshold, logger)
 
-	// Should print nicer stack traces in case of unexpected panic.
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Fatal("Recovered from unhandled panic",
-				zap.Stack("stacktrace"),
-			)
-		}
-	}()
+	app.logLevels.ToggleDebugOn(syscall.SIGUSR2)
 
 	// +1 to track every over the number of buckets we track
 	timeBuckets = make([]int64, app.config.Buckets+1)
@@ -103,14 +306,55 @@ func (app *App) Start() {
 	Metrics.CacheItems = expvar.Func(func() interface{} { return app.config.PathCache.ECItems() })
 	expvar.Publish("cacheItems", Metrics.CacheItems)
 
+	// Pools are keyed by address, so make sure every backend shows up
+	// even before it is first dialed.
+	for _, b := range app.backends.current().serving() {
+		app.pools.Get(b.Address())
+		app.budgets.Get(b.Address())
+	}
+	for _, host := range app.config.Shadow.Backends {
+		app.pools.Get(host)
+		app.budgets.Get(host)
+	}
+	expvar.Publish("backendPools", expvar.Func(app.pools.Snapshot))
+	expvar.Publish("backendErrorRates", expvar.Func(app.budgets.Snapshot))
+	if app.limits != nil {
+		expvar.Publish("backendLimits", expvar.Func(app.limits.Snapshot))
+	}
+	if app.sanitizer != nil {
+		for _, host := range app.pools.Addresses() {
+			app.sanitizer.Get(host)
+		}
+		expvar.Publish("backendNonFinitePoints", expvar.Func(app.sanitizer.Snapshot))
+	}
+
 	r := http.NewServeMux()
 
 	r.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(app.findHandler, app.bucketRequestTimes)))
 	r.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(app.renderHandler, app.bucketRequestTimes)))
 	r.HandleFunc("/info/", httputil.TrackConnections(httputil.TimeHandler(app.infoHandler, app.bucketRequestTimes)))
-	r.HandleFunc("/lb_check", app.lbCheckHandler)
+	r.HandleFunc(app.config.LBCheck.Path, app.lbCheckHandler)
+	r.HandleFunc("/ready", app.readyHandler)
 
-	handler := util.UUIDHandler(r)
+	// A panic fails its request only.
+	handler := util.RecoverHandler(r, logger, func(*http.Request) {
+		Metrics.Panics.Add(1)
+	})
+	handler = util.CORSHandler(handler, app.config.CORS)
+	handler, err := util.ClientLimitHandler(handler, app.config.ClientLimit, func(*http.Request) {
+		Metrics.LimitedRequests.Add(1)
+	})
+	if err != nil {
+		logger.Fatal("Failed to parse the client limits",
+			zap.Error(err),
+		)
+	}
+	handler, err = util.AccessHandler(util.UUIDHandler(handler), app.config.AccessRules)
+	if err != nil {
+		logger.Fatal("Failed to parse the access rules",
+			zap.Error(err),
+		)
+	}
 
 	// nothing in the app.config? check the environment
 	if app.config.Graphite.Host == "" {
@@ -128,9 +372,11 @@ func (app *App) Start() {
 	}
 
 	// only register g2g if we have a graphite host
+	var graphite *util.Graphite
+	var pattern string
 	if app.config.Graphite.Host != "" {
 		// register our metrics with graphite
-		graphite := g2g.NewGraphite(app.config.Graphite.Host, app.config.Graphite.Interval, 10*time.Second)
+		graphite = util.NewGraphite(app.config.Graphite.Host, app.config.Graphite.Interval, 10*time.Second)
 
 		/* #nosec */
 		hostname, _ := os.Hostname()
@@ -138,7 +384,7 @@ func (app *App) Start() {
 
 		prefix := app.config.Graphite.Prefix
 
-		pattern := app.config.Graphite.Pattern
+		pattern = app.config.Graphite.Pattern
 		pattern = strings.Replace(pattern, "{prefix}", prefix, -1)
 		pattern = strings.Replace(pattern, "{fqdn}", hostname, -1)
 
@@ -156,6 +402,21 @@ func (app *App) Start() {
 		graphite.Register(fmt.Sprintf("%s.info_errors", pattern), Metrics.InfoErrors)
 
 		graphite.Register(fmt.Sprintf("%s.timeouts", pattern), Metrics.Timeouts)
+		graphite.Register(fmt.Sprintf("%s.responses_too_large", pattern), Metrics.ResponsesTooLarge)
+		graphite.Register(fmt.Sprintf("%s.partial_responses", pattern), Metrics.PartialResponses)
+		graphite.Register(fmt.Sprintf("%s.client_disconnects", pattern), Metrics.ClientDisconnects)
+		graphite.Register(fmt.Sprintf("%s.blackholed_requests", pattern), Metrics.BlackholedRequests)
+		graphite.Register(fmt.Sprintf("%s.fanout_rejected", pattern), Metrics.FanOutRejected)
+		graphite.Register(fmt.Sprintf("%s.limited_requests", pattern), Metrics.LimitedRequests)
+		graphite.Register(fmt.Sprintf("%s.clamped_requests", pattern), Metrics.ClampedRequests)
+		graphite.Register(fmt.Sprintf("%s.panics", pattern), Metrics.Panics)
+
+		graphite.Register(fmt.Sprintf("%s.shadow_requests", pattern), Metrics.ShadowRequests)
+		graphite.Register(fmt.Sprintf("%s.shadow_errors", pattern), Metrics.ShadowErrors)
+		graphite.Register(fmt.Sprintf("%s.shadow_diffs", pattern), Metrics.ShadowDiffs)
+		graphite.Register(fmt.Sprintf("%s.shadow_missing", pattern), Metrics.ShadowMissing)
+		graphite.Register(fmt.Sprintf("%s.shadow_extra", pattern), Metrics.ShadowExtra)
+		graphite.Register(fmt.Sprintf("%s.shadow_mismatched_points", pattern), Metrics.ShadowMismatchedPoints)
 
 		for i := 0; i <= app.config.Buckets; i++ {
 			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, i*100, (i+1)*100), bucketEntry(i))
@@ -168,6 +429,16 @@ func (app *App) Start() {
 
 		graphite.Register(fmt.Sprintf("%s.cache_hits", pattern), Metrics.CacheHits)
 		graphite.Register(fmt.Sprintf("%s.cache_misses", pattern), Metrics.CacheMisses)
+		graphite.Register(fmt.Sprintf("%s.cache_invalidations", pattern), Metrics.CacheInvalidations)
+
+		registerPools(graphite, pattern, app.pools, app.pools.Addresses())
+		registerErrorRates(graphite, pattern, app.budgets, app.budgets.Addresses())
+		if app.limits != nil {
+			registerAdaptiveLimits(graphite, pattern, app.limits, app.limits.Addresses())
+		}
+		if app.sanitizer != nil {
+			registerSanitizer(graphite, pattern, app.sanitizer, app.sanitizer.Addresses())
+		}
 
 		go mstats.Start(app.config.Graphite.Interval)
 
@@ -179,13 +450,21 @@ func (app *App) Start() {
 		graphite.Register(fmt.Sprintf("%s.pause_ns", pattern), &mstats.PauseNS)
 	}
 
+	if app.config.DNS.Expand && app.config.DNS.RefreshInterval > 0 {
+		app.backends.onAdded = func(added []backend.Backend) {
+			app.registerAdded(graphite, pattern, added)
+		}
+		go app.backends.refresh(app.config.DNS.RefreshInterval)
+	}
+
 	go func() {
 		prometheus.MustRegister(prometheusMetrics.Requests)
 		prometheus.MustRegister(prometheusMetrics.Responses)
 		prometheus.MustRegister(prometheusMetrics.DurationsExp)
 		prometheus.MustRegister(prometheusMetrics.DurationsLin)
+		registerPrometheusErrorRates(app.budgets, app.budgets.Addresses())
 
-		writeTimeout := app.config.Timeouts.Global
+		writeTimeout := app.config.Timeouts.Longest()
 		if writeTimeout < 30*time.Second {
 			writeTimeout = time.Minute
 		}
@@ -200,9 +479,21 @@ func (app *App) Start() {
 		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+		r.Handle("/debug/log-level", app.logLevels)
+
+		r.HandleFunc("/cache/invalidate/", app.invalidateHandler)
+		r.HandleFunc("/cache/invalidate", app.invalidateHandler)
+
+		internal, err := util.AccessHandler(r, app.config.AccessRules)
+		if err != nil {
+			logger.Fatal("Failed to parse the access rules",
+				zap.Error(err),
+			)
+		}
+
 		s := &http.Server{
 			Addr:         app.config.ListenInternal,
-			Handler:      r,
+			Handler:      internal,
 			ReadTimeout:  1 * time.Second,
 			WriteTimeout: writeTimeout,
 		}
@@ -214,12 +505,14 @@ func (app *App) Start() {
 		}
 	}()
 
-	err := gracehttp.Serve(&http.Server{
-		Addr:         app.config.Listen,
-		Handler:      handler,
-		ReadTimeout:  1 * time.Second,
-		WriteTimeout: app.config.Timeouts.Global,
-	})
+	server := app.config.Server.HTTPServer(app.config.Listen, handler, app.config.Timeouts.Longest())
+	if app.config.Server.DrainTimeout > 0 {
+		err = util.Serve(server, app.config.Server.DrainTimeout, logger, func() {
+			flushGraphite(graphite, logger)
+		})
+	} else {
+		err = gracehttp.Serve(server)
+	}
 
 	if err != nil {
 		log.Fatal("error during gracehttp.Serve()",
@@ -278,26 +571,45 @@ func (app *App) bucketRequestTimes(req *http.Request, t time.Duration) {
 	prometheusMetrics.DurationsLin.Observe(t.Seconds())
 }
 
-func initBackends(config cfg.Zipper, logger *zap.Logger) ([]backend.Backend, error) {
-	client := &http.Client{}
-	client.Transport = &http.Transport{
-		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
-		DialContext: (&net.Dialer{
-			Timeout:   config.Timeouts.Connect,
-			KeepAlive: config.KeepAliveInterval,
-			DualStack: true,
-		}).DialContext,
+// newClient creates the HTTP client shared by all backends.
+func newClient(config cfg.Zipper, pools *bnet.Pools) (*http.Client, error) {
+	transport, err := bnet.NewTransport(bnet.TransportConfig{
+		HTTP2:                 config.Transport.HTTP2,
+		ConnectTimeout:        config.Timeouts.Connect,
+		KeepAliveInterval:     config.KeepAliveInterval,
+		MaxIdleConnsPerHost:   config.Transport.MaxIdleConnsPerHost,
+		TLSHandshakeTimeout:   config.Transport.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.Transport.ResponseHeaderTimeout,
+		IdleConnTimeout:       config.Transport.IdleConnTimeout,
+		DNSRefreshInterval:    config.Transport.DNSRefreshInterval,
+		Pools:                 pools,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't create backend transport")
 	}
 
-	backends := make([]backend.Backend, 0, len(config.Backends))
-	for _, host := range config.Backends {
+	return &http.Client{Transport: transport}, nil
+}
+
+func initBackends(config cfg.Zipper, hosts []string, zipper bool, client *http.Client, pools *bnet.Pools, budgets *bnet.ErrorBudgets, faults *bnet.Faults, limits *bnet.AdaptiveLimits, sanitizer *bnet.Sanitizer, logger *zap.Logger) ([]backend.Backend, error) {
+	backends := make([]backend.Backend, 0, len(hosts))
+	for _, host := range hosts {
 		b, err := bnet.New(bnet.Config{
 			Address:            host,
 			Client:             client,
 			Timeout:            config.Timeouts.AfterStarted,
 			Limit:              config.ConcurrencyLimitPerServer,
 			PathCacheExpirySec: uint32(config.ExpireDelaySec),
+			PathCacheJitter:    config.ExpireJitter,
 			Logger:             logger,
+			Pools:              pools,
+			MaxResponseSize:    config.MaxResponseSize,
+			TooLarge:           Metrics.ResponsesTooLarge,
+			ErrorBudgets:       budgets,
+			Faults:             faults,
+			AdaptiveLimits:     limits,
+			Sanitizer:          sanitizer,
+			Zipper:             zipper,
 		})
 
 		if err != nil {
@@ -309,3 +621,157 @@ func initBackends(config cfg.Zipper, logger *zap.Logger) ([]backend.Backend, err
 
 	return backends, nil
 }
+
+// newSanitizer returns the sanitizer of the series of the backends, or nil
+// if they aren't sanitized.
+func newSanitizer(config cfg.Sanitize) *bnet.Sanitizer {
+	if !config.Enabled {
+		return nil
+	}
+
+	return bnet.NewSanitizer(bnet.SanitizerConfig{
+		Replacement: config.Replacement,
+	})
+}
+
+// newFaults returns the faults injected in the calls to the backends, or nil
+// if fault injection isn't enabled.
+func newFaults(config cfg.FaultInjection, logger *zap.Logger) *bnet.Faults {
+	if !config.Enabled {
+		return nil
+	}
+
+	faults := make(map[string]bnet.Fault, len(config.Backends))
+	for address, f := range config.Backends {
+		faults[address] = bnet.Fault{
+			Delay:           f.Delay,
+			DelayPercentage: f.DelayPercentage,
+			ErrorPercentage: f.ErrorPercentage,
+		}
+	}
+	logger.Warn("Injecting faults in the calls to backends",
+		zap.Any("faults", config.Backends),
+	)
+
+	return bnet.NewFaults(faults, logger)
+}
+
+// newAdaptiveLimits returns the adaptive concurrency limits of the backends,
+// or nil if they keep the static concurrencyLimit.
+func newAdaptiveLimits(config cfg.Zipper) *bnet.AdaptiveLimits {
+	if !config.AdaptiveConcurrency.Enabled {
+		return nil
+	}
+
+	return bnet.NewAdaptiveLimits(bnet.AdaptiveLimitConfig{
+		InitialLimit: config.ConcurrencyLimitPerServer,
+		MinLimit:     config.AdaptiveConcurrency.MinLimit,
+		MaxLimit:     config.AdaptiveConcurrency.MaxLimit,
+	})
+}
+
+// flushGraphite sends the last values of the statistics to graphite, if
+// it's configured.
+func flushGraphite(graphite *util.Graphite, logger *zap.Logger) {
+	if graphite == nil {
+		return
+	}
+	if err := graphite.Flush(); err != nil {
+		logger.Warn("Failed to flush the statistics to graphite",
+			zap.Error(err),
+		)
+	}
+}
+
+// registerAdded sends the statistics of the backends that the DNS refresh
+// added to graphite, if it's set, and exposes their error rates to
+// prometheus, as Start does for the backends it starts with.
+func (app *App) registerAdded(graphite *util.Graphite, pattern string, added []backend.Backend) {
+	addresses := make([]string, 0, len(added))
+	for _, b := range added {
+		addresses = append(addresses, b.Address())
+		app.pools.Get(b.Address())
+		app.budgets.Get(b.Address())
+		if app.sanitizer != nil {
+			app.sanitizer.Get(b.Address())
+		}
+	}
+
+	if graphite != nil {
+		registerPools(graphite, pattern, app.pools, addresses)
+		registerErrorRates(graphite, pattern, app.budgets, addresses)
+		if app.limits != nil {
+			registerAdaptiveLimits(graphite, pattern, app.limits, addresses)
+		}
+		if app.sanitizer != nil {
+			registerSanitizer(graphite, pattern, app.sanitizer, addresses)
+		}
+	}
+	registerPrometheusErrorRates(app.budgets, addresses)
+}
+
+// registerPools sends the connection pool statistics of the backends at
+// addresses to graphite, as <pattern>.backends.<host_port>.pool_<stat>.
+func registerPools(graphite *util.Graphite, pattern string, pools *bnet.Pools, addresses []string) {
+	for _, address := range addresses {
+		name := strings.NewReplacer(".", "_", ":", "_").Replace(address)
+		for stat, v := range pools.Get(address).Vars() {
+			graphite.Register(fmt.Sprintf("%s.backends.%s.pool_%s", pattern, name, stat), v)
+		}
+	}
+}
+
+// registerErrorRates sends the error rate of the backends at addresses to
+// graphite, as
+// <pattern>.backends.<host_port>.error_rate.
+func registerErrorRates(graphite *util.Graphite, pattern string, budgets *bnet.ErrorBudgets, addresses []string) {
+	for _, address := range addresses {
+		name := strings.NewReplacer(".", "_", ":", "_").Replace(address)
+		rate := budgets.Get(address)
+		graphite.Register(fmt.Sprintf("%s.backends.%s.error_rate", pattern, name), expvar.Func(func() interface{} {
+			return rate.Rate()
+		}))
+	}
+}
+
+// registerSanitizer sends the number of NaN and ±Inf points replaced in the
+// series of the backends at addresses to graphite, as
+// <pattern>.backends.<host_port>.non_finite_points.
+func registerSanitizer(graphite *util.Graphite, pattern string, sanitizer *bnet.Sanitizer, addresses []string) {
+	for _, address := range addresses {
+		name := strings.NewReplacer(".", "_", ":", "_").Replace(address)
+		graphite.Register(fmt.Sprintf("%s.backends.%s.non_finite_points", pattern, name), sanitizer.Get(address))
+	}
+}
+
+// registerAdaptiveLimits sends the concurrency limit of the backends at
+// addresses to graphite, as <pattern>.backends.<host_port>.concurrency_limit.
+func registerAdaptiveLimits(graphite *util.Graphite, pattern string, limits *bnet.AdaptiveLimits, addresses []string) {
+	for _, address := range addresses {
+		name := strings.NewReplacer(".", "_", ":", "_").Replace(address)
+		limiter := limits.Get(address)
+		graphite.Register(fmt.Sprintf("%s.backends.%s.concurrency_limit", pattern, name), expvar.Func(func() interface{} {
+			return limiter.Limit()
+		}))
+	}
+}
+
+// registerPrometheusErrorRates exposes the error rate of the backends at
+// addresses as the backend_error_rate gauge, labeled with the backend
+// address. A backend that was exposed before, as one that comes back to the
+// DNS records, keeps its gauge.
+func registerPrometheusErrorRates(budgets *bnet.ErrorBudgets, addresses []string) {
+	for _, address := range addresses {
+		err := prometheus.Register(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "backend_error_rate",
+				Help:        "The rolling share of the requests to a backend that failed",
+				ConstLabels: prometheus.Labels{"backend": address},
+			},
+			budgets.Get(address).Rate,
+		))
+		if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package zipper
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// resolveTimeout bounds how long the hostname of a backend takes to resolve.
+const resolveTimeout = 5 * time.Second
+
+// backendGroups are the backends requests are sent to: the default group
+// and its routes, and the groups of the tenants.
+type backendGroups struct {
+	backends []backend.Backend
+	routes   []route
+	tenants  map[string]tenant
+}
+
+// serving returns the backends of all the groups.
+func (g *backendGroups) serving() []backend.Backend {
+	bs := append([]backend.Backend{}, g.backends...)
+	for _, t := range g.tenants {
+		bs = append(bs, t.backends...)
+	}
+
+	return bs
+}
+
+// backendSet builds the backend groups of the config, expanding the
+// hostnames of the backends to one backend per address if DNS expansion is
+// enabled. The backends of the addresses that stay are kept as they are
+// when the groups are built again, with their path caches. onAdded, if set,
+// is called with the backends that refresh adds.
+type backendSet struct {
+	config   cfg.Zipper
+	init     func(hosts []string, zipper bool) ([]backend.Backend, error)
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	resolved map[string][]string
+	known    map[string]backend.Backend
+	logger   *zap.Logger
+	onAdded  func(added []backend.Backend)
+
+	mu     sync.Mutex
+	groups *backendGroups
+}
+
+func newBackendSet(config cfg.Zipper, init func(hosts []string, zipper bool) ([]backend.Backend, error), logger *zap.Logger) *backendSet {
+	return &backendSet{
+		config:   config,
+		init:     init,
+		lookup:   net.DefaultResolver.LookupHost,
+		resolved: make(map[string][]string),
+		known:    make(map[string]backend.Backend),
+		logger:   logger,
+	}
+}
+
+// hosts returns the backends of the config, of all the groups, and the
+// child zippers.
+func (s *backendSet) hosts() []string {
+	hosts := append([]string{}, s.config.Backends...)
+	hosts = append(hosts, s.config.Zippers...)
+	for _, t := range s.config.Tenants.Groups {
+		hosts = append(hosts, t.Backends...)
+	}
+
+	return hosts
+}
+
+// resolve resolves the hostnames of the backends, and reports whether their
+// addresses changed. A hostname that doesn't resolve keeps its addresses,
+// or is used as it is until it first resolves.
+func (s *backendSet) resolve() bool {
+	if !s.config.DNS.Expand {
+		return false
+	}
+
+	changed := false
+	for _, host := range s.hosts() {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		addresses, err := expandAddress(ctx, host, s.lookup)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to resolve backend",
+				zap.String("host", host),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if !reflect.DeepEqual(s.resolved[host], addresses) {
+			s.resolved[host] = addresses
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// addresses returns the addresses host resolved to, or host itself.
+func (s *backendSet) addresses(host string) []string {
+	if resolved, ok := s.resolved[host]; ok {
+		return resolved
+	}
+
+	return []string{host}
+}
+
+// primary returns the hosts of the addresses of the merge primary, as the
+// backends record them in the metrics they fetch.
+func (s *backendSet) primary() []string {
+	if s.config.Merge.Primary == "" {
+		return nil
+	}
+
+	var hosts []string
+	for _, address := range s.addresses(s.config.Merge.Primary) {
+		hosts = append(hosts, backendHost(address))
+	}
+
+	return hosts
+}
+
+// backends returns the backends of hosts, which are child zippers if zipper
+// is set, creating those of the new addresses, and adds those of hosts by
+// host to byHost.
+func (s *backendSet) backends(hosts []string, zipper bool, used map[string]backend.Backend, byHost map[string][]backend.Backend) ([]backend.Backend, error) {
+	var bs []backend.Backend
+	for _, host := range hosts {
+		for _, address := range s.addresses(host) {
+			b, ok := s.known[address]
+			if !ok {
+				created, err := s.init([]string{address}, zipper)
+				if err != nil {
+					return nil, err
+				}
+				b = created[0]
+			}
+			used[address] = b
+			bs = append(bs, b)
+			byHost[host] = append(byHost[host], b)
+		}
+	}
+
+	return bs, nil
+}
+
+// build builds the backend groups of the addresses resolved last, and
+// returns the backends that are new.
+func (s *backendSet) build() ([]backend.Backend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used := make(map[string]backend.Backend)
+	byHost := make(map[string][]backend.Backend)
+	bs, err := s.backends(s.config.Backends, false, used, byHost)
+	if err != nil {
+		return nil, err
+	}
+	zs, err := s.backends(s.config.Zippers, true, used, byHost)
+	if err != nil {
+		return nil, err
+	}
+	bs = append(bs, zs...)
+	routes, err := newRoutes(s.config.Routes, byHost)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]tenant, len(s.config.Tenants.Groups))
+	for name, t := range s.config.Tenants.Groups {
+		tbs, err := s.backends(t.Backends, false, used, make(map[string][]backend.Backend))
+		if err != nil {
+			return nil, errors.WithMessage(err, "tenant '"+name+"'")
+		}
+		tenants[name] = tenant{backends: tbs, prefixes: t.Prefixes}
+	}
+
+	var added []backend.Backend
+	for address, b := range used {
+		if _, ok := s.known[address]; !ok {
+			added = append(added, b)
+		}
+	}
+	s.known = used
+	s.groups = &backendGroups{backends: bs, routes: routes, tenants: tenants}
+
+	return added, nil
+}
+
+// current returns the backend groups built last.
+func (s *backendSet) current() *backendGroups {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.groups
+}
+
+// refresh resolves the hostnames of the backends every interval, and builds
+// the groups again when their addresses change, prewarming and probing the
+// new backends. The merge primary follows the addresses of its hostname.
+func (s *backendSet) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.resolve() {
+			continue
+		}
+
+		added, err := s.build()
+		if err != nil {
+			s.logger.Error("Failed to update backends",
+				zap.Error(err),
+			)
+			continue
+		}
+		s.logger.Info("Backend addresses changed",
+			zap.Any("addresses", s.resolved),
+		)
+		types.SetMergePrimary(s.primary()...)
+		if s.onAdded != nil {
+			s.onAdded(added)
+		}
+		go func() {
+			prewarm(added, s.config.Prewarm.Connections, s.config.Timeouts.Find, s.logger)
+			for _, b := range added {
+				b.Probe()
+			}
+		}()
+	}
+}
+
+// expandAddress returns the addresses of the backend at address, one for
+// each IP address its hostname resolves to, in the same form: "ip:port" or
+// "scheme://ip:port/path". An address with an IP address is returned as it
+// is, and so is an https address: its certificate names the hostname, which
+// the connections to an IP address wouldn't send or verify.
+func expandAddress(ctx context.Context, address string, lookup func(ctx context.Context, host string) ([]string, error)) ([]string, error) {
+	scheme, hostport, path := "", address, ""
+	if i := strings.Index(address, "://"); i != -1 {
+		scheme, hostport = address[:i+3], address[i+3:]
+	}
+	if strings.EqualFold(scheme, "https://") {
+		return []string{address}, nil
+	}
+	if i := strings.Index(hostport, "/"); i != -1 {
+		hostport, path = hostport[:i], hostport[i:]
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	if net.ParseIP(host) != nil {
+		return []string{address}, nil
+	}
+
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no addresses for '%s'", host)
+	}
+	sort.Strings(ips)
+
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if port != "" {
+			ip = net.JoinHostPort(ip, port)
+		} else if strings.Contains(ip, ":") {
+			ip = "[" + ip + "]"
+		}
+		addresses = append(addresses, scheme+ip+path)
+	}
+
+	return addresses, nil
+}
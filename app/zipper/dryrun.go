@@ -0,0 +1,67 @@
+package zipper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// dryRun is the routing of a find or render request, answered instead of its
+// result with dryRun=1: the backends it would be sent to, the route they are
+// picked by, and what they would be asked for. Nothing is sent to them.
+type dryRun struct {
+	Query    string   `json:"query,omitempty"`
+	Targets  []string `json:"targets,omitempty"`
+	From     int32    `json:"from,omitempty"`
+	Until    int32    `json:"until,omitempty"`
+	Route    string   `json:"route"`
+	Backends []string `json:"backends"`
+}
+
+func newDryRun(bs []backend.Backend, route string) dryRun {
+	run := dryRun{
+		Route:    route,
+		Backends: make([]string, 0, len(bs)),
+	}
+	for _, b := range bs {
+		run.Backends = append(run.Backends, b.Address())
+	}
+	sort.Strings(run.Backends)
+
+	return run
+}
+
+// writeDryRun answers the request of handler with run.
+func writeDryRun(w http.ResponseWriter, req *http.Request, run dryRun, accessLogger *zap.Logger, handler string, t0 time.Time) {
+	blob, err := json.Marshal(run)
+	if err != nil {
+		util.HTTPError(w, req, "error marshaling data", http.StatusInternalServerError)
+		accessLogger.Error("request failed",
+			zap.String("reason", "error marshaling data"),
+			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), handler).Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Bool("dry_run", true),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", handler).Inc()
+}
@@ -0,0 +1,32 @@
+package zipper
+
+import (
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// errFanOut is the error of a request routed to more backends than the
+// maximum fan-out.
+type errFanOut struct {
+	backends int
+	max      int
+}
+
+func (e errFanOut) Error() string {
+	return fmt.Sprintf("query would be sent to %d backends, more than the maximum of %d: "+
+		"narrow its glob, e.g. by spelling out its first nodes, so that it's routed to fewer backends", e.backends, e.max)
+}
+
+// checkFanOut fails if bs, the backends a request is routed to, are more than
+// the maximum fan-out, if there is one. It keeps a glob that no route or path
+// cache narrows from being broadcast to all the backends of a large
+// federation.
+func (app *App) checkFanOut(bs []backend.Backend) error {
+	max := app.config.MaxFanOut
+	if max <= 0 || len(bs) <= max {
+		return nil
+	}
+
+	return errFanOut{backends: len(bs), max: max}
+}
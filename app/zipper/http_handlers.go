@@ -4,16 +4,21 @@ import (
 	"context"
 	"expvar"
 	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/parser"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/json"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/raw"
 	"github.com/bookingcom/carbonapi/util"
 	"github.com/lomik/zapwriter"
 	"github.com/pkg/errors"
@@ -22,9 +27,11 @@ import (
 )
 
 const (
-	contentTypeJSON     = "application/json"
-	contentTypeProtobuf = "application/x-protobuf"
-	contentTypePickle   = "application/pickle"
+	contentTypeJSON       = "application/json"
+	contentTypeJavaScript = "text/javascript"
+	contentTypeProtobuf   = "application/x-protobuf"
+	contentTypePickle     = "application/pickle"
+	contentTypeRaw        = "text/plain"
 )
 
 const (
@@ -33,6 +40,11 @@ const (
 	formatTypeJSON      = "json"
 	formatTypeProtobuf  = "protobuf"
 	formatTypeProtobuf3 = "protobuf3"
+	formatTypeRaw       = "raw"
+	formatTypeDygraph   = "dygraph"
+	formatTypeRickshaw  = "rickshaw"
+	formatTypeTreeJSON  = "treejson"
+	formatTypeCompleter = "completer"
 )
 
 // Metrics contains grouped expvars for /debug/vars and graphite
@@ -53,12 +65,38 @@ var Metrics = struct {
 	InfoRequests *expvar.Int
 	InfoErrors   *expvar.Int
 
-	Timeouts *expvar.Int
+	Timeouts          *expvar.Int
+	ResponsesTooLarge *expvar.Int
+	PartialResponses  *expvar.Int
+	ClientDisconnects *expvar.Int
+	// The requests answered with empty results by blackhole routes.
+	BlackholedRequests *expvar.Int
+	// The requests refused with a 400 as they were routed to more backends
+	// than maxFanOut.
+	FanOutRejected *expvar.Int
+	// The requests refused with a 429 by the per-client limits.
+	LimitedRequests *expvar.Int
+	// The render requests answered with a 404 without querying the
+	// backends, as their range is past the retentions of storage-schemas.conf.
+	ClampedRequests *expvar.Int
+	// The requests answered with a 500 after their handler panicked.
+	Panics *expvar.Int
+
+	ShadowRequests *expvar.Int
+	ShadowErrors   *expvar.Int
+	ShadowDiffs    *expvar.Int
+	// The paths or series missing from the shadow responses, those only
+	// in them, and the points of the series in both that don't match.
+	ShadowMissing          *expvar.Int
+	ShadowExtra            *expvar.Int
+	ShadowMismatchedPoints *expvar.Int
 
 	CacheSize   expvar.Func
 	CacheItems  expvar.Func
 	CacheMisses *expvar.Int
 	CacheHits   *expvar.Int
+	// The prefixes invalidated in the path caches.
+	CacheInvalidations *expvar.Int
 }{
 	Requests:  expvar.NewInt("requests"),
 	Responses: expvar.NewInt("responses"),
@@ -73,10 +111,28 @@ var Metrics = struct {
 	InfoRequests: expvar.NewInt("info_requests"),
 	InfoErrors:   expvar.NewInt("info_errors"),
 
-	Timeouts: expvar.NewInt("timeouts"),
+	Timeouts:          expvar.NewInt("timeouts"),
+	ResponsesTooLarge: expvar.NewInt("responses_too_large"),
+	PartialResponses:  expvar.NewInt("partial_responses"),
+	ClientDisconnects: expvar.NewInt("client_disconnects"),
 
-	CacheHits:   expvar.NewInt("cache_hits"),
-	CacheMisses: expvar.NewInt("cache_misses"),
+	BlackholedRequests: expvar.NewInt("blackholed_requests"),
+	FanOutRejected:     expvar.NewInt("fanout_rejected"),
+	LimitedRequests:    expvar.NewInt("limited_requests"),
+	ClampedRequests:    expvar.NewInt("clamped_requests"),
+	Panics:             expvar.NewInt("panics"),
+
+	ShadowRequests: expvar.NewInt("shadow_requests"),
+	ShadowErrors:   expvar.NewInt("shadow_errors"),
+	ShadowDiffs:    expvar.NewInt("shadow_diffs"),
+
+	ShadowMissing:          expvar.NewInt("shadow_missing"),
+	ShadowExtra:            expvar.NewInt("shadow_extra"),
+	ShadowMismatchedPoints: expvar.NewInt("shadow_mismatched_points"),
+
+	CacheHits:          expvar.NewInt("cache_hits"),
+	CacheMisses:        expvar.NewInt("cache_misses"),
+	CacheInvalidations: expvar.NewInt("cache_invalidations"),
 }
 
 var prometheusMetrics = struct {
@@ -114,10 +170,29 @@ var prometheusMetrics = struct {
 	),
 }
 
+// clientGone accounts for a request whose client went away, which canceled
+// the requests to the backends, and reports whether it did. It is neither an
+// error of the backends nor a timeout.
+func clientGone(req *http.Request, accessLogger *zap.Logger, handler string, t0 time.Time) bool {
+	if !util.ClientGone(req) {
+		return false
+	}
+
+	accessLogger.Info("request canceled",
+		zap.String("reason", "client disconnected"),
+		zap.Int("http_code", util.StatusClientClosedRequest),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.ClientDisconnects.Add(1)
+	prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", util.StatusClientClosedRequest), handler).Inc()
+
+	return true
+}
+
 func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 	t0 := time.Now()
 
-	ctx, cancel := context.WithTimeout(req.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(req.Context(), util.Timeout(req, app.config.Timeouts.Find))
 	defer cancel()
 
 	logger := zapwriter.Logger("find").With(
@@ -131,23 +206,126 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 		)
 	}
 
-	originalQuery := req.FormValue("query")
-	format := req.FormValue("format")
-
 	Metrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
 	Metrics.FindRequests.Add(1)
 
 	accessLogger := zapwriter.Logger("access").With(
 		zap.String("handler", "find"),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	err := util.ParseForm(req)
+	if err != nil {
+		util.HTTPError(w, req, "failed to parse arguments", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "failed to parse arguments"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
+	ctx = strictContext(ctx, req)
+
+	originalQuery := req.FormValue("query")
+	format := req.FormValue("format")
+	accessLogger = accessLogger.With(
 		zap.String("format", format),
 		zap.String("target", originalQuery),
-		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
-	request := types.NewFindRequest(originalQuery)
-	bs := backend.Filter(app.backends, []string{originalQuery})
+	jsonp := req.FormValue("jsonp")
+	if jsonp != "" && !json.ValidCallback(jsonp) {
+		util.HTTPError(w, req, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid jsonp callback"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
+
+	pickleProtocol, err := pickle.ParseProtocol(req.FormValue("pickleProtocol"))
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid pickle protocol"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
+
+	query := originalQuery
+	if format == formatTypeCompleter {
+		query = completerQuery(query)
+	}
+
+	t, err := app.tenant(req)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.String("reason", "unknown tenant"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "find").Inc()
+		return
+	}
+
+	query, err = t.findQuery(query)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.String("reason", "target outside of the tenant's prefixes"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "find").Inc()
+		return
+	}
+
+	request := types.NewFindRequest(query)
+	bs, route := t.route([]string{query})
+	if parser.TruthyBool(req.FormValue("dryRun")) {
+		run := newDryRun(bs, route)
+		run.Query = query
+		writeDryRun(w, req, run, accessLogger, "find", t0)
+		return
+	}
+	if route == routeBlackhole {
+		Metrics.BlackholedRequests.Add(1)
+	}
+	if err := app.checkFanOut(bs); err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "fan-out too large"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.FanOutRejected.Add(1)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
 	metrics, err := backend.Finds(ctx, bs, request)
+	if err != nil && clientGone(req, accessLogger, "find", t0) {
+		return
+	}
+	traceBackends(w, req, request.Trace)
 	if err != nil {
 		if _, ok := errors.Cause(err).(types.ErrNotFound); ok {
 			// graphite-web 0.9.12 needs to get a 200 OK response with an empty
@@ -165,13 +343,30 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 				zap.Duration("runtime_seconds", time.Since(t0)),
 				zap.Error(err),
 			)
-			http.Error(w, msg, code)
+			util.HTTPError(w, req, msg, code, types.FailingBackends(err)...)
 			Metrics.Errors.Add(1)
 			prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "find").Inc()
 			return
 		}
 	}
 
+	if msg, failed := app.partial(ctx, w, request.Trace); failed {
+		code := http.StatusInternalServerError
+		util.HTTPError(w, req, msg, code)
+		accessLogger.Error("find failed",
+			zap.String("reason", msg),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "find").Inc()
+		return
+	}
+
+	if route != routeBlackhole {
+		app.shadow.find(util.GetUUID(ctx), query, metrics)
+	}
+
 	sort.Slice(metrics.Matches, func(i, j int) bool {
 		if metrics.Matches[i].Path < metrics.Matches[j].Path {
 			return true
@@ -182,28 +377,40 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 		return metrics.Matches[i].Path < metrics.Matches[j].Path
 	})
 
+	if parser.TruthyBool(req.FormValue("leavesOnly")) {
+		metrics = leavesOnly(metrics)
+	}
+
+	if parser.TruthyBool(req.FormValue("wildcards")) {
+		metrics = wildcards(metrics)
+	}
+
 	var contentType string
 	var blob []byte
 	switch format {
 	case formatTypeProtobuf, formatTypeProtobuf3:
 		contentType = contentTypeProtobuf
 		blob, err = carbonapi_v2.FindEncoder(metrics)
-	case formatTypeJSON:
+	case formatTypeJSON, formatTypeTreeJSON:
 		contentType = contentTypeJSON
 		blob, err = json.FindEncoder(metrics)
+	case formatTypeCompleter:
+		contentType = contentTypeJSON
+		blob, err = json.CompleterEncoder(metrics)
 	case formatTypeEmpty, formatTypePickle:
 		contentType = contentTypePickle
-		if app.config.GraphiteWeb09Compatibility {
+		if app.graphite09Compat(req) {
 			blob, err = pickle.FindEncoderV0_9(metrics)
 		} else {
 			blob, err = pickle.FindEncoderV1_0(metrics)
 		}
+		blob = pickle.WithProtocol(blob, pickleProtocol)
 	default:
 		err = errors.Errorf("Unknown format %s", format)
 	}
 
 	if err != nil {
-		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		util.HTTPError(w, req, "error marshaling data", http.StatusInternalServerError)
 		accessLogger.Error("render failed",
 			zap.Int("http_code", http.StatusInternalServerError),
 			zap.String("reason", "error marshaling data"),
@@ -215,6 +422,11 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if jsonp != "" && contentType == contentTypeJSON {
+		contentType = contentTypeJavaScript
+		blob = json.WrapJSONP(blob, jsonp)
+	}
+
 	w.Header().Set("Content-Type", contentType)
 	w.Write(blob)
 
@@ -227,11 +439,71 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request) {
 	prometheusMetrics.Responses.WithLabelValues("200", "find").Inc()
 }
 
+// graphite09Compat tells whether to answer in the format of graphite-web 0.9
+// rather than 1.x. Clients can override the configured default with the
+// graphite09compat parameter.
+func (app *App) graphite09Compat(req *http.Request) bool {
+	if v := req.FormValue("graphite09compat"); v != "" {
+		return parser.TruthyBool(v)
+	}
+
+	return app.config.GraphiteWeb09Compatibility
+}
+
+// completerQuery turns a partial metric path typed in the graphite-web
+// composer into a glob, like graphite-web does for format=completer.
+func completerQuery(query string) string {
+	query = strings.Replace(query, "/", ".", -1)
+	query = strings.Replace(query, "..", "*.", -1)
+	if !strings.HasSuffix(query, "*") {
+		query += "*"
+	}
+
+	return query
+}
+
+// leavesOnly drops branches from find results, like graphite-web does with
+// leavesOnly=1.
+func leavesOnly(matches types.Matches) types.Matches {
+	leaves := make([]types.Match, 0, len(matches.Matches))
+	for _, m := range matches.Matches {
+		if m.IsLeaf {
+			leaves = append(leaves, m)
+		}
+	}
+	matches.Matches = leaves
+
+	return matches
+}
+
+// wildcards appends a "*" pseudo-node matching all the other nodes, like
+// graphite-web does with wildcards=1. The node is a leaf only if all the
+// matches are leaves.
+func wildcards(matches types.Matches) types.Matches {
+	if len(matches.Matches) < 2 {
+		return matches
+	}
+
+	prefix := ""
+	if i := strings.LastIndex(matches.Name, "."); i != -1 {
+		prefix = matches.Name[:i+1]
+	}
+
+	leaf := true
+	for _, m := range matches.Matches {
+		leaf = leaf && m.IsLeaf
+	}
+
+	matches.Matches = append(matches.Matches, types.Match{Path: prefix + "*", IsLeaf: leaf})
+
+	return matches
+}
+
 func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 	t0 := time.Now()
 	memoryUsage := 0
 
-	ctx, cancel := context.WithTimeout(req.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(req.Context(), util.Timeout(req, app.config.Timeouts.Render))
 	defer cancel()
 
 	logger := zapwriter.Logger("render").With(
@@ -255,9 +527,9 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
-	err := req.ParseForm()
+	err := util.ParseForm(req)
 	if err != nil {
-		http.Error(w, "failed to parse arguments", http.StatusBadRequest)
+		util.HTTPError(w, req, "failed to parse arguments", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.String("reason", "failed to parse arguments"),
@@ -269,6 +541,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
 		return
 	}
+	ctx = strictContext(ctx, req)
 
 	target := req.FormValue("target")
 	format := req.FormValue("format")
@@ -277,9 +550,38 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("target", target),
 	)
 
+	jsonp := req.FormValue("jsonp")
+	if jsonp != "" && !json.ValidCallback(jsonp) {
+		util.HTTPError(w, req, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "invalid jsonp callback"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
+		return
+	}
+
+	pickleProtocol, err := pickle.ParseProtocol(req.FormValue("pickleProtocol"))
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "invalid pickle protocol"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
+		return
+	}
+
 	from, err := strconv.Atoi(req.FormValue("from"))
 	if err != nil {
-		http.Error(w, "from is not a integer", http.StatusBadRequest)
+		util.HTTPError(w, req, "from is not a integer", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.String("reason", "from is not a integer"),
@@ -294,7 +596,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 
 	until, err := strconv.Atoi(req.FormValue("until"))
 	if err != nil {
-		http.Error(w, "until is not a integer", http.StatusBadRequest)
+		util.HTTPError(w, req, "until is not a integer", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.String("reason", "until is not a integer"),
@@ -307,8 +609,13 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	accessLogger = accessLogger.With(
+		zap.Int("from", from),
+		zap.Int("until", until),
+	)
+
 	if target == "" {
-		http.Error(w, "empty target", http.StatusBadRequest)
+		util.HTTPError(w, req, "empty target", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.String("reason", "empty target"),
@@ -320,9 +627,102 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	consolidateBy, err := types.ParseConsolidation(req.FormValue("consolidateBy"))
+	if err != nil {
+		util.HTTPError(w, req, "unknown consolidateBy function", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "unknown consolidateBy function"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
+		return
+	}
+
+	t, err := app.tenant(req)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "unknown tenant"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "render").Inc()
+		return
+	}
+
+	err = t.checkTarget(target)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "target outside of the tenant's prefixes"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "render").Inc()
+		return
+	}
+
+	if schema, ok := app.schemas.Match(target); ok {
+		f, u, _, ok := schema.Clamp(int32(from), int32(until), int32(time.Now().Unix()))
+		if !ok {
+			Metrics.ClampedRequests.Add(1)
+			util.HTTPError(w, req, "not found", http.StatusNotFound)
+			accessLogger.Error("request failed",
+				zap.Int("memory_usage_bytes", memoryUsage),
+				zap.String("reason", "range past the retentions of the target"),
+				zap.String("schema", schema.Name),
+				zap.Int("http_code", http.StatusNotFound),
+				zap.Duration("runtime_seconds", time.Since(t0)),
+			)
+			Metrics.Errors.Add(1)
+			prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusNotFound), "render").Inc()
+			return
+		}
+		from, until = int(f), int(u)
+	}
+
 	request := types.NewRenderRequest([]string{target}, int32(from), int32(until))
-	bs := backend.Filter(app.backends, request.Targets)
+	request.ConsolidateBy = consolidateBy
+	bs, route := t.route(request.Targets)
+	if parser.TruthyBool(req.FormValue("dryRun")) {
+		run := newDryRun(bs, route)
+		run.Targets = request.Targets
+		run.From = request.From
+		run.Until = request.Until
+		writeDryRun(w, req, run, accessLogger, "render", t0)
+		return
+	}
+	if route == routeBlackhole {
+		Metrics.BlackholedRequests.Add(1)
+	}
+	if err := app.checkFanOut(bs); err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "fan-out too large"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.FanOutRejected.Add(1)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
+		return
+	}
 	metrics, err := backend.Renders(ctx, bs, request)
+	if err != nil && clientGone(req, accessLogger, "render", t0) {
+		return
+	}
+	traceBackends(w, req, request.Trace)
 	if err != nil {
 		msg := "error fetching the data"
 		code := http.StatusInternalServerError
@@ -331,7 +731,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 			code = http.StatusNotFound
 		}
 
-		http.Error(w, msg, code)
+		util.HTTPError(w, req, msg, code, types.FailingBackends(err)...)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.Error(err),
@@ -345,6 +745,25 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if msg, failed := app.partial(ctx, w, request.Trace); failed {
+		code := http.StatusInternalServerError
+		util.HTTPError(w, req, msg, code)
+		accessLogger.Error("request failed",
+			zap.String("reason", msg),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.Int64s("trace", request.Trace.Report()),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "render").Inc()
+		return
+	}
+
+	if route != routeBlackhole {
+		app.shadow.render(util.GetUUID(ctx), request, metrics)
+	}
+
 	var blob []byte
 	var contentType string
 	switch format {
@@ -357,12 +776,22 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 	case formatTypeEmpty, formatTypePickle:
 		contentType = contentTypePickle
 		blob, err = pickle.RenderEncoder(metrics)
+		blob = pickle.WithProtocol(blob, pickleProtocol)
+	case formatTypeRaw:
+		contentType = contentTypeRaw
+		blob, err = raw.RenderEncoder(metrics)
+	case formatTypeDygraph:
+		contentType = contentTypeJSON
+		blob, err = json.DygraphEncoder(metrics)
+	case formatTypeRickshaw:
+		contentType = contentTypeJSON
+		blob, err = json.RickshawEncoder(metrics)
 	default:
 		err = errors.Errorf("Unknown format %s", format)
 	}
 
 	if err != nil {
-		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		util.HTTPError(w, req, "error marshaling data", http.StatusInternalServerError)
 		accessLogger.Error("render failed",
 			zap.Int("http_code", http.StatusInternalServerError),
 			zap.String("reason", "error marshaling data"),
@@ -376,6 +805,11 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if jsonp != "" && contentType == contentTypeJSON {
+		contentType = contentTypeJavaScript
+		blob = json.WrapJSONP(blob, jsonp)
+	}
+
 	w.Header().Set("Content-Type", contentType)
 	w.Write(blob)
 
@@ -393,7 +827,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request) {
 func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 	t0 := time.Now()
 
-	ctx, cancel := context.WithTimeout(req.Context(), app.config.Timeouts.Global)
+	ctx, cancel := context.WithTimeout(req.Context(), util.Timeout(req, app.config.Timeouts.Info))
 	defer cancel()
 
 	logger := zapwriter.Logger("info").With(
@@ -415,9 +849,9 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("handler", "info"),
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
-	err := req.ParseForm()
+	err := util.ParseForm(req)
 	if err != nil {
-		http.Error(w, "failed to parse arguments", http.StatusBadRequest)
+		util.HTTPError(w, req, "failed to parse arguments", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.String("reason", "failed to parse arguments"),
 			zap.Int("http_code", http.StatusBadRequest),
@@ -428,6 +862,7 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "info").Inc()
 		return
 	}
+	ctx = strictContext(ctx, req)
 
 	target := req.FormValue("target")
 	format := req.FormValue("format")
@@ -437,33 +872,107 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("format", format),
 	)
 
+	jsonp := req.FormValue("jsonp")
+	if jsonp != "" && !json.ValidCallback(jsonp) {
+		util.HTTPError(w, req, "invalid jsonp callback", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid jsonp callback"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "info").Inc()
+		return
+	}
+
 	if target == "" {
 		accessLogger.Error("info failed",
 			zap.Int("http_code", http.StatusBadRequest),
 			zap.String("reason", "empty target"),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
-		http.Error(w, "info: empty target", http.StatusBadRequest)
+		util.HTTPError(w, req, "info: empty target", http.StatusBadRequest)
 		Metrics.Errors.Add(1)
 		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "info").Inc()
 		return
 	}
 
+	t, err := app.tenant(req)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.String("reason", "unknown tenant"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "info").Inc()
+		return
+	}
+
+	err = t.checkTarget(target)
+	if err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusForbidden)
+		accessLogger.Error("request failed",
+			zap.String("reason", "target outside of the tenant's prefixes"),
+			zap.Int("http_code", http.StatusForbidden),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusForbidden), "info").Inc()
+		return
+	}
+
 	request := types.NewInfoRequest(target)
-	bs := backend.Filter(app.backends, []string{target})
+	bs, route := t.route([]string{target})
+	if route == routeBlackhole {
+		Metrics.BlackholedRequests.Add(1)
+	}
+	if err := app.checkFanOut(bs); err != nil {
+		util.HTTPError(w, req, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "fan-out too large"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.FanOutRejected.Add(1)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "info").Inc()
+		return
+	}
 	infos, err := backend.Infos(ctx, bs, request)
+	if err != nil && clientGone(req, accessLogger, "info", t0) {
+		return
+	}
+	traceBackends(w, req, request.Trace)
 	if err != nil {
 		accessLogger.Error("info failed",
 			zap.Int("http_code", http.StatusInternalServerError),
 			zap.Error(err),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
-		http.Error(w, "info: error processing request", http.StatusInternalServerError)
+		util.HTTPError(w, req, "info: error processing request", http.StatusInternalServerError, types.FailingBackends(err)...)
 		Metrics.Errors.Add(1)
 		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), "info").Inc()
 		return
 	}
 
+	if msg, failed := app.partial(ctx, w, request.Trace); failed {
+		code := http.StatusInternalServerError
+		util.HTTPError(w, req, msg, code)
+		accessLogger.Error("info failed",
+			zap.String("reason", msg),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "info").Inc()
+		return
+	}
+
 	var contentType string
 	var blob []byte
 	switch format {
@@ -478,7 +987,7 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if err != nil {
-		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		util.HTTPError(w, req, "error marshaling data", http.StatusInternalServerError)
 		accessLogger.Error("info failed",
 			zap.Int("http_code", http.StatusInternalServerError),
 			zap.String("reason", "error marshaling data"),
@@ -490,6 +999,11 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if jsonp != "" && contentType == contentTypeJSON {
+		contentType = contentTypeJavaScript
+		blob = json.WrapJSONP(blob, jsonp)
+	}
+
 	w.Header().Set("Content-Type", contentType)
 	w.Write(blob)
 
@@ -516,8 +1030,26 @@ func (app *App) lbCheckHandler(w http.ResponseWriter, req *http.Request) {
 	Metrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
 
+	check := app.config.LBCheck
+	if err := app.findCanary(req, check); err != nil {
+		code := http.StatusServiceUnavailable
+		http.Error(w, "canary metric not found", code)
+		accessLogger.Error("lb request failed",
+			zap.String("reason", "canary metric not found"),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "lbcheck").Inc()
+		return
+	}
+
+	for name, value := range check.Headers {
+		w.Header().Set(name, value)
+	}
 	/* #nosec */
-	fmt.Fprintf(w, "Ok\n")
+	io.WriteString(w, check.Body)
 	accessLogger.Info("lb request served",
 		zap.Int("http_code", http.StatusOK),
 		zap.Duration("runtime_seconds", time.Since(t0)),
@@ -525,3 +1057,27 @@ func (app *App) lbCheckHandler(w http.ResponseWriter, req *http.Request) {
 	Metrics.Responses.Add(1)
 	prometheusMetrics.Responses.WithLabelValues("200", "lbcheck").Inc()
 }
+
+// findCanary finds the canary metric of check, if any, in the backends of
+// the default tenant, and fails if it isn't found.
+func (app *App) findCanary(req *http.Request, check cfg.LBCheck) error {
+	if check.CanaryMetric == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), check.CanaryTimeout)
+	defer cancel()
+
+	groups := app.backends.current()
+	t := tenant{backends: groups.backends, routes: groups.routes}
+	bs, _ := t.route([]string{check.CanaryMetric})
+	matches, err := backend.Finds(ctx, bs, types.NewFindRequest(check.CanaryMetric))
+	if err != nil {
+		return err
+	}
+	if len(matches.Matches) == 0 {
+		return types.ErrMatchesNotFound
+	}
+
+	return nil
+}
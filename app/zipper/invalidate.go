@@ -0,0 +1,69 @@
+package zipper
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// invalidateHandler evicts the cached backends of the find queries, and the
+// paths the backends are known to have, under the top-level domains of the
+// prefix parameters, or all of them if there is none. Invalidation is per
+// top-level domain: prefix=virt.v1 evicts everything under virt. carbonsearch
+// calls it when its index changes, so that the requests for its new paths are
+// routed without waiting out expireDelaySec.
+func (app *App) invalidateHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	accessLogger := zapwriter.Logger("access").With(zap.String("handler", "invalidate"))
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	if err := util.ParseForm(req); err != nil {
+		code := http.StatusBadRequest
+		util.HTTPError(w, req, "failed to parse arguments", code)
+		accessLogger.Error("request failed",
+			zap.String("reason", "failed to parse arguments"),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "invalidate").Inc()
+		return
+	}
+
+	prefixes := req.Form["prefix"]
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	bs := app.backends.current().serving()
+	if app.shadow != nil {
+		bs = append(bs, app.shadow.backends...)
+	}
+	for _, prefix := range prefixes {
+		app.config.PathCache.Invalidate(prefix)
+		for _, b := range bs {
+			b.Invalidate(prefix)
+		}
+	}
+	Metrics.CacheInvalidations.Add(int64(len(prefixes)))
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string][]string{"invalidated": prefixes})
+
+	accessLogger.Info("request served",
+		zap.Strings("prefixes", prefixes),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", "invalidate").Inc()
+}
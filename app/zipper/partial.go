@@ -0,0 +1,63 @@
+package zipper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+	"github.com/pkg/errors"
+)
+
+// partialResults is what the zipper does with a request some of the backends
+// failed to answer.
+type partialResults int
+
+const (
+	partialAllow  partialResults = iota // Answer with the data of the others.
+	partialStrict                       // Fail the request.
+)
+
+func parsePartialResults(name string) (partialResults, error) {
+	switch name {
+	case "", "allow":
+		return partialAllow, nil
+	case "strict":
+		return partialStrict, nil
+	}
+
+	return partialAllow, errors.Errorf("unknown partial results mode '%s'", name)
+}
+
+// strictContext marks ctx strict if the client of req asked for
+// all-or-nothing results with strict=1, whatever the partial results mode,
+// so that the child zippers are asked for them too.
+func strictContext(ctx context.Context, req *http.Request) context.Context {
+	if parser.TruthyBool(req.FormValue("strict")) {
+		return util.WithStrict(ctx)
+	}
+
+	return ctx
+}
+
+// partial checks whether some backends failed to answer the request of
+// trace. It returns the reason the request must fail in strict mode, or if
+// ctx is strict, and otherwise tells the client how many backends failed,
+// out of those queried, with the X-Carbonzipper-Partial header.
+func (app *App) partial(ctx context.Context, w http.ResponseWriter, trace types.Trace) (string, bool) {
+	failures := trace.Failures()
+	if failures == 0 {
+		return "", false
+	}
+
+	Metrics.PartialResponses.Add(1)
+	if app.partialResults == partialStrict || util.Strict(ctx) {
+		return fmt.Sprintf("%d of %d backends failed", failures, trace.Calls()), true
+	}
+
+	w.Header().Set("X-Carbonzipper-Partial", fmt.Sprintf("%d/%d", failures, trace.Calls()))
+
+	return "", false
+}
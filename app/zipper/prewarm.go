@@ -0,0 +1,56 @@
+package zipper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+
+	"go.uber.org/zap"
+)
+
+// warmer is a backend that can open connections ahead of the requests.
+type warmer interface {
+	Warm(ctx context.Context, conns int) error
+}
+
+// prewarm opens conns idle connections to each of bs, with as many finds of
+// their top-level domains, and waits for them up to timeout, so that the
+// first requests after a deploy or a change of the backends don't wait to
+// connect. No conns opens none.
+func prewarm(bs []backend.Backend, conns int, timeout time.Duration, logger *zap.Logger) {
+	if conns <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t0 := time.Now()
+	var wg sync.WaitGroup
+	for _, b := range bs {
+		w, ok := b.(warmer)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(b backend.Backend) {
+			defer wg.Done()
+			if err := w.Warm(ctx, conns); err != nil {
+				logger.Warn("Failed to prewarm backend",
+					zap.String("host", b.Address()),
+					zap.Error(err),
+				)
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	logger.Info("Prewarmed backends",
+		zap.Int("backends", len(bs)),
+		zap.Int("connections", conns),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
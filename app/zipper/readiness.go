@@ -0,0 +1,128 @@
+package zipper
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// readiness tells whether the zipper is ready to serve: the backends have
+// been probed once, which fills the path cache with their top-level
+// domains, and a quorum of them answered the last probe. /lb_check only
+// tells that the process is up.
+type readiness struct {
+	backends func() []backend.Backend
+	quorum   float64
+
+	mu      sync.Mutex
+	probed  bool
+	healthy int
+	total   int
+}
+
+// newReadiness returns the readiness of the zipper serving from the backends
+// returned by backends. The zipper is ready once quorum of them, from 0 to
+// 1, answer the probes, and at least one.
+func newReadiness(backends func() []backend.Backend, quorum float64) *readiness {
+	return &readiness{
+		backends: backends,
+		quorum:   quorum,
+	}
+}
+
+// run probes the backends every interval, or every 5 minutes if it isn't
+// set.
+func (r *readiness) run(interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.probe()
+		<-ticker.C
+	}
+}
+
+// probe probes the backends, and waits for their answers.
+func (r *readiness) probe() {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	healthy := 0
+	bs := r.backends()
+	for _, b := range bs {
+		wg.Add(1)
+		go func(b backend.Backend) {
+			defer wg.Done()
+			if err := b.Probe(); err == nil {
+				mu.Lock()
+				healthy++
+				mu.Unlock()
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	r.probed = true
+	r.healthy = healthy
+	r.total = len(bs)
+	r.mu.Unlock()
+}
+
+// needed returns how many of the backends of the last probe must be healthy.
+func (r *readiness) needed() int {
+	n := int(math.Ceil(r.quorum * float64(r.total)))
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// ready reports whether the zipper is ready, or else why not.
+func (r *readiness) ready() (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.probed {
+		return false, "backends not probed yet"
+	}
+	if needed := r.needed(); r.healthy < needed {
+		return false, fmt.Sprintf("%d of %d backends healthy, %d needed", r.healthy, r.total, needed)
+	}
+
+	return true, ""
+}
+
+func (app *App) readyHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	accessLogger := zapwriter.Logger("access").With(zap.String("handler", "readiness"))
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	code := http.StatusOK
+	if ok, reason := app.readiness.ready(); ok {
+		/* #nosec */
+		fmt.Fprintf(w, "Ok\n")
+	} else {
+		code = http.StatusServiceUnavailable
+		http.Error(w, "Not ready: "+reason, code)
+	}
+	accessLogger.Info("readiness request served",
+		zap.Int("http_code", code),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "readiness").Inc()
+}
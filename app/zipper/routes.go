@@ -0,0 +1,113 @@
+package zipper
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/pkg/errors"
+)
+
+// The routes of requests matching the routing table: those sent to the
+// backends of a prefix, and those answered with empty results.
+const (
+	routePrefix    = "prefix"
+	routeBlackhole = "blackhole"
+)
+
+// route is the group of backends the metrics under prefix are on, or none
+// for a blackhole.
+type route struct {
+	prefix    string
+	backends  []backend.Backend
+	blackhole bool
+}
+
+// newRoutes returns the routing table of config, longest prefixes first. The
+// backends of the routes are picked among byHost, the backends of each host,
+// which are several if its hostname was expanded to its addresses.
+func newRoutes(config []cfg.Route, byHost map[string][]backend.Backend) ([]route, error) {
+	routes := make([]route, 0, len(config))
+	for _, r := range config {
+		if r.Prefix == "" {
+			return nil, errors.New("route without a prefix")
+		}
+		if r.Blackhole {
+			if len(r.Backends) > 0 {
+				return nil, errors.Errorf("blackhole route of prefix '%s' has backends", r.Prefix)
+			}
+			routes = append(routes, route{prefix: r.Prefix, blackhole: true})
+			continue
+		}
+		if len(r.Backends) == 0 {
+			return nil, errors.Errorf("route of prefix '%s' has no backends", r.Prefix)
+		}
+
+		rbs := make([]backend.Backend, 0, len(r.Backends))
+		for _, host := range r.Backends {
+			bs, ok := byHost[host]
+			if !ok {
+				return nil, errors.Errorf("backend '%s' of the route of prefix '%s' is not in backends", host, r.Prefix)
+			}
+			rbs = append(rbs, bs...)
+		}
+		routes = append(routes, route{prefix: r.Prefix, backends: rbs})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return strings.Count(routes[i].prefix, ".") > strings.Count(routes[j].prefix, ".")
+	})
+
+	return routes, nil
+}
+
+// route returns the backends of t to send a request for targets to, and the
+// route they are picked by. A request whose targets all match routes goes to
+// the backends of those routes, and any other to those backend.Route picks.
+// A request whose targets all match blackholes goes to no backend.
+func (t tenant) route(targets []string) ([]backend.Backend, string) {
+	if len(t.routes) == 0 || len(targets) == 0 {
+		return backend.Route(t.backends, targets)
+	}
+
+	seen := make(map[string]bool)
+	var bs []backend.Backend
+	blackholed := 0
+	for _, target := range targets {
+		r, ok := t.match(target)
+		if !ok {
+			return backend.Route(t.backends, targets)
+		}
+		if r.blackhole {
+			blackholed++
+			continue
+		}
+
+		for _, b := range r.backends {
+			if !seen[b.Address()] {
+				seen[b.Address()] = true
+				bs = append(bs, b)
+			}
+		}
+	}
+
+	if blackholed == len(targets) {
+		return nil, routeBlackhole
+	}
+
+	return backend.Filter(bs, targets), routePrefix
+}
+
+// match returns the route of the longest prefix target is under. The prefix
+// nodes are compared literally, so that a glob matches no route before its
+// prefix ends.
+func (t tenant) match(target string) (route, bool) {
+	for _, r := range t.routes {
+		if target == r.prefix || strings.HasPrefix(target, r.prefix+".") {
+			return r, true
+		}
+	}
+
+	return route{}, false
+}
@@ -0,0 +1,244 @@
+package zipper
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxDiffExamples is the number of differing paths or metrics logged for
+// each difference between the main and the shadow backends.
+const maxDiffExamples = 10
+
+// shadow mirrors requests to a canary backend group, whose responses are
+// discarded, or compared to those of the main backends.
+type shadow struct {
+	backends   []backend.Backend
+	percentage float64
+	diff       bool
+	tolerance  float64
+	timeout    time.Duration
+	logger     *zap.Logger
+}
+
+// sampled reports whether a request is mirrored. A nil shadow mirrors none.
+func (s *shadow) sampled() bool {
+	return s != nil && len(s.backends) > 0 && rand.Float64()*100 < s.percentage
+}
+
+// find mirrors a find request for query in the background, comparing the
+// response to the matches of the main backends.
+func (s *shadow) find(uuid string, query string, matches types.Matches) {
+	if !s.sampled() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		Metrics.ShadowRequests.Add(1)
+		request := types.NewFindRequest(query)
+		got, err := backend.Finds(ctx, backend.Filter(s.backends, []string{query}), request)
+		if s.failed(uuid, "find", err, len(matches.Matches) == 0) || !s.diff {
+			return
+		}
+
+		missing, extra := diffStrings(matchPaths(matches), matchPaths(got))
+		if len(missing) == 0 && len(extra) == 0 {
+			return
+		}
+
+		Metrics.ShadowDiffs.Add(1)
+		Metrics.ShadowMissing.Add(int64(len(missing)))
+		Metrics.ShadowExtra.Add(int64(len(extra)))
+		s.logger.Warn("Shadow find response differs",
+			zap.String("carbonapi_uuid", uuid),
+			zap.String("query", query),
+			zap.Int("missing", len(missing)),
+			zap.Strings("missing_examples", examples(missing)),
+			zap.Int("extra", len(extra)),
+			zap.Strings("extra_examples", examples(extra)),
+		)
+	}()
+}
+
+// render mirrors a render request in the background, comparing the response
+// to the metrics of the main backends.
+func (s *shadow) render(uuid string, request types.RenderRequest, metrics []types.Metric) {
+	if !s.sampled() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		Metrics.ShadowRequests.Add(1)
+		mirrored := types.NewRenderRequest(request.Targets, request.From, request.Until)
+		mirrored.ConsolidateBy = request.ConsolidateBy
+		got, err := backend.Renders(ctx, backend.Filter(s.backends, mirrored.Targets), mirrored)
+		if s.failed(uuid, "render", err, len(metrics) == 0) || !s.diff {
+			return
+		}
+
+		missing, extra, different, points := diffMetrics(metrics, got, s.tolerance)
+		if len(missing) == 0 && len(extra) == 0 && len(different) == 0 {
+			return
+		}
+
+		Metrics.ShadowDiffs.Add(1)
+		Metrics.ShadowMissing.Add(int64(len(missing)))
+		Metrics.ShadowExtra.Add(int64(len(extra)))
+		Metrics.ShadowMismatchedPoints.Add(int64(points))
+		s.logger.Warn("Shadow render response differs",
+			zap.String("carbonapi_uuid", uuid),
+			zap.Strings("targets", request.Targets),
+			zap.Int32("from", request.From),
+			zap.Int32("until", request.Until),
+			zap.Int("missing", len(missing)),
+			zap.Strings("missing_examples", examples(missing)),
+			zap.Int("extra", len(extra)),
+			zap.Strings("extra_examples", examples(extra)),
+			zap.Int("different", len(different)),
+			zap.Strings("different_examples", examples(different)),
+			zap.Int("mismatched_points", points),
+		)
+	}()
+}
+
+// failed counts and logs the error of a mirrored request, and reports
+// whether there is no response to compare. Not finding anything is no error
+// if the main backends found nothing either.
+func (s *shadow) failed(uuid string, handler string, err error, mainEmpty bool) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := errors.Cause(err).(types.ErrNotFound); ok && mainEmpty {
+		return true
+	}
+
+	Metrics.ShadowErrors.Add(1)
+	s.logger.Warn("Shadow request failed",
+		zap.String("carbonapi_uuid", uuid),
+		zap.String("handler", handler),
+		zap.Error(err),
+	)
+
+	return true
+}
+
+func matchPaths(matches types.Matches) []string {
+	paths := make([]string, 0, len(matches.Matches))
+	for _, m := range matches.Matches {
+		paths = append(paths, m.Path)
+	}
+
+	return paths
+}
+
+// diffStrings returns the sorted strings of want missing from got, and those
+// of got that are not in want.
+func diffStrings(want, got []string) (missing, extra []string) {
+	wanted := make(map[string]bool, len(want))
+	for _, w := range want {
+		wanted[w] = true
+	}
+
+	seen := make(map[string]bool, len(got))
+	for _, g := range got {
+		seen[g] = true
+		if !wanted[g] {
+			extra = append(extra, g)
+		}
+	}
+
+	for _, w := range want {
+		if !seen[w] {
+			missing = append(missing, w)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	return missing, extra
+}
+
+// diffMetrics compares the metrics returned by the main and the shadow
+// backends by name, returning the sorted names missing from the shadow ones,
+// the extra ones, and those with mismatched points, with their number.
+func diffMetrics(want, got []types.Metric, tolerance float64) (missing, extra, different []string, points int) {
+	wantNames := make([]string, 0, len(want))
+	byName := make(map[string]types.Metric, len(want))
+	for _, m := range want {
+		wantNames = append(wantNames, m.Name)
+		byName[m.Name] = m
+	}
+
+	gotNames := make([]string, 0, len(got))
+	for _, m := range got {
+		gotNames = append(gotNames, m.Name)
+
+		w, ok := byName[m.Name]
+		if !ok {
+			continue
+		}
+		if n := mismatchedPoints(w, m, tolerance); n > 0 {
+			different = append(different, m.Name)
+			points += n
+		}
+	}
+
+	missing, extra = diffStrings(wantNames, gotNames)
+	sort.Strings(different)
+
+	return missing, extra, different, points
+}
+
+// mismatchedPoints returns the number of points of a and b that differ by
+// more than tolerance, relative to the larger of the two. All the points of
+// series with different start times, steps or lengths mismatch.
+func mismatchedPoints(a, b types.Metric, tolerance float64) int {
+	if a.StartTime != b.StartTime || a.StepTime != b.StepTime || len(a.Values) != len(b.Values) {
+		if len(a.Values) > len(b.Values) {
+			return len(a.Values)
+		}
+		return len(b.Values)
+	}
+
+	n := 0
+	for i := range a.Values {
+		aAbsent := (i < len(a.IsAbsent) && a.IsAbsent[i]) || math.IsNaN(a.Values[i])
+		bAbsent := (i < len(b.IsAbsent) && b.IsAbsent[i]) || math.IsNaN(b.Values[i])
+		if aAbsent != bAbsent {
+			n++
+			continue
+		}
+		if aAbsent {
+			continue
+		}
+
+		if math.Abs(a.Values[i]-b.Values[i]) > tolerance*math.Max(math.Abs(a.Values[i]), math.Abs(b.Values[i])) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func examples(s []string) []string {
+	if len(s) > maxDiffExamples {
+		return s[:maxDiffExamples]
+	}
+
+	return s
+}
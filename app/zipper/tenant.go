@@ -0,0 +1,79 @@
+package zipper
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/pkg/errors"
+)
+
+// tenant is the part of the metric tree a request may see: the backends
+// serving it and, optionally, the prefixes its metrics are under and the
+// routes to the backends of some of them.
+type tenant struct {
+	backends []backend.Backend
+	prefixes []string
+	routes   []route
+}
+
+// tenant returns the tenant named by the tenant header of req, or the
+// default one if there is no such header.
+func (app *App) tenant(req *http.Request) (tenant, error) {
+	groups := app.backends.current()
+	def := tenant{backends: groups.backends, routes: groups.routes}
+	if app.config.Tenants.Header == "" {
+		return def, nil
+	}
+
+	name := req.Header.Get(app.config.Tenants.Header)
+	if name == "" {
+		return def, nil
+	}
+
+	t, ok := groups.tenants[name]
+	if !ok {
+		return tenant{}, errors.Errorf("unknown tenant '%s'", name)
+	}
+
+	return t, nil
+}
+
+// checkTarget fails if target is not under one of the prefixes of t. The
+// prefix nodes are compared literally, so that globs can't escape them.
+func (t tenant) checkTarget(target string) error {
+	if t.underPrefix(target) {
+		return nil
+	}
+
+	return errors.Errorf("target '%s' is outside of the tenant's prefixes", target)
+}
+
+// findQuery returns query restricted to the prefixes of t. A query outside of
+// them is put under the prefix if the tenant has only one, and refused
+// otherwise.
+func (t tenant) findQuery(query string) (string, error) {
+	if t.underPrefix(query) {
+		return query, nil
+	}
+
+	if len(t.prefixes) == 1 {
+		return t.prefixes[0] + "." + query, nil
+	}
+
+	return "", errors.Errorf("query '%s' is outside of the tenant's prefixes", query)
+}
+
+func (t tenant) underPrefix(target string) bool {
+	if len(t.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range t.prefixes {
+		if target == prefix || strings.HasPrefix(target, prefix+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package zipper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// traceBackends lists the backends the request of trace was sent to in the
+// X-Carbonzipper-Backends header, if the client asked for it with trace=1,
+// e.g. "host1:8080;status=ok;dur=12.3ms;items=4, host2:8080;status=timeout;dur=1000.0ms;items=0".
+// It tells which backends a slow or partial request waited on.
+func traceBackends(w http.ResponseWriter, req *http.Request, trace types.Trace) {
+	if !parser.TruthyBool(req.FormValue("trace")) {
+		return
+	}
+
+	calls := trace.BackendCalls()
+	entries := make([]string, 0, len(calls))
+	for _, c := range calls {
+		entries = append(entries, fmt.Sprintf("%s;status=%s;dur=%.1fms;items=%d",
+			c.Address, callStatus(c.Err), float64(c.Duration)/float64(time.Millisecond), c.Items))
+	}
+
+	w.Header().Set("X-Carbonzipper-Backends", strings.Join(entries, ", "))
+}
+
+// callStatus is the status of a backend call that failed with err.
+func callStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	switch cause := errors.Cause(err); cause.(type) {
+	case types.ErrNotFound:
+		return "not_found"
+	case types.ErrTimeout:
+		return "timeout"
+	default:
+		if cause == context.DeadlineExceeded {
+			return "timeout"
+		}
+		if cause == context.Canceled {
+			return "canceled"
+		}
+	}
+
+	return "error"
+}
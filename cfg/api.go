@@ -49,6 +49,17 @@ func ParseAPIConfig(r io.Reader) (API, error) {
 		api.Backends = pre.Upstreams.Backends
 	}
 
+	if pre.Upstreams.MaxResponseSize != DefaultConfig.MaxResponseSize {
+		api.MaxResponseSize = pre.Upstreams.MaxResponseSize
+	}
+
+	if pre.Upstreams.Transport != DefaultConfig.Transport {
+		api.Transport = pre.Upstreams.Transport
+	}
+
+	api.applyLegacyTransport()
+	api.Timeouts.applyLegacyTimeouts()
+
 	return api, nil
 }
 
@@ -62,15 +73,54 @@ func defaultAPIConfig() API {
 		SendGlobsAsIs:         false,
 		AlwaysSendGlobsAsIs:   false,
 		MaxBatchSize:          100,
+		ParseCacheSize:        10000,
 		Cache: CacheConfig{
 			Type:              "mem",
 			DefaultTimeoutSec: 60,
 		},
+		HTTPCache: HTTPCacheConfig{
+			MaxAge:     10 * time.Minute,
+			FindMaxAge: time.Minute,
+		},
+		MaxGraphSize: GraphSizeConfig{
+			Width:  4000,
+			Height: 4000,
+		},
+		Audit: AuditConfig{
+			SampleRate: 1,
+		},
+		Events: EventsConfig{
+			Timeout: 10 * time.Second,
+		},
+		QueryCost: QueryCostConfig{
+			DefaultStep:      time.Minute,
+			QueueConcurrency: 1,
+		},
+		TopQueries: TopQueriesConfig{
+			Window: time.Hour,
+		},
+		WarmUp: WarmUpConfig{
+			Ahead: 10 * time.Second,
+		},
+		LoadShedding: LoadSheddingConfig{
+			CheckInterval: time.Second,
+			RetryAfter:    5 * time.Second,
+		},
+		Subscriptions: SubscriptionsConfig{
+			Interval:    10 * time.Second,
+			MinInterval: time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			MaxInFlight:    16,
+			MaxMessageSize: 64 * 1024,
+		},
 	}
 
 	cfg.Listen = ":8081"
 	cfg.MaxProcs = 0
 	cfg.Graphite.Prefix = "carbon.api"
+	// carbonapi has always answered CORS requests from any origin.
+	cfg.CORS.AllowedOrigins = []string{"*"}
 
 	return cfg
 }
@@ -93,6 +143,235 @@ type API struct {
 	IgnoreClientTimeout bool              `yaml:"ignoreClientTimeout"`
 	DefaultColors       map[string]string `yaml:"defaultColors"`
 	FunctionsConfigs    map[string]string `yaml:"functionsConfig"`
+	HTTPCache           HTTPCacheConfig   `yaml:"httpCache"`
+
+	// MaxGraphSize bounds the width and height of the PNG and SVG graphs,
+	// in pixels. Larger graphs are drawn at the largest size.
+	MaxGraphSize GraphSizeConfig `yaml:"maxGraphSize"`
+
+	HandlerConcurrency HandlerConcurrency `yaml:"handlerConcurrency"`
+	DenyTargets        DenyTargets        `yaml:"denyTargets"`
+	TargetRewrites     []RewriteRule      `yaml:"targetRewrites"`
+
+	// AliasFile is a YAML file mapping virtual metric names to the metric
+	// paths they are expanded to in render targets and find queries.
+	AliasFile string `yaml:"aliasFile"`
+	// FunctionAliases define functions as shorthands for longer
+	// expressions, in which $1, $2, ... stand for their arguments, e.g.
+	// p99: "percentileOfSeries($1, 99, true)". They are expanded when the
+	// render targets are parsed.
+	FunctionAliases map[string]string `yaml:"functionAliases"`
+	// DerivedMetrics define metrics as expressions over the stored ones,
+	// e.g. site.requests.total: "sumSeries(dc*.requests)". They are
+	// found by find queries, and rendered by name as their expression
+	// aliased to their name, so that common roll-ups needn't be stored.
+	DerivedMetrics map[string]string `yaml:"derivedMetrics"`
+	// AggregationRulesFile is an aggregation-rules.conf of
+	// carbon-aggregator. The metrics of its outputs that aren't stored are
+	// rendered by aggregating the metrics of their inputs.
+	AggregationRulesFile string `yaml:"aggregationRulesFile"`
+
+	FunctionRules FunctionRules `yaml:"functionRules"`
+
+	// ParseCacheSize is the number of recently rendered targets whose parsed
+	// expressions are kept, so that they are not parsed again. 0 disables
+	// the cache.
+	ParseCacheSize int `yaml:"parseCacheSize"`
+
+	// JSONNullPolicy is how null points are written in JSON render
+	// responses: keep (the default), drop, zero or carryForward. The
+	// nullPolicy and noNullPoints request parameters override it.
+	JSONNullPolicy string `yaml:"jsonNullPolicy"`
+
+	Audit AuditConfig `yaml:"audit"`
+
+	VerboseLogging VerboseLoggingConfig `yaml:"verboseLogging"`
+
+	Events EventsConfig `yaml:"events"`
+
+	QueryCost QueryCostConfig `yaml:"queryCost"`
+
+	TopQueries TopQueriesConfig `yaml:"topQueries"`
+
+	WarmUp WarmUpConfig `yaml:"warmUp"`
+
+	LoadShedding LoadSheddingConfig `yaml:"loadShedding"`
+
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+
+	WebSocket WebSocketConfig `yaml:"webSocket"`
+}
+
+// GraphSizeConfig is a size of graphs, in pixels.
+type GraphSizeConfig struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+}
+
+// AuditConfig controls the audit log, which records who (user, API key and
+// client IP) queried which targets over what time range. It is written to the
+// "audit" logger, which can have an output of its own in Logger. Only a
+// fingerprint of the API key, read from the APIKeyHeader request header, is
+// logged. SampleRate is the fraction of queries logged.
+type AuditConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	APIKeyHeader string  `yaml:"apiKeyHeader"`
+	SampleRate   float64 `yaml:"sampleRate"`
+}
+
+// VerboseLoggingConfig controls the verbose log, which records the full
+// details of a request, its headers and parameters included, to the "verbose"
+// logger. SampleRate is the fraction of requests logged. Besides those,
+// every EveryNthSlow request (1 if unset) taking SlowThreshold or longer is
+// logged too, if SlowThreshold is set.
+type VerboseLoggingConfig struct {
+	SampleRate    float64       `yaml:"sampleRate"`
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+	EveryNthSlow  int64         `yaml:"everyNthSlow"`
+}
+
+// QueryCostConfig controls the admission of render requests by their
+// estimated cost: the number of points of the series their globs resolve to,
+// from the steps of the series fetched lately or DefaultStep. Requests
+// costing more than RejectAbove points are refused, and those costing more
+// than QueueAbove wait for one of QueueConcurrency slots, so that only a few
+// expensive requests are fetched at the same time. Zero disables either.
+type QueryCostConfig struct {
+	DefaultStep      time.Duration `yaml:"defaultStep"`
+	QueueAbove       int64         `yaml:"queueAbove"`
+	QueueConcurrency int           `yaml:"queueConcurrency"`
+	RejectAbove      int64         `yaml:"rejectAbove"`
+}
+
+// TopQueriesConfig controls the tracking of the most frequent and most
+// expensive render targets, and of the most fetched metrics, over the current
+// and previous Window. Each is kept in Capacity entries, so that the counts of
+// the top ones are accurate as long as Capacity is well over the number
+// looked at. They are served by the /top-queries handler of the internal
+// listener. A Capacity of 0 disables the tracking.
+type TopQueriesConfig struct {
+	Capacity int           `yaml:"capacity"`
+	Window   time.Duration `yaml:"window"`
+}
+
+// WarmUpConfig controls the background rendering of popular render requests
+// into the response cache, Ahead of the expiry of their cached responses, so
+// that the most viewed dashboards always hit a warm cache. The requests are
+// the Queries, query strings such as "target=a.b&from=-1h&format=json", and
+// the Learn most frequent render requests of the last hour or two. Nothing is
+// warmed up without either.
+type WarmUpConfig struct {
+	Queries []string      `yaml:"queries"`
+	Learn   int           `yaml:"learn"`
+	Ahead   time.Duration `yaml:"ahead"`
+}
+
+// LoadSheddingConfig refuses the low priority requests with a 503 and a
+// Retry-After header while carbonapi is overloaded: while it runs more than
+// MaxGoroutines goroutines, its heap is larger than MaxHeapMB, or it serves
+// more than MaxInFlight requests. Zero disables a threshold. The goroutines
+// and heap are checked every CheckInterval. Requests are low priority if
+// their path starts with one of LowPriorityPaths, or if their PriorityHeader
+// header is "low".
+type LoadSheddingConfig struct {
+	MaxGoroutines    int           `yaml:"maxGoroutines"`
+	MaxHeapMB        int           `yaml:"maxHeapMB"`
+	MaxInFlight      int           `yaml:"maxInFlight"`
+	CheckInterval    time.Duration `yaml:"checkInterval"`
+	RetryAfter       time.Duration `yaml:"retryAfter"`
+	LowPriorityPaths []string      `yaml:"lowPriorityPaths"`
+	PriorityHeader   string        `yaml:"priorityHeader"`
+}
+
+// SubscriptionsConfig controls the live render subscriptions of the
+// /subscribe handler, which pushes the new points of its targets to clients as
+// Server-Sent Events every Interval, or the interval of the request, of at
+// least MinInterval. At most MaxSubscriptions are served at the same time, 0
+// disabling the handler. A subscription ends after MaxDuration, if set, and
+// before the write timeout of the listener; EventSource clients reconnect and
+// resume after the last event they got.
+type SubscriptionsConfig struct {
+	MaxSubscriptions int           `yaml:"maxSubscriptions"`
+	Interval         time.Duration `yaml:"interval"`
+	MinInterval      time.Duration `yaml:"minInterval"`
+	MaxDuration      time.Duration `yaml:"maxDuration"`
+}
+
+// WebSocketConfig controls the /ws handler, which serves find, render and
+// subscribe requests and their responses multiplexed over a WebSocket, for
+// pages issuing many small requests. At most MaxConnections are open at the
+// same time, 0 disabling the handler, each serving up to MaxInFlight find
+// and render requests at a time, of at most MaxMessageSize bytes.
+// Subscriptions are limited as those of /subscribe. Pages of AllowedOrigins,
+// "*" for any, may open WebSockets, besides those of carbonapi itself.
+type WebSocketConfig struct {
+	MaxConnections int      `yaml:"maxConnections"`
+	MaxInFlight    int      `yaml:"maxInFlight"`
+	MaxMessageSize int      `yaml:"maxMessageSize"`
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// EventsConfig proxies the graphite events API, which Grafana annotations of
+// graphite data sources call, to an events store answering it, such as
+// graphite-web at URL. Reads of /events/get_data are proxied once URL is
+// set; event writes only if AllowWrites is set too. Timeout bounds the
+// proxied requests.
+type EventsConfig struct {
+	URL         string        `yaml:"url"`
+	Timeout     time.Duration `yaml:"timeout"`
+	AllowWrites bool          `yaml:"allowWrites"`
+}
+
+// RewriteRule renames the metrics of incoming render targets and find queries
+// matching the Pattern regular expression, e.g. while metrics are moved to a
+// new prefix. The match is replaced by Replacement, which may refer to
+// submatches as $1. The first matching rule wins.
+type RewriteRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// FunctionRules refuse with a 403 the render targets calling functions that
+// are too expensive for the deployment. The rules at the top level apply to
+// all requests, and the ones of Tenants to the requests of the tenant named by
+// the Tenants.Header request header, in addition.
+type FunctionRules struct {
+	FunctionRule `yaml:",inline"`
+	Tenants      map[string]FunctionRule `yaml:"tenants"`
+}
+
+// FunctionRule lists the functions render targets may not call. If Allow is
+// set, only the functions it lists may be called. MaxRange limits the time
+// range a function may be called over, including the data it fetches before
+// or after the range of the request, e.g. timeShift: 168h.
+type FunctionRule struct {
+	Allow    []string                 `yaml:"allow"`
+	Deny     []string                 `yaml:"deny"`
+	MaxRange map[string]time.Duration `yaml:"maxRange"`
+}
+
+// DenyTargets refuses with a 403 the render targets and find queries matching
+// one of its rules, before they reach the backends. Globs refuse the globs of
+// as many nodes that expand to metrics they match, e.g. "*.*.*.*.*" refuses
+// "a.*.*.*.*", but not metric names; Regexps are matched against the metric
+// names of the query, e.g. "^expensive\.tree\.", and refuse the globs that
+// may expand to names they match: those whose literal prefix, up to their
+// first wildcard, agrees with the literal prefix of a regexp anchored with ^,
+// and all of them for the regexps that aren't. Each alternative of the {} of
+// a query is checked on its own. Queries are checked both as written and as
+// expanded by the AliasFile and rewritten by TargetRewrites.
+type DenyTargets struct {
+	Globs   []string `yaml:"globs"`
+	Regexps []string `yaml:"regexps"`
+}
+
+// HandlerConcurrency limits the requests served at the same time by each
+// handler, so that one kind of request can't starve the others. Requests over
+// the limit wait for a slot until their timeout. Zero means no limit.
+type HandlerConcurrency struct {
+	Find   int `yaml:"find"`
+	Render int `yaml:"render"`
+	Info   int `yaml:"info"`
 }
 
 type CacheConfig struct {
@@ -100,6 +379,26 @@ type CacheConfig struct {
 	Size              int      `yaml:"size_mb"`
 	MemcachedServers  []string `yaml:"memcachedServers"`
 	DefaultTimeoutSec int32    `yaml:"defaultTimeoutSec"`
+
+	// KeyGrid rounds the absolute from and until of render requests down to
+	// a multiple of it, so that the requests of dashboards whose "now"
+	// drifts every second share cached responses. Zero disables it.
+	KeyGrid time.Duration `yaml:"keyGrid"`
+	// NormalizeTargets strips the whitespace of render targets outside of
+	// strings, so that the same targets written differently share cached
+	// responses. The order of targets is kept, as it orders the series of
+	// responses.
+	NormalizeTargets bool `yaml:"normalizeTargets"`
+}
+
+// HTTPCacheConfig controls the Cache-Control and Expires headers that let
+// browsers and HTTP caches keep responses.
+type HTTPCacheConfig struct {
+	// Render responses can be kept for the coarsest step of the returned
+	// series, up to MaxAge. Zero disables the headers.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// Find responses can be kept for FindMaxAge. Zero disables the headers.
+	FindMaxAge time.Duration `yaml:"findMaxAge"`
 }
 
 type preAPI struct {
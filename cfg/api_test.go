@@ -37,6 +37,7 @@ cache:
    type: "memcache"
    size_mb: 0
    defaultTimeoutSec: 60
+   keyGrid: 10s
    memcachedServers:
        - host1:1234
        - host2:1234
@@ -70,6 +71,10 @@ logger:
 					Global:       10 * time.Minute,
 					AfterStarted: 10 * time.Minute,
 					Connect:      200 * time.Millisecond,
+
+					Find:   10 * time.Minute,
+					Render: 10 * time.Minute,
+					Info:   10 * time.Minute,
 				},
 				ConcurrencyLimitPerServer: 1024,
 				KeepAliveInterval:         30 * time.Second,
@@ -100,6 +105,7 @@ logger:
 				"host2:1234",
 			},
 			DefaultTimeoutSec: 60,
+			KeyGrid:           10 * time.Second,
 		},
 		TimezoneString: "UTC+1,3600",
 		PidFile:        "/var/run/carbonapi/carbonapi.pid",
@@ -175,6 +181,10 @@ logger:
 					Global:       10 * time.Minute,
 					AfterStarted: 10 * time.Minute,
 					Connect:      200 * time.Millisecond,
+
+					Find:   10 * time.Minute,
+					Render: 10 * time.Minute,
+					Info:   10 * time.Minute,
 				},
 				ConcurrencyLimitPerServer: 1024,
 				KeepAliveInterval:         30 * time.Second,
@@ -252,7 +262,9 @@ func eqCacheConfig(a, b CacheConfig) bool {
 	return a.Type == b.Type &&
 		a.Size == b.Size &&
 		eqStringSlice(a.MemcachedServers, b.MemcachedServers) &&
-		a.DefaultTimeoutSec == b.DefaultTimeoutSec
+		a.DefaultTimeoutSec == b.DefaultTimeoutSec &&
+		a.KeyGrid == b.KeyGrid &&
+		a.NormalizeTargets == b.NormalizeTargets
 }
 
 func eqStringSlice(a, b []string) bool {
@@ -2,8 +2,10 @@ package cfg
 
 import (
 	"io"
+	"net/http"
 	"time"
 
+	"github.com/bookingcom/carbonapi/util"
 	"github.com/lomik/zapwriter"
 	"gopkg.in/yaml.v2"
 )
@@ -23,6 +25,8 @@ func ParseCommon(r io.Reader) (Common, error) {
 
 	c := DefaultConfig
 	err := d.Decode(&c)
+	c.applyLegacyTransport()
+	c.Timeouts.applyLegacyTimeouts()
 
 	return c, err
 }
@@ -31,26 +35,363 @@ type Common struct {
 	Listen         string   `yaml:"listen"`
 	ListenInternal string   `yaml:"listenInternal"`
 	Backends       []string `yaml:"backends"`
+	// Zippers are other carbonzippers, e.g. those of other regions in a
+	// federated deployment, queried along with the backends of the default
+	// group. Each already merged the replicas of its own backends, which
+	// count in the trace and partial results in its place.
+	Zippers []string `yaml:"zippers"`
+	// DNS expands the hostnames of the backends to their addresses.
+	DNS DNS `yaml:"dns"`
 
 	MaxProcs                  int           `yaml:"maxProcs"`
 	Timeouts                  Timeouts      `yaml:"timeouts"`
 	ConcurrencyLimitPerServer int           `yaml:"concurrencyLimit"`
 	KeepAliveInterval         time.Duration `yaml:"keepAliveInterval"`
-	MaxIdleConnsPerHost       int           `yaml:"maxIdleConnsPerHost"`
+	MaxIdleConnsPerHost       int           `yaml:"maxIdleConnsPerHost"` // Deprecated: use Transport.
+	Transport                 Transport     `yaml:"transport"`
+	MaxResponseSize           int64         `yaml:"maxResponseSize"`
+	// Server bounds how long the main listener waits on its clients.
+	Server Server `yaml:"server"`
+	// AdaptiveConcurrency replaces the static concurrencyLimit of each
+	// backend by a limit following what the backend sustains.
+	AdaptiveConcurrency AdaptiveConcurrency `yaml:"adaptiveConcurrency"`
 
-	ExpireDelaySec             int32   `yaml:"expireDelaySec"`
-	GraphiteWeb09Compatibility bool    `yaml:"graphite09compat"`
-	CorruptionThreshold        float64 `yaml:"corruptionThreshold"`
+	ExpireDelaySec             int32       `yaml:"expireDelaySec"`
+	GraphiteWeb09Compatibility bool        `yaml:"graphite09compat"`
+	CorruptionThreshold        float64     `yaml:"corruptionThreshold"`
+	Merge                      MergeConfig `yaml:"merge"`
+	Tenants                    Tenants     `yaml:"tenants"`
+	// StorageSchemas is the path of the storage-schemas.conf of the
+	// backends. If set, render ranges are clamped to what the retentions
+	// of the metrics still have, and replicas are merged at their step.
+	StorageSchemas string `yaml:"storageSchemas"`
+	// StorageAggregation is the path of the storage-aggregation.conf of
+	// the backends. If set, its aggregation methods consolidate the points
+	// of the metrics they match, after the consolidationRules of Merge.
+	StorageAggregation string `yaml:"storageAggregation"`
+	// Routes send the requests for metrics under a prefix to a group of
+	// the backends only, instead of to all of them.
+	Routes []Route `yaml:"routes"`
+	// MaxFanOut is the most backends a request may be sent to after
+	// routing. The requests routed to more are refused with a 400. 0 is no
+	// limit.
+	MaxFanOut int `yaml:"maxFanOut"`
+	// ExpireJitter randomizes the expiry of path cache entries by up to
+	// this fraction of ExpireDelaySec either way, so that entries set
+	// together don't expire together. RefreshAheadSec refreshes the
+	// entries still used this many seconds before they expire.
+	ExpireJitter    float64 `yaml:"expireJitter"`
+	RefreshAheadSec int32   `yaml:"refreshAheadSec"`
+
+	// PartialResults is what the zipper does when some of the backends of
+	// a request failed: "allow" (the default) answers with the data of the
+	// others and an X-Carbonzipper-Partial header, "strict" fails the
+	// request with a 5xx.
+	PartialResults string `yaml:"partialResults"`
+	// ErrorBudget configures the rolling error rates of the backends.
+	ErrorBudget ErrorBudget `yaml:"errorBudget"`
+	// Shadow mirrors a share of the traffic to a canary backend group.
+	Shadow Shadow `yaml:"shadow"`
+	// FaultInjection delays or fails some of the calls to the backends.
+	FaultInjection FaultInjection `yaml:"faultInjection"`
+	// Sanitize replaces the NaN and ±Inf points of the backends.
+	Sanitize Sanitize `yaml:"sanitize"`
+	// Readiness is when /ready tells the zipper is ready to serve.
+	Readiness Readiness `yaml:"readiness"`
+	// LBCheck is the health check of the load balancers.
+	LBCheck LBCheck `yaml:"lbCheck"`
+	// Prewarm opens connections to the backends before the zipper is
+	// ready.
+	Prewarm Prewarm `yaml:"prewarm"`
+
+	// AccessRules restrict the networks allowed to call each handler, on
+	// both the main and the internal listener.
+	AccessRules []util.AccessRule `yaml:"accessRules"`
+	CORS        util.CORSConfig   `yaml:"cors"`
+	// ClientLimit limits the concurrent requests and the request rate of
+	// each client, so that one client can't take all the backend
+	// concurrency.
+	ClientLimit util.ClientLimit `yaml:"clientLimit"`
 
 	Buckets  int                `yaml:"buckets"`
 	Graphite GraphiteConfig     `yaml:"graphite"`
 	Logger   []zapwriter.Config `yaml:"logger"`
 }
 
+// MergeConfig configures how replicas of a series returned by different
+// backends are merged. Policy is one of "fill" (the default), "fewestNulls",
+// "primary" or "error". Primary is the backend preferred by "primary".
+//
+// If NormalizeSteps is set, replicas with different steps are consolidated
+// to a common step before being merged. The consolidation function is picked
+// from the first matching ConsolidationRules entry, or else the first
+// matching section of StorageAggregation, falling back to Consolidation.
+//
+// Replicas whose start times disagree, as after a clock adjustment of a
+// backend, are snapped to the step grid if they're off it by no more than
+// Skew, so that their points line up rather than leaving gaps.
+type MergeConfig struct {
+	Policy             string              `yaml:"policy"`
+	Primary            string              `yaml:"primary"`
+	NormalizeSteps     bool                `yaml:"normalizeSteps"`
+	Consolidation      string              `yaml:"consolidation"`
+	ConsolidationRules []ConsolidationRule `yaml:"consolidationRules"`
+	Skew               time.Duration       `yaml:"skew"`
+}
+
+// ConsolidationRule maps metrics matching the Pattern regular expression to a
+// consolidation function.
+type ConsolidationRule struct {
+	Pattern  string `yaml:"pattern"`
+	Function string `yaml:"function"`
+}
+
+// AdaptiveConcurrency, if Enabled, adapts the limit of concurrent requests
+// to each backend to the latency of its responses, from concurrencyLimit,
+// between MinLimit (default 1) and MaxLimit (default 1000). The limit grows
+// while the latency holds, and shrinks as it rises or as requests fail.
+type AdaptiveConcurrency struct {
+	Enabled  bool `yaml:"enabled"`
+	MinLimit int  `yaml:"minLimit"`
+	MaxLimit int  `yaml:"maxLimit"`
+}
+
+// ErrorBudget configures the rolling error rate kept for each backend. A
+// backend whose error rate over Window goes over MaxErrorRate, out of at
+// least MinRequests requests, is logged as unhealthy until it is back under.
+// A MaxErrorRate of 0 never logs it.
+type ErrorBudget struct {
+	Window       time.Duration `yaml:"window"`
+	MaxErrorRate float64       `yaml:"maxErrorRate"`
+	MinRequests  int64         `yaml:"minRequests"`
+}
+
+// Shadow mirrors a Percentage of the find and render requests to the
+// Backends of a canary group, e.g. a new storage backend under validation.
+// Their responses are discarded, or, in the compare mode enabled by Diff,
+// compared to those of the main backends, which are the ones returned. The
+// missing series and the points differing by more than Tolerance, relative
+// to the larger value, are then logged and counted, to quantify correctness
+// before a migration. A Percentage of 100 compares every request.
+type Shadow struct {
+	Backends   []string `yaml:"backends"`
+	Percentage float64  `yaml:"percentage"`
+	Diff       bool     `yaml:"diff"`
+	Tolerance  float64  `yaml:"tolerance"`
+}
+
+// FaultInjection delays or fails a percentage of the calls to each backend,
+// to rehearse partial outages in staging. Nothing is injected unless Enabled
+// is set. Backends maps "host:port" addresses to their faults, and "*" to
+// the faults of the backends not listed.
+type FaultInjection struct {
+	Enabled  bool             `yaml:"enabled"`
+	Backends map[string]Fault `yaml:"backends"`
+}
+
+// Sanitize, if Enabled, replaces the NaN and ±Inf points of the series of
+// the backends, which break the JSON responses, with Replacement, or makes
+// them absent if it's not set. The points replaced are counted by backend.
+type Sanitize struct {
+	Enabled     bool     `yaml:"enabled"`
+	Replacement *float64 `yaml:"replacement"`
+}
+
+// DNS, if Expand is set, resolves the hostnames of the backends, of the
+// default group, of the child zippers and of the tenants, to all their A and
+// AAAA records, each then a backend of its own, with its own connection pool,
+// error rate and concurrency limit. They are resolved again every
+// RefreshInterval, so that the backends follow the scaling of their pools. A
+// hostname that fails to resolve keeps its last addresses. The backends of
+// new addresses show in the expvars, graphite and prometheus, and a
+// hostname of Merge.Primary makes all its addresses primary. https backends
+// aren't expanded, as their certificates name their hostnames.
+type DNS struct {
+	Expand          bool          `yaml:"expand"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// Readiness tells when the zipper is ready to serve: once the backends have
+// been probed, and the share Quorum of them, from 0 to 1, answered the last
+// probe, and at least one. /lb_check only tells that the zipper is up. The
+// backends are probed every Interval, with a find of their top-level domains,
+// so that /ready answers 503 soon after they lose the quorum.
+type Readiness struct {
+	Quorum   float64       `yaml:"quorum"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Prewarm opens Connections idle connections to each backend on startup,
+// and to the new backends of re-resolved hostnames, with as many finds of
+// their top-level domains, so that the first requests after a deploy don't
+// wait to connect. /ready answers 503 until they are open, or the find
+// timeout has passed. The connections kept idle are bounded by the
+// maxIdleConnsPerHost of the transport.
+type Prewarm struct {
+	Connections int `yaml:"connections"`
+}
+
+// LBCheck is the health check of the load balancers: Path answers with Body
+// and the response Headers the monitors require. If CanaryMetric is set,
+// Path also finds it, and answers 503 if it isn't found within
+// CanaryTimeout.
+type LBCheck struct {
+	Path          string            `yaml:"path"`
+	Body          string            `yaml:"body"`
+	Headers       map[string]string `yaml:"headers"`
+	CanaryMetric  string            `yaml:"canaryMetric"`
+	CanaryTimeout time.Duration     `yaml:"canaryTimeout"`
+}
+
+// Fault is the fault injected in the calls to a backend: DelayPercentage of
+// them wait for Delay before being made, and ErrorPercentage of them fail.
+type Fault struct {
+	Delay           time.Duration `yaml:"delay"`
+	DelayPercentage float64       `yaml:"delayPercentage"`
+	ErrorPercentage float64       `yaml:"errorPercentage"`
+}
+
+// Tenants route requests to backend groups of their own, by the value of the
+// Header request header, so that a single zipper can front several isolated
+// clusters. Requests without the header go to Backends.
+type Tenants struct {
+	Header string            `yaml:"header"`
+	Groups map[string]Tenant `yaml:"groups"`
+}
+
+// Tenant is the backend group serving a tenant. Its backends have path caches
+// of their own, even when they are also listed in another group.
+//
+// If Prefixes is set, the tenant may only see metrics under them: renders and
+// infos of other targets are refused, and finds are put under the prefix if
+// there is only one, or refused.
+type Tenant struct {
+	Backends []string `yaml:"backends"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// Route sends the requests for metrics under Prefix, e.g. "dc1" or
+// "business.sales", to Backends, which must also be listed in the backends of
+// the zipper. Prefix nodes are matched literally, and the longest matching
+// prefix wins. Requests with targets matching no route go to all backends.
+//
+// A Blackhole route has no backends: the requests for its metrics, e.g. those
+// of a deprecated tree, are answered with empty results without being sent
+// to any backend.
+type Route struct {
+	Prefix    string   `yaml:"prefix"`
+	Backends  []string `yaml:"backends"`
+	Blackhole bool     `yaml:"blackhole"`
+}
+
+// Transport tunes the HTTP connections to the backends. HTTP2 is "" for
+// HTTP/1.1, "h2" to use HTTP/2 over TLS with backends that support it, or
+// "h2c" to use HTTP/2 without TLS. Zero durations mean no limit.
+//
+// Go doesn't cache DNS lookups, but connections are kept open to the address
+// they were made to; DNSRefreshInterval periodically closes idle connections
+// so that backends moving to new addresses are picked up.
+type Transport struct {
+	HTTP2                 string        `yaml:"http2"`
+	MaxIdleConnsPerHost   int           `yaml:"maxIdleConnsPerHost"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout"`
+	IdleConnTimeout       time.Duration `yaml:"idleConnTimeout"`
+	DNSRefreshInterval    time.Duration `yaml:"dnsRefreshInterval"`
+}
+
+// applyLegacyTransport honours the top-level maxIdleConnsPerHost, unless the
+// transport section sets it too.
+func (c *Common) applyLegacyTransport() {
+	if c.MaxIdleConnsPerHost != DefaultConfig.MaxIdleConnsPerHost &&
+		c.Transport.MaxIdleConnsPerHost == DefaultConfig.Transport.MaxIdleConnsPerHost {
+		c.Transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	c.MaxIdleConnsPerHost = c.Transport.MaxIdleConnsPerHost
+}
+
 type Timeouts struct {
 	Global       time.Duration `yaml:"global"`
 	AfterStarted time.Duration `yaml:"afterStarted"`
 	Connect      time.Duration `yaml:"connect"`
+
+	// Per-operation timeouts, by default the global timeout. Those left at
+	// their default follow a global timeout changed from its default, as
+	// they did before they existed.
+	Find   time.Duration `yaml:"find"`
+	Render time.Duration `yaml:"render"`
+	Info   time.Duration `yaml:"info"`
+}
+
+// Server configures the main HTTP listener, so that slow clients can't tie
+// its connections up. ReadTimeout bounds reading a whole request, and
+// ReadHeaderTimeout its headers (by default ReadTimeout). WriteTimeout bounds
+// writing a response (by default the longest of the Timeouts). IdleTimeout is
+// how long a keep-alive connection waits for the next request (by default
+// ReadTimeout). MaxHeaderBytes limits the size of the request headers (by
+// default 1MB).
+//
+// If DrainTimeout is set, SIGINT and SIGTERM stop the listener, wait up to
+// DrainTimeout for the requests in flight and flush the statistics to
+// graphite before exiting. Otherwise the listener is served by gracehttp,
+// which drains for up to a minute, and restarts gracefully on SIGUSR2.
+type Server struct {
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	MaxHeaderBytes    int           `yaml:"maxHeaderBytes"`
+	DrainTimeout      time.Duration `yaml:"drainTimeout"`
+}
+
+// HTTPServer returns an HTTP server of handler listening on addr, configured
+// by s. writeTimeout is the WriteTimeout unless s has one.
+func (s Server) HTTPServer(addr string, handler http.Handler, writeTimeout time.Duration) *http.Server {
+	if s.WriteTimeout > 0 {
+		writeTimeout = s.WriteTimeout
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+	}
+}
+
+// Longest returns the longest of the global and per-operation timeouts, which
+// the HTTP server must allow for writing a response.
+func (t Timeouts) Longest() time.Duration {
+	longest := t.Global
+	for _, d := range []time.Duration{t.Find, t.Render, t.Info} {
+		if d > longest {
+			longest = d
+		}
+	}
+
+	return longest
+}
+
+// applyLegacyTimeouts makes the per-operation timeouts left at their default
+// follow the global timeout, if that was changed.
+func (t *Timeouts) applyLegacyTimeouts() {
+	def := DefaultConfig.Timeouts
+	if t.Global == def.Global {
+		return
+	}
+
+	if t.Find == def.Find {
+		t.Find = t.Global
+	}
+	if t.Render == def.Render {
+		t.Render = t.Global
+	}
+	if t.Info == def.Info {
+		t.Info = t.Global
+	}
 }
 
 var DefaultConfig = Common{
@@ -62,12 +403,40 @@ var DefaultConfig = Common{
 		Global:       10000 * time.Millisecond,
 		AfterStarted: 2 * time.Second,
 		Connect:      200 * time.Millisecond,
+
+		Find:   10 * time.Second,
+		Render: 10 * time.Second,
+		Info:   10 * time.Second,
 	},
 	ConcurrencyLimitPerServer: 20,
 	KeepAliveInterval:         30 * time.Second,
 	MaxIdleConnsPerHost:       100,
+	Server: Server{
+		ReadTimeout: time.Second,
+	},
+	Transport: Transport{
+		MaxIdleConnsPerHost: 100,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	},
+
+	DNS: DNS{
+		RefreshInterval: time.Minute,
+	},
+	Readiness: Readiness{
+		Interval: 10 * time.Second,
+	},
+	LBCheck: LBCheck{
+		Path:          "/lb_check",
+		Body:          "Ok\n",
+		CanaryTimeout: time.Second,
+	},
 
 	ExpireDelaySec: int32(10 * time.Minute / time.Second),
+	ErrorBudget: ErrorBudget{
+		Window:      time.Minute,
+		MinRequests: 10,
+	},
 
 	Buckets: 10,
 	Graphite: GraphiteConfig{
@@ -47,6 +47,10 @@ logger:
 			Global:       20 * time.Second,
 			AfterStarted: 15 * time.Second,
 			Connect:      200 * time.Millisecond,
+
+			Find:   20 * time.Second,
+			Render: 20 * time.Second,
+			Info:   20 * time.Second,
 		},
 		ConcurrencyLimitPerServer: 2048,
 		KeepAliveInterval:         30 * time.Second,
@@ -101,3 +105,194 @@ func eqCommon(a, b Common) bool {
 	return toComparableCommon(a) == toComparableCommon(b) &&
 		eqStringSlice(a.Backends, b.Backends)
 }
+
+func TestParseCommonTransport(t *testing.T) {
+	var input = `
+transport:
+    http2: "h2c"
+    maxIdleConnsPerHost: 10
+    responseHeaderTimeout: "5s"
+    dnsRefreshInterval: "1m"
+`
+
+	got, err := ParseCommon(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Transport{
+		HTTP2:                 "h2c",
+		MaxIdleConnsPerHost:   10,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		DNSRefreshInterval:    time.Minute,
+	}
+
+	if got.Transport != expected {
+		t.Fatalf("Didn't parse expected transport\nGot: %v\nExp: %v", got.Transport, expected)
+	}
+	if got.MaxIdleConnsPerHost != 10 {
+		t.Errorf("Expected maxIdleConnsPerHost 10, got %d", got.MaxIdleConnsPerHost)
+	}
+}
+
+func TestParseCommonTimeouts(t *testing.T) {
+	var input = `
+timeouts:
+    global: "20s"
+    find: "1m"
+`
+
+	got, err := ParseCommon(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Timeouts{
+		Global:       20 * time.Second,
+		AfterStarted: 2 * time.Second,
+		Connect:      200 * time.Millisecond,
+
+		Find:   time.Minute,
+		Render: 20 * time.Second,
+		Info:   20 * time.Second,
+	}
+
+	if got.Timeouts != expected {
+		t.Fatalf("Didn't parse expected timeouts\nGot: %v\nExp: %v", got.Timeouts, expected)
+	}
+	if got.Timeouts.Longest() != time.Minute {
+		t.Errorf("Expected longest timeout 1m, got %v", got.Timeouts.Longest())
+	}
+}
+
+func TestParseCommonDefaultTimeouts(t *testing.T) {
+	got, err := ParseCommon(strings.NewReader("listen: \":8000\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Timeouts != DefaultConfig.Timeouts {
+		t.Fatalf("Expected default timeouts\nGot: %v\nExp: %v", got.Timeouts, DefaultConfig.Timeouts)
+	}
+	if got.Timeouts.Find != got.Timeouts.Global {
+		t.Errorf("Expected find timeout equal to the global timeout, got %v and %v", got.Timeouts.Find, got.Timeouts.Global)
+	}
+}
+
+func TestParseCommonServer(t *testing.T) {
+	var input = `
+server:
+    readHeaderTimeout: "500ms"
+    idleTimeout: "2m"
+    maxHeaderBytes: 65536
+`
+
+	got, err := ParseCommon(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Server{
+		ReadTimeout:       time.Second,
+		ReadHeaderTimeout: 500 * time.Millisecond,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    65536,
+	}
+	if got.Server != expected {
+		t.Fatalf("Didn't parse expected server\nGot: %v\nExp: %v", got.Server, expected)
+	}
+
+	s := got.Server.HTTPServer(":8080", nil, time.Minute)
+	if s.WriteTimeout != time.Minute || s.ReadHeaderTimeout != 500*time.Millisecond || s.MaxHeaderBytes != 65536 {
+		t.Errorf("Expected the server to be configured, got %+v", s)
+	}
+
+	got.Server.WriteTimeout = 30 * time.Second
+	if s := got.Server.HTTPServer(":8080", nil, time.Minute); s.WriteTimeout != 30*time.Second {
+		t.Errorf("Expected a write timeout of 30s, got %v", s.WriteTimeout)
+	}
+}
+
+func TestParseCommonTenants(t *testing.T) {
+	var input = `
+tenants:
+    header: "X-Tenant"
+    groups:
+        team-a:
+            backends:
+                - "http://10.1.0.1:8080"
+                - "http://10.1.0.2:8080"
+        team-b:
+            backends:
+                - "http://10.2.0.1:8080"
+            prefixes:
+                - "team_b"
+`
+
+	got, err := ParseCommon(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Tenants.Header != "X-Tenant" {
+		t.Errorf("Expected tenant header X-Tenant, got '%s'", got.Tenants.Header)
+	}
+	if len(got.Tenants.Groups) != 2 {
+		t.Fatalf("Expected 2 tenants, got %v", got.Tenants.Groups)
+	}
+	if !eqStringSlice(got.Tenants.Groups["team-a"].Backends, []string{"http://10.1.0.1:8080", "http://10.1.0.2:8080"}) {
+		t.Errorf("Unexpected backends for team-a: %v", got.Tenants.Groups["team-a"].Backends)
+	}
+	if !eqStringSlice(got.Tenants.Groups["team-b"].Backends, []string{"http://10.2.0.1:8080"}) {
+		t.Errorf("Unexpected backends for team-b: %v", got.Tenants.Groups["team-b"].Backends)
+	}
+	if !eqStringSlice(got.Tenants.Groups["team-b"].Prefixes, []string{"team_b"}) {
+		t.Errorf("Unexpected prefixes for team-b: %v", got.Tenants.Groups["team-b"].Prefixes)
+	}
+}
+
+func TestParseCommonRoutes(t *testing.T) {
+	var input = `
+routes:
+    - prefix: "dc1"
+      backends:
+          - "http://10.0.0.1:8080"
+    - prefix: "business.sales"
+      backends:
+          - "http://10.0.0.2:8080"
+          - "http://10.0.0.3:8080"
+    - prefix: "legacy"
+      blackhole: true
+`
+
+	got, err := ParseCommon(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %v", got.Routes)
+	}
+	if got.Routes[1].Prefix != "business.sales" {
+		t.Errorf("Expected prefix business.sales, got '%s'", got.Routes[1].Prefix)
+	}
+	if !eqStringSlice(got.Routes[1].Backends, []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}) {
+		t.Errorf("Unexpected backends for business.sales: %v", got.Routes[1].Backends)
+	}
+	if !got.Routes[2].Blackhole || got.Routes[0].Blackhole {
+		t.Errorf("Expected only legacy to be a blackhole, got %v", got.Routes)
+	}
+}
+
+func TestParseCommonLegacyMaxIdleConns(t *testing.T) {
+	got, err := ParseCommon(strings.NewReader("maxIdleConnsPerHost: 1024\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Transport.MaxIdleConnsPerHost != 1024 {
+		t.Errorf("Expected transport maxIdleConnsPerHost 1024, got %d", got.Transport.MaxIdleConnsPerHost)
+	}
+}
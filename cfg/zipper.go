@@ -24,7 +24,7 @@ func ParseZipperConfig(r io.Reader) (Zipper, error) {
 func fromCommon(c Common) Zipper {
 	return Zipper{
 		Common:    c,
-		PathCache: pathcache.NewPathCache(c.ExpireDelaySec),
+		PathCache: pathcache.NewPathCache(c.ExpireDelaySec, c.ExpireJitter, c.RefreshAheadSec),
 	}
 }
 
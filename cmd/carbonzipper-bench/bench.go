@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/loadtest"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+)
+
+// walk collects up to max leaves of the metric tree of the instance at base,
+// breadth first from the nodes matching root.
+func walk(client *http.Client, base string, root string, max int) ([]string, error) {
+	var leaves []string
+	queue := []string{root}
+	for len(queue) > 0 && len(leaves) < max {
+		query := queue[0]
+		queue = queue[1:]
+
+		v := url.Values{"query": []string{query}, "format": []string{"protobuf"}}
+		resp, err := client.Get(base + "/metrics/find/?" + v.Encode())
+		if err != nil {
+			return nil, err
+		}
+		blob, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("find '%s': %s", query, resp.Status)
+		}
+
+		matches, err := carbonapi_v2.FindDecoder(blob)
+		if err != nil {
+			return nil, fmt.Errorf("find '%s': %v", query, err)
+		}
+
+		for _, m := range matches.Matches {
+			if m.IsLeaf {
+				leaves = append(leaves, m.Path)
+			} else {
+				queue = append(queue, m.Path+".*")
+			}
+		}
+	}
+
+	if len(leaves) > max {
+		leaves = leaves[:max]
+	}
+
+	return leaves, nil
+}
+
+// bench sends generated requests to the instance at base.
+type bench struct {
+	base        string
+	client      *http.Client
+	concurrency int
+	rate        float64
+	mix         mix
+}
+
+func (b bench) url(req request) string {
+	v := url.Values{"format": []string{"protobuf"}}
+	if req.handler == "find" {
+		v.Set("query", req.target)
+		return b.base + "/metrics/find/?" + v.Encode()
+	}
+
+	v.Set("target", req.target)
+	v.Set("from", strconv.FormatInt(req.from, 10))
+	v.Set("until", strconv.FormatInt(req.until, 10))
+
+	return b.base + "/render/?" + v.Encode()
+}
+
+// run sends requests for d, at the configured rate if any, and returns how
+// they went.
+func (b bench) run(d time.Duration) *loadtest.Summary {
+	s := loadtest.NewSummary()
+	work := make(chan request)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range work {
+				t0 := time.Now()
+				code, err := b.send(req)
+				s.Add(req.handler, code, err, time.Since(t0))
+			}
+		}()
+	}
+
+	start := time.Now()
+	end := start.Add(d)
+	for n := 0; ; n++ {
+		now := time.Now()
+		if b.rate > 0 {
+			due := start.Add(time.Duration(float64(n) / b.rate * float64(time.Second)))
+			if wait := due.Sub(now); wait > 0 {
+				time.Sleep(wait)
+				now = due
+			}
+		}
+		if !now.Before(end) {
+			break
+		}
+		work <- b.mix.next(now)
+	}
+	close(work)
+	wg.Wait()
+	s.Elapsed = time.Since(start)
+
+	return s
+}
+
+func (b bench) send(req request) (int, error) {
+	resp, err := b.client.Get(b.url(req))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode, err
+}
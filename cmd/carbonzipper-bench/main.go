@@ -0,0 +1,75 @@
+// Command carbonzipper-bench sends a synthetic mix of find and render
+// requests to an instance and reports their latency percentiles, so that
+// performance changes can be evaluated the same way every time.
+//
+// It first walks the metric tree of the instance from -root to collect up to
+// -metrics leaves. Each request picks one of them at random and replaces some
+// of its nodes with wildcards, their number drawn from the -wildcards
+// distribution. Render requests end now and start a duration ago drawn from
+// the -ranges distribution. Distributions are comma-separated value:weight
+// pairs.
+//
+//	carbonzipper-bench -url http://localhost:8080 -duration 5m -rate 200
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "base URL of the instance to send the requests to")
+	root := flag.String("root", "*", "pattern of the nodes the walk of the metric tree starts from")
+	maxMetrics := flag.Int("metrics", 10000, "leaves of the metric tree to collect at most")
+	duration := flag.Duration("duration", time.Minute, "time to send requests for")
+	concurrency := flag.Int("concurrency", 10, "requests in flight at most")
+	rate := flag.Float64("rate", 0, "requests per second, 0 sends them as fast as -concurrency allows")
+	findShare := flag.Float64("finds", 0.2, "share of find requests, from 0 to 1")
+	wildcards := flag.String("wildcards", "0:50,1:30,2:15,3:5", "distribution of the number of wildcard nodes in the requests")
+	ranges := flag.String("ranges", "1h:60,6h:15,1d:15,7d:7,30d:3", "distribution of the time ranges of the render requests")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of each request")
+	seed := flag.Int64("seed", 0, "seed of the random mix, 0 uses the current time")
+	flag.Parse()
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	wildcardDist, err := parseWildcards(*wildcards)
+	if err != nil {
+		log.Fatalf("-wildcards: %v", err)
+	}
+	rangeDist, err := parseRanges(*ranges)
+	if err != nil {
+		log.Fatalf("-ranges: %v", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	metrics, err := walk(client, *url, *root, *maxMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(metrics) == 0 {
+		log.Fatalf("no metrics found under '%s'", *root)
+	}
+	log.Printf("collected %d metrics, seed %d", len(metrics), *seed)
+
+	b := bench{
+		base:        *url,
+		client:      client,
+		concurrency: *concurrency,
+		rate:        *rate,
+		mix: mix{
+			rand:      rand.New(rand.NewSource(*seed)),
+			metrics:   metrics,
+			findShare: *findShare,
+			wildcards: wildcardDist,
+			ranges:    rangeDist,
+		},
+	}
+	b.run(*duration).Print(os.Stdout)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// distribution draws values with the given weights.
+type distribution struct {
+	values  []int64
+	weights []float64
+	total   float64
+}
+
+// parseDistribution parses comma-separated value:weight pairs, with parse
+// turning the values into numbers.
+func parseDistribution(s string, parse func(string) (int64, error)) (distribution, error) {
+	var d distribution
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.LastIndex(pair, ":")
+		if i == -1 {
+			return d, fmt.Errorf("expected value:weight, got '%s'", pair)
+		}
+
+		value, err := parse(strings.TrimSpace(pair[:i]))
+		if err != nil {
+			return d, err
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+		if err != nil || weight < 0 {
+			return d, fmt.Errorf("bad weight '%s'", pair[i+1:])
+		}
+
+		d.values = append(d.values, value)
+		d.weights = append(d.weights, weight)
+		d.total += weight
+	}
+
+	if d.total <= 0 {
+		return d, fmt.Errorf("weights add up to 0")
+	}
+
+	return d, nil
+}
+
+func parseWildcards(s string) (distribution, error) {
+	return parseDistribution(s, func(v string) (int64, error) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad number of wildcards '%s'", v)
+		}
+		return n, nil
+	})
+}
+
+// parseRanges parses a distribution of durations, which may also be in days
+// such as "7d". The values are in seconds.
+func parseRanges(s string) (distribution, error) {
+	return parseDistribution(s, func(v string) (int64, error) {
+		if strings.HasSuffix(v, "d") {
+			days, err := strconv.ParseInt(strings.TrimSuffix(v, "d"), 10, 64)
+			if err != nil || days <= 0 {
+				return 0, fmt.Errorf("bad time range '%s'", v)
+			}
+			return days * 24 * 3600, nil
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return 0, fmt.Errorf("bad time range '%s'", v)
+		}
+		return int64(d / time.Second), nil
+	})
+}
+
+func (d distribution) draw(r *rand.Rand) int64 {
+	x := r.Float64() * d.total
+	for i, w := range d.weights {
+		if x < w {
+			return d.values[i]
+		}
+		x -= w
+	}
+
+	return d.values[len(d.values)-1]
+}
+
+// request is a generated request.
+type request struct {
+	handler string // find or render
+	target  string
+	from    int64
+	until   int64
+}
+
+// mix generates requests. It is not safe for concurrent use.
+type mix struct {
+	rand      *rand.Rand
+	metrics   []string
+	findShare float64
+	wildcards distribution
+	ranges    distribution
+}
+
+func (m *mix) next(now time.Time) request {
+	nodes := strings.Split(m.metrics[m.rand.Intn(len(m.metrics))], ".")
+	wildcards := int(m.wildcards.draw(m.rand))
+	if wildcards > len(nodes) {
+		wildcards = len(nodes)
+	}
+	for _, i := range m.rand.Perm(len(nodes))[:wildcards] {
+		nodes[i] = "*"
+	}
+	target := strings.Join(nodes, ".")
+
+	if m.rand.Float64() < m.findShare {
+		return request{handler: "find", target: target}
+	}
+
+	until := now.Unix()
+	return request{
+		handler: "render",
+		target:  target,
+		from:    until - m.ranges.draw(m.rand),
+		until:   until,
+	}
+}
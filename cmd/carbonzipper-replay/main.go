@@ -0,0 +1,65 @@
+// Command carbonzipper-replay replays the requests of a carbonzipper access
+// log, or of a CSV of target,from,until, against an instance, for capacity
+// testing and regression checks.
+//
+// The access log must use the json encoding. Its find, render and info
+// requests are replayed, spaced as they were logged, divided by -speedup.
+// CSV lines are render requests, with an optional fourth column holding the
+// Unix time they were made at; without it they are sent as fast as
+// -concurrency allows.
+//
+//	carbonzipper-replay -url http://localhost:8080 -concurrency 20 -speedup 2 access.log
+//	carbonzipper-replay -url http://localhost:8080 -format csv requests.csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080", "base URL of the instance to replay the requests against")
+	format := flag.String("format", "log", "input format: log (json access log) or csv (target,from,until[,time])")
+	concurrency := flag.Int("concurrency", 10, "requests in flight at most")
+	speedup := flag.Float64("speedup", 1, "speed-up of the logged request rate, 0 sends requests as fast as possible")
+	relative := flag.Bool("relative", false, "shift the time range of the requests so that it ends as long before now as it did before they were made")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of each request")
+	flag.Parse()
+
+	input := os.Stdin
+	if flag.NArg() > 0 && flag.Arg(0) != "-" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var requests []request
+	var err error
+	switch *format {
+	case "log":
+		requests, err = readAccessLog(input)
+	case "csv":
+		requests, err = readCSV(input)
+	default:
+		err = fmt.Errorf("unknown format '%s'", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := replayer{
+		base:        *url,
+		client:      &http.Client{Timeout: *timeout},
+		concurrency: *concurrency,
+		speedup:     *speedup,
+		relative:    *relative,
+	}
+	r.replay(requests).Print(os.Stdout)
+}
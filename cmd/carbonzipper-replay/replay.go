@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/loadtest"
+)
+
+// request is a request to replay.
+type request struct {
+	handler string // find, render or info
+	target  string
+	from    int64
+	until   int64
+	at      time.Time // When it was made, zero if unknown.
+}
+
+// accessLogEntry holds the fields of an access log line needed to replay
+// it. The timestamp is a string in the iso8601 time encoding, and a number
+// of seconds, milliseconds or nanoseconds in the others.
+type accessLogEntry struct {
+	Logger    string          `json:"logger"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Handler   string          `json:"handler"`
+	Target    string          `json:"target"`
+	From      int64           `json:"from"`
+	Until     int64           `json:"until"`
+}
+
+// readAccessLog reads the find, render and info requests of a json access
+// log. Lines that aren't json, or from another logger, are skipped.
+func readAccessLog(r io.Reader) ([]request, error) {
+	var requests []request
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e accessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Logger != "access" || e.Target == "" {
+			continue
+		}
+
+		switch e.Handler {
+		case "find", "render", "info":
+		default:
+			continue
+		}
+
+		requests = append(requests, request{
+			handler: e.Handler,
+			target:  e.Target,
+			from:    e.From,
+			until:   e.Until,
+			at:      parseTimestamp(e.Timestamp),
+		})
+	}
+
+	return requests, scanner.Err()
+}
+
+// parseTimestamp parses the timestamp of a log line, returning the zero time
+// if it can't.
+func parseTimestamp(raw json.RawMessage) time.Time {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		t, _ := time.Parse("2006-01-02T15:04:05.000Z0700", s)
+		return t
+	}
+
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return time.Time{}
+	}
+
+	switch {
+	case f > 1e17:
+		return time.Unix(0, int64(f))
+	case f > 1e11:
+		return time.Unix(0, int64(f*float64(time.Millisecond)))
+	default:
+		return time.Unix(0, int64(f*float64(time.Second)))
+	}
+}
+
+// readCSV reads render requests from target,from,until[,time] lines.
+func readCSV(r io.Reader) ([]request, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var requests []request
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected target,from,until[,time]", line)
+		}
+
+		req := request{handler: "render", target: record[0]}
+		if req.from, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad from: %v", line, err)
+		}
+		if req.until, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad until: %v", line, err)
+		}
+		if len(record) > 3 {
+			at, err := strconv.ParseInt(record[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad time: %v", line, err)
+			}
+			req.at = time.Unix(at, 0)
+		}
+
+		requests = append(requests, req)
+	}
+}
+
+// replayer sends requests to the instance at base.
+type replayer struct {
+	base        string
+	client      *http.Client
+	concurrency int
+	speedup     float64
+	relative    bool
+}
+
+// url returns the URL of req, asking for protocol buffers responses like
+// carbonapi does.
+func (r replayer) url(req request, now time.Time) string {
+	v := url.Values{"format": []string{"protobuf"}}
+	switch req.handler {
+	case "find":
+		v.Set("query", req.target)
+		return r.base + "/metrics/find/?" + v.Encode()
+
+	case "info":
+		v.Set("target", req.target)
+		return r.base + "/info/?" + v.Encode()
+	}
+
+	from, until := req.from, req.until
+	if from == 0 && until == 0 {
+		until = now.Unix()
+		from = until - 3600
+	} else if r.relative && !req.at.IsZero() {
+		shift := int64(now.Sub(req.at) / time.Second)
+		from += shift
+		until += shift
+	}
+
+	v.Set("target", req.target)
+	v.Set("from", strconv.FormatInt(from, 10))
+	v.Set("until", strconv.FormatInt(until, 10))
+
+	return r.base + "/render/?" + v.Encode()
+}
+
+// replay sends requests, keeping the intervals between their times divided
+// by the speed-up, and returns how they went.
+func (r replayer) replay(requests []request) *loadtest.Summary {
+	s := loadtest.NewSummary()
+	work := make(chan request)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range work {
+				t0 := time.Now()
+				code, err := r.send(req, t0)
+				s.Add(req.handler, code, err, time.Since(t0))
+			}
+		}()
+	}
+
+	start := time.Now()
+	var first time.Time
+	for _, req := range requests {
+		if r.speedup > 0 && !req.at.IsZero() {
+			if first.IsZero() {
+				first = req.at
+			}
+			due := start.Add(time.Duration(float64(req.at.Sub(first)) / r.speedup))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		work <- req
+	}
+	close(work)
+	wg.Wait()
+	s.Elapsed = time.Since(start)
+
+	return s
+}
+
+func (r replayer) send(req request, now time.Time) (int, error) {
+	resp, err := r.client.Get(r.url(req, now))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode, err
+}
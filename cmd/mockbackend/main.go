@@ -0,0 +1,201 @@
+// Command mockbackend serves the find, render and info protocol of
+// go-carbon's carbonserver over a synthetic metric tree, with configurable
+// latencies and injected failures, so that zipper features can be tried
+// without a go-carbon cluster.
+//
+// The tree has -root as its single root node, and -fanout children per node
+// at each level below it. With the default of 10,10,10 it has the metrics
+// mock.n0.n0.n0 to mock.n9.n9.n9. The values are a deterministic daily wave,
+// so that several mock backends serving the same tree return the same
+// points. Only protocol buffers responses are served.
+//
+//	mockbackend -listen :8081 -fanout 100,10 -latency 20ms -jitter 10ms -errors 0.01
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+const contentTypeProtobuf = "application/x-protobuf"
+
+// faults are the latencies and failures injected in the responses.
+type faults struct {
+	latency     time.Duration
+	jitter      time.Duration
+	errorRate   float64
+	slowRate    float64
+	slowLatency time.Duration
+}
+
+// wrap delays the responses of h and fails some of them.
+func (f faults) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay := f.latency
+		if f.jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(f.jitter)))
+		}
+		if rand.Float64() < f.slowRate {
+			delay = f.slowLatency
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		if rand.Float64() < f.errorRate {
+			http.Error(w, "injected failure", http.StatusInternalServerError)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+type server struct {
+	tree       tree
+	retention  retention
+	maxMatches int
+}
+
+func (s server) findHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	matches := s.tree.find(query, s.maxMatches)
+	if len(matches) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	s.write(w, func() ([]byte, error) {
+		return carbonapi_v2.FindEncoder(types.Matches{Name: query, Matches: matches})
+	})
+}
+
+func (s server) renderHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.ParseInt(r.FormValue("from"), 10, 32)
+	if err != nil {
+		http.Error(w, "bad from", http.StatusBadRequest)
+		return
+	}
+	until, err := strconv.ParseInt(r.FormValue("until"), 10, 32)
+	if err != nil || until < from {
+		http.Error(w, "bad until", http.StatusBadRequest)
+		return
+	}
+
+	r.ParseForm()
+	var names []string
+	for _, target := range r.Form["target"] {
+		for _, m := range s.tree.find(target, s.maxMatches) {
+			if m.IsLeaf {
+				names = append(names, m.Path)
+			}
+		}
+	}
+	if len(names) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	metrics := make([]types.Metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, s.retention.series(name, int32(from), int32(until)))
+	}
+
+	s.write(w, func() ([]byte, error) {
+		return carbonapi_v2.RenderEncoder(metrics)
+	})
+}
+
+func (s server) infoHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.FormValue("target")
+	matches := s.tree.find(target, 1)
+	if len(matches) == 0 || !matches[0].IsLeaf || strings.ContainsAny(target, "*?[{") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	info := carbonapi_v2_pb.InfoResponse{
+		Name:              target,
+		AggregationMethod: "average",
+		MaxRetention:      s.retention.step * s.retention.points,
+		XFilesFactor:      0.5,
+		Retentions: []carbonapi_v2_pb.Retention{{
+			SecondsPerPoint: s.retention.step,
+			NumberOfPoints:  s.retention.points,
+		}},
+	}
+	s.write(w, info.Marshal)
+}
+
+func (s server) write(w http.ResponseWriter, marshal func() ([]byte, error)) {
+	blob, err := marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.Write(blob)
+}
+
+func main() {
+	listen := flag.String("listen", ":8081", "address to listen on")
+	root := flag.String("root", "mock", "name of the root node of the metric tree")
+	fanout := flag.String("fanout", "10,10,10", "comma-separated number of children per node at each level of the metric tree")
+	step := flag.Duration("step", time.Minute, "time between the points of the metrics")
+	keep := flag.Duration("retention", 7*24*time.Hour, "time the points of the metrics are kept for")
+	maxMatches := flag.Int("max-matches", 100000, "matches of a glob returned at most")
+	latency := flag.Duration("latency", 0, "latency of the responses")
+	jitter := flag.Duration("jitter", 0, "random latency added to the responses, up to this much")
+	errorRate := flag.Float64("errors", 0, "share of requests failing with 500, from 0 to 1")
+	slowRate := flag.Float64("slow", 0, "share of requests answered after -slow-latency instead, from 0 to 1")
+	slowLatency := flag.Duration("slow-latency", 30*time.Second, "latency of the slow responses")
+	flag.Parse()
+
+	t := tree{root: *root}
+	for _, f := range strings.Split(*fanout, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n <= 0 {
+			log.Fatalf("-fanout: bad number of children '%s'", f)
+		}
+		t.fanout = append(t.fanout, n)
+	}
+	if *step < time.Second || *keep < *step {
+		log.Fatal("-step must be at least a second, and -retention at least a step")
+	}
+
+	s := server{
+		tree: t,
+		retention: retention{
+			step:   int32(*step / time.Second),
+			points: int32(*keep / *step),
+		},
+		maxMatches: *maxMatches,
+	}
+	f := faults{
+		latency:     *latency,
+		jitter:      *jitter,
+		errorRate:   *errorRate,
+		slowRate:    *slowRate,
+		slowLatency: *slowLatency,
+	}
+
+	http.HandleFunc("/metrics/find/", f.wrap(s.findHandler))
+	http.HandleFunc("/render/", f.wrap(s.renderHandler))
+	http.HandleFunc("/info/", f.wrap(s.infoHandler))
+
+	log.Printf("serving %s on %s", *root, *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
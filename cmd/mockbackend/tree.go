@@ -0,0 +1,86 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/glob"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// tree is a synthetic metric tree. Under the root node, level i has
+// fanout[i] children per node, named n0, n1, ... The nodes of the last level
+// are the metrics.
+type tree struct {
+	root   string
+	fanout []int
+}
+
+// find returns the nodes matching the glob query, up to max of them.
+func (t tree) find(query string, max int) []types.Match {
+	patterns := strings.Split(query, ".")
+	if len(patterns) > len(t.fanout)+1 || !glob.MatchNode(patterns[0], t.root) {
+		return nil
+	}
+
+	var matches []types.Match
+	var walk func(prefix string, level int)
+	walk = func(prefix string, level int) {
+		if level == len(patterns) {
+			matches = append(matches, types.Match{
+				Path:   prefix,
+				IsLeaf: level == len(t.fanout)+1,
+			})
+			return
+		}
+
+		for i := 0; i < t.fanout[level-1] && len(matches) < max; i++ {
+			name := "n" + strconv.Itoa(i)
+			if glob.MatchNode(patterns[level], name) {
+				walk(prefix+"."+name, level+1)
+			}
+		}
+	}
+	walk(t.root, 1)
+
+	return matches
+}
+
+// retention is the single archive of the metrics of a tree.
+type retention struct {
+	step   int32
+	points int32
+}
+
+// series returns the points of the metric name from from to until, a daily
+// wave whose level and phase depend on the name, so that every instance
+// serving the same tree returns the same values.
+func (r retention) series(name string, from, until int32) types.Metric {
+	from -= from % r.step
+	until -= until % r.step
+	if oldest := until - r.step*(r.points-1); from < oldest {
+		from = oldest
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	seed := float64(h.Sum32() % 1000)
+
+	n := int((until-from)/r.step) + 1
+	m := types.Metric{
+		Name:      name,
+		StartTime: from,
+		StopTime:  until + r.step,
+		StepTime:  r.step,
+		Values:    make([]float64, n),
+		IsAbsent:  make([]bool, n),
+	}
+	for i := range m.Values {
+		t := float64(from + int32(i)*r.step)
+		m.Values[i] = seed + 100*math.Sin(2*math.Pi*(t+seed*86.4)/86400)
+	}
+
+	return m
+}
@@ -47,6 +47,12 @@ var TimeFormats = []string{"20060102", "01/02/06"}
 
 // DateParamToEpoch turns a passed string parameter into a unix epoch
 func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Location) int32 {
+	return DateParamToEpochAt(s, qtz, d, defaultTimeZone, timeNow())
+}
+
+// DateParamToEpochAt is DateParamToEpoch with relative times and dates, like
+// "-1d" and "today", relative to now
+func DateParamToEpochAt(s string, qtz string, d int64, defaultTimeZone *time.Location, now time.Time) int32 {
 
 	if s == "" {
 		// return the default if nothing was passed
@@ -60,16 +66,24 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 			return int32(d)
 		}
 
-		return int32(timeNow().Add(time.Duration(offset) * time.Second).Unix())
+		return int32(now.Add(time.Duration(offset) * time.Second).Unix())
+	}
+
+	// named times and dates are in the requested time zone
+	var tz = defaultTimeZone
+	if qtz != "" {
+		if z, err := time.LoadLocation(qtz); err == nil {
+			tz = z
+		}
 	}
 
 	switch s {
 	case "now":
-		return int32(timeNow().Unix())
+		return int32(now.Unix())
 	case "midnight", "noon", "teatime":
-		yy, mm, dd := timeNow().Date()
+		yy, mm, dd := now.In(tz).Date()
 		hh, min, _ := parseTime(s) // error ignored, we know it's valid
-		dt := time.Date(yy, mm, dd, hh, min, 0, 0, defaultTimeZone)
+		dt := time.Date(yy, mm, dd, hh, min, 0, 0, tz)
 		return int32(dt.Unix())
 	}
 
@@ -93,23 +107,16 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 		return int32(d)
 	}
 
-	var tz = defaultTimeZone
-	if qtz != "" {
-		if z, err := time.LoadLocation(qtz); err != nil {
-			tz = z
-		}
-	}
-
 	var t time.Time
 dateStringSwitch:
 	switch ds {
 	case "today":
-		t = timeNow()
+		t = now.In(tz)
 		// nothing
 	case "yesterday":
-		t = timeNow().AddDate(0, 0, -1)
+		t = now.In(tz).AddDate(0, 0, -1)
 	case "tomorrow":
-		t = timeNow().AddDate(0, 0, 1)
+		t = now.In(tz).AddDate(0, 0, 1)
 	default:
 		for _, format := range TimeFormats {
 			t, err = time.ParseInLocation(format, ds, tz)
@@ -128,7 +135,7 @@ dateStringSwitch:
 	}
 
 	yy, mm, dd := t.Date()
-	t = time.Date(yy, mm, dd, hour, minute, 0, 0, defaultTimeZone)
+	t = time.Date(yy, mm, dd, hour, minute, 0, 0, tz)
 
 	return int32(t.Unix())
 }
@@ -47,3 +47,55 @@ func TestDateParamToEpoch(t *testing.T) {
 		}
 	}
 }
+
+func TestDateParamToEpochTimeZone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	timeNow = func() time.Time {
+		// 16 Aug 1994 20:00 UTC, already 17 Aug in Tokyo
+		return time.Date(1994, time.August, 16, 20, 0, 0, 0, time.UTC)
+	}
+
+	var tests = []struct {
+		input string
+		qtz   string
+		want  time.Time
+	}{
+		{"midnight", "Asia/Tokyo", time.Date(1994, time.August, 17, 0, 0, 0, 0, tokyo)},
+		{"midnight", "", time.Date(1994, time.August, 16, 0, 0, 0, 0, time.UTC)},
+		{"noon yesterday", "Asia/Tokyo", time.Date(1994, time.August, 16, 12, 0, 0, 0, tokyo)},
+		{"12:00 19940812", "Asia/Tokyo", time.Date(1994, time.August, 12, 12, 0, 0, 0, tokyo)},
+		{"midnight", "Bogus/Zone", time.Date(1994, time.August, 16, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got := DateParamToEpoch(tt.input, tt.qtz, 0, time.UTC)
+		if want := int32(tt.want.Unix()); got != want {
+			t.Errorf("DateParamToEpoch(%q, %q)=%v, want %v", tt.input, tt.qtz, got, want)
+		}
+	}
+}
+
+func TestDateParamToEpochAt(t *testing.T) {
+	now := time.Date(2001, time.September, 9, 1, 46, 40, 0, time.UTC)
+
+	var tests = []struct {
+		input string
+		want  time.Time
+	}{
+		{"now", now},
+		{"-1h", now.Add(-time.Hour)},
+		{"yesterday", time.Date(2001, time.September, 8, 0, 0, 0, 0, time.UTC)},
+		{"", time.Unix(42, 0)},
+	}
+
+	for _, tt := range tests {
+		got := DateParamToEpochAt(tt.input, "", 42, time.UTC, now)
+		if want := int32(tt.want.Unix()); got != want {
+			t.Errorf("DateParamToEpochAt(%q)=%v, want %v", tt.input, got, want)
+		}
+	}
+}
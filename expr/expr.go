@@ -1,6 +1,8 @@
 package expr
 
 import (
+	"time"
+
 	// Import all known functions
 	_ "github.com/bookingcom/carbonapi/expr/functions"
 	"github.com/bookingcom/carbonapi/expr/helper"
@@ -48,6 +50,7 @@ func EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest]
 	f, ok := metadata.FunctionMD.Functions[e.Target()]
 	metadata.FunctionMD.RUnlock()
 	if ok {
+		defer recordCall(e.Target(), time.Now())
 		return f.Do(e, from, until, values)
 	}
 
@@ -56,7 +59,7 @@ func EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest]
 
 // RewriteExpr expands targets that use applyByNode into a new list of targets.
 // eg:
-// applyByNode(foo*, 1, "%") -> (true, ["foo1", "foo2"], nil)
+// applyByNode(foo*, 0, "%") -> (true, ["foo1", "foo2"], nil)
 // sumSeries(foo) -> (false, nil, nil)
 // Assumes that applyByNode only appears as the outermost function.
 func RewriteExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
@@ -65,6 +68,7 @@ func RewriteExpr(e parser.Expr, from, until int32, values map[parser.MetricReque
 		f, ok := metadata.FunctionMD.RewriteFunctions[e.Target()]
 		metadata.FunctionMD.RUnlock()
 		if ok {
+			defer recordCall(e.Target(), time.Now())
 			return f.Do(e, from, until, values)
 		}
 	}
@@ -1,6 +1,7 @@
 package expr
 
 import (
+	"expvar"
 	"math"
 	"testing"
 	"time"
@@ -79,6 +80,16 @@ func TestAlignToBucketSize(t *testing.T) {
 	}
 }
 
+func TestAlignToBucketSizeIn(t *testing.T) {
+	// two hours ahead of UTC, so days start at 22:00 UTC
+	loc := time.FixedZone("UTC+2", 2*60*60)
+
+	start, stop := helper.AlignToBucketSizeIn(3*60*60, 30*60*60, 24*60*60, loc)
+	if start != -2*60*60 || stop != 46*60*60 {
+		t.Errorf("got start %d stop %d, want start %d stop %d", start, stop, -2*60*60, 46*60*60)
+	}
+}
+
 func TestAlignToInterval(t *testing.T) {
 	tests := []struct {
 		inputStart int32
@@ -594,6 +605,16 @@ func TestEvalExpression(t *testing.T) {
 			[]*types.MetricData{types.MakeMetricData("minSeries(metric1,metric2,metric3)",
 				[]float64{1, math.NaN(), 2, 3, 4, 5}, 1, now32)},
 		},
+		{
+			parser.NewExpr("minMax",
+				"metric1",
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, math.NaN(), 2, 3, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("minMax(metric1)",
+				[]float64{0, math.NaN(), 0.25, 0.5, 1}, 1, now32)},
+		},
 		{
 			parser.NewExpr("divideSeriesLists",
 				"metric1", "metric2",
@@ -1104,6 +1125,23 @@ func TestEvalExpression(t *testing.T) {
 					[]float64{1, 2, 3, 4, 5, 6}, 1, now32),
 			},
 		},
+		{
+			parser.NewExpr("linearRegression",
+
+				"metric1",
+				parser.ArgValue(fmt.Sprint(now32+2)),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {
+					types.MakeMetricData("metric1",
+						[]float64{10, 10, 3, 4, 5, 6}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData(fmt.Sprintf("linearRegression(metric1,'%d')", now32+2),
+					[]float64{1, 2, 3, 4, 5, 6}, 1, now32),
+			},
+		},
 		{
 			parser.NewExpr("polyfit",
 
@@ -1439,6 +1477,175 @@ func TestEvalSummarize(t *testing.T) {
 	}
 }
 
+func TestEvalSummarizeTimeZone(t *testing.T) {
+	values := make([]float64, 48)
+	for i := range values {
+		values[i] = float64(i + 1)
+	}
+	m := types.MakeMetricData("metric1", values, 60*60, 0)
+	m.TimeZone = time.FixedZone("UTC+2", 2*60*60)
+
+	// the days start at midnight in the time zone of the request
+	tt := th.SummarizeEvalTestItem{
+		E: parser.NewExpr("summarize",
+			"metric1", parser.ArgValue("1d"),
+		),
+		M: map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {m},
+		},
+		W:     []float64{253, 828, 95},
+		Name:  "summarize(metric1,'1d')",
+		Step:  24 * 60 * 60,
+		Start: -2 * 60 * 60,
+		Stop:  70 * 60 * 60,
+	}
+	th.TestSummarizeEvalExpr(t, &tt)
+}
+
+func TestEvalLinearRegressionTimeZone(t *testing.T) {
+	m := types.MakeMetricData("metric1", []float64{10, 10, 3, 4, 10, 10}, 60*60, 0)
+	m.DateTimeZone = time.FixedZone("UTC+2", 2*60*60)
+
+	// the source range is 02:00 to 03:00 UTC, in the time zone of the request
+	tt := th.EvalTestItem{
+		E: parser.NewExpr("linearRegression",
+			"metric1", parser.ArgValue("04:00_19700101"), parser.ArgValue("05:00_19700101"),
+		),
+		M: map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {m},
+		},
+		Want: []*types.MetricData{
+			types.MakeMetricData("linearRegression(metric1,'04:00_19700101','05:00_19700101')",
+				[]float64{1, 2, 3, 4, 5, 6}, 60*60, 0),
+		},
+	}
+	th.TestEvalExpr(t, &tt)
+}
+
+func TestEvalXFilesFactor(t *testing.T) {
+	tenThirtyTwo, _, tenThirty := th.InitTestSummarize()
+	now32 := tenThirty
+
+	withXFilesFactor := func(m *types.MetricData, xFilesFactor float32) *types.MetricData {
+		m.XFilesFactor = xFilesFactor
+		return m
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("sum",
+				"metric1", "metric2", "metric3",
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {withXFilesFactor(types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, math.NaN()}, 1, now32), 0.7)},
+				{"metric2", 0, 1}: {types.MakeMetricData("metric2", []float64{2, 3, math.NaN(), 5, 6, math.NaN()}, 1, now32)},
+				{"metric3", 0, 1}: {types.MakeMetricData("metric3", []float64{3, 4, 5, 6, math.NaN(), math.NaN()}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("sumSeries(metric1,metric2,metric3)", []float64{6, 9, math.NaN(), 15, math.NaN(), math.NaN()}, 1, now32)},
+		},
+		{
+			parser.NewExpr("averageSeriesWithWildcards",
+				"metric1.foo.*", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*", 0, 1}: {
+					withXFilesFactor(types.MakeMetricData("metric1.foo.bar1", []float64{1, 2, math.NaN()}, 1, now32), 1),
+					types.MakeMetricData("metric1.foo.bar2", []float64{3, math.NaN(), math.NaN()}, 1, now32),
+				},
+			},
+			[]*types.MetricData{types.MakeMetricData("averageSeriesWithWildcards(metric1.foo)", []float64{2, math.NaN(), math.NaN()}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+
+	summarizeTests := []th.SummarizeEvalTestItem{
+		{
+			parser.NewExpr("summarize",
+				"metric1", parser.ArgValue("5s"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {withXFilesFactor(types.MakeMetricData("metric1", []float64{
+					1, math.NaN(), math.NaN(), math.NaN(), 5,
+					1, 2, math.NaN(), 4, 5,
+				}, 1, now32), 0.5)},
+			},
+			[]float64{math.NaN(), 12},
+			"summarize(metric1,'5s')",
+			5,
+			now32,
+			now32 + 10,
+		},
+		{
+			parser.NewExpr("summarize",
+				"metric1", parser.ArgValue("10min"),
+				parser.NamedArgs{
+					"alignToFrom": parser.ArgName("true"),
+				},
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {withXFilesFactor(types.MakeMetricData("metric1", []float64{
+					1, 1, 1, 1, 1, 2, 2, 2, 2, 2,
+					3, math.NaN(), math.NaN(), math.NaN(), 3, 4, 4, 4, 4, 4,
+					5, 5, math.NaN(), 5, 5}, 60, tenThirtyTwo), 0.8)},
+			},
+			[]float64{15, math.NaN(), 20},
+			"summarize(metric1,'10min','sum',true)",
+			600,
+			tenThirtyTwo,
+			tenThirtyTwo + 25*60,
+		},
+	}
+
+	for _, tt := range summarizeTests {
+		th.TestSummarizeEvalExpr(t, &tt)
+	}
+}
+
+func TestHoltWintersConfidenceArea(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	m := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", -4, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 1, 2, 3, 1, 2, 3, 2}, 1, now32)},
+	}
+
+	bands, err := EvalExpr(parser.NewExpr("holtWintersConfidenceBands",
+		"metric1", 2, parser.ArgValue("4s"), parser.ArgValue("3s"),
+	), 0, 1, m)
+	if err != nil {
+		t.Fatalf("failed to eval holtWintersConfidenceBands: %v", err)
+	}
+
+	// Without cairo, areaBetween returns nothing and the bands are
+	// returned as they are.
+	area, err := EvalExpr(parser.NewExpr("holtWintersConfidenceArea",
+		"metric1", 2, parser.NamedArgs{"bootstrapInterval": parser.ArgValue("4s"), "seasonality": parser.ArgValue("3s")},
+	), 0, 1, m)
+	if err != nil {
+		t.Fatalf("failed to eval holtWintersConfidenceArea: %v", err)
+	}
+
+	if len(bands) != 2 || len(area) != 2 {
+		t.Fatalf("expected 2 series, got %d bands and %d areas", len(bands), len(area))
+	}
+	for i := range area {
+		if area[i].Name != "holtWintersConfidenceArea(metric1)" {
+			t.Errorf("bad name %s", area[i].Name)
+		}
+		if area[i].StartTime != now32+4 {
+			t.Errorf("bad start time %d, want %d", area[i].StartTime, now32+4)
+		}
+		if !th.NearlyEqualMetrics(area[i], bands[i]) {
+			t.Errorf("got %v, want %v", area[i].Values, bands[i].Values)
+		}
+	}
+}
+
 func TestRewriteExpr(t *testing.T) {
 	now32 := int32(time.Now().Unix())
 
@@ -1471,7 +1678,7 @@ func TestRewriteExpr(t *testing.T) {
 			parser.NewExpr("applyByNode",
 
 				"metric*",
-				1,
+				0,
 				parser.ArgValue("%.count"),
 			),
 			map[parser.MetricRequest][]*types.MetricData{
@@ -1490,7 +1697,7 @@ func TestRewriteExpr(t *testing.T) {
 			parser.NewExpr("applyByNode",
 
 				"metric*",
-				1,
+				0,
 				parser.ArgValue("%.count"),
 				parser.ArgValue("% count"),
 			),
@@ -1510,7 +1717,7 @@ func TestRewriteExpr(t *testing.T) {
 			parser.NewExpr("applyByNode",
 
 				"foo.metric*",
-				2,
+				1,
 				parser.ArgValue("%.count"),
 			),
 			map[parser.MetricRequest][]*types.MetricData{
@@ -1528,6 +1735,29 @@ func TestRewriteExpr(t *testing.T) {
 			true,
 			[]string{"foo.metric1.count", "foo.metric2.count"},
 		},
+		{
+			"applyByNode unique prefixes",
+			parser.NewExpr("applyByNode",
+
+				"servers.*.disk.bytes_*",
+				1,
+				parser.ArgValue("divideSeries(%.disk.bytes_free,sumSeries(%.disk.bytes_*))"),
+				parser.ArgValue("%.disk.pct_free"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"servers.*.disk.bytes_*", 0, 1}: {
+					types.MakeMetricData("servers.host1.disk.bytes_free", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("servers.host1.disk.bytes_used", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("servers.host2.disk.bytes_free", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("servers.host2.disk.bytes_used", []float64{1, 2, 3}, 1, now32),
+				},
+			},
+			true,
+			[]string{
+				"alias(divideSeries(servers.host1.disk.bytes_free,sumSeries(servers.host1.disk.bytes_*)),\"servers.host1.disk.pct_free\")",
+				"alias(divideSeries(servers.host2.disk.bytes_free,sumSeries(servers.host2.disk.bytes_*)),\"servers.host2.disk.pct_free\")",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -2152,3 +2382,31 @@ func TestEvalCustomFromUntil(t *testing.T) {
 		})
 	}
 }
+
+func TestFunctionStats(t *testing.T) {
+	calls := func(name string) int64 {
+		if v, ok := FunctionCalls.Get(name).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	scaleCalls, absoluteCalls := calls("scale"), calls("absolute")
+
+	e := parser.NewExpr("scale", parser.NewExpr("absolute", "metric1"), 2)
+	_, err := EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{-1, 2}, 1, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := calls("scale") - scaleCalls; got != 1 {
+		t.Errorf("scale was called %d times, want 1", got)
+	}
+	if got := calls("absolute") - absoluteCalls; got != 1 {
+		t.Errorf("absolute was called %d times, want 1", got)
+	}
+	if FunctionTimeNS.Get("scale") == nil {
+		t.Error("no time recorded for scale")
+	}
+}
@@ -0,0 +1,199 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type aggregate struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &aggregate{}
+	functions := []string{"aggregate", "aggregateWithWildcards"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// aggregate(seriesList, func, xFilesFactor=None)
+// aggregateWithWildcards(seriesList, func, *positions)
+func (f *aggregate) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := e.GetStringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	agg, ok := helper.Aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation function: %s", name)
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	if e.Target() == "aggregate" {
+		xFilesFactor, err := e.GetFloatNamedOrPosArgDefault("xFilesFactor", 2, float64(args[0].XFilesFactor))
+		if err != nil {
+			return nil, err
+		}
+
+		r := aggregateSeries(args, agg, float32(xFilesFactor))
+		r.Name = fmt.Sprintf("%sSeries(%s)", name, e.Args()[0].ToString())
+		return []*types.MetricData{r}, nil
+	}
+
+	positions, err := e.GetIntArgs(2)
+	if err != nil && err != parser.ErrMissingArgument {
+		return nil, err
+	}
+	// The positions take all the remaining arguments, so xFilesFactor can
+	// only be named.
+	xFilesFactor, err := e.GetFloatNamedOrPosArgDefault("xFilesFactor", len(e.Args()), float64(args[0].XFilesFactor))
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeList []string
+	groups := make(map[string][]*types.MetricData)
+	for _, a := range args {
+		metric := helper.ExtractMetric(a.Name)
+		nodes := strings.Split(metric, ".")
+		var s []string
+		for i, n := range nodes {
+			if !helper.Contains(positions, i) {
+				s = append(s, n)
+			}
+		}
+
+		node := strings.Join(s, ".")
+		if len(groups[node]) == 0 {
+			nodeList = append(nodeList, node)
+		}
+
+		groups[node] = append(groups[node], a)
+	}
+
+	results := make([]*types.MetricData, 0, len(nodeList))
+	for _, node := range nodeList {
+		r := aggregateSeries(groups[node], agg, float32(xFilesFactor))
+		r.Name = node
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// aggregateSeries aggregates the points of args. A point is absent if the
+// fraction of series having it is less than xFilesFactor, or if no series has
+// it. Unless given, xFilesFactor defaults to the one of the first series.
+func aggregateSeries(args []*types.MetricData, agg helper.Aggregator, xFilesFactor float32) *types.MetricData {
+	args = helper.AlignSeries(args)
+	length := len(args[0].Values)
+
+	r := *args[0]
+	r.Values = make([]float64, length)
+	r.IsAbsent = make([]bool, length)
+	r.XFilesFactor = xFilesFactor
+
+	values := make([]float64, 0, len(args))
+	for i := 0; i < length; i++ {
+		values = values[:0]
+		for _, arg := range args {
+			if i < len(arg.Values) && !arg.IsAbsent[i] {
+				values = append(values, arg.Values[i])
+			}
+		}
+
+		if !types.EnoughValues(len(values), len(args), xFilesFactor) {
+			r.IsAbsent[i] = true
+			continue
+		}
+
+		v := agg(values, len(args))
+		if math.IsNaN(v) {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = v
+	}
+
+	return &r
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *aggregate) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"aggregate": {
+			Description: "Aggregate series using the specified function.\n\nExample:\n\n.. code-block:: none\n\n  &target=aggregate(host.cpu-[0-7].cpu-{user,system}.value, \"sum\")\n\nThis would be the equivalent of\n\n.. code-block:: none\n\n  &target=sumSeries(host.cpu-[0-7].cpu-{user,system}.value)\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``.",
+			Function:    "aggregate(seriesList, func, xFilesFactor=None)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "aggregate",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Type:     types.AggFunc,
+					Options:  helper.AggregatorNames(),
+				},
+				{
+					Name: "xFilesFactor",
+					Type: types.Float,
+				},
+			},
+		},
+		"aggregateWithWildcards": {
+			Description: "Call aggregator after inserting wildcards at the given position(s).\n\nExample:\n\n.. code-block:: none\n\n  &target=aggregateWithWildcards(host.cpu-[0-7].cpu-{user,system}.value, \"sum\", 1)\n\nThis would be the equivalent of\n\n.. code-block:: none\n\n  &target=sumSeries(host.cpu-[0-7].cpu-user.value)&target=sumSeries(host.cpu-[0-7].cpu-system.value)\n  # or\n  &target=aggregate(host.cpu-[0-7].cpu-user.value,\"sum\")&target=aggregate(host.cpu-[0-7].cpu-system.value,\"sum\")\n\nThis function can be used with all aggregation functions supported by\n:py:func:`aggregate <aggregate>`: ``average``, ``median``, ``sum``, ``min``, ``max``, ``diff``,\n``stddev``, ``range`` & ``multiply``.",
+			Function:    "aggregateWithWildcards(seriesList, func, *positions)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "aggregateWithWildcards",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Type:     types.AggFunc,
+					Options:  helper.AggregatorNames(),
+				},
+				{
+					Multiple: true,
+					Name:     "positions",
+					Type:     types.Node,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	series := func() map[parser.MetricRequest][]*types.MetricData {
+		return map[parser.MetricRequest][]*types.MetricData{
+			{"metric[123]", 0, 1}: {
+				types.MakeMetricData("metric1", []float64{1, nan, 2, 3, 4}, 1, now32),
+				types.MakeMetricData("metric2", []float64{2, nan, 3, nan, 5}, 1, now32),
+				types.MakeMetricData("metric3", []float64{3, nan, 4, 5, nan}, 1, now32),
+			},
+		}
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("sum")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("sumSeries(metric[123])",
+				[]float64{6, nan, 9, 8, 9}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("average")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("averageSeries(metric[123])",
+				[]float64{2, nan, 3, 4, 4.5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("avg_zero")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("avg_zeroSeries(metric[123])",
+				[]float64{2, nan, 3, 8.0 / 3, 3}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("median")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("medianSeries(metric[123])",
+				[]float64{2, nan, 3, 4, 4.5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("diff")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("diffSeries(metric[123])",
+				[]float64{-4, nan, -5, -2, -1}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("count")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("countSeries(metric[123])",
+				[]float64{3, nan, 3, 2, 2}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("range")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("rangeSeries(metric[123])",
+				[]float64{2, nan, 2, 2, 1}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("multiply")),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("multiplySeries(metric[123])",
+				[]float64{6, nan, 24, 15, 20}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("max"), 0.7),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("maxSeries(metric[123])",
+				[]float64{3, nan, 4, nan, nan}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aggregate", "metric[123]", parser.ArgValue("min"),
+				parser.NamedArgs{"xFilesFactor": 0.5}),
+			series(),
+			[]*types.MetricData{types.MakeMetricData("minSeries(metric[123])",
+				[]float64{1, nan, 2, 3, 4}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestAggregateUnknownFunction(t *testing.T) {
+	e := parser.NewExpr("aggregate", "metric1", parser.ArgValue("bogus"))
+	_, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, 0)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown aggregation function")
+	}
+}
+
+// This return is multireturn
+func TestAggregateWithWildcards(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	tests := []th.MultiReturnEvalTestItem{
+		{
+			parser.NewExpr("aggregateWithWildcards",
+				"metric1.foo.*.*",
+				parser.ArgValue("sum"),
+				1,
+				2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar1.qux", []float64{6, 7, 8, 9, 10}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{11, 12, 13, 14, 15}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.qux", []float64{7, 8, 9, 10, 11}, 1, now32),
+				},
+			},
+			"aggregateWithWildcards",
+			map[string][]*types.MetricData{
+				"metric1.baz": {types.MakeMetricData("metric1.baz", []float64{12, 14, 16, 18, 20}, 1, now32)},
+				"metric1.qux": {types.MakeMetricData("metric1.qux", []float64{13, 15, 17, 19, 21}, 1, now32)},
+			},
+		},
+		{
+			parser.NewExpr("aggregateWithWildcards",
+				"metric1.foo.*.*",
+				parser.ArgValue("max"),
+				2,
+				parser.NamedArgs{"xFilesFactor": 1},
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, nan, 3}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{4, 5, 6}, 1, now32),
+				},
+			},
+			"aggregateWithWildcards",
+			map[string][]*types.MetricData{
+				"metric1.foo.baz": {types.MakeMetricData("metric1.foo.baz", []float64{4, nan, 6}, 1, now32)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestMultiReturnEvalExpr(t, &tt)
+		})
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/pkg/parser"
-
-	"strings"
 )
 
 type aliasByNode struct {
@@ -32,7 +30,7 @@ func (f *aliasByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 		return nil, err
 	}
 
-	fields, err := e.GetIntArgs(1)
+	fields, err := e.GetNodeOrTagArgs(1)
 	if err != nil {
 		return nil, err
 	}
@@ -40,23 +38,8 @@ func (f *aliasByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 	var results []*types.MetricData
 
 	for _, a := range args {
-
-		metric := helper.ExtractMetric(a.Name)
-		nodes := strings.Split(metric, ".")
-
-		var name []string
-		for _, f := range fields {
-			if f < 0 {
-				f += len(nodes)
-			}
-			if f >= len(nodes) || f < 0 {
-				continue
-			}
-			name = append(name, nodes[f])
-		}
-
 		r := *a
-		r.Name = strings.Join(name, ".")
+		r.Name = helper.AggKey(a.Name, fields)
 		results = append(results, &r)
 	}
 
@@ -54,6 +54,16 @@ func TestAliasByNode(t *testing.T) {
 			[]*types.MetricData{types.MakeMetricData("foo.bar",
 				[]float64{1, 2, 3, 4, 5}, 1, now32)},
 		},
+		{
+			parser.NewExpr("aliasByNode",
+				"cpu.load", parser.ArgValue("dc"), 1, parser.ArgValue("missing"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"cpu.load", 0, 1}: {types.MakeMetricData("cpu.load;dc=ams;host=web1", []float64{1, 2, 3, 4, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("ams.load.",
+				[]float64{1, 2, 3, 4, 5}, 1, now32)},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,55 @@
+package aliasByTags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestAliasByTags(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("aliasByTags",
+				"cpu.load", parser.ArgValue("host"), parser.ArgValue("name"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"cpu.load", 0, 1}: {types.MakeMetricData("cpu.load;dc=ams;host=web1", []float64{1, 2, 3, 4, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("web1.cpu.load", []float64{1, 2, 3, 4, 5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("aliasByTags",
+				"cpu.load", parser.ArgValue("dc"), 0,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"cpu.load", 0, 1}: {types.MakeMetricData("scale(cpu.load;dc=ams;host=web1,2)", []float64{1, 2, 3, 4, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("ams.cpu", []float64{1, 2, 3, 4, 5}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+
+}
@@ -74,23 +74,22 @@ func (f *averageSeriesWithWildcards) Do(e parser.Expr, from, until int32, values
 		r.Values = make([]float64, len(args[0].Values))
 		r.IsAbsent = make([]bool, len(args[0].Values))
 
-		length := make([]float64, len(args[0].Values))
-		atLeastOne := make([]bool, len(args[0].Values))
+		length := make([]int, len(args[0].Values))
 		for _, arg := range args {
 			for i, v := range arg.Values {
 				if arg.IsAbsent[i] {
 					continue
 				}
-				atLeastOne[i] = true
 				length[i]++
 				r.Values[i] += v
 			}
 		}
 
-		for i, v := range atLeastOne {
-			if v {
-				r.Values[i] = r.Values[i] / length[i]
+		for i, n := range length {
+			if types.EnoughValues(n, len(args), r.XFilesFactor) {
+				r.Values[i] = r.Values[i] / float64(n)
 			} else {
+				r.Values[i] = 0
 				r.IsAbsent[i] = true
 			}
 		}
@@ -891,6 +891,7 @@ func MarshalPNGRequest(r *http.Request, results []*types.MetricData, templateNam
 }
 
 func marshalCairo(p PictureParams, results []*types.MetricData, backend cairoBackend) []byte {
+	p = clampSize(p)
 	var params = Params{
 		width:          p.Width,
 		height:         p.Height,
@@ -0,0 +1,231 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	imgpng "image/png"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+type point struct {
+	x, y float64
+}
+
+// canvas is the drawing surface used by the builtin renderer. Coordinates
+// are in pixels with the origin at the top left corner.
+type canvas interface {
+	fillRect(x, y, w, h float64, c color.RGBA)
+	polyline(points []point, c color.RGBA, width float64)
+	polygon(points []point, c color.RGBA)
+	// text draws s with its top left corner at x, y using the builtin font
+	// scaled by scale.
+	text(x, y float64, s string, c color.RGBA, scale int)
+	bytes() []byte
+}
+
+func textWidth(s string, scale int) float64 {
+	return float64(utf8.RuneCountInString(s) * (glyphWidth + 1) * scale)
+}
+
+func textHeight(scale int) float64 {
+	return float64(glyphHeight * scale)
+}
+
+type rasterCanvas struct {
+	img *image.RGBA
+}
+
+func newRasterCanvas(width, height int) *rasterCanvas {
+	return &rasterCanvas{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+}
+
+func (c *rasterCanvas) blend(x, y int, clr color.RGBA) {
+	if !(image.Point{x, y}.In(c.img.Rect)) {
+		return
+	}
+
+	if clr.A == 0xff {
+		c.img.SetRGBA(x, y, clr)
+		return
+	}
+
+	dst := c.img.RGBAAt(x, y)
+	a := uint32(clr.A)
+	mix := func(s, d uint8) uint8 {
+		return uint8((uint32(s)*a + uint32(d)*(0xff-a)) / 0xff)
+	}
+	c.img.SetRGBA(x, y, color.RGBA{
+		R: mix(clr.R, dst.R),
+		G: mix(clr.G, dst.G),
+		B: mix(clr.B, dst.B),
+		A: 0xff,
+	})
+}
+
+func (c *rasterCanvas) fillRect(x, y, w, h float64, clr color.RGBA) {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	x1, y1 := int(math.Ceil(x+w)), int(math.Ceil(y+h))
+	for j := y0; j < y1; j++ {
+		for i := x0; i < x1; i++ {
+			c.blend(i, j, clr)
+		}
+	}
+}
+
+func (c *rasterCanvas) polyline(points []point, clr color.RGBA, width float64) {
+	size := int(math.Max(1, math.Round(width)))
+	seen := make(map[image.Point]bool)
+	stamp := func(x, y float64) {
+		x0, y0 := int(math.Round(x))-size/2, int(math.Round(y))-size/2
+		for j := y0; j < y0+size; j++ {
+			for i := x0; i < x0+size; i++ {
+				// Don't blend a pixel twice, or translucent lines get
+				// darker where segments meet.
+				if p := (image.Point{i, j}); !seen[p] {
+					seen[p] = true
+					c.blend(i, j, clr)
+				}
+			}
+		}
+	}
+
+	if len(points) == 1 {
+		stamp(points[0].x, points[0].y)
+	}
+
+	for k := 1; k < len(points); k++ {
+		a, b := points[k-1], points[k]
+		steps := math.Max(math.Abs(b.x-a.x), math.Abs(b.y-a.y))
+		if steps < 1 {
+			steps = 1
+		}
+		for s := 0.0; s <= steps; s++ {
+			stamp(a.x+(b.x-a.x)*s/steps, a.y+(b.y-a.y)*s/steps)
+		}
+	}
+}
+
+func (c *rasterCanvas) polygon(points []point, clr color.RGBA) {
+	if len(points) < 3 {
+		return
+	}
+
+	ymin, ymax := points[0].y, points[0].y
+	for _, p := range points {
+		ymin, ymax = math.Min(ymin, p.y), math.Max(ymax, p.y)
+	}
+
+	// Scanline fill with the even-odd rule, sampling pixel centers.
+	var xs []float64
+	for y := int(math.Floor(ymin)); y <= int(math.Ceil(ymax)); y++ {
+		yc := float64(y) + 0.5
+		xs = xs[:0]
+		for k := range points {
+			a, b := points[k], points[(k+1)%len(points)]
+			if (a.y <= yc) != (b.y <= yc) {
+				xs = append(xs, a.x+(yc-a.y)*(b.x-a.x)/(b.y-a.y))
+			}
+		}
+		sort.Float64s(xs)
+
+		for k := 0; k+1 < len(xs); k += 2 {
+			for x := int(math.Round(xs[k])); x < int(math.Round(xs[k+1])); x++ {
+				c.blend(x, y, clr)
+			}
+		}
+	}
+}
+
+func (c *rasterCanvas) text(x, y float64, s string, clr color.RGBA, scale int) {
+	px := float64(scale)
+	for i, r := range []rune(s) {
+		gx := x + float64(i*(glyphWidth+1)*scale)
+		for col, bits := range glyph(r) {
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<uint(row)) != 0 {
+					c.fillRect(gx+float64(col)*px, y+float64(row)*px, px, px, clr)
+				}
+			}
+		}
+	}
+}
+
+func (c *rasterCanvas) bytes() []byte {
+	var buf bytes.Buffer
+	if err := imgpng.Encode(&buf, c.img); err != nil {
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func newSVGCanvas(width, height int) *svgCanvas {
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%dpx" height="%dpx" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+
+	return c
+}
+
+// svgPaint returns the attributes to paint with c, using attr ("fill" or
+// "stroke").
+func svgPaint(attr string, c color.RGBA) string {
+	s := fmt.Sprintf(`%s="rgb(%d,%d,%d)"`, attr, c.R, c.G, c.B)
+	if c.A != 0xff {
+		s += fmt.Sprintf(` %s-opacity="%.3f"`, attr, float64(c.A)/0xff)
+	}
+
+	return s
+}
+
+func svgPoints(points []point) string {
+	var buf bytes.Buffer
+	for i, p := range points {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%.2f,%.2f", p.x, p.y)
+	}
+
+	return buf.String()
+}
+
+func (c *svgCanvas) fillRect(x, y, w, h float64, clr color.RGBA) {
+	fmt.Fprintf(&c.buf, "<rect x=\"%.2f\" y=\"%.2f\" width=\"%.2f\" height=\"%.2f\" %s/>\n",
+		x, y, w, h, svgPaint("fill", clr))
+}
+
+func (c *svgCanvas) polyline(points []point, clr color.RGBA, width float64) {
+	fmt.Fprintf(&c.buf, "<polyline points=\"%s\" fill=\"none\" stroke-width=\"%.2f\" stroke-linejoin=\"round\" %s/>\n",
+		svgPoints(points), width, svgPaint("stroke", clr))
+}
+
+func (c *svgCanvas) polygon(points []point, clr color.RGBA) {
+	fmt.Fprintf(&c.buf, "<polygon points=\"%s\" %s/>\n", svgPoints(points), svgPaint("fill", clr))
+}
+
+func (c *svgCanvas) text(x, y float64, s string, clr color.RGBA, scale int) {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(s))
+
+	// Use a monospace font with the builtin font's metrics, so that the
+	// layout matches the PNG output. y is the baseline for SVG text.
+	fmt.Fprintf(&c.buf, "<text x=\"%.2f\" y=\"%.2f\" font-family=\"monospace\" font-size=\"%d\" textLength=\"%.2f\" %s>%s</text>\n",
+		x, y+textHeight(scale)-float64(scale), glyphHeight*scale, textWidth(s, scale), svgPaint("fill", clr), escaped.String())
+}
+
+func (c *svgCanvas) bytes() []byte {
+	c.buf.WriteString("</svg>\n")
+	return c.buf.Bytes()
+}
@@ -339,6 +339,36 @@ func getTimeZone(s string, def *time.Location) *time.Location {
 	return tz
 }
 
+// maxWidth and maxHeight bound the size of the graphs, in pixels.
+var maxWidth, maxHeight = 4000.0, 4000.0
+
+// SetMaxSize sets the largest width and height of the graphs, in pixels.
+// Larger graphs are drawn at the largest size, so that a request can't make
+// the renderer allocate more memory than there is.
+func SetMaxSize(width, height int) {
+	if width > 0 {
+		maxWidth = float64(width)
+	}
+	if height > 0 {
+		maxHeight = float64(height)
+	}
+}
+
+// clampSize returns p with its width and height within 1 and the largest
+// size.
+func clampSize(p PictureParams) PictureParams {
+	p.Width = clampDimension(p.Width, maxWidth)
+	p.Height = clampDimension(p.Height, maxHeight)
+	return p
+}
+
+func clampDimension(v, max float64) float64 {
+	if math.IsNaN(v) || v < 1 {
+		return 1
+	}
+	return math.Min(v, max)
+}
+
 // SetTemplate adds a picture param template with specified name and parameters
 func SetTemplate(name string, params PictureParams) {
 	templates[name] = params
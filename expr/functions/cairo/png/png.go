@@ -3,11 +3,18 @@
 package png
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/pkg/parser"
-	"net/http"
 )
 
+// HaveGraphSupport is false without cairo: graphs are drawn by a simple
+// builtin renderer, and the graph-only functions (color, lineWidth, ...) are
+// not available.
 const HaveGraphSupport = false
 
 func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
@@ -15,21 +22,329 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 }
 
 func MarshalPNG(params PictureParams, results []*types.MetricData) []byte {
-	return nil
+	params = clampSize(params)
+	c := newRasterCanvas(int(params.Width), int(params.Height))
+	drawChart(c, params, results)
+	return c.bytes()
 }
 
 func MarshalSVG(params PictureParams, results []*types.MetricData) []byte {
-	return nil
+	params = clampSize(params)
+	c := newSVGCanvas(int(params.Width), int(params.Height))
+	drawChart(c, params, results)
+	return c.bytes()
 }
 
 func MarshalPNGRequest(r *http.Request, results []*types.MetricData, templateName string) []byte {
-	return nil
+	return MarshalPNG(GetPictureParamsWithTemplate(r, templateName, results), results)
 }
 
 func MarshalSVGRequest(r *http.Request, results []*types.MetricData, templateName string) []byte {
-	return nil
+	return MarshalSVG(GetPictureParamsWithTemplate(r, templateName, results), results)
 }
 
 func Description() map[string]types.FunctionDescription {
 	return nil
 }
+
+type area struct {
+	xmin, xmax, ymin, ymax float64
+}
+
+// drawChart draws a line chart of results. It supports a subset of the
+// graphite-web parameters: size, colors, title, legend, axes, grid, lineMode
+// and areaMode.
+func drawChart(c canvas, p PictureParams, results []*types.MetricData) {
+	scale := int(math.Max(1, math.Round(p.FontSize/glyphHeight)))
+	lineHeight := textHeight(scale) + float64(2*scale)
+	margin := float64(p.Margin)
+	fg := string2RGBA(p.FgColor)
+	if len(p.ColorList) == 0 {
+		p.ColorList = DefaultColorList
+	}
+
+	c.fillRect(0, 0, p.Width, p.Height, string2RGBA(p.BgColor))
+
+	var series []*types.MetricData
+	for _, r := range results {
+		if r != nil && len(r.Values) > 0 {
+			series = append(series, r)
+		}
+	}
+
+	a := area{xmin: margin, xmax: p.Width - margin, ymin: margin, ymax: p.Height - margin}
+
+	if !p.GraphOnly && p.Title != "" {
+		c.text((p.Width-textWidth(p.Title, scale))/2, a.ymin, p.Title, fg, scale)
+		a.ymin += lineHeight + margin/2
+	}
+
+	if len(series) == 0 {
+		const msg = "No Data"
+		c.text((p.Width-textWidth(msg, scale))/2, (a.ymin+a.ymax-textHeight(scale))/2, msg, fg, scale)
+		return
+	}
+
+	if !p.GraphOnly && !p.HideLegend {
+		a.ymax = drawChartLegend(c, p, series, a, scale, lineHeight)
+	}
+
+	start, stop := series[0].StartTime, series[0].StopTime
+	for _, r := range series {
+		if r.StartTime < start {
+			start = r.StartTime
+		}
+		if r.StopTime > stop {
+			stop = r.StopTime
+		}
+	}
+
+	ymin, ymax := chartYRange(p, series)
+	ticks := yTicks(ymin, ymax, p.YStep)
+	ymin, ymax = ticks[0], ticks[len(ticks)-1]
+
+	showAxes := !p.GraphOnly && !p.HideAxes
+	if showAxes && !p.HideYAxis {
+		labelWidth := 0.0
+		for _, v := range ticks {
+			labelWidth = math.Max(labelWidth, textWidth(formatYLabel(v, p.YUnitSystem), scale))
+		}
+		a.xmin += labelWidth + margin/2
+	}
+	if showAxes && !p.HideXAxis {
+		a.ymax -= lineHeight + float64(scale)
+	}
+
+	x := func(t int32) float64 {
+		if stop == start {
+			return a.xmin
+		}
+		return a.xmin + float64(t-start)*(a.xmax-a.xmin)/float64(stop-start)
+	}
+	y := func(v float64) float64 {
+		return a.ymax - (v-ymin)*(a.ymax-a.ymin)/(ymax-ymin)
+	}
+
+	for _, v := range ticks {
+		if !p.HideGrid {
+			c.polyline([]point{{a.xmin, y(v)}, {a.xmax, y(v)}}, string2RGBA(p.MajorGridLineColor), 1)
+		}
+		if showAxes && !p.HideYAxis {
+			label := formatYLabel(v, p.YUnitSystem)
+			c.text(a.xmin-margin/2-textWidth(label, scale), y(v)-textHeight(scale)/2, label, fg, scale)
+		}
+	}
+
+	if showAxes && !p.HideXAxis {
+		layout := "15:04"
+		if stop-start > 2*24*60*60 {
+			layout = "01/02"
+		}
+		tz := p.Tz
+		if tz == nil {
+			tz = time.Local
+		}
+
+		// The first and last labels are aligned to the edges of the graph,
+		// so that they don't overflow it.
+		for k, t := range []int32{start, start + (stop-start)/2, stop} {
+			label := time.Unix(int64(t), 0).In(tz).Format(layout)
+			lx := x(t) - float64(k)*textWidth(label, scale)/2
+			c.text(lx, a.ymax+float64(2*scale), label, fg, scale)
+		}
+
+		c.polyline([]point{{a.xmin, a.ymin}, {a.xmin, a.ymax}, {a.xmax, a.ymax}}, fg, 1)
+	}
+
+	var stack []float64
+	for i, r := range series {
+		clr := string2RGBA(p.ColorList[i%len(p.ColorList)])
+		fillClr := clr
+		if !math.IsNaN(p.AreaAlpha) {
+			fillClr.A = uint8(math.Max(0, math.Min(1, p.AreaAlpha)) * 0xff)
+		}
+		fill := p.AreaMode == AreaModeAll || p.AreaMode == AreaModeStacked ||
+			(p.AreaMode == AreaModeFirst && i == 0)
+
+		values, absent := r.AggregatedValues(), r.AggregatedAbsent()
+		step := r.AggregatedTimeStep()
+		if len(stack) < len(values) {
+			stack = append(stack, make([]float64, len(values)-len(stack))...)
+		}
+
+		// Each run of present points is drawn as its own line, and filled
+		// down to the previous series when stacking, or to zero otherwise.
+		var top, bottom []point
+		flush := func() {
+			if fill && len(top) > 0 {
+				poly := append([]point{}, top...)
+				for k := len(bottom) - 1; k >= 0; k-- {
+					poly = append(poly, bottom[k])
+				}
+				c.polygon(poly, fillClr)
+			}
+			if len(top) > 0 {
+				c.polyline(top, clr, p.LineWidth)
+			}
+			top, bottom = top[:0], bottom[:0]
+		}
+
+		for j, v := range values {
+			if absent[j] || math.IsNaN(v) || math.IsInf(v, 0) {
+				if p.DrawNullAsZero {
+					v = 0
+				} else if p.LineMode == LineModeConnected {
+					continue
+				} else {
+					flush()
+					continue
+				}
+			}
+
+			base := math.Max(ymin, math.Min(ymax, 0))
+			if p.AreaMode == AreaModeStacked {
+				base = stack[j]
+				v += stack[j]
+				stack[j] = v
+			}
+
+			t := r.StartTime + int32(j)*step
+			top = append(top, point{x(t), y(v)})
+			bottom = append(bottom, point{x(t), y(base)})
+			if p.LineMode == LineModeStaircase {
+				top = append(top, point{x(t + step), y(v)})
+				bottom = append(bottom, point{x(t + step), y(base)})
+			}
+		}
+		flush()
+	}
+}
+
+// drawChartLegend draws the legend at the bottom of a, as many rows as fit
+// in half of the height, and returns the new bottom of the graph area.
+func drawChartLegend(c canvas, p PictureParams, series []*types.MetricData, a area, scale int, lineHeight float64) float64 {
+	rows := len(series)
+	if max := int((a.ymax - a.ymin) / 2 / lineHeight); rows > max {
+		rows = max
+	}
+
+	fg := string2RGBA(p.FgColor)
+	box := textHeight(scale)
+	for i := 0; i < rows; i++ {
+		y := a.ymax - float64(rows-i)*lineHeight
+		c.fillRect(a.xmin, y, box, box, string2RGBA(p.ColorList[i%len(p.ColorList)]))
+		c.text(a.xmin+box+float64(2*scale), y, series[i].Name, fg, scale)
+	}
+
+	return a.ymax - float64(rows)*lineHeight - float64(p.Margin)/2
+}
+
+// chartYRange returns the range of values to draw, honouring yMin and yMax.
+func chartYRange(p PictureParams, series []*types.MetricData) (float64, float64) {
+	ymin, ymax := math.Inf(1), math.Inf(-1)
+	var stack []float64
+	for _, r := range series {
+		values, absent := r.AggregatedValues(), r.AggregatedAbsent()
+		if len(stack) < len(values) {
+			stack = append(stack, make([]float64, len(values)-len(stack))...)
+		}
+
+		for i, v := range values {
+			if absent[i] || math.IsNaN(v) || math.IsInf(v, 0) {
+				if !p.DrawNullAsZero {
+					continue
+				}
+				v = 0
+			}
+			if p.AreaMode == AreaModeStacked {
+				stack[i] += v
+				v = stack[i]
+			}
+			ymin, ymax = math.Min(ymin, v), math.Max(ymax, v)
+		}
+	}
+
+	if math.IsInf(ymin, 0) {
+		ymin, ymax = 0, 1
+	}
+	if p.AreaMode != AreaModeNone && ymin > 0 {
+		ymin = 0
+	}
+	if !math.IsNaN(p.YMin) {
+		ymin = p.YMin
+	}
+	if !math.IsNaN(p.YMax) {
+		ymax = p.YMax
+	}
+
+	// Bound the range so that ymax-ymin is finite, and widen an empty one
+	// by enough for round steps to tell its ticks apart at the precision of
+	// the values.
+	ymin = math.Max(-maxYValue, math.Min(ymin, maxYValue))
+	ymax = math.Max(-maxYValue, math.Min(ymax, maxYValue))
+	if ymax-ymin <= math.Max(math.Abs(ymin), math.Abs(ymax))*1e-9 {
+		ymax = ymin + math.Max(1, math.Abs(ymin)*1e-6)
+	}
+
+	return ymin, ymax
+}
+
+// maxYValue bounds the values of the y axis, so that the difference of any
+// two is finite.
+const maxYValue = math.MaxFloat64 / 4
+
+// maxYTicks bounds the number of horizontal grid lines: a yStep that would
+// draw more is ignored.
+const maxYTicks = 100
+
+// yTicks returns the values at which to draw the horizontal grid lines,
+// extending the range to a multiple of a round step. A step that isn't a
+// positive finite number, or that would draw more than maxYTicks lines, is
+// replaced by a round one. ymax-ymin must be finite and positive.
+func yTicks(ymin, ymax, step float64) []float64 {
+	if math.IsNaN(step) || math.IsInf(step, 0) || step <= 0 || (ymax-ymin)/step > maxYTicks-2 {
+		raw := (ymax - ymin) / 4
+		magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+		step = 10 * magnitude
+		for _, m := range []float64{1, 2, 2.5, 5} {
+			if m*magnitude >= raw {
+				step = m * magnitude
+				break
+			}
+		}
+	}
+
+	var ticks []float64
+	lo, hi := math.Floor(ymin/step), math.Ceil(ymax/step)
+	for k := lo; k <= hi && len(ticks) < maxYTicks; k++ {
+		ticks = append(ticks, k*step)
+	}
+	if len(ticks) < 2 {
+		ticks = append(ticks, ticks[0]+step)
+	}
+
+	return ticks
+}
+
+func formatYLabel(v float64, unitSystem string) string {
+	var prefixes []string
+	var base float64
+	switch unitSystem {
+	case "si":
+		prefixes, base = []string{"", "K", "M", "G", "T", "P"}, 1000
+	case "binary":
+		prefixes, base = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi"}, 1024
+	}
+
+	i := 0
+	for ; i+1 < len(prefixes) && math.Abs(v) >= base; i++ {
+		v /= base
+	}
+
+	s := strconv.FormatFloat(v, 'g', 4, 64)
+	if i < len(prefixes) {
+		s += prefixes[i]
+	}
+
+	return s
+}
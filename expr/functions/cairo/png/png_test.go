@@ -0,0 +1,121 @@
+//go:build !cairo
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+func testResults() []*types.MetricData {
+	return []*types.MetricData{
+		types.MakeMetricData("foo.bar", []float64{1, 3, math.NaN(), 2, 5}, 60, 1500000000),
+		types.MakeMetricData("foo.baz", []float64{2, 2, 4, 1, 0}, 60, 1500000000),
+	}
+}
+
+func TestMarshalPNGRequest(t *testing.T) {
+	for _, areaMode := range []string{"none", "first", "all", "stacked"} {
+		r := httptest.NewRequest("GET", "/render?width=400&height=200&title=Foo&areaMode="+areaMode, nil)
+
+		b := MarshalPNGRequest(r, testResults(), "default")
+
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("areaMode=%s: %v", areaMode, err)
+		}
+		if size := img.Bounds().Size(); size.X != 400 || size.Y != 200 {
+			t.Errorf("areaMode=%s: expected a 400x200 image, got %v", areaMode, size)
+		}
+	}
+}
+
+func TestMarshalSVGRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/render?width=400&height=200&title=Foo%20%26%20Bar&colorList=red,blue", nil)
+
+	b := string(MarshalSVGRequest(r, testResults(), "default"))
+
+	for _, expected := range []string{
+		`width="400px" height="200px"`,
+		`>Foo &amp; Bar</text>`,
+		`>foo.baz</text>`,
+		`stroke="rgb(200,0,50)"`,
+	} {
+		if !strings.Contains(b, expected) {
+			t.Errorf("Expected SVG to contain %s, got %s", expected, b)
+		}
+	}
+
+	if !strings.HasSuffix(b, "</svg>\n") {
+		t.Errorf("Expected a complete SVG document, got %s", b)
+	}
+}
+
+func TestMarshalPNGNoData(t *testing.T) {
+	b := MarshalPNG(DefaultParams, nil)
+
+	if _, err := png.Decode(bytes.NewReader(b)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMarshalPNGBounds(t *testing.T) {
+	defer SetMaxSize(int(maxWidth), int(maxHeight))
+	SetMaxSize(800, 600)
+
+	tests := []struct {
+		query         string
+		width, height int
+	}{
+		{"yMin=1e17", 330, 250},
+		{"yStep=1e-12", 330, 250},
+		{"yStep=-1&yMin=-1e308&yMax=1e308", 330, 250},
+		{"width=60000&height=60000", 800, 600},
+		{"width=-5&height=NaN", 1, 1},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/render?"+tt.query, nil)
+
+		b := MarshalPNGRequest(r, testResults(), "default")
+
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if size := img.Bounds().Size(); size.X != tt.width || size.Y != tt.height {
+			t.Errorf("%s: expected a %dx%d image, got %v", tt.query, tt.width, tt.height, size)
+		}
+	}
+}
+
+func TestYTicks(t *testing.T) {
+	tests := []struct {
+		ymin, ymax, step float64
+	}{
+		{0, 1, math.NaN()},
+		{0, 1, 1e-12},
+		{0, 1, math.Inf(1)},
+		{0, 1, -1},
+		{1e17, 2e17, 0},
+		{-maxYValue, maxYValue, 1},
+	}
+
+	for _, tt := range tests {
+		ticks := yTicks(tt.ymin, tt.ymax, tt.step)
+		if len(ticks) < 2 || len(ticks) > maxYTicks {
+			t.Errorf("yTicks(%v, %v, %v): got %d ticks", tt.ymin, tt.ymax, tt.step, len(ticks))
+			continue
+		}
+		if ticks[0] > tt.ymin || ticks[len(ticks)-1] < tt.ymax || ticks[0] >= ticks[len(ticks)-1] {
+			t.Errorf("yTicks(%v, %v, %v): got %v", tt.ymin, tt.ymax, tt.step, ticks)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package exponentialMovingAverage
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type exponentialMovingAverage struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &exponentialMovingAverage{}
+	functions := []string{"exponentialMovingAverage"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// exponentialMovingAverage(seriesList, windowSize)
+func (f *exponentialMovingAverage) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	var windowPoints int
+	var previewSeconds int32
+	var constant float64
+	var argstr string
+
+	// As in graphite-web, the smoothing constant is derived from the number
+	// of points of an integer window, but from the number of seconds of an
+	// interval. Interval windows have data fetched before from, like
+	// movingAverage, and integer windows have it fetched again as many of
+	// their steps earlier once the step is known (see PrimingPoints).
+	switch e.Args()[1].Type() {
+	case parser.EtConst:
+		n, err := e.GetIntArg(1)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, parser.ErrBadType
+		}
+		windowPoints = n
+		constant = 2 / (float64(n) + 1)
+		argstr = strconv.Itoa(n)
+	case parser.EtString:
+		n32, err := e.GetIntervalArg(1, 1)
+		if err != nil {
+			return nil, err
+		}
+		if n32 < 0 {
+			n32 = -n32
+		}
+		previewSeconds = n32
+		constant = 2 / (float64(n32) + 1)
+		argstr = fmt.Sprintf("%q", e.Args()[1].StringValue())
+	default:
+		return nil, parser.ErrBadType
+	}
+
+	arg, err := helper.GetSeriesArg(e.Args()[0], from-previewSeconds, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if windowPoints > 0 {
+		var step int32
+		for _, a := range arg {
+			if a.StepTime > step {
+				step = a.StepTime
+			}
+		}
+		// Without the earlier data, the window is taken from the first
+		// points of the series instead.
+		primed, err := helper.GetSeriesArg(e.Args()[0], from-int32(windowPoints)*step, until, values)
+		if err == nil && len(primed) > 0 {
+			arg = primed
+		}
+	}
+
+	results := make([]*types.MetricData, 0, len(arg))
+	for _, a := range arg {
+		points := windowPoints
+		if previewSeconds > 0 {
+			points = int(previewSeconds / a.StepTime)
+		}
+		if points > len(a.Values) {
+			points = len(a.Values)
+		}
+
+		// The first point is the average of the window, absent if all of
+		// its points are.
+		var ema float64
+		var count int
+		for i := 0; i < points; i++ {
+			if !a.IsAbsent[i] {
+				ema += a.Values[i]
+				count++
+			}
+		}
+		if count > 0 {
+			ema /= float64(count)
+		}
+
+		r := *a
+		r.Name = fmt.Sprintf("exponentialMovingAverage(%s,%s)", a.Name, argstr)
+		r.Values = make([]float64, 1, len(a.Values)-points+1)
+		r.IsAbsent = make([]bool, 1, len(a.Values)-points+1)
+		r.Values[0] = math.Round(ema*1000) / 1000
+		r.IsAbsent[0] = count == 0
+
+		for i := points; i < len(a.Values); i++ {
+			if a.IsAbsent[i] {
+				r.Values = append(r.Values, 0)
+				r.IsAbsent = append(r.IsAbsent, true)
+				continue
+			}
+
+			ema = constant*a.Values[i] + (1-constant)*ema
+			r.Values = append(r.Values, math.Round(ema*1000)/1000)
+			r.IsAbsent = append(r.IsAbsent, false)
+		}
+
+		r.StartTime = a.StartTime + int32(points)*a.StepTime
+		r.StopTime = r.StartTime + int32(len(r.Values))*a.StepTime
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *exponentialMovingAverage) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"exponentialMovingAverage": {
+			Description: "Takes a series of values and a window size and produces an exponential moving\naverage utilizing the following formula:\n\n.. code-block:: none\n\n  ema(current) = constant * (Current Value) + (1 - constant) * ema(previous)\n\nThe Constant is calculated as:\n\n.. code-block:: none\n\n  constant = 2 / (windowSize + 1)\n\nThe first period EMA uses a simple moving average for its value.\n\nExample:\n\n.. code-block:: none\n\n  &target=exponentialMovingAverage(*.transactions.count, 10)\n  &target=exponentialMovingAverage(*.transactions.count, '-10s')",
+			Function:    "exponentialMovingAverage(seriesList, windowSize)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions",
+			Name:        "exponentialMovingAverage",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "windowSize",
+					Required: true,
+					Suggestions: types.NewSuggestions(
+						5,
+						7,
+						10,
+						"1min",
+						"5min",
+						"10min",
+						"30min",
+						"1hour",
+					),
+					Type: types.IntOrInterval,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,89 @@
+package exponentialMovingAverage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestExponentialMovingAverage(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("exponentialMovingAverage",
+				"metric1", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("exponentialMovingAverage(metric1,2)",
+				[]float64{1.5, 2.5, 3.5, 4.5}, 1, now32+2)},
+		},
+		{
+			parser.NewExpr("exponentialMovingAverage",
+				"metric1", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}:  {types.MakeMetricData("metric1", []float64{3, 4, 5}, 1, now32)},
+				{"metric1", -2, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5}, 1, now32-2)},
+			},
+			[]*types.MetricData{types.MakeMetricData("exponentialMovingAverage(metric1,2)",
+				[]float64{1.5, 2.5, 3.5, 4.5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("exponentialMovingAverage",
+				"metric1", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{nan, nan, 3, 4}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("exponentialMovingAverage(metric1,2)",
+				[]float64{nan, 2, 3.333}, 1, now32+2)},
+		},
+		{
+			parser.NewExpr("exponentialMovingAverage",
+				"metric1", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{nan, 2, 3, nan, 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("exponentialMovingAverage(metric1,2)",
+				[]float64{2, 2.667, nan, 4.222}, 1, now32+2)},
+		},
+		{
+			parser.NewExpr("exponentialMovingAverage",
+				"metric1", parser.ArgValue("3s"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", -3, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData(`exponentialMovingAverage(metric1,"3s")`,
+				[]float64{2, 3, 4, 5}, 1, now32+3)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package filterSeries
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type filterSeries struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &filterSeries{}
+	functions := []string{"filterSeries"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+var operators = map[string]func(a, b float64) bool{
+	"=":  func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+}
+
+// filterSeries(seriesList, func, operator, threshold)
+func (f *filterSeries) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 4 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := e.GetStringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	agg, ok := helper.Aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation function: %s", name)
+	}
+
+	operator, err := e.GetStringArg(2)
+	if err != nil {
+		return nil, err
+	}
+	compare, ok := operators[operator]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator: %s", operator)
+	}
+
+	threshold, err := e.GetFloatArg(3)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.MetricData
+	for _, a := range args {
+		var nonNulls []float64
+		for i, v := range a.Values {
+			if !a.IsAbsent[i] {
+				nonNulls = append(nonNulls, v)
+			}
+		}
+		// series without values are dropped, as their aggregate is None
+		if len(nonNulls) == 0 {
+			continue
+		}
+
+		v := agg(nonNulls, len(a.Values))
+		if !math.IsNaN(v) && compare(v, threshold) {
+			results = append(results, a)
+		}
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *filterSeries) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"filterSeries": {
+			Description: "Takes one metric or a wildcard seriesList followed by a consolidation function, an operator and a threshold.\nDraws only the metrics which match the filter expression.\n\nExample:\n\n.. code-block:: none\n\n  &target=filterSeries(system.interface.eth*.packetsSent, 'max', '>', 1000)\n\nThis would only display interfaces which has a peak throughput higher than 1000 packets/min.\n\nSupported aggregation functions: ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``range``, ``multiply`` & ``last``.\n\nSupported operators: ``=``, ``!=``, ``>``, ``>=``, ``<`` & ``<=``.",
+			Function:    "filterSeries(seriesList, func, operator, threshold)",
+			Group:       "Filter Series",
+			Module:      "graphite.render.functions",
+			Name:        "filterSeries",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Type:     types.AggFunc,
+					Options:  helper.AggregatorNames(),
+				},
+				{
+					Name:     "operator",
+					Required: true,
+					Type:     types.String,
+					Options: []string{
+						"!=",
+						"<",
+						"<=",
+						"=",
+						">",
+						">=",
+					},
+				},
+				{
+					Name:     "threshold",
+					Required: true,
+					Type:     types.Float,
+				},
+			},
+		},
+	}
+}
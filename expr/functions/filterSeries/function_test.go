@@ -0,0 +1,82 @@
+package filterSeries
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestFilterSeries(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	series := func() map[parser.MetricRequest][]*types.MetricData {
+		return map[parser.MetricRequest][]*types.MetricData{
+			{"metric[1234]", 0, 1}: {
+				types.MakeMetricData("metric1", []float64{1, 2, 3}, 1, now32),
+				types.MakeMetricData("metric2", []float64{4, nan, 6}, 1, now32),
+				types.MakeMetricData("metric3", []float64{7, 8, 9}, 1, now32),
+				types.MakeMetricData("metric4", []float64{nan, nan, nan}, 1, now32),
+			},
+		}
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("filterSeries", "metric[1234]", parser.ArgValue("max"), parser.ArgValue(">"), 5),
+			series(),
+			[]*types.MetricData{
+				types.MakeMetricData("metric2", []float64{4, nan, 6}, 1, now32),
+				types.MakeMetricData("metric3", []float64{7, 8, 9}, 1, now32),
+			},
+		},
+		{
+			parser.NewExpr("filterSeries", "metric[1234]", parser.ArgValue("sum"), parser.ArgValue("<="), 10),
+			series(),
+			[]*types.MetricData{
+				types.MakeMetricData("metric1", []float64{1, 2, 3}, 1, now32),
+				types.MakeMetricData("metric2", []float64{4, nan, 6}, 1, now32),
+			},
+		},
+		{
+			parser.NewExpr("filterSeries", "metric[1234]", parser.ArgValue("average"), parser.ArgValue("="), 8),
+			series(),
+			[]*types.MetricData{
+				types.MakeMetricData("metric3", []float64{7, 8, 9}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestFilterSeriesBadOperator(t *testing.T) {
+	e := parser.NewExpr("filterSeries", "metric1", parser.ArgValue("max"), parser.ArgValue("~"), 5)
+	_, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, 0)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unsupported operator")
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"strings"
 
 	"github.com/bookingcom/carbonapi/expr/functions/absolute"
+	"github.com/bookingcom/carbonapi/expr/functions/aggregate"
 	"github.com/bookingcom/carbonapi/expr/functions/alias"
 	"github.com/bookingcom/carbonapi/expr/functions/aliasByMetric"
 	"github.com/bookingcom/carbonapi/expr/functions/aliasByNode"
+	"github.com/bookingcom/carbonapi/expr/functions/aliasByTags"
 	"github.com/bookingcom/carbonapi/expr/functions/aliasSub"
 	"github.com/bookingcom/carbonapi/expr/functions/asPercent"
 	"github.com/bookingcom/carbonapi/expr/functions/averageSeries"
@@ -26,19 +28,24 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/divideSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/ewma"
 	"github.com/bookingcom/carbonapi/expr/functions/exclude"
+	"github.com/bookingcom/carbonapi/expr/functions/exponentialMovingAverage"
 	"github.com/bookingcom/carbonapi/expr/functions/fallbackSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/fft"
+	"github.com/bookingcom/carbonapi/expr/functions/filterSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/graphiteWeb"
 	"github.com/bookingcom/carbonapi/expr/functions/grep"
 	"github.com/bookingcom/carbonapi/expr/functions/group"
 	"github.com/bookingcom/carbonapi/expr/functions/groupByNode"
+	"github.com/bookingcom/carbonapi/expr/functions/groupByTags"
 	"github.com/bookingcom/carbonapi/expr/functions/highest"
 	"github.com/bookingcom/carbonapi/expr/functions/hitcount"
 	"github.com/bookingcom/carbonapi/expr/functions/holtWintersAberration"
+	"github.com/bookingcom/carbonapi/expr/functions/holtWintersConfidenceArea"
 	"github.com/bookingcom/carbonapi/expr/functions/holtWintersConfidenceBands"
 	"github.com/bookingcom/carbonapi/expr/functions/holtWintersForecast"
 	"github.com/bookingcom/carbonapi/expr/functions/ifft"
 	"github.com/bookingcom/carbonapi/expr/functions/integral"
+	"github.com/bookingcom/carbonapi/expr/functions/integralByInterval"
 	"github.com/bookingcom/carbonapi/expr/functions/invert"
 	"github.com/bookingcom/carbonapi/expr/functions/isNotNull"
 	"github.com/bookingcom/carbonapi/expr/functions/keepLastValue"
@@ -49,6 +56,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/logarithm"
 	"github.com/bookingcom/carbonapi/expr/functions/lowPass"
 	"github.com/bookingcom/carbonapi/expr/functions/lowest"
+	"github.com/bookingcom/carbonapi/expr/functions/lua"
 	"github.com/bookingcom/carbonapi/expr/functions/mapSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/minMax"
 	"github.com/bookingcom/carbonapi/expr/functions/mostDeviant"
@@ -71,9 +79,12 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/reduce"
 	"github.com/bookingcom/carbonapi/expr/functions/removeBelowSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/removeEmptySeries"
+	"github.com/bookingcom/carbonapi/expr/functions/round"
 	"github.com/bookingcom/carbonapi/expr/functions/scale"
 	"github.com/bookingcom/carbonapi/expr/functions/scaleToSeconds"
 	"github.com/bookingcom/carbonapi/expr/functions/seriesList"
+	"github.com/bookingcom/carbonapi/expr/functions/setXFilesFactor"
+	"github.com/bookingcom/carbonapi/expr/functions/smartSummarize"
 	"github.com/bookingcom/carbonapi/expr/functions/sortBy"
 	"github.com/bookingcom/carbonapi/expr/functions/sortByName"
 	"github.com/bookingcom/carbonapi/expr/functions/squareRoot"
@@ -85,9 +96,12 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/summarize"
 	"github.com/bookingcom/carbonapi/expr/functions/timeFunction"
 	"github.com/bookingcom/carbonapi/expr/functions/timeShift"
+	"github.com/bookingcom/carbonapi/expr/functions/timeSlice"
 	"github.com/bookingcom/carbonapi/expr/functions/timeStack"
 	"github.com/bookingcom/carbonapi/expr/functions/transformNull"
 	"github.com/bookingcom/carbonapi/expr/functions/tukey"
+	"github.com/bookingcom/carbonapi/expr/functions/unique"
+	"github.com/bookingcom/carbonapi/expr/functions/weightedAverage"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/metadata"
 )
@@ -99,16 +113,20 @@ type initFunc struct {
 }
 
 func New(configs map[string]string) {
-	funcs := make([]initFunc, 0, 84)
+	funcs := make([]initFunc, 0, 98)
 
 	funcs = append(funcs, initFunc{name: "absolute", order: absolute.GetOrder(), f: absolute.New})
 
+	funcs = append(funcs, initFunc{name: "aggregate", order: aggregate.GetOrder(), f: aggregate.New})
+
 	funcs = append(funcs, initFunc{name: "alias", order: alias.GetOrder(), f: alias.New})
 
 	funcs = append(funcs, initFunc{name: "aliasByMetric", order: aliasByMetric.GetOrder(), f: aliasByMetric.New})
 
 	funcs = append(funcs, initFunc{name: "aliasByNode", order: aliasByNode.GetOrder(), f: aliasByNode.New})
 
+	funcs = append(funcs, initFunc{name: "aliasByTags", order: aliasByTags.GetOrder(), f: aliasByTags.New})
+
 	funcs = append(funcs, initFunc{name: "aliasSub", order: aliasSub.GetOrder(), f: aliasSub.New})
 
 	funcs = append(funcs, initFunc{name: "asPercent", order: asPercent.GetOrder(), f: asPercent.New})
@@ -145,10 +163,14 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "exclude", order: exclude.GetOrder(), f: exclude.New})
 
+	funcs = append(funcs, initFunc{name: "exponentialMovingAverage", order: exponentialMovingAverage.GetOrder(), f: exponentialMovingAverage.New})
+
 	funcs = append(funcs, initFunc{name: "fallbackSeries", order: fallbackSeries.GetOrder(), f: fallbackSeries.New})
 
 	funcs = append(funcs, initFunc{name: "fft", order: fft.GetOrder(), f: fft.New})
 
+	funcs = append(funcs, initFunc{name: "filterSeries", order: filterSeries.GetOrder(), f: filterSeries.New})
+
 	funcs = append(funcs, initFunc{name: "graphiteWeb", order: graphiteWeb.GetOrder(), f: graphiteWeb.New})
 
 	funcs = append(funcs, initFunc{name: "grep", order: grep.GetOrder(), f: grep.New})
@@ -157,12 +179,16 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "groupByNode", order: groupByNode.GetOrder(), f: groupByNode.New})
 
+	funcs = append(funcs, initFunc{name: "groupByTags", order: groupByTags.GetOrder(), f: groupByTags.New})
+
 	funcs = append(funcs, initFunc{name: "highest", order: highest.GetOrder(), f: highest.New})
 
 	funcs = append(funcs, initFunc{name: "hitcount", order: hitcount.GetOrder(), f: hitcount.New})
 
 	funcs = append(funcs, initFunc{name: "holtWintersAberration", order: holtWintersAberration.GetOrder(), f: holtWintersAberration.New})
 
+	funcs = append(funcs, initFunc{name: "holtWintersConfidenceArea", order: holtWintersConfidenceArea.GetOrder(), f: holtWintersConfidenceArea.New})
+
 	funcs = append(funcs, initFunc{name: "holtWintersConfidenceBands", order: holtWintersConfidenceBands.GetOrder(), f: holtWintersConfidenceBands.New})
 
 	funcs = append(funcs, initFunc{name: "holtWintersForecast", order: holtWintersForecast.GetOrder(), f: holtWintersForecast.New})
@@ -171,6 +197,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "integral", order: integral.GetOrder(), f: integral.New})
 
+	funcs = append(funcs, initFunc{name: "integralByInterval", order: integralByInterval.GetOrder(), f: integralByInterval.New})
+
 	funcs = append(funcs, initFunc{name: "invert", order: invert.GetOrder(), f: invert.New})
 
 	funcs = append(funcs, initFunc{name: "isNotNull", order: isNotNull.GetOrder(), f: isNotNull.New})
@@ -191,6 +219,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "lowest", order: lowest.GetOrder(), f: lowest.New})
 
+	funcs = append(funcs, initFunc{name: "lua", order: lua.GetOrder(), f: lua.New})
+
 	funcs = append(funcs, initFunc{name: "mapSeries", order: mapSeries.GetOrder(), f: mapSeries.New})
 
 	funcs = append(funcs, initFunc{name: "minMax", order: minMax.GetOrder(), f: minMax.New})
@@ -235,12 +265,18 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "removeEmptySeries", order: removeEmptySeries.GetOrder(), f: removeEmptySeries.New})
 
+	funcs = append(funcs, initFunc{name: "round", order: round.GetOrder(), f: round.New})
+
 	funcs = append(funcs, initFunc{name: "scale", order: scale.GetOrder(), f: scale.New})
 
 	funcs = append(funcs, initFunc{name: "scaleToSeconds", order: scaleToSeconds.GetOrder(), f: scaleToSeconds.New})
 
 	funcs = append(funcs, initFunc{name: "seriesList", order: seriesList.GetOrder(), f: seriesList.New})
 
+	funcs = append(funcs, initFunc{name: "setXFilesFactor", order: setXFilesFactor.GetOrder(), f: setXFilesFactor.New})
+
+	funcs = append(funcs, initFunc{name: "smartSummarize", order: smartSummarize.GetOrder(), f: smartSummarize.New})
+
 	funcs = append(funcs, initFunc{name: "sortBy", order: sortBy.GetOrder(), f: sortBy.New})
 
 	funcs = append(funcs, initFunc{name: "sortByName", order: sortByName.GetOrder(), f: sortByName.New})
@@ -263,12 +299,18 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "timeShift", order: timeShift.GetOrder(), f: timeShift.New})
 
+	funcs = append(funcs, initFunc{name: "timeSlice", order: timeSlice.GetOrder(), f: timeSlice.New})
+
 	funcs = append(funcs, initFunc{name: "timeStack", order: timeStack.GetOrder(), f: timeStack.New})
 
 	funcs = append(funcs, initFunc{name: "transformNull", order: transformNull.GetOrder(), f: transformNull.New})
 
 	funcs = append(funcs, initFunc{name: "tukey", order: tukey.GetOrder(), f: tukey.New})
 
+	funcs = append(funcs, initFunc{name: "unique", order: unique.GetOrder(), f: unique.New})
+
+	funcs = append(funcs, initFunc{name: "weightedAverage", order: weightedAverage.GetOrder(), f: weightedAverage.New})
+
 	sort.Slice(funcs, func(i, j int) bool {
 		if funcs[i].order == interfaces.Any && funcs[j].order == interfaces.Last {
 			return true
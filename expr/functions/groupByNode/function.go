@@ -35,26 +35,26 @@ func (f *groupByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 		return nil, err
 	}
 	var callback string
-	var fields []int
+	var fields []parser.NodeOrTag
 
 	if e.Target() == "groupByNode" {
-		field, err := e.GetIntArg(1)
+		callback, err = e.GetStringArg(2)
 		if err != nil {
 			return nil, err
 		}
 
-		callback, err = e.GetStringArg(2)
+		fields, err = e.GetNodeOrTagArgs(1)
 		if err != nil {
 			return nil, err
 		}
-		fields = []int{field}
+		fields = fields[:1]
 	} else {
 		callback, err = e.GetStringArg(1)
 		if err != nil {
 			return nil, err
 		}
 
-		fields, err = e.GetIntArgs(2)
+		fields, err = e.GetNodeOrTagArgs(2)
 		if err != nil {
 			return nil, err
 		}
@@ -67,13 +67,7 @@ func (f *groupByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 
 	for _, a := range args {
 
-		metric := helper.ExtractMetric(a.Name)
-		nodes := strings.Split(metric, ".")
-		nodeKey := make([]string, 0, len(fields))
-		for _, f := range fields {
-			nodeKey = append(nodeKey, nodes[f])
-		}
-		node := strings.Join(nodeKey, ".")
+		node := helper.AggKey(a.Name, fields)
 		if len(groups[node]) == 0 {
 			nodeList = append(nodeList, node)
 		}
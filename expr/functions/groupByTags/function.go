@@ -0,0 +1,162 @@
+package groupByTags
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type groupByTags struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &groupByTags{}
+	for _, n := range []string{"groupByTags"} {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// groupByTags(seriesList, callback, *tags)
+func (f *groupByTags) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := e.GetStringArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	nodesOrTags, err := e.GetNodeOrTagArgs(2)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := false
+	var tags []string
+	for _, nt := range nodesOrTags {
+		if !nt.IsTag {
+			return nil, parser.ErrBadType
+		}
+		tag := nt.Value.(string)
+		if tag == "name" {
+			byName = true
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	seriesTags := make([][]string, len(args))
+	names := make(map[string]struct{})
+	for i, a := range args {
+		t := helper.ExtractTags(a.Name)
+		names[t["name"]] = struct{}{}
+
+		key := make([]string, 0, len(tags)+1)
+		key = append(key, t["name"])
+		for _, tag := range tags {
+			key = append(key, tag+"="+t[tag])
+		}
+		seriesTags[i] = key
+	}
+
+	// Unless grouping by name, the groups are named after the series if they
+	// all have the same name, and after the callback otherwise.
+	name := callback
+	if len(names) == 1 {
+		for n := range names {
+			name = n
+		}
+	}
+
+	var keys []string
+	groups := make(map[string][]*types.MetricData)
+	for i, a := range args {
+		if !byName {
+			seriesTags[i][0] = name
+		}
+		key := strings.Join(seriesTags[i], ";")
+
+		if len(groups[key]) == 0 {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+
+	aggregation := strings.TrimSuffix(callback, "Series")
+	var results []*types.MetricData
+	for _, key := range keys {
+		stub := parser.NewExpr("aggregate", "stub", parser.ArgValue(aggregation))
+		nvalues := map[parser.MetricRequest][]*types.MetricData{
+			{Metric: "stub", From: from, Until: until}: groups[key],
+		}
+
+		r, err := f.Evaluator.EvalExpr(stub, from, until, nvalues)
+		if err != nil {
+			return nil, err
+		}
+		if len(r) > 0 {
+			r[0].Name = key
+			results = append(results, r[0])
+		}
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *groupByTags) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"groupByTags": {
+			Description: "Takes a serieslist and maps a callback to subgroups within as defined by multiple tags\n\n.. code-block:: none\n\n  &target=seriesByTag(\"name=cpu\")|groupByTags(\"average\",\"dc\")\n\nWould return multiple series which are each the result of applying the \"averageSeries\" function\nto groups joined on the specified tags resulting in a list of targets like\n\n.. code-block :: none\n\n  averageSeries(seriesByTag(\"name=cpu\",\"dc=dc1\")),averageSeries(seriesByTag(\"name=cpu\",\"dc=dc2\")),...\n\nThis function can be used with all aggregation functions supported by\n:py:func:`aggregate <aggregate>`: ``average``, ``median``, ``sum``, ``min``, ``max``, ``diff``,\n``stddev``, ``range`` & ``multiply``.",
+			Function:    "groupByTags(seriesList, callback, *tags)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "groupByTags",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name: "callback",
+					Options: []string{
+						"average",
+						"count",
+						"diff",
+						"last",
+						"max",
+						"median",
+						"min",
+						"multiply",
+						"range",
+						"stddev",
+						"sum",
+					},
+					Required: true,
+					Type:     types.AggFunc,
+				},
+				{
+					Multiple: true,
+					Name:     "tags",
+					Required: true,
+					Type:     types.Tag,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package groupByTags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/functions/aggregate"
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	a := aggregate.New("")
+	for _, m := range a {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+	md := New("")
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+
+	evaluator := th.EvaluatorFromFuncWithMetadata(metadata.FunctionMD.Functions)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+}
+
+// This return is multireturn
+func TestGroupByTags(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	series := map[parser.MetricRequest][]*types.MetricData{
+		{"cpu.*", 0, 1}: {
+			types.MakeMetricData("cpu.load;dc=ams;host=web1", []float64{1, 2, 3}, 1, now32),
+			types.MakeMetricData("cpu.load;dc=ams;host=web2", []float64{4, 5, 6}, 1, now32),
+			types.MakeMetricData("cpu.load;dc=lhr;host=web3", []float64{7, 8, 9}, 1, now32),
+		},
+	}
+	mixed := map[parser.MetricRequest][]*types.MetricData{
+		{"cpu.*", 0, 1}: {
+			types.MakeMetricData("cpu.load;dc=ams", []float64{1, 2, 3}, 1, now32),
+			types.MakeMetricData("cpu.idle;dc=ams", []float64{4, 5, 6}, 1, now32),
+		},
+	}
+
+	tests := []th.MultiReturnEvalTestItem{
+		{
+			parser.NewExpr("groupByTags",
+				"cpu.*",
+				parser.ArgValue("sum"),
+				parser.ArgValue("dc"),
+			),
+			series,
+			"groupByTags",
+			map[string][]*types.MetricData{
+				"cpu.load;dc=ams": {types.MakeMetricData("cpu.load;dc=ams", []float64{5, 7, 9}, 1, now32)},
+				"cpu.load;dc=lhr": {types.MakeMetricData("cpu.load;dc=lhr", []float64{7, 8, 9}, 1, now32)},
+			},
+		},
+		{
+			parser.NewExpr("groupByTags",
+				"cpu.*",
+				parser.ArgValue("averageSeries"),
+				parser.ArgValue("dc"),
+			),
+			mixed,
+			"groupByTags",
+			map[string][]*types.MetricData{
+				"averageSeries;dc=ams": {types.MakeMetricData("averageSeries;dc=ams", []float64{2.5, 3.5, 4.5}, 1, now32)},
+			},
+		},
+		{
+			parser.NewExpr("groupByTags",
+				"cpu.*",
+				parser.ArgValue("max"),
+				parser.ArgValue("name"),
+				parser.ArgValue("dc"),
+			),
+			mixed,
+			"groupByTags",
+			map[string][]*types.MetricData{
+				"cpu.load;dc=ams": {types.MakeMetricData("cpu.load;dc=ams", []float64{1, 2, 3}, 1, now32)},
+				"cpu.idle;dc=ams": {types.MakeMetricData("cpu.idle;dc=ams", []float64{4, 5, 6}, 1, now32)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestMultiReturnEvalExpr(t, &tt)
+		})
+	}
+}
@@ -29,9 +29,15 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
+// holtWintersAberration(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')
 func (f *holtWintersAberration) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	bootstrapInterval, seasonality, err := holtwinters.GetIntervalArgs(e, 2)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArg(e.Args()[0], from-7*86400, until, values)
+	args, err := helper.GetSeriesArg(e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -46,9 +52,9 @@ func (f *holtWintersAberration) Do(e parser.Expr, from, until int32, values map[
 
 		stepTime := arg.StepTime
 
-		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta)
+		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta, bootstrapInterval, seasonality)
 
-		windowPoints := 7 * 86400 / stepTime
+		windowPoints := holtwinters.WindowPoints(len(arg.Values), stepTime, bootstrapInterval)
 		series := arg.Values[windowPoints:]
 		absent := arg.IsAbsent[windowPoints:]
 
@@ -69,7 +75,7 @@ func (f *holtWintersAberration) Do(e parser.Expr, from, until int32, values map[
 			Values:    aberration,
 			IsAbsent:  make([]bool, len(aberration)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + bootstrapInterval,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -83,7 +89,7 @@ func (f *holtWintersAberration) Description() map[string]types.FunctionDescripti
 	return map[string]types.FunctionDescription{
 		"holtWintersAberration": {
 			Description: "Performs a Holt-Winters forecast using the series as input data and plots the\npositive or negative deviation of the series data from the forecast.",
-			Function:    "holtWintersAberration(seriesList, delta=3, bootstrapInterval='7d')",
+			Function:    "holtWintersAberration(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersAberration",
@@ -107,6 +113,15 @@ func (f *holtWintersAberration) Description() map[string]types.FunctionDescripti
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
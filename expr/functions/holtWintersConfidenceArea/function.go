@@ -0,0 +1,142 @@
+package holtWintersConfidenceArea
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/holtwinters"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+type holtWintersConfidenceArea struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &holtWintersConfidenceArea{}
+	functions := []string{"holtWintersConfidenceArea"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// holtWintersConfidenceArea(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')
+func (f *holtWintersConfidenceArea) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	bootstrapInterval, seasonality, err := holtwinters.GetIntervalArgs(e, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from-bootstrapInterval, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := e.GetFloatNamedOrPosArgDefault("delta", 1, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.MetricData
+	for _, arg := range args {
+		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, arg.StepTime, delta, bootstrapInterval, seasonality)
+		bands := []*types.MetricData{
+			makeBand(fmt.Sprintf("holtWintersConfidenceLower(%s)", arg.Name), lowerBand, arg, bootstrapInterval),
+			makeBand(fmt.Sprintf("holtWintersConfidenceUpper(%s)", arg.Name), upperBand, arg, bootstrapInterval),
+		}
+
+		// The area is drawn by areaBetween, which only returns series when
+		// carbonapi is built with cairo; otherwise the bands are returned
+		// as they are.
+		stub := parser.NewExpr("areaBetween", "stub")
+		nvalues := map[parser.MetricRequest][]*types.MetricData{
+			{Metric: "stub", From: from, Until: until}: bands,
+		}
+		area, err := f.Evaluator.EvalExpr(stub, from, until, nvalues)
+		if err != nil && err != helper.ErrUnknownFunction("areaBetween") {
+			return nil, err
+		}
+		if len(area) == 0 {
+			area = bands
+		}
+
+		for _, a := range area {
+			a.Name = fmt.Sprintf("holtWintersConfidenceArea(%s)", arg.Name)
+		}
+		results = append(results, area...)
+	}
+	return results, nil
+}
+
+func makeBand(name string, band []float64, arg *types.MetricData, bootstrapInterval int32) *types.MetricData {
+	r := types.MetricData{FetchResponse: pb.FetchResponse{
+		Name:      name,
+		Values:    band,
+		IsAbsent:  make([]bool, len(band)),
+		StepTime:  arg.StepTime,
+		StartTime: arg.StartTime + bootstrapInterval,
+		StopTime:  arg.StopTime,
+	}}
+
+	for i, val := range r.Values {
+		if math.IsNaN(val) {
+			r.Values[i] = 0
+			r.IsAbsent[i] = true
+		}
+	}
+
+	return &r
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *holtWintersConfidenceArea) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"holtWintersConfidenceArea": {
+			Description: "Performs a Holt-Winters forecast using the series as input data and plots the\narea between the upper and lower bands of the predicted forecast deviations.",
+			Function:    "holtWintersConfidenceArea(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions",
+			Name:        "holtWintersConfidenceArea",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Default: types.NewSuggestion(3),
+					Name:    "delta",
+					Type:    types.Integer,
+				},
+				{
+					Default: types.NewSuggestion("7d"),
+					Name:    "bootstrapInterval",
+					Suggestions: types.NewSuggestions(
+						"7d",
+						"30d",
+					),
+					Type: types.Interval,
+				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
+			},
+		},
+	}
+}
@@ -29,9 +29,15 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
+// holtWintersConfidenceBands(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')
 func (f *holtWintersConfidenceBands) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	bootstrapInterval, seasonality, err := holtwinters.GetIntervalArgs(e, 2)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArg(e.Args()[0], from-7*86400, until, values)
+	args, err := helper.GetSeriesArg(e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -44,14 +50,14 @@ func (f *holtWintersConfidenceBands) Do(e parser.Expr, from, until int32, values
 	for _, arg := range args {
 		stepTime := arg.StepTime
 
-		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta)
+		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta, bootstrapInterval, seasonality)
 
 		lowerSeries := types.MetricData{FetchResponse: pb.FetchResponse{
 			Name:      fmt.Sprintf("holtWintersConfidenceLower(%s)", arg.Name),
 			Values:    lowerBand,
 			IsAbsent:  make([]bool, len(lowerBand)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + bootstrapInterval,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -67,7 +73,7 @@ func (f *holtWintersConfidenceBands) Do(e parser.Expr, from, until int32, values
 			Values:    upperBand,
 			IsAbsent:  make([]bool, len(upperBand)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + bootstrapInterval,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -90,7 +96,7 @@ func (f *holtWintersConfidenceBands) Description() map[string]types.FunctionDesc
 	return map[string]types.FunctionDescription{
 		"holtWintersConfidenceBands": {
 			Description: "Performs a Holt-Winters forecast using the series as input data and plots\nupper and lower bands with the predicted forecast deviations.",
-			Function:    "holtWintersConfidenceBands(seriesList, delta=3, bootstrapInterval='7d')",
+			Function:    "holtWintersConfidenceBands(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersConfidenceBands",
@@ -114,6 +120,15 @@ func (f *holtWintersConfidenceBands) Description() map[string]types.FunctionDesc
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
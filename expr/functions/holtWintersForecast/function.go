@@ -8,6 +8,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/pkg/parser"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"math"
 )
 
 type holtWintersForecast struct {
@@ -28,9 +29,15 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
+// holtWintersForecast(seriesList, bootstrapInterval='7d', seasonality='1d')
 func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	bootstrapInterval, seasonality, err := holtwinters.GetIntervalArgs(e, 1)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from-7*86400, until, values)
+	args, err := helper.GetSeriesArg(e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -38,9 +45,9 @@ func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[pa
 	for _, arg := range args {
 		stepTime := arg.StepTime
 
-		predictions, _ := holtwinters.HoltWintersAnalysis(arg.Values, stepTime)
+		predictions, _ := holtwinters.HoltWintersAnalysis(arg.Values, stepTime, seasonality)
 
-		windowPoints := 7 * 86400 / stepTime
+		windowPoints := holtwinters.WindowPoints(len(predictions), stepTime, bootstrapInterval)
 		predictionsOfInterest := predictions[windowPoints:]
 
 		r := types.MetricData{FetchResponse: pb.FetchResponse{
@@ -48,10 +55,17 @@ func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[pa
 			Values:    predictionsOfInterest,
 			IsAbsent:  make([]bool, len(predictionsOfInterest)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + bootstrapInterval,
 			StopTime:  arg.StopTime,
 		}}
 
+		for i, val := range r.Values {
+			if math.IsNaN(val) {
+				r.Values[i] = 0
+				r.IsAbsent[i] = true
+			}
+		}
+
 		results = append(results, &r)
 	}
 	return results, nil
@@ -63,7 +77,7 @@ func (f *holtWintersForecast) Description() map[string]types.FunctionDescription
 	return map[string]types.FunctionDescription{
 		"holtWintersForecast": {
 			Description: "Performs a Holt-Winters forecast using the series as input data. Data from\n`bootstrapInterval` (one week by default) previous to the series is used to bootstrap the initial forecast.",
-			Function:    "holtWintersForecast(seriesList, bootstrapInterval='7d')",
+			Function:    "holtWintersForecast(seriesList, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersForecast",
@@ -82,6 +96,15 @@ func (f *holtWintersForecast) Description() map[string]types.FunctionDescription
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
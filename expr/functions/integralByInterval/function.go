@@ -0,0 +1,113 @@
+package integralByInterval
+
+import (
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type integralByInterval struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &integralByInterval{}
+	functions := []string{"integralByInterval"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// integralByInterval(seriesList, intervalUnit)
+func (f *integralByInterval) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := e.GetIntervalArg(1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if interval < 0 {
+		interval = -interval
+	}
+	if interval == 0 {
+		return nil, parser.ErrBadType
+	}
+	intervalUnit, _ := e.GetStringArg(1)
+
+	results := make([]*types.MetricData, 0, len(args))
+	for _, a := range args {
+		r := *a
+		r.Name = fmt.Sprintf("integralByInterval(%s,'%s')", a.Name, intervalUnit)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		// The intervals are counted from the start of the request, and the
+		// integral is reset whenever a point falls into a new one. Absent
+		// points keep the current value, as in graphite-web.
+		current := 0.0
+		t := a.StartTime
+		for i, v := range a.Values {
+			if floorDiv(t-from, interval) != floorDiv(t-from-a.StepTime, interval) {
+				current = 0
+			}
+			if !a.IsAbsent[i] {
+				current += v
+			}
+			r.Values[i] = current
+			t += a.StepTime
+		}
+
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
+
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *integralByInterval) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"integralByInterval": {
+			Description: "This will do the same as integral() funcion, except resetting the total to 0\nat the given time in the parameter \"from\"\nUseful for finding totals per hour/day/week/..\n\nExample:\n\n.. code-block:: none\n\n  &target=integralByInterval(company.sales.perMinute, \"1d\")&from=midnight-10days\n\nThis would start at zero on the left side of the graph, adding the sales each\nminute, and show the evolution of sales per day during the last 10 days.",
+			Function:    "integralByInterval(seriesList, intervalUnit)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "integralByInterval",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "intervalUnit",
+					Required: true,
+					Type:     types.String,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package integralByInterval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestIntegralByInterval(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("integralByInterval", "metric1", parser.ArgValue("2s")),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 1, 0)},
+			},
+			[]*types.MetricData{types.MakeMetricData("integralByInterval(metric1,'2s')",
+				[]float64{1, 3, 3, 7, 5, 11}, 1, 0)},
+		},
+		{
+			parser.NewExpr("integralByInterval", "metric1", parser.ArgValue("3s")),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, nan, 1, nan, 1, 1}, 1, 0)},
+			},
+			[]*types.MetricData{types.MakeMetricData("integralByInterval(metric1,'3s')",
+				[]float64{1, 1, 2, 0, 1, 2}, 1, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
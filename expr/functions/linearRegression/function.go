@@ -2,7 +2,9 @@ package linearRegression
 
 import (
 	"fmt"
+	"math"
 
+	"github.com/bookingcom/carbonapi/date"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/types"
@@ -36,16 +38,35 @@ func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parse
 		return nil, err
 	}
 
+	startSourceAt, err := e.GetStringNamedOrPosArgDefault("startSourceAt", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	endSourceAt, err := e.GetStringNamedOrPosArgDefault("endSourceAt", 2, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the fetched data is used, so the source range is limited to
+	// the one of the request, unlike in graphite-web.
+	if len(arg) == 0 {
+		return nil, nil
+	}
+
+	now, loc := arg[0].CurrentTime(), arg[0].DateLocation()
+	sourceFrom := date.DateParamToEpochAt(startSourceAt, "", math.MinInt32, loc, now)
+	sourceUntil := date.DateParamToEpochAt(endSourceAt, "", math.MaxInt32, loc, now)
+
 	degree := 1
 
 	var results []*types.MetricData
 
 	for _, a := range arg {
 		r := *a
-		if len(e.Args()) > 2 {
-			r.Name = fmt.Sprintf("linearRegression(%s,'%s','%s')", a.GetName(), e.Args()[1].StringValue(), e.Args()[2].StringValue())
-		} else if len(e.Args()) > 1 {
-			r.Name = fmt.Sprintf("linearRegression(%s,'%s')", a.GetName(), e.Args()[2].StringValue())
+		if endSourceAt != "" {
+			r.Name = fmt.Sprintf("linearRegression(%s,'%s','%s')", a.GetName(), startSourceAt, endSourceAt)
+		} else if startSourceAt != "" {
+			r.Name = fmt.Sprintf("linearRegression(%s,'%s')", a.GetName(), startSourceAt)
 		} else {
 			r.Name = fmt.Sprintf("linearRegression(%s)", a.GetName())
 		}
@@ -54,12 +75,17 @@ func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parse
 		r.IsAbsent = make([]bool, len(r.Values))
 		r.StopTime = a.GetStopTime()
 
-		// Removing absent values from original dataset
+		// Removing absent values and values outside of the source range
+		// from original dataset
 		nonNulls := make([]float64, 0)
+		skipped := make([]bool, len(a.Values))
 		for i := range a.Values {
-			if !a.IsAbsent[i] {
-				nonNulls = append(nonNulls, a.Values[i])
+			t := a.StartTime + int32(i)*a.StepTime
+			if a.IsAbsent[i] || t < sourceFrom || t > sourceUntil {
+				skipped[i] = true
+				continue
 			}
+			nonNulls = append(nonNulls, a.Values[i])
 		}
 		if len(nonNulls) < 2 {
 			for i := range r.IsAbsent {
@@ -70,7 +96,7 @@ func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parse
 		}
 
 		// STEP 1: Creating Vandermonde (X)
-		v := helper.Vandermonde(a.IsAbsent, degree)
+		v := helper.Vandermonde(skipped, degree)
 		// STEP 2: Creating (X^T * X)**-1
 		var t mat.Dense
 		t.Mul(v.T(), v)
@@ -0,0 +1,410 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/lomik/zapwriter"
+	"github.com/spf13/viper"
+	glua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"go.uber.org/zap"
+)
+
+// lua is a render function defined by a Lua script. The script must define a
+// global function with the name of the file, which is called with the
+// arguments of the target: series lists become arrays of series tables,
+// numbers and strings are passed as they are. It must return an array of
+// series tables.
+//
+// A series table has the fields name, start, stop, step, length and values.
+// Absent points are nil in values, so length must be used to iterate over
+// them.
+//
+// Scripts run in a sandbox with only the base, table, string and math
+// libraries, without the functions of the base library that access files,
+// other modules or the standard output, and each call is stopped after the
+// configured timeout. The series a script returns can't be longer than the
+// ones it was given.
+type lua struct {
+	interfaces.FunctionBase
+
+	name    string
+	proto   *glua.FunctionProto
+	timeout time.Duration
+	states  sync.Pool
+
+	description types.FunctionDescription
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Last
+}
+
+type luaConfig struct {
+	Enabled   bool
+	Directory string
+	Timeout   time.Duration
+}
+
+var functionName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// unsafeBaseFunctions are removed from the base library as they give access
+// to the filesystem, to other modules or to the standard output.
+var unsafeBaseFunctions = []string{"dofile", "loadfile", "print", "_printregs", "require", "module"}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	logger := zapwriter.Logger("functionInit").With(zap.String("function", "lua"))
+	if configFile == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	err := v.ReadInConfig()
+	if err != nil {
+		logger.Fatal("failed to read config file",
+			zap.Error(err),
+		)
+	}
+
+	cfg := luaConfig{
+		Enabled: false,
+		Timeout: time.Second,
+	}
+	err = v.Unmarshal(&cfg)
+	if err != nil {
+		logger.Fatal("failed to parse config",
+			zap.Error(err),
+		)
+	}
+
+	if !cfg.Enabled {
+		logger.Warn("lua config found but lua functions are disabled")
+		return nil
+	}
+
+	logger.Info("lua configured",
+		zap.Any("config", cfg),
+		zap.String("config_file", configFile),
+	)
+
+	files, err := filepath.Glob(filepath.Join(cfg.Directory, "*.lua"))
+	if err != nil {
+		logger.Fatal("failed to list scripts",
+			zap.Error(err),
+		)
+	}
+	sort.Strings(files)
+
+	res := make([]interfaces.FunctionMetadata, 0, len(files))
+	functions := make([]string, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".lua")
+		if !functionName.MatchString(name) {
+			logger.Error("invalid function name, skipping script",
+				zap.String("script", file),
+			)
+			continue
+		}
+
+		metadata.FunctionMD.RLock()
+		_, native := metadata.FunctionMD.Functions[name]
+		metadata.FunctionMD.RUnlock()
+		if native {
+			logger.Error("function already exists, skipping script",
+				zap.String("script", file),
+			)
+			continue
+		}
+
+		f, err := newLua(name, file, cfg.Timeout)
+		if err != nil {
+			logger.Error("failed to load script",
+				zap.String("script", file),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		functions = append(functions, name)
+		res = append(res, interfaces.FunctionMetadata{Name: name, F: f, Order: interfaces.Last})
+	}
+
+	logger.Info("will handle following functions",
+		zap.Strings("functions_metadata", functions),
+	)
+
+	return res
+}
+
+func newLua(name, file string, timeout time.Duration) (*lua, error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	chunk, err := parse.Parse(source, file)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := glua.Compile(chunk, file)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &lua{
+		name:    name,
+		proto:   proto,
+		timeout: timeout,
+	}
+
+	// Load the script once to check it, and to read its description.
+	L, err := f.newState()
+	if err != nil {
+		return nil, err
+	}
+	description := L.GetGlobal("description")
+	f.states.Put(L)
+
+	f.description = types.FunctionDescription{
+		Description: glua.LVAsString(description),
+		Function:    name + "(seriesList, ...)",
+		Group:       "Custom",
+		Module:      "carbonapi.functions.lua",
+		Name:        name,
+		Params: []types.FunctionParam{
+			{
+				Name:     "seriesList",
+				Required: true,
+				Type:     types.SeriesList,
+			},
+		},
+	}
+
+	return f, nil
+}
+
+// newState returns a sandboxed Lua state where the script has been run.
+func (f *lua) newState() (*glua.LState, error) {
+	L := glua.NewState(glua.Options{SkipOpenLibs: true})
+
+	for _, lib := range []struct {
+		name string
+		open glua.LGFunction
+	}{
+		{glua.BaseLibName, glua.OpenBase},
+		{glua.TabLibName, glua.OpenTable},
+		{glua.StringLibName, glua.OpenString},
+		{glua.MathLibName, glua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(glua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeBaseFunctions {
+		L.SetGlobal(name, glua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(f.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	if _, ok := L.GetGlobal(f.name).(*glua.LFunction); !ok {
+		L.Close()
+		return nil, fmt.Errorf("script doesn't define function %s", f.name)
+	}
+
+	return L, nil
+}
+
+func (f *lua) getState() (*glua.LState, error) {
+	if L, ok := f.states.Get().(*glua.LState); ok {
+		return L, nil
+	}
+
+	return f.newState()
+}
+
+// Do calls the function of the script. A state that failed is discarded, as
+// it might have been stopped in the middle of changing its globals.
+func (f *lua) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) == 0 {
+		return nil, parser.ErrMissingArgument
+	}
+	if len(e.NamedArgs()) > 0 {
+		return nil, fmt.Errorf("%s: named arguments are not supported", f.name)
+	}
+
+	L, err := f.getState()
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]glua.LValue, 0, len(e.Args()))
+	maxLength := 0
+	for _, arg := range e.Args() {
+		switch {
+		case arg.IsConst():
+			args = append(args, glua.LNumber(arg.FloatValue()))
+		case arg.IsString():
+			args = append(args, glua.LString(arg.StringValue()))
+		default:
+			series, err := helper.GetSeriesArg(arg, from, until, values)
+			if err != nil {
+				f.states.Put(L)
+				return nil, err
+			}
+			for _, s := range series {
+				if len(s.Values) > maxLength {
+					maxLength = len(s.Values)
+				}
+			}
+			args = append(args, seriesListToTable(L, series))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	err = L.CallByParam(glua.P{
+		Fn:      L.GetGlobal(f.name),
+		NRet:    1,
+		Protect: true,
+	}, args...)
+	if err != nil {
+		L.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: timed out after %v", f.name, f.timeout)
+		}
+		return nil, fmt.Errorf("%s: %v", f.name, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	L.RemoveContext()
+
+	results, err := tableToSeriesList(ret, maxLength)
+	f.states.Put(L)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.name, err)
+	}
+
+	return results, nil
+}
+
+func seriesListToTable(L *glua.LState, series []*types.MetricData) *glua.LTable {
+	list := L.CreateTable(len(series), 0)
+	for _, s := range series {
+		values := L.CreateTable(len(s.Values), 0)
+		for i, v := range s.Values {
+			if !s.IsAbsent[i] {
+				values.RawSetInt(i+1, glua.LNumber(v))
+			}
+		}
+
+		t := L.CreateTable(0, 6)
+		t.RawSetString("name", glua.LString(s.Name))
+		t.RawSetString("start", glua.LNumber(s.StartTime))
+		t.RawSetString("stop", glua.LNumber(s.StopTime))
+		t.RawSetString("step", glua.LNumber(s.StepTime))
+		t.RawSetString("length", glua.LNumber(len(s.Values)))
+		t.RawSetString("values", values)
+		list.Append(t)
+	}
+
+	return list
+}
+
+// tableToSeriesList converts the series tables returned by a script. A series
+// can't be longer than its values or than the longest series passed to the
+// script, maxLength, so that a script can't make the render allocate without
+// bound.
+func tableToSeriesList(ret glua.LValue, maxLength int) ([]*types.MetricData, error) {
+	if ret == glua.LNil {
+		return nil, nil
+	}
+	list, ok := ret.(*glua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("returned %s instead of a list of series", ret.Type())
+	}
+
+	var results []*types.MetricData
+	for i := 1; i <= list.Len(); i++ {
+		t, ok := list.RawGetInt(i).(*glua.LTable)
+		if !ok {
+			return nil, fmt.Errorf("series %d is not a table", i)
+		}
+
+		name, ok := t.RawGetString("name").(glua.LString)
+		if !ok {
+			return nil, fmt.Errorf("series %d has no name", i)
+		}
+		start, ok := t.RawGetString("start").(glua.LNumber)
+		if !ok {
+			return nil, fmt.Errorf("series %d has no start", i)
+		}
+		step, ok := t.RawGetString("step").(glua.LNumber)
+		if !ok || step <= 0 {
+			return nil, fmt.Errorf("series %d has no valid step", i)
+		}
+		values, ok := t.RawGetString("values").(*glua.LTable)
+		if !ok {
+			return nil, fmt.Errorf("series %d has no values", i)
+		}
+		length := values.Len()
+		if l, ok := t.RawGetString("length").(glua.LNumber); ok {
+			limit := maxLength
+			if values.Len() > limit {
+				limit = values.Len()
+			}
+			// Written so that NaN is rejected too.
+			if !(l >= 0 && float64(l) <= float64(limit)) {
+				return nil, fmt.Errorf("series %d has an invalid length %v", i, l)
+			}
+			length = int(l)
+		}
+
+		points := make([]float64, length)
+		for j := range points {
+			v, ok := values.RawGetInt(j + 1).(glua.LNumber)
+			if !ok {
+				points[j] = math.NaN()
+				continue
+			}
+			points[j] = float64(v)
+		}
+
+		results = append(results, types.MakeMetricData(string(name), points, int32(step), int32(start)))
+	}
+
+	return results, nil
+}
+
+// Description returns the description of the function, using the global
+// description string of the script if there is one.
+func (f *lua) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		f.name: f.description,
+	}
+}
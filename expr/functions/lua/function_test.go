@@ -0,0 +1,197 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+var scripts = map[string]string{
+	"addPoints.lua": `
+description = "Adds n to every point"
+
+function addPoints(series, n)
+  for _, s in ipairs(series) do
+    s.name = "addPoints(" .. s.name .. ")"
+    for i = 1, s.length do
+      if s.values[i] then
+        s.values[i] = s.values[i] + n
+      end
+    end
+  end
+  return series
+end
+`,
+	"spin.lua": `
+function spin(series)
+  while true do end
+end
+`,
+	"readFile.lua": `
+function readFile(series)
+  return dofile("/etc/passwd")
+end
+`,
+	"globals.lua": `
+function globals(series)
+  for _, name in ipairs({"_printregs", "require", "module", "dofile", "loadfile", "print"}) do
+    if _G[name] ~= nil then
+      error(name .. " is defined")
+    end
+  end
+  return series
+end
+`,
+	"negativeLength.lua": `
+function negativeLength(series)
+  series[1].length = -1
+  return series
+end
+`,
+	"hugeLength.lua": `
+function hugeLength(series)
+  series[1].length = 1e12
+  return series
+end
+`,
+	"noFunction.lua": `x = 1`,
+	"sum.lua": `
+function sum(series)
+  return series
+end
+`,
+}
+
+func setUp(t *testing.T) (map[string]interfaces.Function, func()) {
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(dir, "lua.yaml")
+	yaml := "enabled: true\ndirectory: " + dir + "\ntimeout: 100ms\n"
+	if err := ioutil.WriteFile(config, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scripts can't replace native functions.
+	metadata.FunctionMD.Lock()
+	metadata.FunctionMD.Functions["sum"] = nil
+	metadata.FunctionMD.Unlock()
+
+	functions := make(map[string]interfaces.Function)
+	for _, m := range New(config) {
+		functions[m.Name] = m.F
+	}
+
+	metadata.FunctionMD.Lock()
+	delete(metadata.FunctionMD.Functions, "sum")
+	metadata.FunctionMD.Unlock()
+
+	evaluator := th.EvaluatorFromFuncWithMetadata(functions)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+
+	return functions, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	functions, tearDown := setUp(t)
+	defer tearDown()
+
+	for _, name := range []string{"addPoints", "spin", "readFile"} {
+		if _, ok := functions[name]; !ok {
+			t.Errorf("function %s not loaded", name)
+		}
+	}
+	for _, name := range []string{"noFunction", "sum"} {
+		if _, ok := functions[name]; ok {
+			t.Errorf("function %s loaded", name)
+		}
+	}
+
+	d := functions["addPoints"].Description()["addPoints"]
+	if d.Description != "Adds n to every point" {
+		t.Errorf("unexpected description %q", d.Description)
+	}
+}
+
+func TestLua(t *testing.T) {
+	_, tearDown := setUp(t)
+	defer tearDown()
+
+	now32 := int32(time.Now().Unix())
+	tt := th.EvalTestItem{
+		parser.NewExpr("addPoints",
+			"metric1",
+			2,
+		),
+		map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4}, 1, now32)},
+		},
+		[]*types.MetricData{types.MakeMetricData("addPoints(metric1)",
+			[]float64{3, 4, 5, 6}, 1, now32)},
+	}
+	th.TestEvalExpr(t, &tt)
+}
+
+func TestLuaSandbox(t *testing.T) {
+	functions, tearDown := setUp(t)
+	defer tearDown()
+
+	now32 := int32(time.Now().Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4}, 1, now32)},
+	}
+
+	e := parser.NewExpr("globals", "metric1")
+	if _, err := functions["globals"].Do(e, 0, 1, values); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLuaErrors(t *testing.T) {
+	functions, tearDown := setUp(t)
+	defer tearDown()
+
+	now32 := int32(time.Now().Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4}, 1, now32)},
+	}
+
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"spin", "timed out"},
+		{"readFile", "attempt to call a non-function object"},
+		{"negativeLength", "invalid length"},
+		{"hugeLength", "invalid length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := parser.NewExpr(tt.name, "metric1")
+			_, err := functions[tt.name].Do(e, 0, 1, values)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -20,7 +20,7 @@ func GetOrder() interfaces.Order {
 func New(configFile string) []interfaces.FunctionMetadata {
 	res := make([]interfaces.FunctionMetadata, 0)
 	f := &minMax{}
-	functions := []string{"minSeries", "min", "maxSeries", "max"}
+	functions := []string{"minSeries", "min", "maxSeries", "max", "minMax"}
 	for _, n := range functions {
 		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
 	}
@@ -31,6 +31,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 //  alias: max
 // minSeries(*seriesLists)
 //  alias: min
+// minMax(seriesList)
 func (f *minMax) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from, until, values)
 	if err != nil {
@@ -58,6 +59,25 @@ func (f *minMax) Do(e parser.Expr, from, until int32, values map[parser.MetricRe
 			}
 			return min
 		})
+	case "minMax":
+		var results []*types.MetricData
+		for _, a := range args {
+			r := *a
+			r.Name = fmt.Sprintf("minMax(%s)", a.Name)
+			r.Values = make([]float64, len(a.Values))
+
+			min := helper.MinValue(a.Values, a.IsAbsent)
+			max := helper.MaxValue(a.Values, a.IsAbsent)
+			for i, v := range a.Values {
+				if a.IsAbsent[i] || max == min {
+					continue
+				}
+				r.Values[i] = (v - min) / (max - min)
+			}
+
+			results = append(results, &r)
+		}
+		return results, nil
 	}
 
 	return nil, fmt.Errorf("unsupported target: %v", e.Target())
@@ -126,5 +146,19 @@ func (f *minMax) Description() map[string]types.FunctionDescription {
 				},
 			},
 		},
+		"minMax": {
+			Description: "Applies the popular min max normalization technique, which takes\neach point and applies the following normalization transformation\nto it: normalized = (point - min) / (max - min).\n\nExample:\n\n.. code-block:: none\n\n  &target=minMax(Server.instance01.threads.busy)",
+			Function:    "minMax(seriesList)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "minMax",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
 	}
 }
@@ -75,8 +75,7 @@ func (f *multiplySeriesWithWildcards) Do(e parser.Expr, from, until int32, value
 		r.Values = make([]float64, len(args[0].Values))
 		r.IsAbsent = make([]bool, len(args[0].Values))
 
-		atLeastOne := make([]bool, len(args[0].Values))
-		hasVal := make([]bool, len(args[0].Values))
+		count := make([]int, len(args[0].Values))
 
 		for _, arg := range args {
 			for i, v := range arg.Values {
@@ -84,18 +83,18 @@ func (f *multiplySeriesWithWildcards) Do(e parser.Expr, from, until int32, value
 					continue
 				}
 
-				atLeastOne[i] = true
-				if hasVal[i] == false {
+				if count[i] == 0 {
 					r.Values[i] = v
-					hasVal[i] = true
 				} else {
 					r.Values[i] *= v
 				}
+				count[i]++
 			}
 		}
 
-		for i, v := range atLeastOne {
-			if !v {
+		for i, n := range count {
+			if !types.EnoughValues(n, len(args), r.XFilesFactor) {
+				r.Values[i] = 0
 				r.IsAbsent[i] = true
 			}
 		}
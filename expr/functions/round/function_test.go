@@ -0,0 +1,62 @@
+package round
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestRound(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("round", "metric1"),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1.4, nan, 2.5, -3.6, 1234.5678}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("round(metric1)",
+				[]float64{1, nan, 3, -4, 1235}, 1, now32)},
+		},
+		{
+			parser.NewExpr("round", "metric1", 2),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1.4, nan, 2.5, -3.6, 1234.5678}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("round(metric1,2)",
+				[]float64{1.4, nan, 2.5, -3.6, 1234.57}, 1, now32)},
+		},
+		{
+			parser.NewExpr("round", "metric1", -2),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1.4, nan, 2.5, -3.6, 1234.5678}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("round(metric1,-2)",
+				[]float64{0, nan, 0, 0, 1200}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package setXFilesFactor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestSetXFilesFactor(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	for _, target := range []string{"setXFilesFactor", "xFilesFactor"} {
+		e := parser.NewExpr(target, "metric1", 0.75)
+		res, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, nan, 3, 4}, 1, now32)},
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", target, err)
+		}
+		if len(res) != 1 {
+			t.Fatalf("%s: expected 1 series, got %d", target, len(res))
+		}
+		if res[0].Name != "metric1" {
+			t.Errorf("%s: bad name %s", target, res[0].Name)
+		}
+		if res[0].XFilesFactor != 0.75 {
+			t.Errorf("%s: bad xFilesFactor %v", target, res[0].XFilesFactor)
+		}
+
+		// 3 of the 4 values are not null, but only 1 of the first 2.
+		res[0].SetValuesPerPoint(4)
+		if res[0].AggregatedAbsent()[0] {
+			t.Errorf("%s: expected a value when consolidating by 4", target)
+		}
+		res[0].SetValuesPerPoint(2)
+		if absent := res[0].AggregatedAbsent(); !absent[0] {
+			t.Errorf("%s: expected no value when consolidating by 2, got %v", target, absent)
+		}
+	}
+}
+
+func TestSetXFilesFactorOutOfRange(t *testing.T) {
+	for _, xff := range []float64{-0.1, 1.5} {
+		e := parser.NewExpr("setXFilesFactor", "metric1", xff)
+		_, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, 0)},
+		})
+		if err == nil {
+			t.Errorf("expected an error for xFilesFactor %v", xff)
+		}
+	}
+}
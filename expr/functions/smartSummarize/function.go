@@ -0,0 +1,208 @@
+package smartSummarize
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type smartSummarize struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &smartSummarize{}
+	functions := []string{"smartSummarize"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// smartSummarize(seriesList, intervalString, func='sum', alignTo=None)
+func (f *smartSummarize) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	bucketSize, err := e.GetIntervalArg(1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if bucketSize <= 0 {
+		return nil, parser.ErrBadType
+	}
+
+	summarizeFunction, err := e.GetStringNamedOrPosArgDefault("func", 2, "sum")
+	if err != nil {
+		return nil, err
+	}
+
+	// As in graphite-web, buckets are aligned to the start of the largest
+	// unit of the interval, up to days, unless alignTo is given.
+	unit := parser.IntervalUnit(bucketSize)
+	alignTo, err := e.GetStringNamedOrPosArgDefault("alignTo", 3, "")
+	if err != nil {
+		return nil, err
+	}
+	if alignTo != "" {
+		unit, err = parser.UnitString(alignTo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from-parser.MaxUnitInterval(unit), until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	start := alignStart(from, unit, args[0].Location())
+	results := make([]*types.MetricData, 0, len(args))
+	for _, arg := range args {
+		// skip the points fetched before the aligned start
+		skip := 0
+		if arg.StartTime < start {
+			skip = int((start - arg.StartTime + arg.StepTime - 1) / arg.StepTime)
+			if skip > len(arg.Values) {
+				skip = len(arg.Values)
+			}
+		}
+		seriesStart := arg.StartTime + int32(skip)*arg.StepTime
+		seriesStop := arg.StartTime + int32(len(arg.Values))*arg.StepTime
+
+		buckets := int((seriesStop - seriesStart + bucketSize - 1) / bucketSize)
+		bucketValues := make([][]float64, buckets)
+		for i := skip; i < len(arg.Values); i++ {
+			if arg.IsAbsent[i] {
+				continue
+			}
+			t := arg.StartTime + int32(i)*arg.StepTime
+			b := int((t - seriesStart) / bucketSize)
+			bucketValues[b] = append(bucketValues[b], arg.Values[i])
+		}
+
+		pointsPerBucket := int(bucketSize / arg.StepTime)
+		if pointsPerBucket < 1 {
+			pointsPerBucket = 1
+		}
+
+		r := *arg
+		r.Name = fmt.Sprintf("smartSummarize(%s,'%s','%s')", arg.Name, e.Args()[1].StringValue(), summarizeFunction)
+		r.Values = make([]float64, buckets)
+		r.IsAbsent = make([]bool, buckets)
+		r.StepTime = bucketSize
+		r.StartTime = seriesStart
+		r.StopTime = seriesStart + int32(buckets)*bucketSize
+
+		for i, bv := range bucketValues {
+			rv := math.NaN()
+			if types.EnoughValues(len(bv), pointsPerBucket, arg.XFilesFactor) {
+				rv = helper.SummarizeValues(summarizeFunction, bv)
+			}
+			if math.IsNaN(rv) {
+				r.IsAbsent[i] = true
+				continue
+			}
+			r.Values[i] = rv
+		}
+
+		results = append(results, &r)
+	}
+	return results, nil
+}
+
+// alignStart rounds t down to the start of unit, in loc. Weeks start on
+// Mondays.
+func alignStart(t int32, unit string, loc *time.Location) int32 {
+	tm := time.Unix(int64(t), 0).In(loc)
+	year, month, day := tm.Date()
+
+	switch unit {
+	case "minutes":
+		tm = tm.Truncate(time.Minute)
+	case "hours":
+		// not Truncate, as some time zones are off by half hours
+		tm = time.Date(year, month, day, tm.Hour(), 0, 0, 0, loc)
+	case "days":
+		tm = time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case "weeks":
+		tm = time.Date(year, month, day-(int(tm.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case "months":
+		tm = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case "years":
+		tm = time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	return int32(tm.Unix())
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *smartSummarize) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"smartSummarize": {
+			Description: "Smarter version of summarize.\n\nThe alignToFrom boolean parameter has been replaced by alignTo and no longer has any effect.\nAlignment can be to years, months, weeks, days, hours, and minutes.\n\nThis function can be used with aggregation functions ``average``, ``sum``, ``min``,\n``max`` & ``last``, as well as percentiles such as ``p95``.",
+			Function:    "smartSummarize(seriesList, intervalString, func='sum', alignTo=None)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "smartSummarize",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "intervalString",
+					Required: true,
+					Suggestions: types.NewSuggestions(
+						"10min",
+						"1h",
+						"1d",
+					),
+					Type: types.Interval,
+				},
+				{
+					Default: types.NewSuggestion("sum"),
+					Name:    "func",
+					Options: []string{
+						"average",
+						"avg",
+						"last",
+						"max",
+						"min",
+						"sum",
+						"total",
+					},
+					Type: types.AggFunc,
+				},
+				{
+					Name: "alignTo",
+					Suggestions: types.NewSuggestions(
+						"minutes",
+						"hours",
+						"days",
+						"weeks",
+						"months",
+						"years",
+					),
+					Type: types.String,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package smartSummarize
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestSmartSummarize(t *testing.T) {
+	const day = 24 * 60 * 60
+	// 1970-01-01 is a Thursday, so its week starts 3 days before
+	const monday = -3 * day
+
+	tests := []th.SummarizeEvalTestItem{
+		{
+			parser.NewExpr("smartSummarize",
+				"metric1", parser.ArgValue("5s"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{
+					1, 2, 3, 4, 5,
+					6, math.NaN(), 8, 9, 10,
+					11, 12,
+				}, 1, 0)},
+			},
+			[]float64{15, 33, 23},
+			"smartSummarize(metric1,'5s','sum')",
+			5,
+			0,
+			15,
+		},
+		{
+			parser.NewExpr("smartSummarize",
+				"metric1", parser.ArgValue("1h"), parser.ArgValue("max"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", -3600, 1}: {types.MakeMetricData("metric1", []float64{
+					1, 2, 3, 4, 5, 6,
+					7, 8, 9, 10, 11, 12,
+				}, 600, -3600)},
+			},
+			[]float64{12},
+			"smartSummarize(metric1,'1h','max')",
+			3600,
+			0,
+			3600,
+		},
+		{
+			parser.NewExpr("smartSummarize",
+				"metric1", parser.ArgValue("1d"),
+				parser.NamedArgs{
+					"alignTo": parser.ArgValue("weeks"),
+					"func":    parser.ArgValue("avg"),
+				},
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", -7 * day, 1}: {types.MakeMetricData("metric1", []float64{
+					1, 2, 3, 4, 5, 6, 7, 8,
+				}, day, -7*day)},
+			},
+			[]float64{5, 6, 7, 8},
+			"smartSummarize(metric1,'1d','avg')",
+			day,
+			monday,
+			monday + 4*day,
+		},
+	}
+
+	for _, tt := range tests {
+		th.TestSummarizeEvalExpr(t, &tt)
+	}
+}
+
+func TestSmartSummarizeTimeZone(t *testing.T) {
+	const day = 24 * 60 * 60
+
+	values := make([]float64, 48)
+	for i := range values {
+		values[i] = float64(i + 1)
+	}
+	m := types.MakeMetricData("metric1", values, 60*60, -day)
+	m.TimeZone = time.FixedZone("UTC+2", 2*60*60)
+
+	// the day starts at midnight in the time zone of the request, two hours
+	// before from
+	tt := th.SummarizeEvalTestItem{
+		E: parser.NewExpr("smartSummarize",
+			"metric1", parser.ArgValue("1d"),
+		),
+		M: map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", -day, 1}: {m},
+		},
+		W:     []float64{828, 95},
+		Name:  "smartSummarize(metric1,'1d','sum')",
+		Step:  day,
+		Start: -2 * 60 * 60,
+		Stop:  -2*60*60 + 2*day,
+	}
+	th.TestSummarizeEvalExpr(t, &tt)
+}
+
+func TestSmartSummarizeBadAlignTo(t *testing.T) {
+	e := parser.NewExpr("smartSummarize", "metric1", parser.ArgValue("1d"), parser.ArgValue("sum"), parser.ArgValue("fortnights"))
+	_, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, 0)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown alignTo unit")
+	}
+}
@@ -74,19 +74,20 @@ func (f *sumSeriesWithWildcards) Do(e parser.Expr, from, until int32, values map
 		r.Values = make([]float64, len(args[0].Values))
 		r.IsAbsent = make([]bool, len(args[0].Values))
 
-		atLeastOne := make([]bool, len(args[0].Values))
+		count := make([]int, len(args[0].Values))
 		for _, arg := range args {
 			for i, v := range arg.Values {
 				if arg.IsAbsent[i] {
 					continue
 				}
-				atLeastOne[i] = true
+				count[i]++
 				r.Values[i] += v
 			}
 		}
 
-		for i, v := range atLeastOne {
-			if !v {
+		for i, n := range count {
+			if !types.EnoughValues(n, len(args), r.XFilesFactor) {
+				r.Values[i] = 0
 				r.IsAbsent[i] = true
 			}
 		}
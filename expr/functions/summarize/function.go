@@ -65,7 +65,7 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 	start := args[0].StartTime
 	stop := args[0].StopTime
 	if !alignToFrom {
-		start, stop = helper.AlignToBucketSize(start, stop, bucketSize)
+		start, stop = helper.AlignToBucketSizeIn(start, stop, bucketSize, args[0].Location())
 	}
 
 	buckets := helper.GetBuckets(start, stop, bucketSize)
@@ -98,7 +98,7 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 				StepTime:  arg.StepTime,
 				StartTime: arg.StartTime,
 				StopTime:  arg.StopTime,
-			}})
+			}, XFilesFactor: arg.XFilesFactor, TimeZone: arg.TimeZone, Now: arg.Now})
 			continue
 		}
 
@@ -110,6 +110,9 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 			StartTime: start,
 			StopTime:  stop,
 		}}
+		r.XFilesFactor = arg.XFilesFactor
+		r.TimeZone = arg.TimeZone
+		r.Now = arg.Now
 
 		t := arg.StartTime // unadjusted
 		bucketEnd := start + bucketSize
@@ -129,7 +132,10 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 			}
 
 			if t >= bucketEnd {
-				rv := helper.SummarizeValues(summarizeFunction, values)
+				rv := math.NaN()
+				if types.EnoughValues(len(values), bucketItems, arg.XFilesFactor) {
+					rv = helper.SummarizeValues(summarizeFunction, values)
+				}
 
 				if math.IsNaN(rv) {
 					r.IsAbsent[ridx] = true
@@ -145,7 +151,10 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 
 		// last partial bucket
 		if bucketItems > 0 {
-			rv := helper.SummarizeValues(summarizeFunction, values)
+			rv := math.NaN()
+			if types.EnoughValues(len(values), bucketItems, arg.XFilesFactor) {
+				rv = helper.SummarizeValues(summarizeFunction, values)
+			}
 			if math.IsNaN(rv) {
 				r.Values[ridx] = 0
 				r.IsAbsent[ridx] = true
@@ -0,0 +1,114 @@
+package timeSlice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type timeSlice struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &timeSlice{}
+	functions := []string{"timeSlice"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// timeSlice(seriesList, startSliceAt, endSliceAt='now')
+func (f *timeSlice) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	startSliceAt, err := e.GetStringNamedOrPosArgDefault("startSliceAt", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	if startSliceAt == "" {
+		return nil, parser.ErrMissingArgument
+	}
+	endSliceAt, err := e.GetStringNamedOrPosArgDefault("endSliceAt", 2, "now")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	now := args[0].CurrentTime()
+	start := date.DateParamToEpochAt(startSliceAt, "", int64(from), time.Local, now)
+	end := date.DateParamToEpochAt(endSliceAt, "", int64(until), time.Local, now)
+
+	results := make([]*types.MetricData, 0, len(args))
+	for _, a := range args {
+		r := *a
+		r.Name = fmt.Sprintf("timeSlice(%s,%d,%d)", a.Name, start, end)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		t := a.StartTime
+		for i, v := range a.Values {
+			if a.IsAbsent[i] || t < start || t > end {
+				r.IsAbsent[i] = true
+			} else {
+				r.Values[i] = v
+			}
+			t += a.StepTime
+		}
+
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *timeSlice) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"timeSlice": {
+			Description: "Takes one metric or a wildcard metric, followed by a quoted\nstring with the time to start the line and another quoted string\nwith the time to end the line. The start and end times are\ninclusive. See ``from / until`` in the render\\_api_ for examples of\ntime formats.\n\nUseful for filtering out a part of a series of data from a wider\nrange of data.\n\nExample:\n\n.. code-block:: none\n\n  &target=timeSlice(network.core.port1,\"00:00 20140101\",\"11:59 20140630\")\n  &target=timeSlice(network.core.port1,\"12:00 20140630\",\"now\")",
+			Function:    "timeSlice(seriesList, startSliceAt, endSliceAt='now')",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "timeSlice",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "startSliceAt",
+					Required: true,
+					Type:     types.Date,
+				},
+				{
+					Default: types.NewSuggestion("now"),
+					Name:    "endSliceAt",
+					Type:    types.Date,
+				},
+			},
+		},
+	}
+}
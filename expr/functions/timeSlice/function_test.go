@@ -0,0 +1,58 @@
+package timeSlice
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestTimeSlice(t *testing.T) {
+	nan := math.NaN()
+	start := int32(1500000000)
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("timeSlice", "metric1", parser.ArgValue("1500000001"), parser.ArgValue("1500000003")),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, nan, 4, 5}, 1, start)},
+			},
+			[]*types.MetricData{types.MakeMetricData("timeSlice(metric1,1500000001,1500000003)",
+				[]float64{nan, 2, nan, 4, nan}, 1, start)},
+		},
+	}
+
+	pinned := types.MakeMetricData("metric1", []float64{1, 2, nan, 4, 5}, 1, start)
+	pinned.Now = time.Unix(int64(start)+4, 0)
+	tests = append(tests, th.EvalTestItem{
+		// relative to the current time pinned by the request
+		parser.NewExpr("timeSlice", "metric1", parser.ArgValue("-3s")),
+		map[parser.MetricRequest][]*types.MetricData{
+			{"metric1", 0, 1}: {pinned},
+		},
+		[]*types.MetricData{types.MakeMetricData("timeSlice(metric1,1500000001,1500000004)",
+			[]float64{nan, 2, nan, 4, 5}, 1, start)},
+	})
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package weightedAverage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type weightedAverage struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &weightedAverage{}
+	functions := []string{"weightedAverage"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+type pair struct {
+	avg, weight *types.MetricData
+}
+
+// weightedAverage(seriesListAvg, seriesListWeight, *nodes)
+func (f *weightedAverage) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	avgs, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(avgs) != len(weights) {
+		return nil, fmt.Errorf("weightedAverage must receive the same number of series for seriesListAvg and seriesListWeight but received respectively %d and %d", len(avgs), len(weights))
+	}
+
+	nodes, err := e.GetNodeOrTagArgs(2)
+	if err != nil && err != parser.ErrMissingArgument {
+		return nil, err
+	}
+
+	nodeStrs := make([]string, 0, len(nodes))
+	for _, n := range e.Args()[2:] {
+		nodeStrs = append(nodeStrs, n.ToString())
+	}
+	name := fmt.Sprintf("weightedAverage(%s,%s)", e.Args()[0].ToString(), e.Args()[1].ToString())
+	if len(nodeStrs) > 0 {
+		name = fmt.Sprintf("weightedAverage(%s,%s,%s)", e.Args()[0].ToString(), e.Args()[1].ToString(), strings.Join(nodeStrs, ","))
+	}
+
+	if len(avgs) == 0 {
+		return nil, nil
+	}
+
+	all := make([]*types.MetricData, 0, len(avgs)+len(weights))
+	all = append(all, avgs...)
+	all = append(all, weights...)
+	helper.AlignSeries(all)
+
+	// As in graphite-web, series are paired by the nodes they share, and a
+	// later series with the same key replaces an earlier one.
+	var keys []string
+	pairs := make(map[string]*pair)
+	get := func(key string) *pair {
+		p, ok := pairs[key]
+		if !ok {
+			p = &pair{}
+			pairs[key] = p
+			keys = append(keys, key)
+		}
+		return p
+	}
+	for i := range avgs {
+		get(helper.AggKey(avgs[i].Name, nodes)).avg = avgs[i]
+		get(helper.AggKey(weights[i].Name, nodes)).weight = weights[i]
+	}
+
+	var products []*types.MetricData
+	for _, key := range keys {
+		p := pairs[key]
+		if p.avg == nil || p.weight == nil {
+			continue
+		}
+		products = append(products, product(p.avg, p.weight))
+	}
+
+	if len(products) == 0 {
+		return nil, nil
+	}
+
+	sumProducts, productCount := sumSeries(products)
+	sumWeights, weightCount := sumSeries(weights)
+
+	r := *avgs[0]
+	r.Name = name
+	r.Values = make([]float64, len(sumProducts))
+	r.IsAbsent = make([]bool, len(sumProducts))
+	for i := range r.Values {
+		if !types.EnoughValues(productCount[i], len(products), r.XFilesFactor) ||
+			!types.EnoughValues(weightCount[i], len(weights), r.XFilesFactor) ||
+			sumWeights[i] == 0 {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = sumProducts[i] / sumWeights[i]
+	}
+
+	return []*types.MetricData{&r}, nil
+}
+
+// product multiplies the points of a and b, a point is absent if it's absent
+// in either series.
+func product(a, b *types.MetricData) *types.MetricData {
+	r := *a
+	r.Values = make([]float64, len(a.Values))
+	r.IsAbsent = make([]bool, len(a.Values))
+	for i := range a.Values {
+		if a.IsAbsent[i] || i >= len(b.Values) || b.IsAbsent[i] {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = a.Values[i] * b.Values[i]
+	}
+	return &r
+}
+
+// sumSeries returns the sum of the present points of args, and how many
+// series have each point.
+func sumSeries(args []*types.MetricData) ([]float64, []int) {
+	sums := make([]float64, len(args[0].Values))
+	counts := make([]int, len(args[0].Values))
+	for _, a := range args {
+		for i := range sums {
+			if i < len(a.Values) && !a.IsAbsent[i] {
+				sums[i] += a.Values[i]
+				counts[i]++
+			}
+		}
+	}
+	return sums, counts
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *weightedAverage) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"weightedAverage": {
+			Description: "Takes a series of average values and a series of weights and\nproduces a weighted average for all values.\nThe corresponding values should share one or more zero-indexed nodes and/or tags.\n\nExample:\n\n.. code-block:: none\n\n  &target=weightedAverage(*.transactions.mean,*.transactions.count,0)\n  &target=weightedAverage(*.transactions.mean,*.transactions.count,1,3,4)",
+			Function:    "weightedAverage(seriesListAvg, seriesListWeight, *nodes)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "weightedAverage",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesListAvg",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "seriesListWeight",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Multiple: true,
+					Name:     "nodes",
+					Type:     types.NodeOrTag,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package weightedAverage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestWeightedAverage(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("weightedAverage", "*.mean", "*.count", 0),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"*.mean", 0, 1}: {
+					types.MakeMetricData("host1.mean", []float64{1, 2, nan, 4}, 1, now32),
+					types.MakeMetricData("host2.mean", []float64{3, 4, 5, nan}, 1, now32),
+				},
+				// Deliberately in a different order than the averages
+				{"*.count", 0, 1}: {
+					types.MakeMetricData("host2.count", []float64{1, 0, 2, 2}, 1, now32),
+					types.MakeMetricData("host1.count", []float64{3, 0, 2, 2}, 1, now32),
+				},
+			},
+			[]*types.MetricData{types.MakeMetricData("weightedAverage(*.mean,*.count,0)",
+				[]float64{1.5, nan, 2.5, 2}, 1, now32)},
+		},
+		{
+			parser.NewExpr("weightedAverage", "dc*.*.mean", "dc*.*.count", 0, 1),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"dc*.*.mean", 0, 1}: {
+					types.MakeMetricData("dc1.host1.mean", []float64{2, 4}, 1, now32),
+					types.MakeMetricData("dc2.host1.mean", []float64{6, 8}, 1, now32),
+				},
+				{"dc*.*.count", 0, 1}: {
+					types.MakeMetricData("dc2.host1.count", []float64{1, 1}, 1, now32),
+					types.MakeMetricData("dc1.host1.count", []float64{1, 3}, 1, now32),
+				},
+			},
+			[]*types.MetricData{types.MakeMetricData("weightedAverage(dc*.*.mean,dc*.*.count,0,1)",
+				[]float64{4, 5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("weightedAverage", "*.mean", "*.count", 0),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"*.mean", 0, 1}: {
+					types.MakeMetricData("host1.mean", []float64{1, 2}, 1, now32),
+				},
+				{"*.count", 0, 1}: {
+					types.MakeMetricData("host2.count", []float64{1, 1}, 1, now32),
+				},
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestWeightedAverageMismatchedLists(t *testing.T) {
+	e := parser.NewExpr("weightedAverage", "*.mean", "*.count", 0)
+	_, err := metadata.GetEvaluator().EvalExpr(e, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"*.mean", 0, 1}: {
+			types.MakeMetricData("host1.mean", []float64{1}, 1, 0),
+			types.MakeMetricData("host2.mean", []float64{1}, 1, 0),
+		},
+		{"*.count", 0, 1}: {
+			types.MakeMetricData("host1.count", []float64{1}, 1, 0),
+		},
+	})
+	if err == nil {
+		t.Error("expected an error for lists of different lengths")
+	}
+}
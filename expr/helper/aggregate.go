@@ -0,0 +1,128 @@
+package helper
+
+import (
+	"math"
+	"sort"
+)
+
+// Aggregator computes an aggregated point from the values present at that
+// point, out of total series.
+type Aggregator func(values []float64, total int) float64
+
+// Aggregators are the aggregation functions known by name, as in
+// graphite-web's aggFuncs.
+var Aggregators = map[string]Aggregator{
+	"average":  average,
+	"avg":      average,
+	"avg_zero": averageZero,
+	"median":   median,
+	"sum":      sum,
+	"total":    sum,
+	"min":      min,
+	"max":      max,
+	"diff":     diff,
+	"stddev":   stddev,
+	"count":    count,
+	"range":    rangeOf,
+	"rangeOf":  rangeOf,
+	"multiply": multiply,
+	"last":     last,
+	"current":  last,
+}
+
+func sum(values []float64, total int) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+func average(values []float64, total int) float64 {
+	return sum(values, total) / float64(len(values))
+}
+
+// averageZero is the average counting absent points as 0.
+func averageZero(values []float64, total int) float64 {
+	return sum(values, total) / float64(total)
+}
+
+func median(values []float64, total int) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func min(values []float64, total int) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func max(values []float64, total int) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// diff subtracts the other values from the first one.
+func diff(values []float64, total int) float64 {
+	d := values[0]
+	for _, v := range values[1:] {
+		d -= v
+	}
+	return d
+}
+
+func stddev(values []float64, total int) float64 {
+	avg := average(values, total)
+	var s float64
+	for _, v := range values {
+		s += (v - avg) * (v - avg)
+	}
+	return math.Sqrt(s / float64(len(values)))
+}
+
+func count(values []float64, total int) float64 {
+	return float64(len(values))
+}
+
+func rangeOf(values []float64, total int) float64 {
+	return max(values, total) - min(values, total)
+}
+
+func multiply(values []float64, total int) float64 {
+	p := 1.0
+	for _, v := range values {
+		p *= v
+	}
+	return p
+}
+
+func last(values []float64, total int) float64 {
+	return values[len(values)-1]
+}
+
+// AggregatorNames returns the sorted names of the Aggregators.
+func AggregatorNames() []string {
+	names := make([]string, 0, len(Aggregators))
+	for name := range Aggregators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
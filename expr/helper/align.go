@@ -34,3 +34,12 @@ func AlignToBucketSize(start, stop, bucketSize int32) (int32, int32) {
 
 	return start, newStop
 }
+
+// AlignToBucketSizeIn aligns start and stop like AlignToBucketSize, but with
+// the buckets starting at multiples of bucketSize in the local time of loc,
+// e.g. at midnight there for daily buckets.
+func AlignToBucketSizeIn(start, stop, bucketSize int32, loc *time.Location) (int32, int32) {
+	_, offset := time.Unix(int64(start), 0).In(loc).Zone()
+	start, stop = AlignToBucketSize(start+int32(offset), stop+int32(offset), bucketSize)
+	return start - int32(offset), stop - int32(offset)
+}
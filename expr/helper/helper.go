@@ -219,7 +219,7 @@ func AggregateSeries(e parser.Expr, args []*types.MetricData, function Aggregate
 		}
 
 		r.Values[i] = math.NaN()
-		if len(values) > 0 {
+		if types.EnoughValues(len(values), len(args), r.XFilesFactor) {
 			r.Values[i] = function(values)
 		}
 
@@ -243,7 +243,7 @@ func SummarizeValues(f string, values []float64) float64 {
 			rv += av
 		}
 
-	case "avg":
+	case "avg", "average":
 		for _, av := range values {
 			rv += av
 		}
@@ -268,16 +268,68 @@ func SummarizeValues(f string, values []float64) float64 {
 		}
 
 	default:
-		f = strings.Split(f, "p")[1]
-		percent, err := strconv.ParseFloat(f, 64)
-		if err == nil {
-			rv = Percentile(values, percent, true)
+		if strings.HasPrefix(f, "p") {
+			percent, err := strconv.ParseFloat(f[1:], 64)
+			if err == nil {
+				rv = Percentile(values, percent, true)
+			}
 		}
 	}
 
 	return rv
 }
 
+// ExtractTags returns the tags of the metric in a series name, which follow
+// the metric separated by semicolons, as in "cpu.load;host=web1;dc=ams".
+// The metric itself is the "name" tag.
+func ExtractTags(s string) map[string]string {
+	i := strings.IndexByte(s, ';')
+	if i < 0 {
+		return map[string]string{"name": ExtractMetric(s)}
+	}
+
+	tags := map[string]string{"name": ExtractMetric(s[:i])}
+
+	rest := s[i+1:]
+	if j := strings.IndexAny(rest, ",)"); j >= 0 {
+		rest = rest[:j]
+	}
+	for _, tag := range strings.Split(rest, ";") {
+		if j := strings.IndexByte(tag, '='); j > 0 {
+			tags[tag[:j]] = tag[j+1:]
+		}
+	}
+
+	return tags
+}
+
+// AggKey returns the nodes and tags of the series name selected by
+// nodesOrTags, joined by dots. Nodes out of range are skipped and missing
+// tags are empty.
+func AggKey(name string, nodesOrTags []parser.NodeOrTag) string {
+	tags := ExtractTags(name)
+	nodes := strings.Split(tags["name"], ".")
+
+	key := make([]string, 0, len(nodesOrTags))
+	for _, nt := range nodesOrTags {
+		if nt.IsTag {
+			key = append(key, tags[nt.Value.(string)])
+			continue
+		}
+
+		f := nt.Value.(int)
+		if f < 0 {
+			f += len(nodes)
+		}
+		if f >= len(nodes) || f < 0 {
+			continue
+		}
+		key = append(key, nodes[f])
+	}
+
+	return strings.Join(key, ".")
+}
+
 // ExtractMetric extracts metric out of function list
 func ExtractMetric(s string) string {
 
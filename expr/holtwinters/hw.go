@@ -5,6 +5,16 @@ package holtwinters
 
 import (
 	"math"
+
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+const (
+	// DefaultBootstrapInterval is the default amount of data, in seconds,
+	// used to bootstrap the forecast before the requested range.
+	DefaultBootstrapInterval = 7 * 86400
+	// DefaultSeasonality is the default length of a season, in seconds.
+	DefaultSeasonality = 86400
 )
 
 func holtWintersIntercept(alpha, actual, lastSeason, lastIntercept, lastSlope float64) float64 {
@@ -26,16 +36,19 @@ func holtWintersDeviation(gamma, actual, prediction, lastSeasonalDev float64) fl
 	return gamma*math.Abs(actual-prediction) + (1-gamma)*lastSeasonalDev
 }
 
-// HoltWintersAnalysis do Holt-Winters Analysis
-func HoltWintersAnalysis(series []float64, step int32) ([]float64, []float64) {
+// HoltWintersAnalysis do Holt-Winters Analysis, with seasons of seasonality
+// seconds
+func HoltWintersAnalysis(series []float64, step int32, seasonality int32) ([]float64, []float64) {
 	const (
 		alpha = 0.1
 		beta  = 0.0035
 		gamma = 0.1
 	)
 
-	// season is currently one day
-	seasonLength := 24 * 60 * 60 / int(step)
+	seasonLength := int(seasonality / step)
+	if seasonLength < 1 {
+		seasonLength = 1
+	}
 
 	var (
 		intercepts  []float64
@@ -115,13 +128,14 @@ func HoltWintersAnalysis(series []float64, step int32) ([]float64, []float64) {
 	return predictions, deviations
 }
 
-// HoltWintersConfidenceBands do Holt-Winters Confidence Bands
-func HoltWintersConfidenceBands(series []float64, step int32, delta float64) ([]float64, []float64) {
+// HoltWintersConfidenceBands do Holt-Winters Confidence Bands, skipping the
+// first bootstrapInterval seconds of series
+func HoltWintersConfidenceBands(series []float64, step int32, delta float64, bootstrapInterval int32, seasonality int32) ([]float64, []float64) {
 	var lowerBand, upperBand []float64
 
-	predictions, deviations := HoltWintersAnalysis(series, step)
+	predictions, deviations := HoltWintersAnalysis(series, step, seasonality)
 
-	windowPoints := 7 * 86400 / step
+	windowPoints := WindowPoints(len(series), step, bootstrapInterval)
 
 	predictionsOfInterest := predictions[windowPoints:]
 	deviationsOfInterest := deviations[windowPoints:]
@@ -139,3 +153,35 @@ func HoltWintersConfidenceBands(series []float64, step int32, delta float64) ([]
 
 	return lowerBand, upperBand
 }
+
+// WindowPoints returns the number of points of a series of length points
+// taken by bootstrapInterval seconds.
+func WindowPoints(length int, step int32, bootstrapInterval int32) int {
+	windowPoints := int(bootstrapInterval / step)
+	if windowPoints > length {
+		return length
+	}
+	return windowPoints
+}
+
+// GetIntervalArgs returns the bootstrapInterval and seasonality arguments of
+// the Holt-Winters functions, the former being the n-th argument.
+func GetIntervalArgs(e parser.Expr, n int) (int32, int32, error) {
+	bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", n, 1, DefaultBootstrapInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	if bootstrapInterval < 0 {
+		bootstrapInterval = -bootstrapInterval
+	}
+
+	seasonality, err := e.GetIntervalNamedOrPosArgDefault("seasonality", n+1, 1, DefaultSeasonality)
+	if err != nil {
+		return 0, 0, err
+	}
+	if seasonality <= 0 {
+		return 0, 0, parser.ErrBadType
+	}
+
+	return bootstrapInterval, seasonality, nil
+}
@@ -50,11 +50,26 @@ func (f *applyByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 		}
 	}
 
+	if field < 0 {
+		return false, nil, parser.ErrBadType
+	}
+
+	// As in graphite-web, the template is applied once per unique prefix,
+	// made of the nodes up to and including nodeNum.
 	var rv []string
+	seen := make(map[string]struct{})
 	for _, a := range args {
 		metric := helper.ExtractMetric(a.Name)
 		nodes := strings.Split(metric, ".")
-		node := strings.Join(nodes[0:field], ".")
+		if field+1 < len(nodes) {
+			nodes = nodes[:field+1]
+		}
+		node := strings.Join(nodes, ".")
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+
 		newTarget := strings.Replace(callback, "%", node, -1)
 
 		if newName != "" {
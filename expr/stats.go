@@ -0,0 +1,21 @@
+package expr
+
+import (
+	"expvar"
+	"time"
+)
+
+// FunctionCalls and FunctionTimeNS count the evaluations of each function, by
+// name, and the time they took. The time of a function includes the one of
+// the functions in its arguments, so the time spent in a function itself is
+// its time minus the one of its arguments.
+var (
+	FunctionCalls  = expvar.NewMap("function_calls")
+	FunctionTimeNS = expvar.NewMap("function_time_ns")
+)
+
+// recordCall accounts for an evaluation of function that started at t0.
+func recordCall(function string, t0 time.Time) {
+	FunctionCalls.Add(function, 1)
+	FunctionTimeNS.Add(function, time.Since(t0).Nanoseconds())
+}
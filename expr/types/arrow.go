@@ -0,0 +1,310 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The Arrow IPC stream format is a schema message followed by record batch
+// messages, each a flatbuffer of metadata and a body of buffers, and an
+// end-of-stream marker. The arrow library isn't vendored, so the few
+// flatbuffers needed are built by hand, after the Schema.fbs and Message.fbs
+// of the format.
+const (
+	arrowContinuation  = 0xFFFFFFFF
+	arrowMetadataV5    = 4
+	arrowHeaderSchema  = 1
+	arrowHeaderBatch   = 3
+	arrowTypeFloat     = 3
+	arrowTypeUtf8      = 5
+	arrowTypeTimestamp = 10
+	arrowPrecisionDbl  = 2
+	arrowUnitSecond    = 0
+	arrowAlignment     = 8
+)
+
+// MarshalArrow marshals metric data to an Arrow IPC stream in long form, with
+// a record batch per series of its target name, the timestamp of each point
+// in seconds and its value, null if absent.
+func MarshalArrow(results []*MetricData) []byte {
+	var b []byte
+	b = appendArrowMessage(b, arrowSchema(), nil)
+
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		metadata, body := arrowBatch(r)
+		b = appendArrowMessage(b, metadata, body)
+	}
+
+	b = appendUint32(b, arrowContinuation)
+	b = appendUint32(b, 0)
+
+	return b
+}
+
+// appendArrowMessage appends an encapsulated message of the IPC stream: its
+// metadata flatbuffer, padded to 8 bytes, and its body.
+func appendArrowMessage(b []byte, metadata []byte, body []byte) []byte {
+	padded := arrowPad(len(metadata))
+	b = appendUint32(b, arrowContinuation)
+	b = appendUint32(b, uint32(padded))
+	b = append(b, metadata...)
+	b = append(b, make([]byte, padded-len(metadata))...)
+
+	return append(b, body...)
+}
+
+func arrowPad(n int) int {
+	return (n + arrowAlignment - 1) / arrowAlignment * arrowAlignment
+}
+
+// arrowSchema returns the schema message: a non-null utf8 "target", a
+// non-null "timestamp" in seconds in UTC, and a nullable float64 "value".
+func arrowSchema() []byte {
+	fb := &flatBuilder{}
+
+	field := func(name string, nullable bool, typeType byte, typ int) int {
+		n := fb.createString(name)
+		children := fb.createOffsets(nil)
+		fb.startTable()
+		fb.addOffset(0, n)
+		fb.addBool(1, nullable)
+		fb.addByte(2, typeType)
+		fb.addOffset(3, typ)
+		fb.addOffset(5, children)
+		return fb.endTable()
+	}
+
+	fb.startTable()
+	utf8 := fb.endTable()
+	tz := fb.createString("UTC")
+	fb.startTable()
+	fb.addOffset(1, tz)
+	fb.addInt16(0, arrowUnitSecond)
+	timestamp := fb.endTable()
+	fb.startTable()
+	fb.addInt16(0, arrowPrecisionDbl)
+	float := fb.endTable()
+
+	fields := fb.createOffsets([]int{
+		field("target", false, arrowTypeUtf8, utf8),
+		field("timestamp", false, arrowTypeTimestamp, timestamp),
+		field("value", true, arrowTypeFloat, float),
+	})
+	fb.startTable()
+	fb.addOffset(1, fields)
+	schema := fb.endTable()
+
+	return arrowMessage(fb, arrowHeaderSchema, schema, 0)
+}
+
+// arrowBatch returns the record batch message of the points of r, and its
+// body.
+func arrowBatch(r *MetricData) ([]byte, []byte) {
+	n := len(r.Values)
+
+	offsets := make([]byte, 0, 4*(n+1))
+	names := make([]byte, 0, n*len(r.Name))
+	timestamps := make([]byte, 0, 8*n)
+	values := make([]byte, 0, 8*n)
+	validity := make([]byte, (n+7)/8)
+	nulls := 0
+
+	t := int64(r.StartTime)
+	for i, v := range r.Values {
+		offsets = appendUint32(offsets, uint32(len(names)))
+		names = append(names, r.Name...)
+		timestamps = appendUint64(timestamps, uint64(t))
+		if r.IsAbsent[i] {
+			nulls++
+			v = 0
+		} else {
+			validity[i/8] |= 1 << uint(i%8)
+		}
+		values = appendUint64(values, math.Float64bits(v))
+		t += int64(r.StepTime)
+	}
+	offsets = appendUint32(offsets, uint32(len(names)))
+	if nulls == 0 {
+		validity = nil
+	}
+
+	var body []byte
+	var buffers [][2]int64
+	for _, buf := range [][]byte{nil, offsets, names, nil, timestamps, validity, values} {
+		buffers = append(buffers, [2]int64{int64(len(body)), int64(len(buf))})
+		body = append(body, buf...)
+		body = append(body, make([]byte, arrowPad(len(buf))-len(buf))...)
+	}
+
+	fb := &flatBuilder{}
+	nodes := fb.createStructs([][2]int64{{int64(n), 0}, {int64(n), 0}, {int64(n), int64(nulls)}})
+	bufs := fb.createStructs(buffers)
+	fb.startTable()
+	fb.addInt64(0, int64(n))
+	fb.addOffset(1, nodes)
+	fb.addOffset(2, bufs)
+	batch := fb.endTable()
+
+	return arrowMessage(fb, arrowHeaderBatch, batch, int64(len(body))), body
+}
+
+// arrowMessage finishes fb with a Message of the header, of type headerType.
+func arrowMessage(fb *flatBuilder, headerType byte, header int, bodyLength int64) []byte {
+	fb.startTable()
+	fb.addInt64(3, bodyLength)
+	fb.addOffset(2, header)
+	fb.addInt16(0, arrowMetadataV5)
+	fb.addByte(1, headerType)
+	return fb.finish(fb.endTable())
+}
+
+// flatBuilder builds a flatbuffer from its end, as the flatbuffers library
+// does: objects are referred to by their offset from the end of the buffer,
+// which is known as soon as they are written.
+type flatBuilder struct {
+	buf      []byte // The end of the buffer, in order.
+	minAlign int
+	fields   map[int]int // The offsets of the fields of the current table.
+	start    int         // The offset of the current table.
+}
+
+func (fb *flatBuilder) offset() int {
+	return len(fb.buf)
+}
+
+// prep pads the buffer so that size bytes can be written aligned after
+// additional ones.
+func (fb *flatBuilder) prep(size, additional int) {
+	if size > fb.minAlign {
+		fb.minAlign = size
+	}
+	pad := (size - (len(fb.buf)+additional)%size) % size
+	fb.prepend(make([]byte, pad))
+}
+
+func (fb *flatBuilder) prepend(b []byte) {
+	fb.buf = append(append(make([]byte, 0, len(b)+len(fb.buf)), b...), fb.buf...)
+}
+
+func (fb *flatBuilder) prependUint32(v uint32) {
+	fb.prep(4, 0)
+	fb.prepend(appendUint32(nil, v))
+}
+
+// prependOffset writes the offset to the object at off, from where it's
+// written.
+func (fb *flatBuilder) prependOffset(off int) {
+	fb.prep(4, 0)
+	fb.prependUint32(uint32(fb.offset() + 4 - off))
+}
+
+func (fb *flatBuilder) createString(s string) int {
+	fb.prep(4, len(s)+1)
+	fb.prepend(append([]byte(s), 0))
+	fb.prependUint32(uint32(len(s)))
+	return fb.offset()
+}
+
+// createOffsets writes a vector of offsets to objects.
+func (fb *flatBuilder) createOffsets(offs []int) int {
+	fb.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		fb.prependOffset(offs[i])
+	}
+	fb.prependUint32(uint32(len(offs)))
+	return fb.offset()
+}
+
+// createStructs writes a vector of structs of two longs.
+func (fb *flatBuilder) createStructs(structs [][2]int64) int {
+	fb.prep(8, 16*len(structs))
+	for i := len(structs) - 1; i >= 0; i-- {
+		fb.prepend(appendUint64(appendUint64(nil, uint64(structs[i][0])), uint64(structs[i][1])))
+	}
+	fb.prependUint32(uint32(len(structs)))
+	return fb.offset()
+}
+
+func (fb *flatBuilder) startTable() {
+	fb.fields = make(map[int]int)
+	fb.start = fb.offset()
+}
+
+func (fb *flatBuilder) addScalar(slot int, b []byte) {
+	fb.prep(len(b), 0)
+	fb.prepend(b)
+	fb.fields[slot] = fb.offset()
+}
+
+func (fb *flatBuilder) addByte(slot int, v byte) {
+	fb.addScalar(slot, []byte{v})
+}
+
+func (fb *flatBuilder) addBool(slot int, v bool) {
+	if v {
+		fb.addByte(slot, 1)
+	}
+}
+
+func (fb *flatBuilder) addInt16(slot int, v int16) {
+	fb.addScalar(slot, []byte{byte(v), byte(v >> 8)})
+}
+
+func (fb *flatBuilder) addInt64(slot int, v int64) {
+	fb.addScalar(slot, appendUint64(nil, uint64(v)))
+}
+
+func (fb *flatBuilder) addOffset(slot int, off int) {
+	fb.prependOffset(off)
+	fb.fields[slot] = fb.offset()
+}
+
+// endTable writes the current table and its vtable, and returns its offset.
+func (fb *flatBuilder) endTable() int {
+	fb.prependUint32(0) // The offset to the vtable, patched below.
+	table := fb.offset()
+
+	slots := 0
+	for slot := range fb.fields {
+		if slot+1 > slots {
+			slots = slot + 1
+		}
+	}
+	vtable := make([]byte, 0, 4+2*slots)
+	vtable = appendUint16(vtable, uint16(4+2*slots))
+	vtable = appendUint16(vtable, uint16(table-fb.start))
+	for slot := 0; slot < slots; slot++ {
+		var field uint16
+		if off, ok := fb.fields[slot]; ok {
+			field = uint16(table - off)
+		}
+		vtable = appendUint16(vtable, field)
+	}
+	fb.prep(2, len(vtable))
+	fb.prepend(vtable)
+
+	binary.LittleEndian.PutUint32(fb.buf[len(fb.buf)-table:], uint32(fb.offset()-table))
+	return table
+}
+
+// finish writes the offset to the root table, and returns the buffer.
+func (fb *flatBuilder) finish(root int) []byte {
+	fb.prep(fb.minAlign, 4)
+	fb.prependOffset(root)
+	return fb.buf
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
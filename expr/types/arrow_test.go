@@ -0,0 +1,199 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// flatTable reads a table of a flatbuffer.
+type flatTable struct {
+	buf []byte
+	pos int
+}
+
+func flatRoot(buf []byte) flatTable {
+	return flatTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns the position of the field in slot, or 0 if it's absent.
+func (t flatTable) field(slot int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*slot >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*slot:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+func (t flatTable) deref(pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t flatTable) table(slot int) flatTable {
+	return flatTable{t.buf, t.deref(t.field(slot))}
+}
+
+func (t flatTable) byteField(slot int) byte {
+	if p := t.field(slot); p != 0 {
+		return t.buf[p]
+	}
+	return 0
+}
+
+func (t flatTable) int16Field(slot int) int16 {
+	if p := t.field(slot); p != 0 {
+		return int16(binary.LittleEndian.Uint16(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t flatTable) int64Field(slot int) int64 {
+	if p := t.field(slot); p != 0 {
+		return int64(binary.LittleEndian.Uint64(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t flatTable) stringField(slot int) string {
+	p := t.deref(t.field(slot))
+	n := int(binary.LittleEndian.Uint32(t.buf[p:]))
+	return string(t.buf[p+4 : p+4+n])
+}
+
+// vector returns the position of the first element of the vector in slot,
+// and its length.
+func (t flatTable) vector(slot int) (int, int) {
+	p := t.deref(t.field(slot))
+	return p + 4, int(binary.LittleEndian.Uint32(t.buf[p:]))
+}
+
+// readArrowMessage returns the root table of the message at the start of b,
+// its body and the rest of b.
+func readArrowMessage(t *testing.T, b []byte) (flatTable, []byte, []byte) {
+	if binary.LittleEndian.Uint32(b) != arrowContinuation {
+		t.Fatalf("Expected a continuation marker, got %x", b[:4])
+	}
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if n%8 != 0 {
+		t.Fatalf("Expected padded metadata, got %d bytes", n)
+	}
+	metadata := b[8 : 8+n]
+	if binary.LittleEndian.Uint32(metadata)%4 != 0 {
+		t.Fatalf("Expected an aligned root table")
+	}
+	message := flatRoot(metadata)
+	bodyLength := int(message.int64Field(3))
+
+	return message, b[8+n : 8+n+bodyLength], b[8+n+bodyLength:]
+}
+
+func TestMarshalArrow(t *testing.T) {
+	b := MarshalArrow([]*MetricData{
+		MakeMetricData("metric1", []float64{1, math.NaN(), 2.5}, 60, 1500000000),
+	})
+
+	message, body, b := readArrowMessage(t, b)
+	if v := message.int16Field(0); v != arrowMetadataV5 {
+		t.Errorf("Expected metadata version %d, got %d", arrowMetadataV5, v)
+	}
+	if h := message.byteField(1); h != arrowHeaderSchema {
+		t.Fatalf("Expected a schema, got header %d", h)
+	}
+	if len(body) != 0 {
+		t.Errorf("Expected a schema without a body, got %d bytes", len(body))
+	}
+
+	schema := message.table(2)
+	pos, n := schema.vector(1)
+	expected := []struct {
+		name     string
+		nullable byte
+		typ      byte
+	}{
+		{"target", 0, arrowTypeUtf8},
+		{"timestamp", 0, arrowTypeTimestamp},
+		{"value", 1, arrowTypeFloat},
+	}
+	if n != len(expected) {
+		t.Fatalf("Expected %d fields, got %d", len(expected), n)
+	}
+	for i, e := range expected {
+		field := flatTable{schema.buf, schema.deref(pos + 4*i)}
+		if name := field.stringField(0); name != e.name {
+			t.Errorf("Field %d: expected name %s, got %s", i, e.name, name)
+		}
+		if nullable := field.byteField(1); nullable != e.nullable {
+			t.Errorf("Field %s: expected nullable %d, got %d", e.name, e.nullable, nullable)
+		}
+		if typ := field.byteField(2); typ != e.typ {
+			t.Errorf("Field %s: expected type %d, got %d", e.name, e.typ, typ)
+		}
+		if _, children := field.vector(5); children != 0 {
+			t.Errorf("Field %s: expected no children, got %d", e.name, children)
+		}
+	}
+	timestamp := flatTable{schema.buf, schema.deref(pos + 4)}.table(3)
+	if unit, tz := timestamp.int16Field(0), timestamp.stringField(1); unit != arrowUnitSecond || tz != "UTC" {
+		t.Errorf("Expected timestamps in seconds in UTC, got unit %d in %s", unit, tz)
+	}
+
+	message, body, b = readArrowMessage(t, b)
+	if h := message.byteField(1); h != arrowHeaderBatch {
+		t.Fatalf("Expected a record batch, got header %d", h)
+	}
+	batch := message.table(2)
+	if length := batch.int64Field(0); length != 3 {
+		t.Errorf("Expected 3 rows, got %d", length)
+	}
+
+	pos, n = batch.vector(1)
+	if n != 3 {
+		t.Fatalf("Expected 3 field nodes, got %d", n)
+	}
+	if pos%8 != 0 {
+		t.Errorf("Expected aligned field nodes")
+	}
+	if nulls := binary.LittleEndian.Uint64(batch.buf[pos+2*16+8:]); nulls != 1 {
+		t.Errorf("Expected 1 null value, got %d", nulls)
+	}
+
+	pos, n = batch.vector(2)
+	if n != 7 {
+		t.Fatalf("Expected 7 buffers, got %d", n)
+	}
+	buffer := func(i int) []byte {
+		offset := binary.LittleEndian.Uint64(batch.buf[pos+16*i:])
+		length := binary.LittleEndian.Uint64(batch.buf[pos+16*i+8:])
+		if offset%8 != 0 {
+			t.Errorf("Buffer %d: expected an aligned offset, got %d", i, offset)
+		}
+		return body[offset : offset+length]
+	}
+
+	if names := string(buffer(2)); names != "metric1metric1metric1" {
+		t.Errorf("Expected the names of the rows, got %s", names)
+	}
+	if end := binary.LittleEndian.Uint32(buffer(1)[12:]); end != 21 {
+		t.Errorf("Expected the last name to end at 21, got %d", end)
+	}
+	timestamps := buffer(4)
+	for i, want := range []uint64{1500000000, 1500000060, 1500000120} {
+		if got := binary.LittleEndian.Uint64(timestamps[8*i:]); got != want {
+			t.Errorf("Row %d: expected timestamp %d, got %d", i, want, got)
+		}
+	}
+	if validity := buffer(5); len(validity) != 1 || validity[0] != 0x5 {
+		t.Errorf("Expected a validity bitmap of 0x5, got %x", validity)
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(buffer(6)[16:])); v != 2.5 {
+		t.Errorf("Expected a last value of 2.5, got %v", v)
+	}
+
+	if len(b) != 8 || binary.LittleEndian.Uint32(b[4:]) != 0 {
+		t.Errorf("Expected an end-of-stream marker, got %x", b)
+	}
+}
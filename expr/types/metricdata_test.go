@@ -23,13 +23,99 @@ func TestJSONResponse(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		b := MarshalJSON(tt.results)
+		b := MarshalJSON(tt.results, NullKeep)
 		if !bytes.Equal(b, tt.out) {
 			t.Errorf("marshalJSON(%+v)=%+v, want %+v", tt.results, string(b), string(tt.out))
 		}
 	}
 }
 
+func TestJSONNullPolicy(t *testing.T) {
+	results := []*MetricData{
+		MakeMetricData("metric1", []float64{math.NaN(), 1, math.NaN(), 2}, 100, 100),
+		MakeMetricData("metric2", []float64{math.NaN(), math.NaN()}, 100, 100),
+	}
+
+	tests := []struct {
+		nulls NullPolicy
+		out   string
+	}{
+		{
+			NullKeep,
+			`[{"target":"metric1","datapoints":[[null,100],[1,200],[null,300],[2,400]]},{"target":"metric2","datapoints":[[null,100],[null,200]]}]`,
+		},
+		{
+			NullDrop,
+			`[{"target":"metric1","datapoints":[[1,200],[2,400]]}]`,
+		},
+		{
+			NullZero,
+			`[{"target":"metric1","datapoints":[[0,100],[1,200],[0,300],[2,400]]},{"target":"metric2","datapoints":[[0,100],[0,200]]}]`,
+		},
+		{
+			NullCarryForward,
+			`[{"target":"metric1","datapoints":[[1,200],[1,300],[2,400]]},{"target":"metric2","datapoints":[]}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		b := MarshalJSON(results, tt.nulls)
+		if string(b) != tt.out {
+			t.Errorf("marshalJSON(%d)=%s, want %s", tt.nulls, b, tt.out)
+		}
+	}
+
+	if _, err := ParseNullPolicy("skip"); err == nil {
+		t.Error("expected an error for an unknown null policy")
+	}
+}
+
+func TestNDJSONResponse(t *testing.T) {
+	results := []*MetricData{
+		MakeMetricData("metric1", []float64{1, math.NaN()}, 100, 100),
+		MakeMetricData("metric2", []float64{math.NaN(), math.NaN()}, 100, 100),
+	}
+
+	want := `{"target":"metric1","datapoints":[[1,100],[null,200]]}
+{"target":"metric2","datapoints":[[null,100],[null,200]]}
+`
+	if b := MarshalNDJSON(results, NullKeep); string(b) != want {
+		t.Errorf("MarshalNDJSON()=%s, want %s", b, want)
+	}
+
+	want = `{"target":"metric1","datapoints":[[1,100]]}
+`
+	if b := MarshalNDJSON(results, NullDrop); string(b) != want {
+		t.Errorf("MarshalNDJSON(NullDrop)=%s, want %s", b, want)
+	}
+}
+
+func TestCompactJSONResponse(t *testing.T) {
+	tests := []struct {
+		results []*MetricData
+		out     string
+	}{
+		{
+			[]*MetricData{MakeMetricData("counter", []float64{math.NaN(), 10, 12, 14, 16, math.NaN(), math.NaN(), 15}, 60, 120)},
+			`[{"target":"counter","start":120,"step":60,"gaps":[[0,1],[5,2]],"delta":true,"values":[10,[3,2],-1]}]`,
+		},
+		{
+			[]*MetricData{MakeMetricData("gauge", []float64{0.1, 0.2, 0.3, 0.3, 0.3}, 60, 120)},
+			`[{"target":"gauge","start":120,"step":60,"gaps":[],"delta":false,"values":[0.1,0.2,[3,0.3]]}]`,
+		},
+		{
+			[]*MetricData{MakeMetricData("empty", []float64{math.NaN(), math.Inf(1)}, 60, 120)},
+			`[{"target":"empty","start":120,"step":60,"gaps":[[0,2]],"delta":false,"values":[]}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		if b := MarshalCompactJSON(tt.results); string(b) != tt.out {
+			t.Errorf("MarshalCompactJSON(%s)=%s, want %s", tt.results[0].Name, b, tt.out)
+		}
+	}
+}
+
 func TestRawResponse(t *testing.T) {
 
 	tests := []struct {
@@ -53,6 +139,63 @@ func TestRawResponse(t *testing.T) {
 	}
 }
 
+func TestDygraphResponse(t *testing.T) {
+
+	tests := []struct {
+		results []*MetricData
+		out     []byte
+	}{
+		{
+			[]*MetricData{
+				MakeMetricData("metric1", []float64{1, 1.5, math.NaN()}, 100, 100),
+				MakeMetricData("metric2", []float64{2, 2.5, 3.25, 4}, 100, 100),
+			},
+			[]byte(`{"labels":["Time","metric1","metric2"],"data":[[100000,1,2],[200000,1.5,2.5],[300000,null,3.25],[400000,null,4]]}`),
+		},
+		{
+			[]*MetricData{
+				MakeMetricData("metric1", []float64{1, 2, 3}, 100, 100),
+				MakeMetricData("metric2", []float64{4, 5}, 200, 150),
+			},
+			[]byte(`{"labels":["Time","metric1","metric2"],"data":[[100000,1,null],[150000,null,4],[200000,2,null],[300000,3,null],[350000,null,5]]}`),
+		},
+		{
+			nil,
+			[]byte(`{}`),
+		},
+	}
+
+	for _, tt := range tests {
+		b := MarshalDygraph(tt.results)
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("marshalDygraph(%+v)=%+v, want %+v", tt.results, string(b), string(tt.out))
+		}
+	}
+}
+
+func TestRickshawResponse(t *testing.T) {
+
+	tests := []struct {
+		results []*MetricData
+		out     []byte
+	}{
+		{
+			[]*MetricData{
+				MakeMetricData("metric1", []float64{1, math.NaN()}, 100, 100),
+				MakeMetricData("metric2", []float64{2.5}, 100, 100),
+			},
+			[]byte(`[{"target":"metric1","datapoints":[{"x":100,"y":1},{"x":200,"y":null}]},{"target":"metric2","datapoints":[{"x":100,"y":2.5}]}]`),
+		},
+	}
+
+	for _, tt := range tests {
+		b := MarshalRickshaw(tt.results)
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("marshalRickshaw(%+v)=%+v, want %+v", tt.results, string(b), string(tt.out))
+		}
+	}
+}
+
 func getData(rangeSize int) []float64 {
 	var data = make([]float64, rangeSize)
 	var r = rand.New(rand.NewSource(99))
@@ -71,6 +214,6 @@ func BenchmarkMarshalJSON(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = MarshalJSON(data)
+		_ = MarshalJSON(data, NullKeep)
 	}
 }
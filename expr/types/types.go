@@ -1,12 +1,15 @@
 package types
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/bookingcom/carbonapi/util"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	pickle "github.com/lomik/og-rek"
 )
@@ -28,6 +31,58 @@ type MetricData struct {
 	aggregatedValues  []float64
 	aggregatedAbsent  []bool
 	AggregateFunction func([]float64, []bool) (float64, bool)
+
+	// XFilesFactor is the minimum fraction of non-null values needed for
+	// an aggregated point not to be null.
+	XFilesFactor float32
+
+	// TimeZone is the time zone of the request, which functions aligning
+	// to days or hours align to. Nil means UTC.
+	TimeZone *time.Location
+
+	// DateTimeZone is the time zone that the dates in the arguments of
+	// functions are parsed in, as from and until are: the one of the
+	// request, or the default one of the config. Nil means the local one.
+	DateTimeZone *time.Location
+
+	// Now is the current time of the request, which functions resolve
+	// relative times against. The zero time means the actual current time.
+	Now time.Time
+}
+
+// Location returns the time zone of r, UTC unless the request gave one.
+func (r *MetricData) Location() *time.Location {
+	if r.TimeZone == nil {
+		return time.UTC
+	}
+	return r.TimeZone
+}
+
+// DateLocation returns the time zone that the dates in the arguments of
+// functions are parsed in.
+func (r *MetricData) DateLocation() *time.Location {
+	if r.DateTimeZone == nil {
+		return time.Local
+	}
+	return r.DateTimeZone
+}
+
+// CurrentTime returns the current time of the request, which the request may
+// have pinned.
+func (r *MetricData) CurrentTime() time.Time {
+	if r.Now.IsZero() {
+		return time.Now()
+	}
+	return r.Now
+}
+
+// EnoughValues tells if nonNull values out of total are enough to compute an
+// aggregated point with the given xFilesFactor.
+func EnoughValues(nonNull, total int, xFilesFactor float32) bool {
+	if nonNull == 0 || total == 0 {
+		return false
+	}
+	return float32(nonNull)/float32(total) >= xFilesFactor
 }
 
 // MakeMetricData creates new metrics data with given metric timeseries
@@ -54,11 +109,25 @@ func MakeMetricData(name string, values []float64, step, start int32) *MetricDat
 	}}
 }
 
-// MarshalCSV marshals metric data to CSV
-func MarshalCSV(results []*MetricData) []byte {
+// MarshalCSV marshals metric data to CSV, with the timestamps in loc
+func MarshalCSV(results []*MetricData, loc *time.Location) []byte {
+	return marshalPooled(results, func(b []byte, results []*MetricData) []byte {
+		return appendCSV(b, results, loc)
+	})
+}
+
+// marshalPooled marshals results with appendTo into a pooled buffer, so that
+// only the returned slice is allocated.
+func marshalPooled(results []*MetricData, appendTo func([]byte, []*MetricData) []byte) []byte {
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
+
+	*buf = appendTo(*buf, results)
 
-	var b []byte
+	return util.CopyBuffer(buf)
+}
 
+func appendCSV(b []byte, results []*MetricData, loc *time.Location) []byte {
 	for _, r := range results {
 
 		step := r.StepTime
@@ -68,7 +137,7 @@ func MarshalCSV(results []*MetricData) []byte {
 			b = append(b, r.Name...)
 			b = append(b, '"')
 			b = append(b, ',')
-			b = append(b, time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")...)
+			b = append(b, time.Unix(int64(t), 0).In(loc).Format("2006-01-02 15:04:05")...)
 			b = append(b, ',')
 			if !r.IsAbsent[i] {
 				b = strconv.AppendFloat(b, v, 'f', -1, 64)
@@ -111,9 +180,392 @@ func ConsolidateJSON(maxDataPoints int, results []*MetricData) {
 	}
 }
 
-// MarshalJSON marshals metric data to JSON
-func MarshalJSON(results []*MetricData) []byte {
-	var b []byte
+// NullPolicy is how null points are written in JSON responses.
+type NullPolicy int
+
+const (
+	// NullKeep writes null points as null.
+	NullKeep NullPolicy = iota
+	// NullDrop leaves null points out, and the series having only null
+	// points, like graphite-web's noNullPoints.
+	NullDrop
+	// NullZero writes null points as 0.
+	NullZero
+	// NullCarryForward writes null points as the last non-null point before
+	// them. The null points before the first non-null one are left out.
+	NullCarryForward
+)
+
+// ParseNullPolicy parses a null policy name, one of "keep", "drop", "zero" and
+// "carryForward". The empty string means NullKeep.
+func ParseNullPolicy(s string) (NullPolicy, error) {
+	switch s {
+	case "", "keep":
+		return NullKeep, nil
+	case "drop":
+		return NullDrop, nil
+	case "zero":
+		return NullZero, nil
+	case "carryForward":
+		return NullCarryForward, nil
+	}
+
+	return NullKeep, fmt.Errorf("unsupported null policy %s", s)
+}
+
+// MarshalJSON marshals metric data to JSON, writing the null points as the
+// nulls policy says
+func MarshalJSON(results []*MetricData, nulls NullPolicy) []byte {
+	return marshalPooled(results, func(b []byte, results []*MetricData) []byte {
+		return appendJSON(b, results, nulls)
+	})
+}
+
+func appendJSON(b []byte, results []*MetricData, nulls NullPolicy) []byte {
+	b = append(b, '[')
+
+	var topComma bool
+	for _, r := range results {
+		if r == nil || nulls == NullDrop && allNull(r) {
+			continue
+		}
+
+		if topComma {
+			b = append(b, ',')
+		}
+		topComma = true
+
+		b = appendJSONSeries(b, r, nulls)
+	}
+
+	b = append(b, ']')
+
+	return b
+}
+
+// MarshalNDJSON marshals metric data to newline-delimited JSON: one line per
+// series, with the object MarshalJSON writes for it.
+func MarshalNDJSON(results []*MetricData, nulls NullPolicy) []byte {
+	return marshalPooled(results, func(b []byte, results []*MetricData) []byte {
+		for _, r := range results {
+			if r == nil || nulls == NullDrop && allNull(r) {
+				continue
+			}
+
+			b = appendJSONSeries(b, r, nulls)
+			b = append(b, '\n')
+		}
+
+		return b
+	})
+}
+
+// isNullPoint reports whether the i-th of the aggregated values of a series
+// is written as null.
+func isNullPoint(values []float64, absent []bool, i int) bool {
+	return absent[i] || math.IsInf(values[i], 0) || math.IsNaN(values[i])
+}
+
+// allNull reports whether all the aggregated values of r are null.
+func allNull(r *MetricData) bool {
+	values := r.AggregatedValues()
+	absent := r.AggregatedAbsent()
+	for i := range values {
+		if !isNullPoint(values, absent, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// appendJSONSeries appends the JSON object of the series r.
+func appendJSONSeries(b []byte, r *MetricData, nulls NullPolicy) []byte {
+	values := r.AggregatedValues()
+	absent := r.AggregatedAbsent()
+
+	b = append(b, `{"target":`...)
+	b = strconv.AppendQuoteToASCII(b, r.Name)
+	b = append(b, `,"datapoints":[`...)
+
+	var innerComma bool
+	var last float64
+	var haveLast bool
+	t := r.StartTime
+	for i, v := range values {
+		null := isNullPoint(values, absent, i)
+		if !null {
+			last, haveLast = v, true
+		}
+		if null && (nulls == NullDrop || nulls == NullCarryForward && !haveLast) {
+			t += r.AggregatedTimeStep()
+			continue
+		}
+
+		if innerComma {
+			b = append(b, ',')
+		}
+		innerComma = true
+
+		b = append(b, '[')
+
+		switch {
+		case !null:
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		case nulls == NullZero:
+			b = append(b, '0')
+		case nulls == NullCarryForward:
+			b = strconv.AppendFloat(b, last, 'f', -1, 64)
+		default:
+			b = append(b, "null"...)
+		}
+
+		b = append(b, ',')
+
+		b = strconv.AppendInt(b, int64(t), 10)
+
+		b = append(b, ']')
+
+		t += r.AggregatedTimeStep()
+	}
+
+	b = append(b, `]}`...)
+
+	return b
+}
+
+// compactRun is the shortest run of equal values or deltas written as a
+// [count, value] pair by MarshalCompactJSON.
+const compactRun = 3
+
+// MarshalCompactJSON marshals metric data to a compact JSON format, in which
+// each series is an object of its target, the timestamp of its first point,
+// its step and its values:
+//
+//	{"target":"a","start":1500000000,"step":60,"gaps":[[0,2]],"delta":true,"values":[5,[3,1],-2]}
+//
+// gaps are the [index, length] of the runs of null points, which values
+// skip. If delta is true, values are the differences from the previous
+// non-null value, starting from 0; they are used when adding them up gives
+// back the exact values, and when they are shorter than the values
+// themselves. Runs of at least three equal entries of values are written as
+// [count, entry].
+func MarshalCompactJSON(results []*MetricData) []byte {
+	return marshalPooled(results, appendCompactJSON)
+}
+
+func appendCompactJSON(b []byte, results []*MetricData) []byte {
+	b = append(b, '[')
+
+	var topComma bool
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+
+		if topComma {
+			b = append(b, ',')
+		}
+		topComma = true
+
+		b = appendCompactJSONSeries(b, r)
+	}
+
+	b = append(b, ']')
+
+	return b
+}
+
+func appendCompactJSONSeries(b []byte, r *MetricData) []byte {
+	values := r.AggregatedValues()
+	absent := r.AggregatedAbsent()
+
+	var present []float64
+	var gaps [][2]int
+	for i := range values {
+		if !isNullPoint(values, absent, i) {
+			present = append(present, values[i])
+			continue
+		}
+
+		if n := len(gaps); n != 0 && gaps[n-1][0]+gaps[n-1][1] == i {
+			gaps[n-1][1]++
+		} else {
+			gaps = append(gaps, [2]int{i, 1})
+		}
+	}
+
+	// Deltas are only used if the values are decoded exactly from them,
+	// and if they're shorter.
+	encoded := appendCompactValues(nil, present)
+	delta := false
+	deltas := make([]float64, len(present))
+	exact := true
+	var prev float64
+	for i, v := range present {
+		deltas[i] = v - prev
+		if prev+deltas[i] != v {
+			exact = false
+			break
+		}
+		prev = v
+	}
+	if exact {
+		if d := appendCompactValues(nil, deltas); len(d) < len(encoded) {
+			encoded, delta = d, true
+		}
+	}
+
+	b = append(b, `{"target":`...)
+	b = strconv.AppendQuoteToASCII(b, r.Name)
+	b = append(b, `,"start":`...)
+	b = strconv.AppendInt(b, int64(r.StartTime), 10)
+	b = append(b, `,"step":`...)
+	b = strconv.AppendInt(b, int64(r.AggregatedTimeStep()), 10)
+
+	b = append(b, `,"gaps":[`...)
+	for i, g := range gaps {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '[')
+		b = strconv.AppendInt(b, int64(g[0]), 10)
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(g[1]), 10)
+		b = append(b, ']')
+	}
+
+	b = append(b, `],"delta":`...)
+	b = strconv.AppendBool(b, delta)
+
+	b = append(b, `,"values":`...)
+	b = append(b, encoded...)
+	b = append(b, '}')
+
+	return b
+}
+
+// appendCompactValues appends the values of MarshalCompactJSON, with runs of
+// equal values written as [count, value].
+func appendCompactValues(b []byte, values []float64) []byte {
+	b = append(b, '[')
+	for i := 0; i < len(values); {
+		n := 1
+		for i+n < len(values) && values[i+n] == values[i] {
+			n++
+		}
+
+		if i != 0 {
+			b = append(b, ',')
+		}
+		if n >= compactRun {
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(n), 10)
+			b = append(b, ',')
+			b = strconv.AppendFloat(b, values[i], 'f', -1, 64)
+			b = append(b, ']')
+			i += n
+			continue
+		}
+
+		b = strconv.AppendFloat(b, values[i], 'f', -1, 64)
+		i++
+	}
+
+	return append(b, ']')
+}
+
+// MarshalDygraph marshals metric data to the JSON format expected by dygraph.
+// Rows are keyed by timestamps in milliseconds, those of the points of all
+// the series, so that series with different start times or steps line up.
+func MarshalDygraph(results []*MetricData) []byte {
+	return marshalPooled(results, appendDygraph)
+}
+
+func appendDygraph(b []byte, results []*MetricData) []byte {
+	var series []*MetricData
+	start := len(b)
+	b = append(b, `{"labels":["Time"`...)
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+
+		series = append(series, r)
+		b = append(b, ',')
+		b = strconv.AppendQuoteToASCII(b, r.Name)
+	}
+
+	if len(series) == 0 {
+		return append(b[:start], "{}"...)
+	}
+
+	b = append(b, `],"data":[`...)
+
+	for i, t := range dygraphTimes(series) {
+		if i > 0 {
+			b = append(b, ',')
+		}
+
+		b = append(b, '[')
+		b = strconv.AppendInt(b, int64(t)*1000, 10)
+		for _, r := range series {
+			b = append(b, ',')
+			values, absent := r.AggregatedValues(), r.AggregatedAbsent()
+			j := pointAt(r.StartTime, r.AggregatedTimeStep(), t)
+			if j < 0 || j >= len(values) || absent[j] || math.IsInf(values[j], 0) || math.IsNaN(values[j]) {
+				b = append(b, "null"...)
+			} else {
+				b = strconv.AppendFloat(b, values[j], 'f', -1, 64)
+			}
+		}
+		b = append(b, ']')
+	}
+
+	b = append(b, "]}"...)
+
+	return b
+}
+
+// dygraphTimes returns the timestamps of the points of all the series, in
+// order.
+func dygraphTimes(series []*MetricData) []int32 {
+	seen := make(map[int32]bool)
+	var times []int32
+	for _, r := range series {
+		step := r.AggregatedTimeStep()
+		for i := range r.AggregatedValues() {
+			t := r.StartTime + int32(i)*step
+			if !seen[t] {
+				seen[t] = true
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times
+}
+
+// pointAt returns the index of the point at t of a series starting at start
+// with step, or -1 if it has none there.
+func pointAt(start, step, t int32) int {
+	switch {
+	case t == start:
+		return 0
+	case step <= 0 || t < start || (t-start)%step != 0:
+		return -1
+	}
+
+	return int((t - start) / step)
+}
+
+// MarshalRickshaw marshals metric data to the JSON format expected by rickshaw
+func MarshalRickshaw(results []*MetricData) []byte {
+	return marshalPooled(results, appendRickshaw)
+}
+
+func appendRickshaw(b []byte, results []*MetricData) []byte {
 	b = append(b, '[')
 
 	var topComma bool
@@ -131,28 +583,22 @@ func MarshalJSON(results []*MetricData) []byte {
 		b = strconv.AppendQuoteToASCII(b, r.Name)
 		b = append(b, `,"datapoints":[`...)
 
-		var innerComma bool
 		t := r.StartTime
 		absent := r.AggregatedAbsent()
 		for i, v := range r.AggregatedValues() {
-			if innerComma {
+			if i > 0 {
 				b = append(b, ',')
 			}
-			innerComma = true
-
-			b = append(b, '[')
 
+			b = append(b, `{"x":`...)
+			b = strconv.AppendInt(b, int64(t), 10)
+			b = append(b, `,"y":`...)
 			if absent[i] || math.IsInf(v, 0) || math.IsNaN(v) {
 				b = append(b, "null"...)
 			} else {
 				b = strconv.AppendFloat(b, v, 'f', -1, 64)
 			}
-
-			b = append(b, ',')
-
-			b = strconv.AppendInt(b, int64(t), 10)
-
-			b = append(b, ']')
+			b = append(b, '}')
 
 			t += r.AggregatedTimeStep()
 		}
@@ -189,12 +635,14 @@ func MarshalPickle(results []*MetricData) []byte {
 		})
 	}
 
-	var buf bytes.Buffer
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
 
-	penc := pickle.NewEncoder(&buf)
-	penc.Encode(p)
+	util.WriteBuffer(buf, func(w io.Writer) error {
+		return pickle.NewEncoder(w).Encode(p)
+	})
 
-	return buf.Bytes()
+	return util.CopyBuffer(buf)
 }
 
 // MarshalProtobuf marshals metric data to protobuf
@@ -213,9 +661,10 @@ func MarshalProtobuf(results []*MetricData) ([]byte, error) {
 
 // MarshalRaw marshals metric data to graphite's internal format, called 'raw'
 func MarshalRaw(results []*MetricData) []byte {
+	return marshalPooled(results, appendRaw)
+}
 
-	var b []byte
-
+func appendRaw(b []byte, results []*MetricData) []byte {
 	for _, r := range results {
 
 		b = append(b, r.Name...)
@@ -300,7 +749,7 @@ func (r *MetricData) AggregateValues() {
 	absent := r.IsAbsent
 
 	for len(v) >= r.ValuesPerPoint {
-		val, abs := r.AggregateFunction(v[:r.ValuesPerPoint], absent[:r.ValuesPerPoint])
+		val, abs := r.aggregate(v[:r.ValuesPerPoint], absent[:r.ValuesPerPoint])
 		aggV = append(aggV, val)
 		aggA = append(aggA, abs)
 		v = v[r.ValuesPerPoint:]
@@ -308,7 +757,7 @@ func (r *MetricData) AggregateValues() {
 	}
 
 	if len(v) > 0 {
-		val, abs := r.AggregateFunction(v, absent)
+		val, abs := r.aggregate(v, absent)
 		aggV = append(aggV, val)
 		aggA = append(aggA, abs)
 	}
@@ -317,6 +766,21 @@ func (r *MetricData) AggregateValues() {
 	r.aggregatedAbsent = aggA
 }
 
+// aggregate consolidates a bucket of values, which is absent if it has less
+// non-null values than required by XFilesFactor.
+func (r *MetricData) aggregate(v []float64, absent []bool) (float64, bool) {
+	var nonNull int
+	for _, a := range absent {
+		if !a {
+			nonNull++
+		}
+	}
+	if !EnoughValues(nonNull, len(absent), r.XFilesFactor) {
+		return math.NaN(), true
+	}
+	return r.AggregateFunction(v, absent)
+}
+
 // AggMean computes mean (sum(v)/len(v), excluding NaN points) of values
 func AggMean(v []float64, absent []bool) (float64, bool) {
 	var sum float64
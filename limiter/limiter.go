@@ -1,5 +1,7 @@
 package limiter
 
+import "context"
+
 // ServerLimiter provides interface to limit amount of requests
 type ServerLimiter struct {
 	limiters map[string]chan struct{}
@@ -28,6 +30,27 @@ func (sl ServerLimiter) Enter(s string) {
 	sl.limiters[s] <- struct{}{}
 }
 
+// EnterContext claims one of free slots or blocks until there is one, or
+// until ctx is done.
+func (sl ServerLimiter) EnterContext(ctx context.Context, s string) error {
+	if sl.limiters == nil {
+		return nil
+	}
+
+	select {
+	case sl.limiters[s] <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case sl.limiters[s] <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Frees a slot in limiter
 func (sl ServerLimiter) Leave(s string) {
 	if sl.limiters == nil {
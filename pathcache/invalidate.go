@@ -0,0 +1,83 @@
+package pathcache
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// globTLD is the top-level domain of the keys whose first node is a glob,
+// which may match the prefix of any invalidation.
+const globTLD = "*"
+
+// Generations are the generations of the keys of a cache, by their
+// top-level domain. Invalidating a prefix moves the keys under its top-level
+// domain to a new generation, so that the entries set before are never read
+// again, and expire in the background. The cache can't list its keys to
+// evict them. A nil Generations leaves the keys as they are.
+type Generations struct {
+	mu    sync.RWMutex
+	all   uint64
+	byTLD map[string]uint64
+}
+
+// NewGenerations returns the generations of the keys of a new cache.
+func NewGenerations() *Generations {
+	return &Generations{byTLD: make(map[string]uint64)}
+}
+
+// Key returns k in its generation. The keys of the first generation are
+// left as they are.
+func (g *Generations) Key(k string) string {
+	if g == nil {
+		return k
+	}
+
+	tld := strings.SplitN(k, ".", 2)[0]
+	if strings.ContainsAny(tld, "*?[{") {
+		tld = globTLD
+	}
+
+	g.mu.RLock()
+	all, gen := g.all, g.byTLD[tld]
+	g.mu.RUnlock()
+	if all == 0 && gen == 0 {
+		return k
+	}
+
+	return k + "\x00" + strconv.FormatUint(all, 10) + "." + strconv.FormatUint(gen, 10)
+}
+
+// Invalidate moves the keys under the top-level domain of prefix to a new
+// generation, along with those whose first node is a glob, and all of them if
+// prefix is empty.
+func (g *Generations) Invalidate(prefix string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tld := strings.SplitN(prefix, ".", 2)[0]
+	if tld == "" || strings.ContainsAny(tld, "*?[{") {
+		g.all++
+		return
+	}
+
+	g.byTLD[tld]++
+	g.byTLD[globTLD]++
+}
+
+// Invalidate evicts the entries of the queries under the top-level domain of
+// prefix, e.g. when the index of carbonsearch changes, instead of letting
+// them live out their expiry. An empty prefix evicts all the entries.
+func (p *PathCache) Invalidate(prefix string) {
+	p.gens.Invalidate(prefix)
+}
+
+// Key returns the key of query in caches that must be invalidated along with
+// p, such as caches of find responses.
+func (p *PathCache) Key(query string) string {
+	return p.gens.Key(query)
+}
@@ -1,9 +1,11 @@
 package pathcache
 
 import (
-	"github.com/dgryski/go-expirecache"
-
+	"math/rand"
+	"sync/atomic"
 	"time"
+
+	"github.com/dgryski/go-expirecache"
 )
 
 // PathCache provides general interface to cache find and search queries
@@ -11,14 +13,35 @@ type PathCache struct {
 	ec *expirecache.Cache
 
 	expireDelaySec int32
+	// jitter is the fraction of expireDelaySec the expiry of an entry is
+	// randomized by, so that entries set together don't expire together.
+	jitter float64
+	// refreshAhead is how long before their expiry entries are reported as
+	// needing a refresh by GetRefresh.
+	refreshAhead time.Duration
+	// gens evict the entries of invalidated queries.
+	gens *Generations
 }
 
-// NewPathCache initializes PathCache structure
-func NewPathCache(ExpireDelaySec int32) PathCache {
+// entry is the value of a key, with its expiry.
+type entry struct {
+	servers    []string
+	expires    time.Time
+	refreshing int32
+}
+
+// NewPathCache initializes PathCache structure. The expiry of each entry is
+// randomized by up to jitter times ExpireDelaySec either way, and the entries
+// still used refreshAheadSec seconds before they expire are reported as
+// needing a refresh by GetRefresh.
+func NewPathCache(ExpireDelaySec int32, jitter float64, refreshAheadSec int32) PathCache {
 
 	p := PathCache{
 		ec:             expirecache.New(0),
 		expireDelaySec: ExpireDelaySec,
+		jitter:         jitter,
+		refreshAhead:   time.Duration(refreshAheadSec) * time.Second,
+		gens:           NewGenerations(),
 	}
 
 	go p.ec.ApproximateCleaner(10 * time.Second)
@@ -44,14 +67,47 @@ func (p *PathCache) Set(k string, v []string) {
 		size += uint64(len(vv))
 	}
 
-	p.ec.Set(k, v, size, p.expireDelaySec)
+	expiry := p.expiry()
+	p.ec.Set(p.gens.Key(k), &entry{servers: v, expires: time.Now().Add(time.Duration(expiry) * time.Second)}, size, expiry)
+}
+
+// expiry returns the expiry of an entry set now, in seconds.
+func (p *PathCache) expiry() int32 {
+	if p.jitter <= 0 || p.expireDelaySec <= 0 {
+		return p.expireDelaySec
+	}
+
+	delta := float64(p.expireDelaySec) * p.jitter * (2*rand.Float64() - 1)
+	expiry := p.expireDelaySec + int32(delta)
+	if expiry < 1 {
+		return 1
+	}
+
+	return expiry
 }
 
 // Get returns an an element by key. If not successful - returns also false in second var.
 func (p *PathCache) Get(k string) ([]string, bool) {
-	if v, ok := p.ec.Get(k); ok {
-		return v.([]string), true
+	if v, ok := p.ec.Get(p.gens.Key(k)); ok {
+		return v.(*entry).servers, true
 	}
 
 	return nil, false
 }
+
+// GetRefresh returns an element by key like Get, and whether it expires soon
+// enough to be refreshed ahead of its expiry. Only the first of the callers
+// getting an element while it expires soon is told to refresh it.
+func (p *PathCache) GetRefresh(k string) ([]string, bool, bool) {
+	v, ok := p.ec.Get(p.gens.Key(k))
+	if !ok {
+		return nil, false, false
+	}
+
+	e := v.(*entry)
+	if p.refreshAhead <= 0 || time.Until(e.expires) > p.refreshAhead {
+		return e.servers, true, false
+	}
+
+	return e.servers, true, atomic.CompareAndSwapInt32(&e.refreshing, 0, 1)
+}
@@ -0,0 +1,84 @@
+package pathcache
+
+import (
+	"testing"
+)
+
+func TestExpiryJitter(t *testing.T) {
+	p := NewPathCache(100, 0.1, 0)
+
+	seen := make(map[int32]bool)
+	for i := 0; i < 1000; i++ {
+		expiry := p.expiry()
+		if expiry < 90 || expiry > 110 {
+			t.Fatalf("Expected an expiry between 90 and 110, got %d", expiry)
+		}
+		seen[expiry] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected jittered expiries, got %v", seen)
+	}
+
+	p = NewPathCache(100, 0, 0)
+	if expiry := p.expiry(); expiry != 100 {
+		t.Errorf("Expected an expiry of 100 without jitter, got %d", expiry)
+	}
+}
+
+func TestGetRefresh(t *testing.T) {
+	p := NewPathCache(10, 0, 20)
+	p.Set("foo", []string{"a"})
+
+	servers, ok, refresh := p.GetRefresh("foo")
+	if !ok || len(servers) != 1 || !refresh {
+		t.Fatalf("Expected the first caller to refresh, got %v, %v, %v", servers, ok, refresh)
+	}
+	if _, ok, refresh := p.GetRefresh("foo"); !ok || refresh {
+		t.Errorf("Expected only the first caller to refresh, got %v, %v", ok, refresh)
+	}
+
+	p.Set("foo", []string{"a"})
+	if _, _, refresh := p.GetRefresh("foo"); !refresh {
+		t.Errorf("Expected a new entry to be refreshed again")
+	}
+
+	p = NewPathCache(60, 0, 10)
+	p.Set("foo", []string{"a"})
+	if _, ok, refresh := p.GetRefresh("foo"); !ok || refresh {
+		t.Errorf("Expected a fresh entry not to be refreshed, got %v, %v", ok, refresh)
+	}
+
+	if _, ok, _ := p.GetRefresh("bar"); ok {
+		t.Errorf("Expected no entry for bar")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	p := NewPathCache(60, 0, 0)
+	for _, k := range []string{"virt.v1.foo", "virt.v2.*", "*.v1.foo", "carbon.agents"} {
+		p.Set(k, []string{"a"})
+	}
+	if key := p.Key("virt.v1.foo"); key != "virt.v1.foo" {
+		t.Errorf("Expected the keys of the first generation as they are, got %q", key)
+	}
+
+	p.Invalidate("virt.v1")
+	for _, k := range []string{"virt.v1.foo", "virt.v2.*", "*.v1.foo"} {
+		if _, ok := p.Get(k); ok {
+			t.Errorf("Expected %s to be invalidated", k)
+		}
+	}
+	if _, ok := p.Get("carbon.agents"); !ok {
+		t.Error("Expected carbon.agents to stay")
+	}
+
+	p.Set("virt.v1.foo", []string{"b"})
+	if servers, ok := p.Get("virt.v1.foo"); !ok || servers[0] != "b" {
+		t.Errorf("Expected the entry set again, got %v, %v", servers, ok)
+	}
+
+	p.Invalidate("")
+	if _, ok := p.Get("carbon.agents"); ok {
+		t.Error("Expected all the entries to be invalidated")
+	}
+}
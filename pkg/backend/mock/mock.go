@@ -22,6 +22,7 @@ import (
 
 // Backend is a mock backend.
 type Backend struct {
+	address  string
 	find     func(context.Context, types.FindRequest) (types.Matches, error)
 	info     func(context.Context, types.InfoRequest) ([]types.Info, error)
 	render   func(context.Context, types.RenderRequest) ([]types.Metric, error)
@@ -33,6 +34,7 @@ type Backend struct {
 // default to one that returns an empty response and nil error.
 // A mock backend contains all targets by default.
 type Config struct {
+	Address  string // The address reported by the backend, "mock" by default.
 	Find     func(context.Context, types.FindRequest) (types.Matches, error)
 	Info     func(context.Context, types.InfoRequest) ([]types.Info, error)
 	Render   func(context.Context, types.RenderRequest) ([]types.Metric, error)
@@ -59,18 +61,32 @@ func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]typ
 	return b.render(ctx, request)
 }
 
+// Address returns the configured address.
+func (b Backend) Address() string {
+	return b.address
+}
+
 // Logger returns a no-op logger.
 func (b Backend) Logger() *zap.Logger {
 	return noLog
 }
 
 // Probe is a no-op.
-func (b Backend) Probe() {}
+func (b Backend) Probe() error { return nil }
+
+// Invalidate is a no-op.
+func (b Backend) Invalidate(prefix string) {}
 
 // New creates a new mock backend.
 func New(cfg Config) Backend {
 	b := Backend{}
 
+	if cfg.Address != "" {
+		b.address = cfg.Address
+	} else {
+		b.address = "mock"
+	}
+
 	if cfg.Find != nil {
 		b.find = cfg.Find
 	} else {
@@ -0,0 +1,198 @@
+package net
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// The parameters of the gradient of AdaptiveLimiter. The long-term latency
+// is an average over about adaptiveLongWindow calls, and a latency up to
+// adaptiveTolerance times it doesn't shrink the limit. The limit moves by
+// adaptiveSmoothing of the way to its new value on each call, and by
+// adaptiveBackoff on each failed call.
+const (
+	adaptiveLongWindow = 600
+	adaptiveTolerance  = 1.5
+	adaptiveSmoothing  = 0.2
+	adaptiveBackoff    = 0.9
+)
+
+// AdaptiveLimitConfig configures AdaptiveLimits.
+type AdaptiveLimitConfig struct {
+	InitialLimit int // The limit of a backend before its first call. Defaults to MinLimit.
+	MinLimit     int // The lowest limit. Defaults to 1.
+	MaxLimit     int // The highest limit. Defaults to 1000.
+}
+
+// AdaptiveLimiter limits the concurrent calls to a backend to a limit that
+// tracks what the backend sustains, gradient2 style: the limit is scaled by
+// the ratio of the long-term latency of the backend to the latency of each
+// call, plus a queue of its square root, so that it grows while the latency
+// holds, and shrinks as it rises. A failed call shrinks it multiplicatively.
+type AdaptiveLimiter struct {
+	mu       sync.Mutex
+	config   AdaptiveLimitConfig
+	limit    float64
+	inFlight int
+	longRTT  float64 // In seconds, zero before the first call.
+	// changed is closed and replaced when a slot may have been freed.
+	changed chan struct{}
+}
+
+func newAdaptiveLimiter(config AdaptiveLimitConfig) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		config:  config,
+		limit:   float64(config.InitialLimit),
+		changed: make(chan struct{}),
+	}
+}
+
+// Limit returns the current limit.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// enter claims a slot, blocking until there is one or until ctx is done.
+func (l *AdaptiveLimiter) enter(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// leave frees a slot, and updates the limit with the latency rtt of the call
+// unless it's zero, or backs it off if the call failed.
+func (l *AdaptiveLimiter) leave(rtt time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+
+	switch {
+	case failed:
+		l.limit *= adaptiveBackoff
+	case rtt > 0:
+		l.update(rtt.Seconds(), inFlight)
+	}
+
+	if l.limit < float64(l.config.MinLimit) {
+		l.limit = float64(l.config.MinLimit)
+	}
+	if l.limit > float64(l.config.MaxLimit) {
+		l.limit = float64(l.config.MaxLimit)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// update must be called with mu held.
+func (l *AdaptiveLimiter) update(rtt float64, inFlight int) {
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / adaptiveLongWindow
+	}
+	// A long-term latency far above the current one is from a past
+	// overload, and would keep the limit too high while it averages out.
+	if l.longRTT > 2*rtt {
+		l.longRTT *= 0.95
+	}
+
+	// A backend that isn't asked for half of its limit doesn't tell
+	// whether it would sustain more.
+	if float64(inFlight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, adaptiveTolerance*l.longRTT/rtt))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-adaptiveSmoothing) + limit*adaptiveSmoothing
+}
+
+// AdaptiveLimits keeps the adaptive limiters of backends by "host:port"
+// address.
+type AdaptiveLimits struct {
+	mu       sync.Mutex
+	config   AdaptiveLimitConfig
+	limiters map[string]*AdaptiveLimiter
+}
+
+// NewAdaptiveLimits creates adaptive limiters at their initial limit.
+func NewAdaptiveLimits(config AdaptiveLimitConfig) *AdaptiveLimits {
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 1000
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.InitialLimit < config.MinLimit {
+		config.InitialLimit = config.MinLimit
+	}
+	if config.InitialLimit > config.MaxLimit {
+		config.InitialLimit = config.MaxLimit
+	}
+
+	return &AdaptiveLimits{
+		config:   config,
+		limiters: make(map[string]*AdaptiveLimiter),
+	}
+}
+
+// Get returns the limiter of the given backend, which may be a "host:port"
+// address or any backend address accepted by New.
+func (a *AdaptiveLimits) Get(address string) *AdaptiveLimiter {
+	if host, _, err := parseAddress(address); err == nil {
+		address = host
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.limiters[address]
+	if !ok {
+		l = newAdaptiveLimiter(a.config)
+		a.limiters[address] = l
+	}
+
+	return l
+}
+
+// Addresses returns the sorted addresses of the backends with a limiter.
+func (a *AdaptiveLimits) Addresses() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return sortedKeys(a.limiters)
+}
+
+// Snapshot returns the current limit of each backend by address, for expvar.
+func (a *AdaptiveLimits) Snapshot() interface{} {
+	snapshot := make(map[string]int)
+	for _, address := range a.Addresses() {
+		snapshot[address] = a.Get(address).Limit()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,90 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterGrows(t *testing.T) {
+	l := NewAdaptiveLimits(AdaptiveLimitConfig{InitialLimit: 4, MaxLimit: 20}).Get("host:8080")
+
+	// A saturated backend answering as fast as ever is asked for more.
+	for i := 0; i < 100; i++ {
+		for j := 0; j < l.Limit(); j++ {
+			if err := l.enter(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j := l.inFlight; j > 0; j-- {
+			l.leave(10*time.Millisecond, false)
+		}
+	}
+	if got := l.Limit(); got != 20 {
+		t.Errorf("Expected the limit to grow to 20, got %d", got)
+	}
+
+	// Latency rising far over the long-term one shrinks the limit.
+	for i := 0; i < 20; i++ {
+		if err := l.enter(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		l.leave(time.Second, false)
+	}
+	if got := l.Limit(); got >= 20 {
+		t.Errorf("Expected the limit to shrink as the latency rises, got %d", got)
+	}
+}
+
+func TestAdaptiveLimiterBacksOff(t *testing.T) {
+	l := NewAdaptiveLimits(AdaptiveLimitConfig{InitialLimit: 10, MinLimit: 2}).Get("host:8080")
+
+	for i := 0; i < 50; i++ {
+		if err := l.enter(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		l.leave(time.Millisecond, true)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Expected failures to back the limit off to 2, got %d", got)
+	}
+}
+
+func TestAdaptiveLimiterBlocks(t *testing.T) {
+	l := NewAdaptiveLimits(AdaptiveLimitConfig{InitialLimit: 1, MaxLimit: 1}).Get("host:8080")
+	if err := l.enter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.enter(ctx); err == nil {
+		t.Error("Expected to time out while the only slot is taken")
+	}
+
+	entered := make(chan error)
+	go func() {
+		entered <- l.enter(context.Background())
+	}()
+	l.leave(0, false)
+	if err := <-entered; err != nil {
+		t.Errorf("Expected to enter once the slot is freed, got %v", err)
+	}
+}
+
+func TestBackendAdaptiveLimit(t *testing.T) {
+	limits := NewAdaptiveLimits(AdaptiveLimitConfig{InitialLimit: 5})
+	b, err := New(Config{Address: "localhost:8080", Limit: 1, AdaptiveLimits: limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.limiter != nil || b.adaptive != limits.Get("localhost:8080") {
+		t.Error("Expected the adaptive limiter to replace the static one")
+	}
+	if got := limits.Snapshot().(map[string]int); got["localhost:8080"] != 5 {
+		t.Errorf("Expected a limit of 5, got %v", got)
+	}
+}
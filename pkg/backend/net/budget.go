@@ -0,0 +1,190 @@
+package net
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errorRateSlots is the number of slots the window of an ErrorRate is split
+// into. The oldest slot is dropped whole as time goes by.
+const errorRateSlots = 10
+
+var timeNow = time.Now
+
+type errorRateSlot struct {
+	id       int64 // The index of the slot since the epoch.
+	requests int64
+	errors   int64
+}
+
+// ErrorRate is the rolling error rate of the requests to a backend.
+type ErrorRate struct {
+	mu       sync.Mutex
+	slotSize time.Duration
+	slots    [errorRateSlots]errorRateSlot
+	// unhealthy is set while the rate is over the budget.
+	unhealthy bool
+}
+
+func newErrorRate(window time.Duration) *ErrorRate {
+	slotSize := window / errorRateSlots
+	if slotSize <= 0 {
+		slotSize = time.Second
+	}
+
+	return &ErrorRate{slotSize: slotSize}
+}
+
+// record must be called with mu held.
+func (r *ErrorRate) record(failed bool) {
+	id := timeNow().UnixNano() / int64(r.slotSize)
+	s := &r.slots[id%errorRateSlots]
+	if s.id != id {
+		*s = errorRateSlot{id: id}
+	}
+
+	s.requests++
+	if failed {
+		s.errors++
+	}
+}
+
+// counts must be called with mu held.
+func (r *ErrorRate) counts() (requests, errors int64) {
+	id := timeNow().UnixNano() / int64(r.slotSize)
+	for _, s := range r.slots {
+		if s.id > id-errorRateSlots && s.id <= id {
+			requests += s.requests
+			errors += s.errors
+		}
+	}
+
+	return requests, errors
+}
+
+// Rate returns the share of the requests of the window that failed, from 0
+// to 1.
+func (r *ErrorRate) Rate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requests, errors := r.counts()
+	if requests == 0 {
+		return 0
+	}
+
+	return float64(errors) / float64(requests)
+}
+
+// ErrorBudgetConfig configures ErrorBudgets.
+type ErrorBudgetConfig struct {
+	Window       time.Duration // The window of the error rates. Defaults to a minute.
+	MaxErrorRate float64       // The error rate over which a backend is unhealthy. 0 never logs it.
+	MinRequests  int64         // The requests of the window needed to tell a backend is unhealthy.
+	Logger       *zap.Logger   // Logger of the health changes. Defaults to a no-op logger.
+}
+
+// ErrorBudgets keeps the rolling error rates of backends by "host:port"
+// address, and logs a "Backend unhealthy" event when the rate of a backend
+// goes over the budget, and a "Backend healthy" one when it is back under.
+type ErrorBudgets struct {
+	mu     sync.Mutex
+	config ErrorBudgetConfig
+	rates  map[string]*ErrorRate
+}
+
+// NewErrorBudgets creates error rates with no requests.
+func NewErrorBudgets(config ErrorBudgetConfig) *ErrorBudgets {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.Logger == nil {
+		config.Logger = zap.New(nil)
+	}
+
+	return &ErrorBudgets{
+		config: config,
+		rates:  make(map[string]*ErrorRate),
+	}
+}
+
+// Get returns the error rate of the given backend, which may be a
+// "host:port" address or any backend address accepted by New.
+func (e *ErrorBudgets) Get(address string) *ErrorRate {
+	if host, _, err := parseAddress(address); err == nil {
+		address = host
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.rates[address]
+	if !ok {
+		r = newErrorRate(e.config.Window)
+		e.rates[address] = r
+	}
+
+	return r
+}
+
+// Record counts a request to the backend at address, and whether it failed.
+// A nil ErrorBudgets does nothing.
+func (e *ErrorBudgets) Record(address string, failed bool) {
+	if e == nil {
+		return
+	}
+
+	r := e.Get(address)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record(failed)
+	if e.config.MaxErrorRate <= 0 {
+		return
+	}
+
+	requests, errors := r.counts()
+	rate := float64(errors) / float64(requests)
+	switch {
+	case !r.unhealthy && requests >= e.config.MinRequests && rate > e.config.MaxErrorRate:
+		r.unhealthy = true
+		e.config.Logger.Error("Backend unhealthy",
+			zap.String("host", address),
+			zap.Float64("error_rate", rate),
+			zap.Float64("max_error_rate", e.config.MaxErrorRate),
+			zap.Int64("requests", requests),
+			zap.Duration("window", e.config.Window),
+		)
+
+	case r.unhealthy && rate <= e.config.MaxErrorRate:
+		r.unhealthy = false
+		e.config.Logger.Info("Backend healthy",
+			zap.String("host", address),
+			zap.Float64("error_rate", rate),
+			zap.Float64("max_error_rate", e.config.MaxErrorRate),
+			zap.Int64("requests", requests),
+			zap.Duration("window", e.config.Window),
+		)
+	}
+}
+
+// Addresses returns the sorted addresses of the backends with an error rate.
+func (e *ErrorBudgets) Addresses() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return sortedKeys(e.rates)
+}
+
+// Snapshot returns the current error rate of each backend by address, for
+// expvar.
+func (e *ErrorBudgets) Snapshot() interface{} {
+	snapshot := make(map[string]float64)
+	for _, address := range e.Addresses() {
+		snapshot[address] = e.Get(address).Rate()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,46 @@
+package net
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestErrorBudgets(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var logs bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&logs), zap.InfoLevel)
+	budgets := NewErrorBudgets(ErrorBudgetConfig{
+		Window:       time.Minute,
+		MaxErrorRate: 0.5,
+		MinRequests:  4,
+		Logger:       zap.New(core),
+	})
+
+	for _, failed := range []bool{true, true, true, false} {
+		budgets.Record("localhost:8080", failed)
+	}
+	if got := budgets.Get("http://localhost:8080").Rate(); got != 0.75 {
+		t.Errorf("Expected an error rate of 0.75, got %v", got)
+	}
+	if n := strings.Count(logs.String(), `"Backend unhealthy"`); n != 1 {
+		t.Errorf("Expected 1 unhealthy event, got %d", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := budgets.Get("localhost:8080").Rate(); got != 0 {
+		t.Errorf("Expected the errors to be out of the window, got %v", got)
+	}
+
+	budgets.Record("localhost:8080", false)
+	if n := strings.Count(logs.String(), `"Backend healthy"`); n != 1 {
+		t.Errorf("Expected 1 healthy event, got %d", n)
+	}
+}
@@ -0,0 +1,91 @@
+package net
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ErrInjectedFault is the error of the calls failed by Faults.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Fault is the fault injected in the calls to a backend.
+type Fault struct {
+	Delay           time.Duration // The time the delayed calls wait before being made.
+	DelayPercentage float64       // The percentage of the calls that are delayed.
+	ErrorPercentage float64       // The percentage of the calls that fail with ErrInjectedFault.
+}
+
+// Faults delays or fails a percentage of the calls to backends, to rehearse
+// partial outages. It is meant for staging only.
+type Faults struct {
+	faults map[string]Fault
+	logger *zap.Logger
+	rand   func() float64
+}
+
+// NewFaults creates the faults injected in the calls to backends, by
+// "host:port" address or any backend address accepted by New. The fault of
+// the "*" address applies to the backends without one of their own.
+func NewFaults(faults map[string]Fault, logger *zap.Logger) *Faults {
+	if logger == nil {
+		logger = zap.New(nil)
+	}
+
+	f := &Faults{
+		faults: make(map[string]Fault, len(faults)),
+		logger: logger,
+		rand:   rand.Float64,
+	}
+	for address, fault := range faults {
+		if host, _, err := parseAddress(address); err == nil && address != "*" {
+			address = host
+		}
+		f.faults[address] = fault
+	}
+
+	return f
+}
+
+// inject delays or fails a call to the backend at address. It returns the
+// error the call fails with, if any. A nil Faults injects nothing.
+func (f *Faults) inject(ctx context.Context, address string) error {
+	if f == nil {
+		return nil
+	}
+
+	fault, ok := f.faults[address]
+	if !ok {
+		fault, ok = f.faults["*"]
+		if !ok {
+			return nil
+		}
+	}
+
+	if fault.Delay > 0 && f.rand()*100 < fault.DelayPercentage {
+		f.logger.Debug("Delaying backend call",
+			zap.String("host", address),
+			zap.Duration("delay", fault.Delay),
+		)
+
+		t := time.NewTimer(fault.Delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if f.rand()*100 < fault.ErrorPercentage {
+		f.logger.Debug("Failing backend call",
+			zap.String("host", address),
+		)
+		return ErrInjectedFault
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/pkg/errors"
+)
+
+func TestFaultsInject(t *testing.T) {
+	faults := NewFaults(map[string]Fault{
+		"http://localhost:8080": {ErrorPercentage: 50},
+		"*":                     {Delay: time.Hour, DelayPercentage: 50},
+	}, nil)
+
+	faults.rand = func() float64 { return 0.4 }
+	if err := faults.inject(context.Background(), "localhost:8080"); err != ErrInjectedFault {
+		t.Errorf("Expected the call to fail, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := faults.inject(ctx, "localhost:8081"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the call to be delayed past its deadline, got %v", err)
+	}
+
+	faults.rand = func() float64 { return 0.6 }
+	if err := faults.inject(context.Background(), "localhost:8080"); err != nil {
+		t.Errorf("Expected no fault, got %v", err)
+	}
+
+	var none *Faults
+	if err := none.inject(context.Background(), "localhost:8080"); err != nil {
+		t.Errorf("Expected no fault, got %v", err)
+	}
+}
+
+func TestFindInjectedFault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blob, err := carbonapi_v2.FindEncoder(types.Matches{
+			Name:    "foo",
+			Matches: []types.Match{{Path: "foo", IsLeaf: true}},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	budgets := NewErrorBudgets(ErrorBudgetConfig{})
+	b, err := New(Config{
+		Address:      server.URL,
+		ErrorBudgets: budgets,
+		Faults:       NewFaults(map[string]Fault{"*": {ErrorPercentage: 100}}, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Find(context.Background(), types.NewFindRequest("foo"))
+	if errors.Cause(err) != ErrInjectedFault {
+		t.Errorf("Expected an injected fault, got %v", err)
+	}
+	if rate := budgets.Get(server.URL).Rate(); rate != 1 {
+		t.Errorf("Expected the fault to count as an error, got an error rate of %v", rate)
+	}
+}
@@ -0,0 +1,17 @@
+// +build go1.24
+
+package net
+
+import (
+	"net/http"
+)
+
+// enableH2C makes t speak HTTP/2 without TLS to http:// backends, and
+// HTTP/2 or HTTP/1.1 to https:// ones.
+func enableH2C(t *http.Transport) error {
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP2(true)
+	t.Protocols.SetUnencryptedHTTP2(true)
+
+	return nil
+}
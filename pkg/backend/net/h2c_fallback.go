@@ -0,0 +1,13 @@
+// +build !go1.24
+
+package net
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+func enableH2C(t *http.Transport) error {
+	return errors.New("h2c needs a build with Go 1.24 or later")
+}
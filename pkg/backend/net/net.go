@@ -1,20 +1,25 @@
 // Package net implements a backend that communicates over a network.
-// It uses HTTP and protocol buffers for communication.
+// It uses HTTP and protocol buffers for communication, or pickle for the
+// backends which don't speak protocol buffers.
 package net
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/bookingcom/carbonapi/pathcache"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/dgryski/go-expirecache"
@@ -44,9 +49,25 @@ type Backend struct {
 	client        *http.Client
 	timeout       time.Duration
 	limiter       chan struct{}
+	adaptive      *AdaptiveLimiter
 	logger        *zap.Logger
 	paths         *expirecache.Cache
+	pathGens      *pathcache.Generations
 	pathExpirySec int32
+	pathJitter    float64
+	pools         *Pools
+	maxSize       int64
+	tooLarge      *expvar.Int
+	budgets       *ErrorBudgets
+	faults        *Faults
+	sanitizer     *Sanitizer
+	zipper        bool
+	// prefix is the path of the address the backend serves its handlers
+	// under, e.g. /graphite for http://host:8080/graphite.
+	prefix string
+	// protocol is the index in protocols of the format the backend is
+	// asked for. It's shared by the copies of the backend, and set by Probe.
+	protocol *int32
 }
 
 // Config configures an HTTP backend.
@@ -55,22 +76,40 @@ type Backend struct {
 // "address[:port]", where address is an IP address or a hostname.
 // Address must be a point that can accept HTTP requests.
 type Config struct {
-	Address string // The backend address.
+	Address string // The backend address, "host:port" or "scheme://host:port/path". The handlers are under the path, if any.
 
 	// Optional fields
-	Client             *http.Client  // The client to use to communicate with backend. Defaults to http.DefaultClient.
-	Timeout            time.Duration // Set request timeout. Defaults to no timeout.
-	Limit              int           // Set limit of concurrent requests to backend. Defaults to no limit.
-	PathCacheExpirySec uint32        // Set time in seconds before items in path cache expire. Defaults to 10 minutes.
-	Logger             *zap.Logger   // Logger to use. Defaults to a no-op logger.
+	Client             *http.Client    // The client to use to communicate with backend. Defaults to http.DefaultClient.
+	Timeout            time.Duration   // Set request timeout. Defaults to no timeout.
+	Limit              int             // Set limit of concurrent requests to backend. Defaults to no limit.
+	PathCacheExpirySec uint32          // Set time in seconds before items in path cache expire. Defaults to 10 minutes.
+	PathCacheJitter    float64         // Randomize the expiry of each path by up to this fraction of it either way. Defaults to none.
+	Logger             *zap.Logger     // Logger to use. Defaults to a no-op logger.
+	Pools              *Pools          // Connection pool statistics to update. Defaults to none.
+	MaxResponseSize    int64           // Maximum size of a response body in bytes. Defaults to no limit.
+	TooLarge           *expvar.Int     // Counts the responses over MaxResponseSize. Optional.
+	ErrorBudgets       *ErrorBudgets   // Error rates to update. Defaults to none.
+	Faults             *Faults         // Faults to inject in the calls. Defaults to none.
+	AdaptiveLimits     *AdaptiveLimits // Adapt the limit of concurrent requests to the latency of the backend, instead of Limit. Defaults to none.
+	Sanitizer          *Sanitizer      // Replace the NaN and ±Inf points of the series of the backend. Defaults to none.
+	Zipper             bool            // The backend is another carbonzipper, which traces the calls to its own backends. Defaults to false.
 }
 
 var fmtProto = []string{"protobuf"}
 
+// protocols are the formats of find and render responses, from the preferred
+// one. Probe falls back to the first one a backend doesn't refuse, so that
+// clusters keep working while their backends are upgraded. Info responses are
+// always protocol buffers, as /info is a carbonapi invention. The carbonapi_v3
+// schema isn't vendored, so v2 is the most recent protocol.
+var protocols = [][]string{fmtProto, {"pickle"}}
+
 // New creates a new backend from the given configuration.
 func New(cfg Config) (*Backend, error) {
 	b := &Backend{
-		paths: expirecache.New(0),
+		paths:    expirecache.New(0),
+		pathGens: pathcache.NewGenerations(),
+		protocol: new(int32),
 	}
 
 	if cfg.PathCacheExpirySec > 0 {
@@ -78,6 +117,7 @@ func New(cfg Config) (*Backend, error) {
 	} else {
 		b.pathExpirySec = int32(10 * time.Minute / time.Second)
 	}
+	b.pathJitter = cfg.PathCacheJitter
 
 	address, scheme, err := parseAddress(cfg.Address)
 	if err != nil {
@@ -86,6 +126,7 @@ func New(cfg Config) (*Backend, error) {
 
 	b.address = address
 	b.scheme = scheme
+	b.prefix = addressPath(cfg.Address)
 
 	if cfg.Timeout > 0 {
 		b.timeout = cfg.Timeout
@@ -99,7 +140,9 @@ func New(cfg Config) (*Backend, error) {
 		b.client = http.DefaultClient
 	}
 
-	if cfg.Limit > 0 {
+	if cfg.AdaptiveLimits != nil {
+		b.adaptive = cfg.AdaptiveLimits.Get(address)
+	} else if cfg.Limit > 0 {
 		b.limiter = make(chan struct{}, cfg.Limit)
 	}
 
@@ -109,6 +152,14 @@ func New(cfg Config) (*Backend, error) {
 		b.logger = zap.New(nil)
 	}
 
+	b.pools = cfg.Pools
+	b.maxSize = cfg.MaxResponseSize
+	b.tooLarge = cfg.TooLarge
+	b.budgets = cfg.ErrorBudgets
+	b.faults = cfg.Faults
+	b.sanitizer = cfg.Sanitizer
+	b.zipper = cfg.Zipper
+
 	return b, nil
 }
 
@@ -125,19 +176,57 @@ func parseAddress(address string) (string, string, error) {
 	return u.Host, u.Scheme, nil
 }
 
+// addressPath returns the path of address, without its trailing /.
+func addressPath(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.Path, "/")
+}
+
 func (b Backend) url(path string) *url.URL {
 	return &url.URL{
 		Scheme: b.scheme,
 		Host:   b.address,
-		Path:   path,
+		Path:   b.prefix + path,
 	}
 }
 
+// Address returns the address of the backend.
+func (b Backend) Address() string {
+	return b.address
+}
+
 func (b Backend) Logger() *zap.Logger {
 	return b.logger
 }
 
+// format returns the format the backend is asked for.
+func (b Backend) format() []string {
+	return protocols[atomic.LoadInt32(b.protocol)]
+}
+
+// sanitize replaces the NaN and ±Inf points of m, if the backend has a
+// sanitizer.
+func (b Backend) sanitize(m *types.Metric) {
+	if n := b.sanitizer.Sanitize(b.address, m); n > 0 {
+		b.logger.Debug("Replaced non-finite points",
+			zap.String("metric", m.Name),
+			zap.Int("points", n),
+		)
+	}
+}
+
 func (b Backend) enter(ctx context.Context) error {
+	if b.adaptive != nil {
+		return b.adaptive.enter(ctx)
+	}
 	if b.limiter == nil {
 		return nil
 	}
@@ -153,7 +242,14 @@ func (b Backend) enter(ctx context.Context) error {
 	return nil
 }
 
-func (b Backend) leave() error {
+// leave frees the slot taken by enter. The latency rtt of the call, and
+// whether it failed, adapt the limit of an adaptive limiter; a zero rtt
+// leaves it be.
+func (b Backend) leave(rtt time.Duration, failed bool) error {
+	if b.adaptive != nil {
+		b.adaptive.leave(rtt, failed)
+		return nil
+	}
 	if b.limiter == nil {
 		return nil
 	}
@@ -183,6 +279,12 @@ func (b Backend) request(ctx context.Context, u *url.URL, body io.Reader) (*http
 		return nil, err
 	}
 	req.URL = u
+	if b.zipper {
+		// Ask a child zipper for the calls to its backends.
+		q := u.Query()
+		q.Set("trace", "1")
+		req.URL.RawQuery = q.Encode()
+	}
 
 	req = req.WithContext(ctx)
 	req = util.MarshalCtx(ctx, req)
@@ -191,6 +293,9 @@ func (b Backend) request(ctx context.Context, u *url.URL, body io.Reader) (*http
 }
 
 func (b Backend) do(ctx context.Context, trace types.Trace, req *http.Request) (string, []byte, error) {
+	req, done := b.pools.Trace(req)
+	defer done()
+
 	t0 := time.Now()
 	resp, err := b.client.Do(req)
 	trace.AddHTTPCall(t0)
@@ -202,25 +307,109 @@ func (b Backend) do(ctx context.Context, trace types.Trace, req *http.Request) (
 	}
 
 	t1 := time.Now()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := util.ReadAll(b.limit(resp.Body))
 	resp.Body.Close()
 	trace.AddReadBody(t1)
 	if err != nil {
-		return "", nil, err
+		return "", nil, b.checkSize(err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		return "", body, ErrHTTPCode(resp.StatusCode)
 	}
+	if b.zipper {
+		b.traceChild(trace, resp.Header)
+	}
 
 	return resp.Header.Get("Content-Type"), body, nil
 }
 
+// doStream makes a request like do, but hands the body of a successful
+// response to decode as it is received instead of reading it whole.
+func (b Backend) doStream(ctx context.Context, trace types.Trace, req *http.Request, decode func(contentType string, body io.Reader) error) error {
+	req, done := b.pools.Trace(req)
+	defer done()
+
+	t0 := time.Now()
+	resp, err := b.client.Do(req)
+	trace.AddHTTPCall(t0)
+	if err != nil {
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ErrHTTPCode(resp.StatusCode)
+	}
+	if b.zipper {
+		b.traceChild(trace, resp.Header)
+	}
+
+	// Reading and decoding are interleaved, so the time spent decoding is
+	// accounted as reading the body.
+	t1 := time.Now()
+	err = decode(resp.Header.Get("Content-Type"), b.limit(resp.Body))
+	trace.AddReadBody(t1)
+
+	return b.checkSize(err)
+}
+
+// limit enforces the maximum response size on body, if any.
+func (b Backend) limit(body io.Reader) io.Reader {
+	if b.maxSize > 0 {
+		return util.LimitReader(body, b.maxSize)
+	}
+
+	return body
+}
+
+// checkSize counts and logs err if it is caused by a response that is too
+// large, and returns it unwrapped so callers can tell it apart.
+func (b Backend) checkSize(err error) error {
+	tooLarge, ok := errors.Cause(err).(util.ErrResponseTooLarge)
+	if !ok {
+		return err
+	}
+
+	if b.tooLarge != nil {
+		b.tooLarge.Add(1)
+	}
+	b.logger.Warn("Backend response too large",
+		zap.String("host", b.address),
+		zap.Int64("limit", int64(tooLarge)),
+	)
+
+	return tooLarge
+}
+
 // Call makes a call to a backend.
 // If the backend timeout is positive, Call will override the context timeout
 // with the backend timeout.
 // Call ensures that the outgoing request has a UUID set.
 func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL, body io.Reader) (string, []byte, error) {
+	var contentType string
+	var resp []byte
+	err := b.callWith(ctx, trace, u, body, func(ctx context.Context, req *http.Request) error {
+		var err error
+		contentType, resp, err = b.do(ctx, trace, req)
+		return err
+	})
+
+	return contentType, resp, err
+}
+
+// callStream makes a call to a backend like call, decoding the response with
+// decode as it is received.
+func (b Backend) callStream(ctx context.Context, trace types.Trace, u *url.URL, body io.Reader, decode func(contentType string, body io.Reader) error) error {
+	return b.callWith(ctx, trace, u, body, func(ctx context.Context, req *http.Request) error {
+		return b.doStream(ctx, trace, req, decode)
+	})
+}
+
+func (b Backend) callWith(ctx context.Context, trace types.Trace, u *url.URL, body io.Reader, do func(context.Context, *http.Request) error) error {
 	ctx, cancel := b.setTimeout(ctx)
 	defer cancel()
 
@@ -228,11 +417,13 @@ func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL, body i
 	err := b.enter(ctx)
 	trace.AddLimiter(t0)
 	if err != nil {
-		return "", nil, err
+		return err
 	}
 
+	var rtt time.Duration
+	var callFailed bool
 	defer func() {
-		if err := b.leave(); err != nil {
+		if err := b.leave(rtt, callFailed); err != nil {
 			b.logger.Error("Backend limiter full",
 				zap.String("host", b.address),
 				zap.String("uuid", util.GetUUID(ctx)),
@@ -245,26 +436,145 @@ func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL, body i
 	req, err := b.request(ctx, u, body)
 	trace.AddMarshal(t1)
 	if err != nil {
-		return "", nil, err
+		return err
+	}
+
+	t2 := time.Now()
+	err = b.faults.inject(ctx, b.address)
+	if err == nil {
+		err = do(ctx, req)
+	}
+	if ctx.Err() != context.Canceled {
+		b.budgets.Record(b.address, failed(err))
+		rtt, callFailed = time.Since(t2), failed(err)
 	}
 
-	return b.do(ctx, trace, req)
+	return err
 }
 
-// Probe performs a single update of the backend's top-level domains.
-func (b *Backend) Probe() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// failed reports whether err means the backend failed to answer, as opposed
+// to not having the data asked for.
+func failed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code, ok := err.(ErrHTTPCode)
+	return !ok || code/100 != 4
+}
 
+// probeTimeout bounds each find of Probe.
+const probeTimeout = 5 * time.Second
+
+// Probe performs a single update of the backend's top-level domains, and of
+// the protocol it's asked for, which is the first of protocols it answers
+// with. It only falls back to the next protocol if the backend refuses the
+// format, with a 400 or a 406, or answers with another content type. The
+// protocol is left as it is if the backend fails otherwise, e.g. with a
+// timeout, and the error returned.
+func (b *Backend) Probe() error {
 	request := types.NewFindRequest("*")
-	matches, err := b.Find(ctx, request)
+	var contentType string
+	var resp []byte
+	var err error
+	for i, format := range protocols {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		u, body := carbonapiV2FindEncoder(b.url("/metrics/find/"), request.Query, format)
+		contentType, resp, err = b.call(ctx, request.Trace, u, body)
+		cancel()
+		if err != nil {
+			if formatRefused(err) {
+				continue
+			}
+			return err
+		}
+		if !formatContentType(format, contentType) {
+			err = errors.Errorf("Unexpected content type '%s' for format %s", contentType, format[0])
+			continue
+		}
+
+		if old := atomic.SwapInt32(b.protocol, int32(i)); old != int32(i) {
+			b.logger.Info("Backend protocol changed",
+				zap.String("host", b.address),
+				zap.String("from", protocols[old][0]),
+				zap.String("to", format[0]),
+			)
+		}
+		break
+	}
 	if err != nil {
-		return
+		return err
+	}
+
+	matches, err := b.decodeFind(request, contentType, resp)
+	if err != nil {
+		return err
 	}
 
 	for _, m := range matches.Matches {
-		b.paths.Set(m.Path, struct{}{}, 0, b.pathExpirySec)
+		b.paths.Set(b.pathGens.Key(m.Path), struct{}{}, 0, b.pathExpiry())
+	}
+
+	return nil
+}
+
+// formatRefused reports whether a backend answered err because it doesn't
+// support the format it was asked for.
+func formatRefused(err error) bool {
+	code, ok := err.(ErrHTTPCode)
+	return ok && (code == http.StatusBadRequest || code == http.StatusNotAcceptable)
+}
+
+// formatContentType reports whether contentType is the one of a response in
+// format.
+func formatContentType(format []string, contentType string) bool {
+	switch format[0] {
+	case "protobuf":
+		return contentType == "application/x-protobuf" || contentType == "application/protobuf"
+	case "pickle":
+		return contentType == "application/pickle"
+	}
+
+	return false
+}
+
+// Warm opens up to conns connections to the backend with as many concurrent
+// finds of its top-level domains, so that they are left idle in the pool of
+// its client for the first requests, which then don't wait to connect. It
+// returns the error of one of the finds that failed, if any.
+func (b *Backend) Warm(ctx context.Context, conns int) error {
+	errs := make(chan error, conns)
+	for i := 0; i < conns; i++ {
+		go func() {
+			_, err := b.find(ctx, types.NewFindRequest("*"), b.format())
+			errs <- err
+		}()
+	}
+
+	var err error
+	for i := 0; i < conns; i++ {
+		if e := <-errs; e != nil {
+			err = e
+		}
 	}
+
+	return err
+}
+
+// pathExpiry returns the expiry of a path set in the path cache now, in
+// seconds. It is jittered so that the paths set together don't expire
+// together, and send the requests for them to all backends at once.
+func (b Backend) pathExpiry() int32 {
+	if b.pathJitter <= 0 {
+		return b.pathExpirySec
+	}
+
+	expiry := b.pathExpirySec + int32(float64(b.pathExpirySec)*b.pathJitter*(2*rand.Float64()-1))
+	if expiry < 1 {
+		return 1
+	}
+
+	return expiry
 }
 
 // TODO(gmagnusson): Should Contains become something different, where instead
@@ -276,7 +586,7 @@ func (b *Backend) Probe() {
 // Contains reports whether the backend contains any of the given targets.
 func (b Backend) Contains(targets []string) bool {
 	for _, target := range targets {
-		if _, ok := b.paths.Get(target); ok {
+		if _, ok := b.paths.Get(b.pathGens.Key(target)); ok {
 			return true
 		}
 	}
@@ -284,6 +594,13 @@ func (b Backend) Contains(targets []string) bool {
 	return false
 }
 
+// Invalidate forgets the paths of the backend under the top-level domain of
+// prefix, or all of them if prefix is empty, so that the requests for them are
+// sent to all backends until it is found to have them again.
+func (b Backend) Invalidate(prefix string) {
+	b.pathGens.Invalidate(prefix)
+}
+
 // Render fetches raw metrics from a backend.
 func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
 	from := request.From
@@ -292,11 +609,62 @@ func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]typ
 
 	t0 := time.Now()
 	u := b.url("/render/")
-	u, body := carbonapiV2RenderEncoder(u, from, until, targets)
+	u, body := carbonapiV2RenderEncoder(u, from, until, targets, b.format())
+	if b.zipper && request.ConsolidateBy != types.ConsolidateDefault {
+		// A child zipper downsamples the series with the hint too.
+		q := u.Query()
+		q.Set("consolidateBy", request.ConsolidateBy.String())
+		u.RawQuery = q.Encode()
+	}
 	request.Trace.AddMarshal(t0)
 
-	contentType, resp, err := b.call(ctx, request.Trace, u, body)
+	var metrics []types.Metric
+	err := b.callStream(ctx, request.Trace, u, body, func(contentType string, r io.Reader) error {
+		switch contentType {
+		case "application/x-protobuf", "application/protobuf":
+			err := carbonapi_v2.RenderStreamDecoder(r, func(m types.Metric) error {
+				m.Host = b.address
+				b.sanitize(&m)
+				b.paths.Set(b.pathGens.Key(m.Name), struct{}{}, 0, b.pathExpiry())
+				metrics = append(metrics, m)
+				return nil
+			})
+			return errors.Wrap(err, "Unmarshal failed")
+
+		case "application/pickle":
+			blob, err := util.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			ms, err := pickle.RenderDecoder(blob)
+			if err != nil {
+				return errors.Wrap(err, "Unmarshal failed")
+			}
+			for _, m := range ms {
+				m.Host = b.address
+				b.sanitize(&m)
+				b.paths.Set(b.pathGens.Key(m.Name), struct{}{}, 0, b.pathExpiry())
+				metrics = append(metrics, m)
+			}
+			return nil
+
+		/* TODO(gmagnusson)
+		case "application/json":
+
+		case "application/x-msgpack":
+
+		case "application/x-carbonapi-v3-pb":
+		*/
+
+		default:
+			return errors.Errorf("Unknown content type '%s'", contentType)
+		}
+	})
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			// The client went away, which is no timeout.
+			return nil, ctx.Err()
+		}
 		if ctx.Err() != nil {
 			return nil, types.ErrTimeout{ctx.Err()}
 		}
@@ -308,49 +676,17 @@ func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]typ
 		return nil, err
 	}
 
-	t1 := time.Now()
-	defer func() {
-		request.Trace.AddUnmarshal(t1)
-	}()
-	var metrics []types.Metric
-
-	switch contentType {
-	case "application/x-protobuf", "application/protobuf":
-		metrics, err = carbonapi_v2.RenderDecoder(resp)
-
-	/* TODO(gmagnusson)
-	case "application/json":
-
-	case "application/pickle":
-
-	case "application/x-msgpack":
-
-	case "application/x-carbonapi-v3-pb":
-	*/
-
-	default:
-		return nil, errors.Errorf("Unknown content type '%s'", contentType)
-	}
-
-	if err != nil {
-		return metrics, errors.Wrap(err, "Unmarshal failed")
-	}
-
 	if len(metrics) == 0 {
 		return nil, types.ErrMetricsNotFound
 	}
 
-	for _, metric := range metrics {
-		b.paths.Set(metric.Name, struct{}{}, 0, b.pathExpirySec)
-	}
-
 	return metrics, nil
 }
 
-func carbonapiV2RenderEncoder(u *url.URL, from int32, until int32, targets []string) (*url.URL, io.Reader) {
+func carbonapiV2RenderEncoder(u *url.URL, from int32, until int32, targets []string, format []string) (*url.URL, io.Reader) {
 	vals := url.Values{
 		"target": targets,
-		"format": fmtProto,
+		"format": format,
 		"from":   []string{strconv.Itoa(int(from))},
 		"until":  []string{strconv.Itoa(int(until))},
 	}
@@ -412,15 +748,24 @@ func carbonapiV2InfoEncoder(u *url.URL, metric string) (*url.URL, io.Reader) {
 
 // Find resolves globs and finds metrics in a backend.
 func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	return b.find(ctx, request, b.format())
+}
+
+// find is Find asking for a response in format.
+func (b Backend) find(ctx context.Context, request types.FindRequest, format []string) (types.Matches, error) {
 	query := request.Query
 
 	t0 := time.Now()
 	u := b.url("/metrics/find/")
-	u, body := carbonapiV2FindEncoder(u, query)
+	u, body := carbonapiV2FindEncoder(u, query, format)
 	request.Trace.AddMarshal(t0)
 
 	contentType, resp, err := b.call(ctx, request.Trace, u, body)
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			// The client went away, which is no timeout.
+			return types.Matches{}, ctx.Err()
+		}
 		if ctx.Err() != nil {
 			return types.Matches{}, types.ErrTimeout{ctx.Err()}
 		}
@@ -432,21 +777,30 @@ func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Mat
 		return types.Matches{}, err
 	}
 
+	return b.decodeFind(request, contentType, resp)
+}
+
+// decodeFind decodes the response to a find request, and caches the paths of
+// its leaves.
+func (b Backend) decodeFind(request types.FindRequest, contentType string, resp []byte) (types.Matches, error) {
 	t1 := time.Now()
 	defer func() {
 		request.Trace.AddUnmarshal(t1)
 	}()
 	var matches types.Matches
+	var err error
 
 	switch contentType {
 	case "application/x-protobuf", "application/protobuf":
 		matches, err = carbonapi_v2.FindDecoder(resp)
 
+	case "application/pickle":
+		matches, err = pickle.FindDecoder(resp)
+		matches.Name = request.Query
+
 	/* TODO(gmagnusson)
 	case "application/json":
 
-	case "application/pickle":
-
 	case "application/x-msgpack":
 
 	case "application/x-carbonapi-v3-pb":
@@ -466,17 +820,17 @@ func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Mat
 
 	for _, match := range matches.Matches {
 		if match.IsLeaf {
-			b.paths.Set(match.Path, struct{}{}, 0, b.pathExpirySec)
+			b.paths.Set(b.pathGens.Key(match.Path), struct{}{}, 0, b.pathExpiry())
 		}
 	}
 
 	return matches, nil
 }
 
-func carbonapiV2FindEncoder(u *url.URL, query string) (*url.URL, io.Reader) {
+func carbonapiV2FindEncoder(u *url.URL, query string, format []string) (*url.URL, io.Reader) {
 	vals := url.Values{
 		"query":  []string{query},
-		"format": fmtProto,
+		"format": format,
 	}
 	u.RawQuery = vals.Encode()
 
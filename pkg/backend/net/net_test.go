@@ -3,15 +3,22 @@ package net
 import (
 	"bytes"
 	"context"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
+	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/dgryski/go-expirecache"
 )
@@ -87,6 +94,30 @@ func TestContains(t *testing.T) {
 	}
 }
 
+func TestInvalidate(t *testing.T) {
+	b, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"virt", "virt.v1.a", "foo.bar"} {
+		b.paths.Set(b.pathGens.Key(path), struct{}{}, 0, 30)
+	}
+
+	b.Invalidate("virt.v1")
+	if b.Contains([]string{"virt"}) || b.Contains([]string{"virt.v1.a"}) {
+		t.Error("Expected the paths under virt to be invalidated")
+	}
+	if !b.Contains([]string{"foo.bar"}) {
+		t.Error("Expected the paths under foo to be kept")
+	}
+
+	b.Invalidate("")
+	if b.Contains([]string{"foo.bar"}) {
+		t.Error("Expected all the paths to be invalidated")
+	}
+}
+
 func TestCall(t *testing.T) {
 	exp := []byte("OK")
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -113,6 +144,34 @@ func TestCall(t *testing.T) {
 	}
 }
 
+func TestCallResponseTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	tooLarge := new(expvar.Int)
+	b, err := New(Config{
+		Address:         server.URL,
+		Client:          server.Client(),
+		MaxResponseSize: 5,
+		TooLarge:        tooLarge,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, _, err = b.call(context.Background(), types.NewTrace(), b.url("/render"), nil)
+	if err != util.ErrResponseTooLarge(5) {
+		t.Errorf("Expected %v, got %v", util.ErrResponseTooLarge(5), err)
+	}
+
+	if tooLarge.Value() != 1 {
+		t.Errorf("Expected 1 response too large, got %d", tooLarge.Value())
+	}
+}
+
 func TestCallServerError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad", 500)
@@ -358,7 +417,7 @@ func TestExitNilLimiter(t *testing.T) {
 		return
 	}
 
-	if err := b.leave(); err != nil {
+	if err := b.leave(0, false); err != nil {
 		t.Error("Expected to leave limiter")
 	}
 }
@@ -374,7 +433,7 @@ func TestEnterExitLimiter(t *testing.T) {
 		t.Error("Expected to enter limiter")
 	}
 
-	if err := b.leave(); err != nil {
+	if err := b.leave(0, false); err != nil {
 		t.Error("Expected to leave limiter")
 	}
 }
@@ -386,7 +445,7 @@ func TestEnterExitLimiterError(t *testing.T) {
 		return
 	}
 
-	if err := b.leave(); err == nil {
+	if err := b.leave(0, false); err == nil {
 		t.Error("Expected to get error")
 	}
 }
@@ -434,7 +493,7 @@ func TestCarbonapiv2RenderEncoder(t *testing.T) {
 	var until int32 = 200
 	metrics := []string{"foo", "bar"}
 
-	gotURL, gotReader := carbonapiV2RenderEncoder(u, from, until, metrics)
+	gotURL, gotReader := carbonapiV2RenderEncoder(u, from, until, metrics, fmtProto)
 	if gotReader != nil {
 		t.Error("Expected nil reader")
 	}
@@ -474,7 +533,7 @@ func TestCarbonapiv2InfoEncoder(t *testing.T) {
 func TestCarbonapiv2FindEncoder(t *testing.T) {
 	u := &url.URL{}
 
-	gotURL, gotReader := carbonapiV2FindEncoder(u, "foo")
+	gotURL, gotReader := carbonapiV2FindEncoder(u, "foo", fmtProto)
 	if gotReader != nil {
 		t.Error("Expected nil reader")
 	}
@@ -486,3 +545,277 @@ func TestCarbonapiv2FindEncoder(t *testing.T) {
 	}
 
 }
+
+func TestProbeProtocolFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("format") != "pickle" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var blob []byte
+		switch r.URL.Path {
+		case "/metrics/find/":
+			blob, _ = pickle.FindEncoderV0_9(types.Matches{Matches: []types.Match{{Path: "foo", IsLeaf: false}}})
+		case "/render/":
+			blob, _ = pickle.RenderEncoder([]types.Metric{{
+				Name:      "foo.bar",
+				StartTime: 100,
+				StopTime:  200,
+				StepTime:  50,
+				Values:    []float64{1, 0},
+				IsAbsent:  []bool{false, true},
+			}})
+		}
+		w.Header().Set("Content-Type", "application/pickle")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Probe(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.format()[0]; got != "pickle" {
+		t.Fatalf("Expected the pickle protocol, got %s", got)
+	}
+	if !b.Contains([]string{"foo"}) {
+		t.Error("Expected the probed top-level domain")
+	}
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"foo.bar"}, 100, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "foo.bar" || metrics[0].Values[0] != 1 || !metrics[0].IsAbsent[1] {
+		t.Errorf("Bad metrics %+v", metrics)
+	}
+}
+
+func TestProbeProtocolNoFallback(t *testing.T) {
+	var pickles int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("format") == "pickle" {
+			atomic.AddInt32(&pickles, 1)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Probe(); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if got := b.format()[0]; got != "protobuf" {
+		t.Errorf("Expected the protobuf protocol, got %s", got)
+	}
+	if pickles != 0 {
+		t.Errorf("Expected no pickle request, got %d", pickles)
+	}
+}
+
+func TestProbeProtocolContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A backend that ignores the format it's asked for.
+		blob, _ := pickle.FindEncoderV0_9(types.Matches{Matches: []types.Match{{Path: "foo", IsLeaf: false}}})
+		w.Header().Set("Content-Type", "application/pickle")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Probe(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.format()[0]; got != "pickle" {
+		t.Errorf("Expected the pickle protocol, got %s", got)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	const conns = 4
+
+	var arrived sync.WaitGroup
+	arrived.Add(conns)
+	var opened, requests int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the finds of the warm-up until they all arrive, so that none
+		// reuses the connection of another.
+		if atomic.AddInt32(&requests, 1) <= conns {
+			arrived.Done()
+			arrived.Wait()
+		}
+
+		blob, _ := carbonapi_v2.FindEncoder(types.Matches{Matches: []types.Match{{Path: "foo"}}})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&opened, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Warm(ctx, conns); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&opened); got != conns {
+		t.Fatalf("Expected %d connections, got %d", conns, got)
+	}
+
+	// The next request reuses an idle connection.
+	if _, err := b.Find(ctx, types.NewFindRequest("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&opened); got != conns {
+		t.Errorf("Expected no new connection, got %d connections", got)
+	}
+}
+
+func TestZipperTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("trace") != "1" {
+			t.Errorf("Expected the child zipper to be asked for its trace, got %s", r.URL.RawQuery)
+		}
+
+		blob, _ := carbonapi_v2.FindEncoder(types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo.bar", IsLeaf: true}}})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("X-Carbonzipper-Backends", "a:8080;status=ok;dur=1.5ms;items=1, b:8080;status=error;dur=2.0ms;items=0, "+
+			"c:8080;status=ok;dur=3.0ms;items=1, c:8080/d:8080;status=timeout;dur=1000.0ms;items=0, c:8080/e:8080;status=not_found;dur=1.0ms;items=0")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+		Zipper:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := types.NewFindRequest("foo")
+	request.IncCall()
+	if _, err := b.Find(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	// a, b, d and e count in place of the child, and c, a zipper, doesn't.
+	if got := request.Calls(); got != 4 {
+		t.Errorf("Expected 4 calls, got %d", got)
+	}
+	if got := request.Failures(); got != 2 {
+		t.Errorf("Expected 2 failures, got %d", got)
+	}
+
+	calls := request.BackendCalls()
+	if len(calls) != 5 {
+		t.Fatalf("Expected 5 backend calls, got %+v", calls)
+	}
+	child := b.Address() + "/"
+	if c := calls[0]; c.Address != child+"a:8080" || c.Err != nil || c.Items != 1 || c.Duration != 1500*time.Microsecond {
+		t.Errorf("Bad backend call %+v", c)
+	}
+	if c := calls[3]; c.Address != child+"c:8080/d:8080" {
+		t.Errorf("Bad backend call %+v", c)
+	} else if _, ok := c.Err.(types.ErrTimeout); !ok {
+		t.Errorf("Expected a timeout, got %v", c.Err)
+	}
+}
+
+func TestZipperConsolidateBy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("consolidateBy"); got != "sum" {
+			t.Errorf("Expected the child zipper to be sent consolidateBy=sum, got %s", r.URL.RawQuery)
+		}
+
+		blob, _ := carbonapi_v2.RenderEncoder([]types.Metric{{
+			Name:      "foo",
+			StartTime: 100,
+			StopTime:  120,
+			StepTime:  10,
+			Values:    []float64{1, 2},
+			IsAbsent:  []bool{false, false},
+		}})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+		Zipper:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := types.NewRenderRequest([]string{"foo"}, 100, 120)
+	request.ConsolidateBy = types.ConsolidateSum
+	if _, err := b.Render(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddressPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphite/metrics/find/" {
+			t.Errorf("Expected the find under the path of the address, got %s", r.URL.Path)
+		}
+
+		blob, _ := carbonapi_v2.FindEncoder(types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo", IsLeaf: true}}})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL + "/graphite/",
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Find(context.Background(), types.NewFindRequest("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Address(); got != strings.TrimPrefix(server.URL, "http://") {
+		t.Errorf("Expected the address without the path, got %s", got)
+	}
+}
@@ -0,0 +1,212 @@
+package net
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolStats describes the connections of a transport to one backend.
+type PoolStats struct {
+	Open       *expvar.Int // Connections currently open.
+	InUse      *expvar.Int // Connections currently serving a request.
+	Idle       expvar.Func // Connections open but not in use.
+	Waits      *expvar.Int // Requests that found no idle connection and waited for a new one.
+	WaitTimeNS *expvar.Int // Total time requests spent waiting for a connection.
+	Dials      *expvar.Int // Connection attempts.
+	DialErrors *expvar.Int // Failed connection attempts.
+}
+
+func newPoolStats() *PoolStats {
+	s := &PoolStats{
+		Open:       new(expvar.Int),
+		InUse:      new(expvar.Int),
+		Waits:      new(expvar.Int),
+		WaitTimeNS: new(expvar.Int),
+		Dials:      new(expvar.Int),
+		DialErrors: new(expvar.Int),
+	}
+	s.Idle = expvar.Func(func() interface{} {
+		return s.Open.Value() - s.InUse.Value()
+	})
+
+	return s
+}
+
+// Vars returns the statistics by name, for publishing them.
+func (s *PoolStats) Vars() map[string]expvar.Var {
+	return map[string]expvar.Var{
+		"open":         s.Open,
+		"in_use":       s.InUse,
+		"idle":         s.Idle,
+		"waits":        s.Waits,
+		"wait_time_ns": s.WaitTimeNS,
+		"dials":        s.Dials,
+		"dial_errors":  s.DialErrors,
+	}
+}
+
+// Pools keeps the connection pool statistics of a transport, by backend
+// "host:port" address.
+type Pools struct {
+	mu    sync.Mutex
+	stats map[string]*PoolStats
+}
+
+// NewPools creates an empty set of pool statistics.
+func NewPools() *Pools {
+	return &Pools{stats: make(map[string]*PoolStats)}
+}
+
+// Get returns the statistics of the pool to the given backend, which may be
+// a "host:port" address or any backend address accepted by New.
+func (p *Pools) Get(address string) *PoolStats {
+	address = poolAddress(address)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.stats[address]
+	if !ok {
+		s = newPoolStats()
+		p.stats[address] = s
+	}
+
+	return s
+}
+
+// Addresses returns the addresses of the known pools, sorted.
+func (p *Pools) Addresses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return sortedKeys(p.stats)
+}
+
+// sortedKeys returns the keys of m, a map keyed by address, sorted.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	addresses := make([]string, len(keys))
+	for i, key := range keys {
+		addresses[i] = key.String()
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// Snapshot returns the current value of all statistics by address, for
+// expvar.
+func (p *Pools) Snapshot() interface{} {
+	snapshot := make(map[string]map[string]int64)
+	for _, address := range p.Addresses() {
+		s := p.Get(address)
+		snapshot[address] = map[string]int64{
+			"open":         s.Open.Value(),
+			"in_use":       s.InUse.Value(),
+			"idle":         s.Open.Value() - s.InUse.Value(),
+			"waits":        s.Waits.Value(),
+			"wait_time_ns": s.WaitTimeNS.Value(),
+			"dials":        s.Dials.Value(),
+			"dial_errors":  s.DialErrors.Value(),
+		}
+	}
+
+	return snapshot
+}
+
+// poolAddress returns the "host:port" address the transport dials for a
+// backend address.
+func poolAddress(address string) string {
+	host, scheme, err := parseAddress(address)
+	if err != nil {
+		return address
+	}
+
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dial counts the connections made by d.
+func (p *Pools) dial(d dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		s := p.Get(address)
+		s.Dials.Add(1)
+
+		conn, err := d(ctx, network, address)
+		if err != nil {
+			s.DialErrors.Add(1)
+			return nil, err
+		}
+		s.Open.Add(1)
+
+		return &countedConn{Conn: conn, stats: s}, nil
+	}
+}
+
+// Trace returns req instrumented to count the connection it uses, and a
+// function to call once the response body is closed. A nil Pools leaves req
+// alone.
+func (p *Pools) Trace(req *http.Request) (*http.Request, func()) {
+	if p == nil {
+		return req, func() {}
+	}
+
+	var s *PoolStats
+	var t0 time.Time
+	var gotConn bool
+
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			s = p.Get(hostPort)
+			t0 = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.InUse.Add(1)
+			gotConn = true
+			if !info.Reused {
+				s.Waits.Add(1)
+			}
+			s.WaitTimeNS.Add(time.Since(t0).Nanoseconds())
+		},
+	}
+
+	done := func() {
+		if gotConn {
+			s.InUse.Add(-1)
+		}
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), done
+}
+
+type countedConn struct {
+	net.Conn
+	stats *PoolStats
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.stats.Open.Add(-1)
+	})
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,71 @@
+package net
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoolAddress(t *testing.T) {
+	for input, expected := range map[string]string{
+		"localhost:8080":         "localhost:8080",
+		"http://localhost:8080":  "localhost:8080",
+		"http://localhost":       "localhost:80",
+		"https://localhost":      "localhost:443",
+		"http://[::1]":           "[::1]:80",
+		"https://10.0.0.1:10443": "10.0.0.1:10443",
+	} {
+		if got := poolAddress(input); got != expected {
+			t.Errorf("poolAddress(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestPoolsCountConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	pools := NewPools()
+	transport, err := NewTransport(TransportConfig{MaxIdleConnsPerHost: 1, Pools: pools})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, done := pools.Trace(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if got := pools.Get(server.URL).InUse.Value(); got != 1 {
+			t.Errorf("Expected 1 connection in use, got %d", got)
+		}
+		done()
+	}
+
+	s := pools.Get(server.URL)
+	if s.Dials.Value() != 1 || s.DialErrors.Value() != 0 {
+		t.Errorf("Expected 1 dial and no errors, got %d and %d", s.Dials.Value(), s.DialErrors.Value())
+	}
+	if s.Open.Value() != 1 || s.InUse.Value() != 0 || s.Waits.Value() != 1 {
+		t.Errorf("Expected 1 open connection, none in use and 1 wait, got %d, %d and %d",
+			s.Open.Value(), s.InUse.Value(), s.Waits.Value())
+	}
+
+	transport.CloseIdleConnections()
+	if got := s.Open.Value(); got != 0 {
+		t.Errorf("Expected no open connections, got %d", got)
+	}
+}
@@ -0,0 +1,100 @@
+package net
+
+import (
+	"expvar"
+	"math"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// SanitizerConfig configures a Sanitizer.
+type SanitizerConfig struct {
+	// Replacement is the value NaN and ±Inf points are replaced with.
+	// Defaults to making them absent.
+	Replacement *float64
+}
+
+// Sanitizer replaces the NaN and ±Inf points of the series of the backends,
+// which can't be encoded in JSON, and counts them by backend "host:port"
+// address.
+type Sanitizer struct {
+	config SanitizerConfig
+
+	mu     sync.Mutex
+	counts map[string]*expvar.Int
+}
+
+// NewSanitizer creates a sanitizer that has replaced no points.
+func NewSanitizer(config SanitizerConfig) *Sanitizer {
+	return &Sanitizer{
+		config: config,
+		counts: make(map[string]*expvar.Int),
+	}
+}
+
+// Get returns the number of points replaced in the series of the given
+// backend, which may be a "host:port" address or any backend address
+// accepted by New.
+func (s *Sanitizer) Get(address string) *expvar.Int {
+	if host, _, err := parseAddress(address); err == nil {
+		address = host
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counts[address]
+	if !ok {
+		c = new(expvar.Int)
+		s.counts[address] = c
+	}
+
+	return c
+}
+
+// Addresses returns the sorted addresses of the backends with a counter.
+func (s *Sanitizer) Addresses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortedKeys(s.counts)
+}
+
+// Snapshot returns the number of points replaced by address, for expvar.
+func (s *Sanitizer) Snapshot() interface{} {
+	snapshot := make(map[string]int64)
+	for _, address := range s.Addresses() {
+		snapshot[address] = s.Get(address).Value()
+	}
+
+	return snapshot
+}
+
+// Sanitize replaces the NaN and ±Inf points of m, a series of the backend at
+// address, and returns how many there were. A nil Sanitizer does nothing.
+func (s *Sanitizer) Sanitize(address string, m *types.Metric) int {
+	if s == nil {
+		return 0
+	}
+
+	n := 0
+	for i, v := range m.Values {
+		if m.IsAbsent[i] || !math.IsNaN(v) && !math.IsInf(v, 0) {
+			continue
+		}
+
+		n++
+		if s.config.Replacement != nil {
+			m.Values[i] = *s.config.Replacement
+		} else {
+			m.Values[i] = 0
+			m.IsAbsent[i] = true
+		}
+	}
+	if n > 0 {
+		s.Get(address).Add(int64(n))
+	}
+
+	return n
+}
@@ -0,0 +1,49 @@
+package net
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestSanitizer(t *testing.T) {
+	metric := func() types.Metric {
+		return types.Metric{
+			Name:     "foo",
+			Values:   []float64{1, math.NaN(), math.Inf(1), math.Inf(-1), math.NaN()},
+			IsAbsent: []bool{false, false, false, false, true},
+		}
+	}
+
+	s := NewSanitizer(SanitizerConfig{})
+	m := metric()
+	if n := s.Sanitize("localhost:8080", &m); n != 3 {
+		t.Errorf("Expected 3 points replaced, got %d", n)
+	}
+	if expected := []bool{false, true, true, true, true}; !reflect.DeepEqual(m.IsAbsent, expected) {
+		t.Errorf("Expected the points to be absent, got %v", m.IsAbsent)
+	}
+	if m.Values[0] != 1 || m.Values[1] != 0 || m.Values[2] != 0 || m.Values[3] != 0 {
+		t.Errorf("Expected the values of absent points to be 0, got %v", m.Values)
+	}
+
+	replacement := -1.0
+	s = NewSanitizer(SanitizerConfig{Replacement: &replacement})
+	m = metric()
+	s.Sanitize("http://localhost:8080", &m)
+	s.Sanitize("localhost:8080", &m)
+	if m.Values[1] != -1 || m.Values[2] != -1 || m.Values[3] != -1 || m.IsAbsent[3] {
+		t.Errorf("Expected the points to be replaced with -1, got %v %v", m.Values, m.IsAbsent)
+	}
+	if got := s.Get("localhost:8080").Value(); got != 3 {
+		t.Errorf("Expected 3 points counted, got %d", got)
+	}
+
+	var nilSanitizer *Sanitizer
+	m = metric()
+	if n := nilSanitizer.Sanitize("localhost:8080", &m); n != 0 || !math.IsNaN(m.Values[1]) {
+		t.Errorf("Expected a nil sanitizer to leave the points alone")
+	}
+}
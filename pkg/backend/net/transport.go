@@ -0,0 +1,79 @@
+package net
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Values of TransportConfig.HTTP2.
+const (
+	HTTP2Off = ""    // Use HTTP/1.1.
+	HTTP2TLS = "h2"  // Use HTTP/2 over TLS when the backend supports it.
+	HTTP2H2C = "h2c" // Use HTTP/2 without TLS, with prior knowledge.
+)
+
+// TransportConfig configures the HTTP transport used to talk to backends.
+// Zero values keep the net/http defaults, which means no limit for the
+// timeouts.
+type TransportConfig struct {
+	HTTP2                 string        // One of HTTP2Off, HTTP2TLS or HTTP2H2C.
+	ConnectTimeout        time.Duration // Timeout to establish a TCP connection.
+	KeepAliveInterval     time.Duration // Interval between TCP keep-alive probes.
+	MaxIdleConnsPerHost   int           // Idle connections kept per backend.
+	TLSHandshakeTimeout   time.Duration // Timeout for the TLS handshake.
+	ResponseHeaderTimeout time.Duration // Timeout to receive the response headers once the request is sent.
+	IdleConnTimeout       time.Duration // Time after which an idle connection is closed.
+
+	// DNSRefreshInterval periodically closes the idle connections, so that
+	// new ones are made to the addresses the backend names resolve to now.
+	// Zero keeps connections until IdleConnTimeout.
+	DNSRefreshInterval time.Duration
+
+	Pools *Pools // If set, counts the connections made by the transport.
+}
+
+// NewTransport creates an HTTP transport from the given configuration.
+func NewTransport(cfg TransportConfig) (*http.Transport, error) {
+	dial := (&net.Dialer{
+		Timeout:   cfg.ConnectTimeout,
+		KeepAlive: cfg.KeepAliveInterval,
+		DualStack: true,
+	}).DialContext
+	if cfg.Pools != nil {
+		dial = cfg.Pools.dial(dial)
+	}
+
+	t := &http.Transport{
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		DialContext:           dial,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+	}
+
+	switch cfg.HTTP2 {
+	case HTTP2Off:
+	case HTTP2TLS:
+		// A custom dialer disables HTTP/2 unless it is asked for explicitly.
+		t.ForceAttemptHTTP2 = true
+	case HTTP2H2C:
+		if err := enableH2C(t); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown HTTP/2 mode '%s'", cfg.HTTP2)
+	}
+
+	if cfg.DNSRefreshInterval > 0 {
+		go func() {
+			for range time.Tick(cfg.DNSRefreshInterval) {
+				t.CloseIdleConnections()
+			}
+		}()
+	}
+
+	return t, nil
+}
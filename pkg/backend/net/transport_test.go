@@ -0,0 +1,33 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTransport(t *testing.T) {
+	tr, err := NewTransport(TransportConfig{
+		HTTP2:                 HTTP2TLS,
+		MaxIdleConnsPerHost:   10,
+		ResponseHeaderTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tr.ForceAttemptHTTP2 {
+		t.Error("Expected HTTP/2 to be enabled")
+	}
+	if tr.MaxIdleConnsPerHost != 10 {
+		t.Errorf("Expected 10 idle connections per host, got %d", tr.MaxIdleConnsPerHost)
+	}
+	if tr.ResponseHeaderTimeout != time.Second {
+		t.Errorf("Expected response header timeout of 1s, got %s", tr.ResponseHeaderTimeout)
+	}
+}
+
+func TestNewTransportUnknownHTTP2(t *testing.T) {
+	if _, err := NewTransport(TransportConfig{HTTP2: "spdy"}); err == nil {
+		t.Error("Expected an error")
+	}
+}
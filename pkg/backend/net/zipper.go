@@ -0,0 +1,124 @@
+package net
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+// backendsHeader lists the backends a zipper sent a request to, when asked
+// with trace=1, e.g. "host1:8080;status=ok;dur=12.3ms;items=4".
+const backendsHeader = "X-Carbonzipper-Backends"
+
+// traceChild records in trace the calls a child zipper made to its backends
+// for a request, from the backends header of its response, under the address
+// of the child. They count in the calls and failures of trace in place of the
+// call to the child, so that a failing backend of a child counts once, and
+// not the child answering for it.
+func (b Backend) traceChild(trace types.Trace, header http.Header) {
+	calls := parseBackendCalls(header.Get(backendsHeader))
+	if len(calls) == 0 {
+		return
+	}
+
+	var leaves, failures int64
+	for _, c := range calls {
+		if !isLeaf(c.Address, calls) {
+			// A grandchild zipper, counted by its own backends.
+			continue
+		}
+		leaves++
+		if childFailed(c.Err) {
+			failures++
+		}
+	}
+	trace.AddChildCalls(leaves, failures)
+
+	for _, c := range calls {
+		c.Address = b.address + "/" + c.Address
+		trace.AddChildCall(c)
+	}
+}
+
+// isLeaf reports whether address is a backend of the calls, as opposed to a
+// zipper that made some of them.
+func isLeaf(address string, calls []types.BackendCall) bool {
+	for _, c := range calls {
+		if strings.HasPrefix(c.Address, address+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// childFailed reports whether a backend call of a child zipper failed with
+// err, as the child counts it: not having the data, or the client going
+// away, is no failure.
+func childFailed(err error) bool {
+	if _, ok := err.(types.ErrNotFound); ok {
+		return false
+	}
+
+	return err != nil && err != context.Canceled
+}
+
+// parseBackendCalls parses the backends header of a zipper. The entries it
+// can't parse are skipped.
+func parseBackendCalls(header string) []types.BackendCall {
+	if header == "" {
+		return nil
+	}
+
+	var calls []types.BackendCall
+	for _, entry := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ";")
+		if fields[0] == "" {
+			continue
+		}
+
+		c := types.BackendCall{Address: fields[0]}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			switch kv[0] {
+			case "status":
+				c.Err = statusError(kv[1])
+			case "dur":
+				if ms, err := strconv.ParseFloat(strings.TrimSuffix(kv[1], "ms"), 64); err == nil {
+					c.Duration = time.Duration(ms * float64(time.Millisecond))
+				}
+			case "items":
+				c.Items, _ = strconv.Atoi(kv[1])
+			}
+		}
+		calls = append(calls, c)
+	}
+
+	return calls
+}
+
+// statusError is the error of a backend call listed with status.
+func statusError(status string) error {
+	switch status {
+	case "ok":
+		return nil
+	case "not_found":
+		return types.ErrMetricsNotFound
+	case "timeout":
+		return types.ErrTimeout{context.DeadlineExceeded}
+	case "canceled":
+		return context.Canceled
+	}
+
+	return errors.New(status)
+}
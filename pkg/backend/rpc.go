@@ -18,7 +18,9 @@ package backend
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/util"
@@ -33,9 +35,11 @@ type Backend interface {
 	Info(context.Context, types.InfoRequest) ([]types.Info, error)
 	Render(context.Context, types.RenderRequest) ([]types.Metric, error)
 
+	Address() string        // The address of the backend, to report the backends that failed.
 	Contains([]string) bool // Reports whether a backend contains any of the given targets.
 	Logger() *zap.Logger    // A logger used to communicate non-fatal warnings.
-	Probe()                 // Probe updates internal state of the backend.
+	Probe() error           // Probe updates internal state of the backend, and fails if it doesn't answer.
+	Invalidate(string)      // Invalidate forgets the paths of the backend under the top-level domain of a prefix.
 }
 
 // TODO(gmagnusson): ^ Remove IsAbsent: IsAbsent[i] => Values[i] == NaN
@@ -44,6 +48,20 @@ type Backend interface {
 // needs an if IsAbsent[i] check anyway, which is also expensive if we're
 // worrying about those levels of performance in the first place.
 
+// backendError is the error of a call to a backend, with its address.
+type backendError struct {
+	address string
+	err     error
+}
+
+func (e backendError) Error() string {
+	return e.err.Error()
+}
+
+func (e backendError) Cause() error {
+	return e.err
+}
+
 // Renders makes Render calls to multiple backends.
 func Renders(ctx context.Context, backends []Backend, request types.RenderRequest) ([]types.Metric, error) {
 	if len(backends) == 0 {
@@ -55,9 +73,11 @@ func Renders(ctx context.Context, backends []Backend, request types.RenderReques
 	for _, backend := range backends {
 		request.IncCall()
 		go func(b Backend) {
+			t0 := time.Now()
 			msg, err := b.Render(ctx, request)
+			request.AddBackendCall(b.Address(), t0, len(msg), err)
 			if err != nil {
-				errCh <- err
+				errCh <- backendError{address: b.Address(), err: err}
 			} else {
 				msgCh <- msg
 			}
@@ -72,6 +92,7 @@ func Renders(ctx context.Context, backends []Backend, request types.RenderReques
 			msgs = append(msgs, msg)
 		case err := <-errCh:
 			errs = append(errs, err)
+			countFailure(ctx, request.Trace, err)
 		}
 	}
 
@@ -79,7 +100,7 @@ func Renders(ctx context.Context, backends []Backend, request types.RenderReques
 		return nil, err
 	}
 
-	return types.MergeMetrics(msgs), nil
+	return types.MergeMetricsBy(msgs, request.ConsolidateBy)
 }
 
 // Infos makes Info calls to multiple backends.
@@ -93,9 +114,11 @@ func Infos(ctx context.Context, backends []Backend, request types.InfoRequest) (
 	for _, backend := range backends {
 		request.IncCall()
 		go func(b Backend) {
+			t0 := time.Now()
 			msg, err := b.Info(ctx, request)
+			request.AddBackendCall(b.Address(), t0, len(msg), err)
 			if err != nil {
-				errCh <- err
+				errCh <- backendError{address: b.Address(), err: err}
 			} else {
 				msgCh <- msg
 			}
@@ -110,6 +133,7 @@ func Infos(ctx context.Context, backends []Backend, request types.InfoRequest) (
 			msgs = append(msgs, msg)
 		case err := <-errCh:
 			errs = append(errs, err)
+			countFailure(ctx, request.Trace, err)
 		}
 	}
 
@@ -131,9 +155,11 @@ func Finds(ctx context.Context, backends []Backend, request types.FindRequest) (
 	for _, backend := range backends {
 		request.IncCall()
 		go func(b Backend) {
+			t0 := time.Now()
 			msg, err := b.Find(ctx, request)
+			request.AddBackendCall(b.Address(), t0, len(msg.Matches), err)
 			if err != nil {
-				errCh <- err
+				errCh <- backendError{address: b.Address(), err: err}
 			} else {
 				msgCh <- msg
 			}
@@ -148,6 +174,7 @@ func Finds(ctx context.Context, backends []Backend, request types.FindRequest) (
 			msgs = append(msgs, msg)
 		case err := <-errCh:
 			errs = append(errs, err)
+			countFailure(ctx, request.Trace, err)
 		}
 	}
 
@@ -158,14 +185,40 @@ func Finds(ctx context.Context, backends []Backend, request types.FindRequest) (
 	return types.MergeMatches(msgs), nil
 }
 
+// countFailure counts err as a failure of a backend to answer, unless it only
+// didn't have the data, or the client went away.
+func countFailure(ctx context.Context, trace types.Trace, err error) {
+	if ctx.Err() == context.Canceled {
+		return
+	}
+
+	if _, ok := errors.Cause(err).(types.ErrNotFound); !ok {
+		trace.IncFailure()
+	}
+}
+
 func getTLD(metric string) string {
 	return strings.SplitN(metric, ".", 2)[0]
 }
 
+// The routes Route picks backends by.
+const (
+	RoutePath = "path" // The backends contain the targets.
+	RouteTLD  = "tld"  // The backends contain the top-level domains of the targets.
+	RouteAll  = "all"  // No backend contains them, so all may.
+)
+
 // Filter filters the given backends by whether they Contain() the given targets.
 func Filter(backends []Backend, targets []string) []Backend {
+	bs, _ := Route(backends, targets)
+	return bs
+}
+
+// Route returns the backends Filter picks for targets, and the route they
+// are picked by.
+func Route(backends []Backend, targets []string) ([]Backend, string) {
 	if bs := filter(backends, targets); len(bs) > 0 {
-		return bs
+		return bs, RoutePath
 	}
 
 	tlds := make([]string, 0, len(targets))
@@ -174,10 +227,10 @@ func Filter(backends []Backend, targets []string) []Backend {
 	}
 
 	if bs := filter(backends, tlds); len(bs) > 0 {
-		return bs
+		return bs, RouteTLD
 	}
 
-	return backends
+	return backends, RouteAll
 }
 
 func filter(backends []Backend, targets []string) []Backend {
@@ -197,7 +250,18 @@ func checkErrs(ctx context.Context, errs []error, limit int, logger *zap.Logger)
 	}
 
 	if len(errs) >= limit {
-		return errors.WithMessage(combineErrors(errs), "All backend requests failed")
+		addresses := make([]string, 0, len(errs))
+		for _, err := range errs {
+			if e, ok := err.(backendError); ok {
+				addresses = append(addresses, e.address)
+			}
+		}
+		sort.Strings(addresses)
+
+		return types.ErrBackends{
+			Backends: addresses,
+			Err:      errors.WithMessage(combineErrors(errs), "All backend requests failed"),
+		}
 	}
 
 	logger.Warn("Some requests failed",
@@ -41,6 +41,37 @@ func TestFilterNoneContains(t *testing.T) {
 	}
 }
 
+func TestRoute(t *testing.T) {
+	backends := []Backend{
+		mock.New(mock.Config{
+			Address:  "store1:8080",
+			Contains: func(targets []string) bool { return targets[0] == "a.b" },
+		}),
+		mock.New(mock.Config{
+			Address:  "store2:8080",
+			Contains: func(targets []string) bool { return targets[0] == "a" },
+		}),
+	}
+
+	for _, tt := range []struct {
+		target  string
+		address string
+		route   string
+	}{
+		{"a.b", "store1:8080", RoutePath},
+		{"a.c", "store2:8080", RouteTLD},
+	} {
+		got, route := Route(backends, []string{tt.target})
+		if len(got) != 1 || got[0].Address() != tt.address || route != tt.route {
+			t.Errorf("Expected %s to route to %s by %s, got %d backends by %s", tt.target, tt.address, tt.route, len(got), route)
+		}
+	}
+
+	if got, route := Route(backends, []string{"b.c"}); len(got) != 2 || route != RouteAll {
+		t.Errorf("Expected b.c to route to all backends, got %d by %s", len(got), route)
+	}
+}
+
 func TestCarbonapiv2InfosEmpty(t *testing.T) {
 	got, err := Infos(context.Background(), []Backend{}, types.NewInfoRequest(""))
 	if err != nil {
@@ -269,3 +300,107 @@ func TestCheckErrs(t *testing.T) {
 		t.Error("Expected no error")
 	}
 }
+
+func TestFailingBackends(t *testing.T) {
+	fail := func(context.Context, types.FindRequest) (types.Matches, error) {
+		return types.Matches{}, errors.New("no")
+	}
+	backends := []Backend{
+		mock.New(mock.Config{Address: "store2:8080", Find: fail}),
+		mock.New(mock.Config{Address: "store1:8080", Find: fail}),
+	}
+
+	_, err := Finds(context.Background(), backends, types.NewFindRequest("a.*"))
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	got := types.FailingBackends(err)
+	if len(got) != 2 || got[0] != "store1:8080" || got[1] != "store2:8080" {
+		t.Errorf("Expected the two backends, got %v", got)
+	}
+}
+
+func TestRendersCountsFailures(t *testing.T) {
+	backends := []Backend{
+		mock.New(mock.Config{
+			Render: func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+				return nil, errors.New("no")
+			},
+		}),
+		mock.New(mock.Config{
+			Render: func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+				return nil, types.ErrMetricsNotFound
+			},
+		}),
+		mock.New(mock.Config{}),
+	}
+
+	request := types.NewRenderRequest([]string{"a"}, 0, 1)
+	if _, err := Renders(context.Background(), backends, request); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := request.Failures(); got != 1 {
+		t.Errorf("Expected 1 failure, got %d", got)
+	}
+	if got := request.Calls(); got != 3 {
+		t.Errorf("Expected 3 calls, got %d", got)
+	}
+}
+
+func TestRendersClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backends := []Backend{
+		mock.New(mock.Config{
+			Render: func(ctx context.Context, _ types.RenderRequest) ([]types.Metric, error) {
+				cancel()
+				return nil, ctx.Err()
+			},
+		}),
+		mock.New(mock.Config{}),
+	}
+
+	request := types.NewRenderRequest([]string{"a"}, 0, 1)
+	Renders(ctx, backends, request)
+
+	if got := request.Failures(); got != 0 {
+		t.Errorf("Expected no failures, got %d", got)
+	}
+}
+
+func TestFindsRecordsBackendCalls(t *testing.T) {
+	backends := []Backend{
+		mock.New(mock.Config{
+			Address: "store2:8080",
+			Find: func(context.Context, types.FindRequest) (types.Matches, error) {
+				return types.Matches{}, errors.New("no")
+			},
+		}),
+		mock.New(mock.Config{
+			Address: "store1:8080",
+			Find: func(context.Context, types.FindRequest) (types.Matches, error) {
+				return types.Matches{
+					Name:    "a.*",
+					Matches: []types.Match{{Path: "a.b"}, {Path: "a.c"}},
+				}, nil
+			},
+		}),
+	}
+
+	request := types.NewFindRequest("a.*")
+	if _, err := Finds(context.Background(), backends, request); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := request.BackendCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 calls, got %d", len(calls))
+	}
+	if calls[0].Address != "store1:8080" || calls[0].Items != 2 || calls[0].Err != nil {
+		t.Errorf("Expected 2 matches from store1:8080, got %+v", calls[0])
+	}
+	if calls[1].Address != "store2:8080" || calls[1].Err == nil {
+		t.Errorf("Expected store2:8080 to fail, got %+v", calls[1])
+	}
+}
@@ -0,0 +1,104 @@
+package carbonconf
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Aggregation is a section of storage-aggregation.conf: the points of the
+// metrics matching Pattern are aggregated with Method when they're rolled up
+// to a coarser archive.
+type Aggregation struct {
+	Name         string
+	Pattern      *regexp.Regexp
+	Method       string
+	XFilesFactor float64
+}
+
+// Aggregations are the aggregations of storage-aggregation.conf, in order.
+// The first matching aggregation of a metric is its aggregation, as in
+// carbon.
+type Aggregations []Aggregation
+
+// aggregationMethods are the aggregation methods of carbon.
+var aggregationMethods = map[string]bool{
+	"average":  true,
+	"sum":      true,
+	"min":      true,
+	"max":      true,
+	"last":     true,
+	"avg_zero": true,
+	"absmax":   true,
+	"absmin":   true,
+}
+
+// ReadAggregations reads the storage-aggregation.conf at path.
+func ReadAggregations(path string) (Aggregations, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseAggregations(f)
+}
+
+// ParseAggregations parses a storage-aggregation.conf. The method and
+// xFilesFactor of a section default to carbon's, average and 0.5.
+func ParseAggregations(r io.Reader) (Aggregations, error) {
+	sections, err := parseSections(r)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregations := make(Aggregations, 0, len(sections))
+	for _, s := range sections {
+		if _, ok := s.values["pattern"]; !ok {
+			return nil, errors.Errorf("aggregation '%s' has no pattern", s.name)
+		}
+		pattern, err := regexp.Compile(s.values["pattern"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad pattern of aggregation '%s'", s.name)
+		}
+
+		method := "average"
+		if m, ok := s.values["aggregationmethod"]; ok {
+			method = m
+		}
+		if !aggregationMethods[method] {
+			return nil, errors.Errorf("unknown aggregation method '%s' of aggregation '%s'", method, s.name)
+		}
+
+		xff := 0.5
+		if x, ok := s.values["xfilesfactor"]; ok {
+			xff, err = strconv.ParseFloat(x, 64)
+			if err != nil || xff < 0 || xff > 1 {
+				return nil, errors.Errorf("bad xFilesFactor '%s' of aggregation '%s'", x, s.name)
+			}
+		}
+
+		aggregations = append(aggregations, Aggregation{
+			Name:         s.name,
+			Pattern:      pattern,
+			Method:       method,
+			XFilesFactor: xff,
+		})
+	}
+
+	return aggregations, nil
+}
+
+// Match returns the aggregation of metric, if any.
+func (a Aggregations) Match(metric string) (Aggregation, bool) {
+	for _, aggregation := range a {
+		if aggregation.Pattern.MatchString(metric) {
+			return aggregation, true
+		}
+	}
+
+	return Aggregation{}, false
+}
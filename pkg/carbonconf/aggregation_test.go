@@ -0,0 +1,61 @@
+package carbonconf
+
+import (
+	"strings"
+	"testing"
+)
+
+const testAggregations = `
+[min]
+pattern = \.lower$
+xFilesFactor = 0.1
+aggregationMethod = min
+
+[count]
+pattern = \.count$
+xFilesFactor = 0
+aggregationMethod = sum
+
+[default_average]
+pattern = .*
+`
+
+func TestParseAggregations(t *testing.T) {
+	aggregations, err := ParseAggregations(strings.NewReader(testAggregations))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aggregations) != 3 {
+		t.Fatalf("Expected 3 aggregations, got %d", len(aggregations))
+	}
+
+	tests := []struct {
+		metric string
+		method string
+		xff    float64
+	}{
+		{"foo.latency.lower", "min", 0.1},
+		{"foo.requests.count", "sum", 0},
+		{"foo.gauge", "average", 0.5},
+	}
+	for _, tt := range tests {
+		a, ok := aggregations.Match(tt.metric)
+		if !ok || a.Method != tt.method || a.XFilesFactor != tt.xff {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", tt.metric, tt.method, tt.xff, a.Method, a.XFilesFactor)
+		}
+	}
+}
+
+func TestParseAggregationsErrors(t *testing.T) {
+	for _, conf := range []string{
+		"[a]\naggregationMethod = sum",
+		"[a]\npattern = (",
+		"[a]\npattern = .*\naggregationMethod = median",
+		"[a]\npattern = .*\nxFilesFactor = 2",
+	} {
+		if _, err := ParseAggregations(strings.NewReader(conf)); err == nil {
+			t.Errorf("Expected an error parsing %q", conf)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package carbonconf reads the configuration files of carbon that describe
+// how metrics are stored, so that the zipper can tell what the backends can
+// serve.
+package carbonconf
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Retention is an archive of a storage schema: points SecondsPerPoint apart,
+// NumberOfPoints of them.
+type Retention struct {
+	SecondsPerPoint int32
+	NumberOfPoints  int32
+}
+
+// Duration returns how long the points of r are kept, in seconds.
+func (r Retention) Duration() int32 {
+	return r.SecondsPerPoint * r.NumberOfPoints
+}
+
+// Schema is a section of storage-schemas.conf: the metrics matching Pattern
+// are stored in the archives of Retentions, finest first.
+type Schema struct {
+	Name       string
+	Pattern    *regexp.Regexp
+	Retentions []Retention
+}
+
+// Schemas are the schemas of storage-schemas.conf, in order. The first
+// matching schema of a metric is its schema, as in carbon.
+type Schemas []Schema
+
+// ReadSchemas reads the storage-schemas.conf at path.
+func ReadSchemas(path string) (Schemas, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseSchemas(f)
+}
+
+// ParseSchemas parses a storage-schemas.conf.
+func ParseSchemas(r io.Reader) (Schemas, error) {
+	sections, err := parseSections(r)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(Schemas, 0, len(sections))
+	for _, s := range sections {
+		if _, ok := s.values["pattern"]; !ok {
+			return nil, errors.Errorf("schema '%s' has no pattern", s.name)
+		}
+		pattern, err := regexp.Compile(s.values["pattern"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad pattern of schema '%s'", s.name)
+		}
+
+		retentions, err := ParseRetentions(s.values["retentions"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad retentions of schema '%s'", s.name)
+		}
+
+		schemas = append(schemas, Schema{
+			Name:       s.name,
+			Pattern:    pattern,
+			Retentions: retentions,
+		})
+	}
+
+	return schemas, nil
+}
+
+// ParseRetentions parses the retentions of a schema, e.g.
+// "10s:6h,1m:7d,10m:5y" or "60:1440", finest first.
+func ParseRetentions(s string) ([]Retention, error) {
+	var retentions []Retention
+	for _, def := range strings.Split(s, ",") {
+		def = strings.TrimSpace(def)
+		parts := strings.Split(def, ":")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("bad retention '%s'", def)
+		}
+
+		step, err := parseSeconds(parts[0])
+		if err != nil || step <= 0 {
+			return nil, errors.Errorf("bad precision of retention '%s'", def)
+		}
+
+		var points int64
+		if n, err := strconv.ParseInt(parts[1], 10, 32); err == nil {
+			points = n
+		} else if d, err := parseSeconds(parts[1]); err == nil {
+			points = d / step
+		}
+		if points <= 0 || step*points > 1<<31-1 {
+			return nil, errors.Errorf("bad points of retention '%s'", def)
+		}
+
+		retentions = append(retentions, Retention{
+			SecondsPerPoint: int32(step),
+			NumberOfPoints:  int32(points),
+		})
+	}
+
+	sort.SliceStable(retentions, func(i, j int) bool {
+		return retentions[i].SecondsPerPoint < retentions[j].SecondsPerPoint
+	})
+
+	return retentions, nil
+}
+
+// units are the multipliers of the units of retentions, which may be
+// abbreviated as in carbon: "m" and "min" are minutes.
+var units = []struct {
+	name    string
+	seconds int64
+}{
+	{"seconds", 1},
+	{"minutes", 60},
+	{"hours", 3600},
+	{"days", 86400},
+	{"weeks", 604800},
+	{"years", 31536000},
+}
+
+// parseSeconds parses a number of seconds, or of a unit such as "5min".
+func parseSeconds(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		return strconv.ParseInt(s, 10, 64)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := strings.ToLower(s[i:])
+	for _, u := range units {
+		if strings.HasPrefix(u.name, unit) {
+			return n * u.seconds, nil
+		}
+	}
+
+	return 0, errors.Errorf("unknown unit '%s'", s[i:])
+}
+
+// Match returns the schema of metric, if any.
+func (s Schemas) Match(metric string) (Schema, bool) {
+	for _, schema := range s {
+		if schema.Pattern.MatchString(metric) {
+			return schema, true
+		}
+	}
+
+	return Schema{}, false
+}
+
+// archive returns the retention of the archive serving the points from from
+// at now: the finest one that still has them, or else the coarsest one.
+func (s Schema) archive(from, now int32) Retention {
+	for _, r := range s.Retentions {
+		if now-from <= r.Duration() {
+			return r
+		}
+	}
+
+	return s.Retentions[len(s.Retentions)-1]
+}
+
+// Clamp returns the part of the range from-until that the archives of s
+// still have at now, aligned to the step of the archive serving it, and that
+// step. It returns false if there's nothing left of the range.
+func (s Schema) Clamp(from, until, now int32) (int32, int32, int32, bool) {
+	if len(s.Retentions) == 0 {
+		return from, until, 0, from < until
+	}
+
+	if until > now {
+		until = now
+	}
+	if oldest := now - s.Retentions[len(s.Retentions)-1].Duration(); from < oldest {
+		from = oldest
+	}
+
+	step := s.archive(from, now).SecondsPerPoint
+	from -= from % step
+	until -= until % step
+
+	return from, until, step, from < until
+}
+
+// Step returns the step of the series of metric starting at start, served at
+// now, or 0 if metric has no schema.
+func (s Schemas) Step(metric string, start, now int32) int32 {
+	schema, ok := s.Match(metric)
+	if !ok || len(schema.Retentions) == 0 {
+		return 0
+	}
+
+	return schema.archive(start, now).SecondsPerPoint
+}
+
+// section is a section of an INI file of carbon.
+type section struct {
+	name   string
+	values map[string]string
+}
+
+// parseSections parses the sections of an INI file of carbon, such as
+// storage-schemas.conf, skipping comments.
+func parseSections(r io.Reader) ([]section, error) {
+	var sections []section
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") || strings.HasPrefix(l, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			sections = append(sections, section{
+				name:   strings.TrimSpace(l[1 : len(l)-1]),
+				values: make(map[string]string),
+			})
+			continue
+		}
+
+		i := strings.IndexAny(l, "=:")
+		if i < 0 || len(sections) == 0 {
+			return nil, errors.Errorf("line %d: expected a section or a key = value", line)
+		}
+		key := strings.ToLower(strings.TrimSpace(l[:i]))
+		sections[len(sections)-1].values[key] = strings.TrimSpace(l[i+1:])
+	}
+
+	return sections, scanner.Err()
+}
@@ -0,0 +1,98 @@
+package carbonconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchemas = `
+# Schema definitions for Whisper files.
+[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[fine]
+pattern = ^fine\.
+retentions = 1m:7d,10s:6h, 10min:5y
+
+[default]
+pattern = .*
+retentions = 60s:1d
+`
+
+func TestParseSchemas(t *testing.T) {
+	schemas, err := ParseSchemas(strings.NewReader(testSchemas))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schemas) != 3 {
+		t.Fatalf("Expected 3 schemas, got %d", len(schemas))
+	}
+
+	expected := []Retention{{10, 2160}, {60, 10080}, {600, 262800}}
+	if schemas[1].Name != "fine" || !reflect.DeepEqual(schemas[1].Retentions, expected) {
+		t.Errorf("Expected the fine schema of %v, got %s of %v", expected, schemas[1].Name, schemas[1].Retentions)
+	}
+	if expected := []Retention{{60, 129600}}; !reflect.DeepEqual(schemas[0].Retentions, expected) {
+		t.Errorf("Expected the carbon schema of %v, got %v", expected, schemas[0].Retentions)
+	}
+
+	if s, ok := schemas.Match("carbon.agents.a.cpu"); !ok || s.Name != "carbon" {
+		t.Errorf("Expected carbon metrics to match the carbon schema, got %s", s.Name)
+	}
+	if s, ok := schemas.Match("other.metric"); !ok || s.Name != "default" {
+		t.Errorf("Expected other metrics to match the default schema, got %s", s.Name)
+	}
+}
+
+func TestParseSchemasErrors(t *testing.T) {
+	for _, conf := range []string{
+		"pattern = .*",
+		"[a]\nretentions = 60:1d",
+		"[a]\npattern = (\nretentions = 60:1d",
+		"[a]\npattern = .*\nretentions = 60",
+		"[a]\npattern = .*\nretentions = 60x:1d",
+		"[a]\npattern = .*\nretentions = 60:0",
+	} {
+		if _, err := ParseSchemas(strings.NewReader(conf)); err == nil {
+			t.Errorf("Expected an error parsing %q", conf)
+		}
+	}
+}
+
+func TestSchemaClamp(t *testing.T) {
+	schemas, err := ParseSchemas(strings.NewReader(testSchemas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fine := schemas[1]
+	now := int32(1000000000)
+
+	tests := []struct {
+		name        string
+		from, until int32
+		expFrom     int32
+		expUntil    int32
+		expStep     int32
+		ok          bool
+	}{
+		{"recent", now - 3605, now + 60, now - 3610, now, 10, true},
+		{"week", now - 86400, now - 3600, now - 86400 - 40, now - 3600 - 40, 60, true},
+		{"too old", now - 10*31536000, now - 86400, now - 5*31536000 - 400, now - 86400 - 400, 600, true},
+		{"all expired", now - 10*31536000, now - 9*31536000, now - 5*31536000 - 400, now - 9*31536000 - 400, 600, false},
+	}
+
+	for _, tt := range tests {
+		from, until, step, ok := fine.Clamp(tt.from, tt.until, now)
+		if from != tt.expFrom || until != tt.expUntil || step != tt.expStep || ok != tt.ok {
+			t.Errorf("%s: expected %d-%d at %d (%v), got %d-%d at %d (%v)", tt.name,
+				tt.expFrom, tt.expUntil, tt.expStep, tt.ok, from, until, step, ok)
+		}
+	}
+
+	if step := schemas.Step("fine.a", now-86400, now); step != 60 {
+		t.Errorf("Expected a step of 60, got %d", step)
+	}
+}
@@ -0,0 +1,64 @@
+// Package glob matches the nodes of metric names against the nodes of
+// graphite glob queries.
+package glob
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchNode reports whether the node name matches the glob pattern, which
+// may have * and ? wildcards, [] classes and {} alternatives.
+func MatchNode(pattern, name string) bool {
+	for _, p := range ExpandBraces(pattern) {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExpandBraces returns the patterns of the alternatives of the first {} of
+// pattern, expanded in turn. A { without a closing } is left as it is.
+func ExpandBraces(pattern string) []string {
+	open := strings.IndexByte(pattern, '{')
+	if open == -1 {
+		return []string{pattern}
+	}
+	end := strings.IndexByte(pattern[open:], '}')
+	if end == -1 {
+		return []string{pattern}
+	}
+	end += open
+
+	var patterns []string
+	for _, alt := range strings.Split(pattern[open+1:end], ",") {
+		patterns = append(patterns, ExpandBraces(pattern[:open]+alt+pattern[end+1:])...)
+	}
+
+	return patterns
+}
+
+// Alternatives returns how many patterns ExpandBraces returns for pattern,
+// without nested {}, without expanding them. It stops counting once there
+// are more than max.
+func Alternatives(pattern string, max int) int {
+	n := 1
+	for n <= max {
+		open := strings.IndexByte(pattern, '{')
+		if open == -1 {
+			break
+		}
+		end := strings.IndexByte(pattern[open:], '}')
+		if end == -1 {
+			break
+		}
+		end += open
+
+		n *= strings.Count(pattern[open:end], ",") + 1
+		pattern = pattern[end+1:]
+	}
+
+	return n
+}
@@ -0,0 +1,70 @@
+package glob
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchNode(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"f*", "foo", true},
+		{"f?o", "foo", true},
+		{"f?o", "fooo", false},
+		{"[a-f]oo", "foo", true},
+		{"[a-e]oo", "foo", false},
+		{"{bar,foo}", "foo", true},
+		{"{bar,baz}", "foo", false},
+		{"{f,b}o{o,x}", "box", true},
+		{"{foo", "{foo", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchNode(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("MatchNode(%q, %q): expected %v, got %v", tt.pattern, tt.name, tt.match, got)
+		}
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"foo", []string{"foo"}},
+		{"{a,b}", []string{"a", "b"}},
+		{"x{a,b}y{c,d}", []string{"xayc", "xayd", "xbyc", "xbyd"}},
+		{"a.{b,c}.*", []string{"a.b.*", "a.c.*"}},
+		{"{a,b", []string{"{a,b"}},
+	}
+
+	for _, tt := range tests {
+		if got := ExpandBraces(tt.pattern); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ExpandBraces(%q): expected %v, got %v", tt.pattern, tt.expected, got)
+		}
+	}
+}
+
+func TestAlternatives(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		max      int
+		expected int
+	}{
+		{"foo", 10, 1},
+		{"{a,b}", 10, 2},
+		{"x{a,b}y{c,d,e}", 10, 6},
+		{"{a,b", 10, 1},
+		{"{a,b}{a,b}{a,b}{a,b}{a,b}", 10, 16},
+	}
+
+	for _, tt := range tests {
+		if got := Alternatives(tt.pattern, tt.max); got != tt.expected {
+			t.Errorf("Alternatives(%q, %d): expected %d, got %d", tt.pattern, tt.max, tt.expected, got)
+		}
+	}
+}
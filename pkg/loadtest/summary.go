@@ -0,0 +1,108 @@
+// Package loadtest has the parts shared by the tools sending requests to an
+// instance to measure it.
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Summary collects the status codes and latencies of requests by handler.
+// It is safe for concurrent use.
+type Summary struct {
+	mu        sync.Mutex
+	codes     map[string]map[int]int
+	errors    map[string]int
+	durations map[string][]time.Duration
+
+	// Elapsed is the time it took to send all the requests.
+	Elapsed time.Duration
+}
+
+// NewSummary creates a summary of no requests.
+func NewSummary() *Summary {
+	return &Summary{
+		codes:     make(map[string]map[int]int),
+		errors:    make(map[string]int),
+		durations: make(map[string][]time.Duration),
+	}
+}
+
+// Add counts a request to handler that got a code response after d, or that
+// failed with err before any response.
+func (s *Summary) Add(handler string, code int, err error, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.errors[handler]++
+		return
+	}
+
+	if s.codes[handler] == nil {
+		s.codes[handler] = make(map[int]int)
+	}
+	s.codes[handler][code]++
+	s.durations[handler] = append(s.durations[handler], d)
+}
+
+// Percentile returns the p percentile, from 0 to 1, of the latencies of the
+// responses of handler, or 0 if there is none.
+func (s *Summary) Percentile(handler string, p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.durations[handler]
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+
+	return d[int(float64(len(d)-1)*p)]
+}
+
+// Print writes the number of requests by status code and the latency
+// percentiles of each handler.
+func (s *Summary) Print(w io.Writer) {
+	s.mu.Lock()
+	handlers := make([]string, 0, len(s.codes)+len(s.errors))
+	for h := range s.codes {
+		handlers = append(handlers, h)
+	}
+	for h := range s.errors {
+		if s.codes[h] == nil {
+			handlers = append(handlers, h)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(handlers)
+
+	fmt.Fprintf(w, "sent in %v\n", s.Elapsed)
+	for _, h := range handlers {
+		s.mu.Lock()
+		codes := make([]int, 0, len(s.codes[h]))
+		for c := range s.codes[h] {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+
+		fmt.Fprintf(w, "%s:", h)
+		for _, c := range codes {
+			fmt.Fprintf(w, " %d=%d", c, s.codes[h][c])
+		}
+		if n := s.errors[h]; n > 0 {
+			fmt.Fprintf(w, " errors=%d", n)
+		}
+		responses := len(s.durations[h])
+		s.mu.Unlock()
+
+		if responses > 0 {
+			fmt.Fprintf(w, " p50=%v p90=%v p99=%v max=%v",
+				s.Percentile(h, 0.5), s.Percentile(h, 0.9), s.Percentile(h, 0.99), s.Percentile(h, 1))
+		}
+		fmt.Fprintln(w)
+	}
+}
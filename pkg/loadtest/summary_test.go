@@ -0,0 +1,38 @@
+package loadtest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	s := NewSummary()
+	for i := 1; i <= 100; i++ {
+		s.Add("render", 200, nil, time.Duration(i)*time.Millisecond)
+	}
+	s.Add("render", 500, nil, time.Second)
+	s.Add("find", 0, errors.New("connection refused"), 0)
+
+	if got := s.Percentile("render", 0.5); got != 51*time.Millisecond {
+		t.Errorf("p50: expected 51ms, got %v", got)
+	}
+	if got := s.Percentile("render", 1); got != time.Second {
+		t.Errorf("max: expected 1s, got %v", got)
+	}
+	if got := s.Percentile("find", 0.5); got != 0 {
+		t.Errorf("find p50: expected 0, got %v", got)
+	}
+
+	var out bytes.Buffer
+	s.Elapsed = 2 * time.Second
+	s.Print(&out)
+
+	expected := "sent in 2s\n" +
+		"find: errors=1\n" +
+		"render: 200=100 500=1 p50=51ms p90=91ms p99=100ms max=1s\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache keeps the expressions parsed from the most recently used targets, so
+// that the targets of dashboards refreshed again and again are only parsed
+// once. It is safe for concurrent use.
+type Cache struct {
+	size int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	target string
+	exp    *expr
+	rest   string
+}
+
+// NewCache returns a cache of the expressions of up to size targets. A size of
+// 0 or less disables the cache.
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:  size,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// ParseExpr parses target like ParseExpr, reusing the expression of a previous
+// call with the same target. Only targets that parse are kept. The expression
+// returned is a copy that the caller may modify.
+func (c *Cache) ParseExpr(target string) (Expr, string, error) {
+	if c == nil || c.size <= 0 {
+		return ParseExpr(target)
+	}
+
+	c.mu.Lock()
+	if el, ok := c.items[target]; ok {
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*cacheEntry)
+		c.mu.Unlock()
+
+		atomic.AddUint64(&c.hits, 1)
+		return entry.exp.clone(), entry.rest, nil
+	}
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.misses, 1)
+	exp, rest, err := ParseExpr(target)
+	if err != nil {
+		return exp, rest, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[target]; ok {
+		// parsed concurrently by another request
+		return exp, rest, nil
+	}
+	c.items[target] = c.lru.PushFront(&cacheEntry{
+		target: target,
+		exp:    exp.(*expr).clone(),
+		rest:   rest,
+	})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).target)
+	}
+
+	return exp, rest, nil
+}
+
+// Hits returns how many targets were found in the cache.
+func (c *Cache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+// Misses returns how many targets had to be parsed.
+func (c *Cache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+// Len returns the number of targets in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// clone returns a deep copy of e, as expressions are modified while they are
+// evaluated.
+func (e *expr) clone() *expr {
+	if e == nil {
+		return nil
+	}
+
+	c := *e
+	if e.args != nil {
+		c.args = make([]*expr, len(e.args))
+		for i, arg := range e.args {
+			c.args[i] = arg.clone()
+		}
+	}
+	if e.namedArgs != nil {
+		c.namedArgs = make(map[string]*expr, len(e.namedArgs))
+		for k, arg := range e.namedArgs {
+			c.namedArgs[k] = arg.clone()
+		}
+	}
+
+	return &c
+}
@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+
+	e, rest, err := c.ParseExpr("sumSeries(foo.*,bar)")
+	if err != nil || rest != "" {
+		t.Fatalf("failed to parse: %v, rest %q", err, rest)
+	}
+	if c.Misses() != 1 || c.Hits() != 0 {
+		t.Errorf("got %d hits and %d misses, want 0 and 1", c.Hits(), c.Misses())
+	}
+
+	// Changes to a returned expression don't leak into the cache
+	e.SetRawArgs("foo.*")
+	e.Args()[0].SetTarget("changed")
+
+	e, _, _ = c.ParseExpr("sumSeries(foo.*,bar)")
+	if c.Hits() != 1 {
+		t.Errorf("got %d hits, want 1", c.Hits())
+	}
+	if e.RawArgs() != "foo.*,bar" || e.Args()[0].Target() != "foo.*" {
+		t.Errorf("cached expression was modified: %s", e.ToString())
+	}
+
+	if _, _, err := c.ParseExpr("sumSeries(foo"); err == nil {
+		t.Error("expected a parse error")
+	}
+	if c.Len() != 1 {
+		t.Errorf("got %d cached targets, want 1: errors are not cached", c.Len())
+	}
+
+	c.ParseExpr("a")
+	c.ParseExpr("sumSeries(foo.*,bar)")
+	c.ParseExpr("b")
+	if c.Len() != 2 {
+		t.Errorf("got %d cached targets, want 2", c.Len())
+	}
+	hits := c.Hits()
+	c.ParseExpr("a")
+	if c.Hits() != hits {
+		t.Error("least recently used target was not evicted")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := NewCache(0)
+	c.ParseExpr("foo")
+	c.ParseExpr("foo")
+	if c.Hits() != 0 || c.Len() != 0 {
+		t.Errorf("disabled cache got %d hits and %d targets", c.Hits(), c.Len())
+	}
+}
@@ -92,9 +92,15 @@ type Expr interface {
 
 	// Metrics returns list of metric requests
 	Metrics() []MetricRequest
+	// PrimingPoints returns the metric requests whose series need points before From, with how many
+	PrimingPoints() map[MetricRequest]int
+	// ConsolidateBy returns the metric requests whose series are consolidated by consolidateBy, with its function
+	ConsolidateBy() map[MetricRequest]string
 
 	// GetIntervalArg returns interval typed argument.
 	GetIntervalArg(n int, defaultSign int) (int32, error)
+	// GetIntervalNamedOrPosArgDefault returns specific positioned interval-typed argument or replace it with default if none found.
+	GetIntervalNamedOrPosArgDefault(k string, n int, defaultSign int, v int32) (int32, error)
 
 	// GetIntervalArg returns n-th argument as string.
 	GetStringArg(n int) (string, error)
@@ -116,6 +122,8 @@ type Expr interface {
 	GetIntArgs(n int) ([]int, error)
 	// GetIntArgDefault returns n-th argument as int. It will replace it with Default value if none present.
 	GetIntArgDefault(n int, d int) (int, error)
+	// GetNodeOrTagArgs returns the arguments from the n-th on as node indexes or tag names.
+	GetNodeOrTagArgs(n int) ([]NodeOrTag, error)
 	// GetIntNamedOrPosArgDefault returns specific positioned int-typed argument or replace it with default if none found.
 	GetIntNamedOrPosArgDefault(k string, n int, d int) (int, error)
 
@@ -175,6 +183,13 @@ func NewValueExpr(value string) Expr {
 	return e
 }
 
+// NodeOrTag is an argument selecting either a node of a series name, by index,
+// or one of its tags, by name.
+type NodeOrTag struct {
+	IsTag bool
+	Value interface{}
+}
+
 // ArgName is a type for Name Argument
 type ArgName string
 
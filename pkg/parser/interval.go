@@ -2,6 +2,7 @@ package parser
 
 import (
 	"strconv"
+	"strings"
 )
 
 // IntervalString converts a sign and string into a number of seconds
@@ -64,6 +65,65 @@ func IntervalString(s string, defaultSign int) (int32, error) {
 	return totalInterval, nil
 }
 
+// UnitString returns the canonical name of the time unit of an interval,
+// ignoring its count, eg. "days" for both "1d" and "day".
+func UnitString(s string) (string, error) {
+	s = strings.TrimLeft(s, "+-0123456789")
+
+	switch s {
+	case "s", "sec", "secs", "second", "seconds":
+		return "seconds", nil
+	case "m", "min", "mins", "minute", "minutes":
+		return "minutes", nil
+	case "h", "hour", "hours":
+		return "hours", nil
+	case "d", "day", "days":
+		return "days", nil
+	case "w", "week", "weeks":
+		return "weeks", nil
+	case "mon", "month", "months":
+		return "months", nil
+	case "y", "year", "years":
+		return "years", nil
+	}
+
+	return "", ErrUnknownTimeUnits
+}
+
+// IntervalUnit returns the largest of days, hours, minutes or seconds that
+// is not longer than interval.
+func IntervalUnit(interval int32) string {
+	switch {
+	case interval >= 24*60*60:
+		return "days"
+	case interval >= 60*60:
+		return "hours"
+	case interval >= 60:
+		return "minutes"
+	}
+	return "seconds"
+}
+
+// MaxUnitInterval returns the longest duration, in seconds, of a unit
+// returned by UnitString.
+func MaxUnitInterval(unit string) int32 {
+	switch unit {
+	case "minutes":
+		return 60
+	case "hours":
+		return 60 * 60
+	case "days":
+		return 24 * 60 * 60
+	case "weeks":
+		return 7 * 24 * 60 * 60
+	case "months":
+		return 31 * 24 * 60 * 60
+	case "years":
+		return 366 * 24 * 60 * 60
+	}
+	return 0
+}
+
 func TruthyBool(s string) bool {
 	switch s {
 	case "", "0", "false", "False", "no", "No":
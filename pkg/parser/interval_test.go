@@ -51,3 +51,28 @@ func TestInterval(t *testing.T) {
 		}
 	}
 }
+
+func TestUnitString(t *testing.T) {
+	var tests = []struct {
+		s    string
+		unit string
+	}{
+		{"1s", "seconds"},
+		{"min", "minutes"},
+		{"2hours", "hours"},
+		{"1d", "days"},
+		{"weeks", "weeks"},
+		{"3mon", "months"},
+		{"y", "years"},
+	}
+
+	for _, tt := range tests {
+		if unit, err := UnitString(tt.s); err != nil || unit != tt.unit {
+			t.Errorf("UnitString(%q)=%q, %v, want %q\n", tt.s, unit, err, tt.unit)
+		}
+	}
+
+	if _, err := UnitString("1x"); err != ErrUnknownTimeUnits {
+		t.Errorf("UnitString(%q) error=%v, want %v\n", "1x", err, ErrUnknownTimeUnits)
+	}
+}
@@ -128,64 +128,166 @@ func (e *expr) Metrics() []MetricRequest {
 			r = append(r, a.Metrics()...)
 		}
 
-		switch e.target {
-		case "timeShift":
-			offs, err := e.GetIntervalArg(1, -1)
-			if err != nil {
-				return nil
-			}
-			for i := range r {
-				r[i].From += offs
-				r[i].Until += offs
-			}
-		case "timeStack":
-			offs, err := e.GetIntervalArg(1, -1)
-			if err != nil {
-				return nil
+		return e.adjustMetrics(r)
+	}
+
+	return nil
+}
+
+// adjustMetrics adjusts the time ranges of the metric requests r of the
+// arguments of the function e to the ones it needs.
+func (e *expr) adjustMetrics(r []MetricRequest) []MetricRequest {
+	switch e.target {
+	case "timeShift":
+		offs, err := e.GetIntervalArg(1, -1)
+		if err != nil {
+			return nil
+		}
+		for i := range r {
+			r[i].From += offs
+			r[i].Until += offs
+		}
+	case "timeStack":
+		offs, err := e.GetIntervalArg(1, -1)
+		if err != nil {
+			return nil
+		}
+
+		start, err := e.GetIntArg(2)
+		if err != nil {
+			return nil
+		}
+
+		end, err := e.GetIntArg(3)
+		if err != nil {
+			return nil
+		}
+
+		var r2 []MetricRequest
+		for _, v := range r {
+			for i := int32(start); i < int32(end); i++ {
+				r2 = append(r2, MetricRequest{
+					Metric: v.Metric,
+					From:   v.From + (i * offs),
+					Until:  v.Until + (i * offs),
+				})
 			}
+		}
 
-			start, err := e.GetIntArg(2)
+		return r2
+	case "holtWintersForecast", "holtWintersConfidenceBands", "holtWintersConfidenceArea", "holtWintersAberration":
+		// the bootstrapInterval comes after the delta, except for holtWintersForecast
+		n := 2
+		if e.target == "holtWintersForecast" {
+			n = 1
+		}
+		bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", n, 1, 7*86400)
+		if err != nil {
+			return nil
+		}
+		if bootstrapInterval < 0 {
+			bootstrapInterval = -bootstrapInterval
+		}
+		for i := range r {
+			r[i].From -= bootstrapInterval // starts bootstrapInterval before where the original starts
+		}
+	case "smartSummarize":
+		// the first bucket is aligned to the start of a unit, which
+		// may be up to one unit before from
+		interval, err := e.GetIntervalArg(1, 1)
+		if err != nil {
+			return nil
+		}
+		unit := IntervalUnit(interval)
+		alignTo, err := e.GetStringNamedOrPosArgDefault("alignTo", 3, "")
+		if err != nil {
+			return nil
+		}
+		if alignTo != "" {
+			unit, err = UnitString(alignTo)
 			if err != nil {
 				return nil
 			}
-
-			end, err := e.GetIntArg(3)
+		}
+		for i := range r {
+			r[i].From -= MaxUnitInterval(unit)
+		}
+	case "movingAverage", "movingMedian", "movingMin", "movingMax", "movingSum", "exponentialMovingAverage":
+		switch e.args[1].etype {
+		case EtString:
+			offs, err := e.GetIntervalArg(1, 1)
 			if err != nil {
 				return nil
 			}
+			for i := range r {
+				r[i].From -= offs
+			}
+		}
+	}
+	return r
+}
+
+// PrimingPoints returns the metric requests of e whose series a function of
+// an integer window needs points before From for, with how many, e.g. 10 for
+// exponentialMovingAverage(a.b, 10). As the step of the series isn't known
+// before they are fetched, the requests starting that many points earlier
+// can only be made after them.
+func (e *expr) PrimingPoints() map[MetricRequest]int {
+	if e.etype != EtFunc {
+		return nil
+	}
+
+	primed := make(map[MetricRequest]int)
+	add := func(r MetricRequest, n int) {
+		if n > primed[r] {
+			primed[r] = n
+		}
+	}
 
-			var r2 []MetricRequest
-			for _, v := range r {
-				for i := int32(start); i < int32(end); i++ {
-					r2 = append(r2, MetricRequest{
-						Metric: v.Metric,
-						From:   v.From + (i * offs),
-						Until:  v.Until + (i * offs),
-					})
-				}
+	for _, a := range e.args {
+		for r, n := range a.PrimingPoints() {
+			for _, adjusted := range e.adjustMetrics([]MetricRequest{r}) {
+				add(adjusted, n)
 			}
+		}
+	}
 
-			return r2
-		case "holtWintersForecast", "holtWintersConfidenceBands", "holtWintersAberration":
-			for i := range r {
-				r[i].From -= 7 * 86400 // starts -7 days from where the original starts
+	if e.target == "exponentialMovingAverage" && len(e.args) > 1 && e.args[1].etype == EtConst {
+		if n := int(e.args[1].val); n > 0 {
+			for _, r := range e.args[0].Metrics() {
+				add(r, n)
 			}
-		case "movingAverage", "movingMedian", "movingMin", "movingMax", "movingSum":
-			switch e.args[1].etype {
-			case EtString:
-				offs, err := e.GetIntervalArg(1, 1)
-				if err != nil {
-					return nil
-				}
-				for i := range r {
-					r[i].From -= offs
-				}
+		}
+	}
+
+	return primed
+}
+
+// ConsolidateBy returns the metric requests of e whose series are consolidated
+// by consolidateBy, with the name of its function, e.g. "sum" for
+// consolidateBy(a.b, 'sum'), so that the backends can downsample them with
+// it. The outermost consolidateBy of a request wins, as in the evaluation.
+func (e *expr) ConsolidateBy() map[MetricRequest]string {
+	if e.etype != EtFunc {
+		return nil
+	}
+
+	funcs := make(map[MetricRequest]string)
+	for _, a := range e.args {
+		for r, f := range a.ConsolidateBy() {
+			for _, adjusted := range e.adjustMetrics([]MetricRequest{r}) {
+				funcs[adjusted] = f
 			}
 		}
-		return r
 	}
 
-	return nil
+	if e.target == "consolidateBy" && len(e.args) > 1 && e.args[1].etype == EtString {
+		for _, r := range e.args[0].Metrics() {
+			funcs[r] = e.args[1].valStr
+		}
+	}
+
+	return funcs
 }
 
 func (e *expr) GetIntervalArg(n int, defaultSign int) (int32, error) {
@@ -193,11 +295,27 @@ func (e *expr) GetIntervalArg(n int, defaultSign int) (int32, error) {
 		return 0, ErrMissingArgument
 	}
 
-	if e.args[n].etype != EtString {
+	return e.args[n].doGetIntervalArg(defaultSign)
+}
+
+func (e *expr) GetIntervalNamedOrPosArgDefault(k string, n int, defaultSign int, v int32) (int32, error) {
+	if a := e.getNamedArg(k); a != nil {
+		return a.doGetIntervalArg(defaultSign)
+	}
+
+	if len(e.args) <= n {
+		return v, nil
+	}
+
+	return e.args[n].doGetIntervalArg(defaultSign)
+}
+
+func (e *expr) doGetIntervalArg(defaultSign int) (int32, error) {
+	if e.etype != EtString {
 		return 0, ErrBadType
 	}
 
-	seconds, err := IntervalString(e.args[n].valStr, defaultSign)
+	seconds, err := IntervalString(e.valStr, defaultSign)
 	if err != nil {
 		return 0, ErrBadType
 	}
@@ -288,6 +406,26 @@ func (e *expr) GetIntArgDefault(n int, d int) (int, error) {
 	return e.args[n].doGetIntArg()
 }
 
+func (e *expr) GetNodeOrTagArgs(n int) ([]NodeOrTag, error) {
+	if len(e.args) <= n {
+		return nil, ErrMissingArgument
+	}
+
+	nodeTags := make([]NodeOrTag, 0, len(e.args)-n)
+	for _, arg := range e.args[n:] {
+		switch arg.etype {
+		case EtConst:
+			nodeTags = append(nodeTags, NodeOrTag{Value: int(arg.val)})
+		case EtString:
+			nodeTags = append(nodeTags, NodeOrTag{IsTag: true, Value: arg.valStr})
+		default:
+			return nil, ErrBadType
+		}
+	}
+
+	return nodeTags, nil
+}
+
 func (e *expr) GetIntNamedOrPosArgDefault(k string, n int, d int) (int, error) {
 	if a := e.getNamedArg(k); a != nil {
 		return a.doGetIntArg()
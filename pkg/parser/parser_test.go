@@ -373,3 +373,66 @@ func TestParseExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestPrimingPoints(t *testing.T) {
+	tests := []struct {
+		s    string
+		want map[MetricRequest]int
+	}{
+		{"sumSeries(a.*)", map[MetricRequest]int{}},
+		{`exponentialMovingAverage(a.*,"1min")`, map[MetricRequest]int{}},
+		{"exponentialMovingAverage(a.*,10)", map[MetricRequest]int{{Metric: "a.*"}: 10}},
+		{
+			"exponentialMovingAverage(sumSeries(a.*,exponentialMovingAverage(b,20)),10)",
+			map[MetricRequest]int{{Metric: "a.*"}: 10, {Metric: "b"}: 20},
+		},
+		{
+			`timeShift(exponentialMovingAverage(a,5),"1h")`,
+			map[MetricRequest]int{{Metric: "a", From: -3600, Until: -3600}: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.s)
+		if err != nil {
+			t.Errorf("parse for %+v failed: err=%v", tt.s, err)
+			continue
+		}
+		if got := e.PrimingPoints(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("priming points for %+v: got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestConsolidateBy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want map[MetricRequest]string
+	}{
+		{"sumSeries(a.*)", map[MetricRequest]string{}},
+		{`consolidateBy(a.*,"sum")`, map[MetricRequest]string{{Metric: "a.*"}: "sum"}},
+		{
+			`sumSeries(consolidateBy(a.*,"max"),b)`,
+			map[MetricRequest]string{{Metric: "a.*"}: "max"},
+		},
+		{
+			`consolidateBy(consolidateBy(a,"min"),"max")`,
+			map[MetricRequest]string{{Metric: "a"}: "max"},
+		},
+		{
+			`timeShift(consolidateBy(a,"sum"),"1h")`,
+			map[MetricRequest]string{{Metric: "a", From: -3600, Until: -3600}: "sum"},
+		},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.s)
+		if err != nil {
+			t.Errorf("parse for %+v failed: err=%v", tt.s, err)
+			continue
+		}
+		if got := e.ConsolidateBy(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("consolidateBy for %+v: got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// Consolidation is a function used to combine several points of a series into
+// one when the series is downsampled.
+type Consolidation int
+
+const (
+	ConsolidateDefault Consolidation = iota // Use the configured rules, or average.
+	ConsolidateAverage
+	ConsolidateSum
+	ConsolidateMin
+	ConsolidateMax
+	ConsolidateFirst
+	ConsolidateLast
+)
+
+var consolidationNames = map[string]Consolidation{
+	"":        ConsolidateDefault,
+	"avg":     ConsolidateAverage,
+	"average": ConsolidateAverage,
+	"sum":     ConsolidateSum,
+	"min":     ConsolidateMin,
+	"max":     ConsolidateMax,
+	"first":   ConsolidateFirst,
+	"last":    ConsolidateLast,
+}
+
+// ParseConsolidation returns the consolidation function with the given name.
+// The names are the ones accepted by graphite-web's consolidateBy.
+func ParseConsolidation(name string) (Consolidation, error) {
+	c, ok := consolidationNames[name]
+	if !ok {
+		return ConsolidateDefault, fmt.Errorf("unknown consolidation function '%s'", name)
+	}
+
+	return c, nil
+}
+
+// String returns the name of c accepted by ParseConsolidation, or "" for
+// ConsolidateDefault.
+func (c Consolidation) String() string {
+	switch c {
+	case ConsolidateAverage:
+		return "average"
+	case ConsolidateSum:
+		return "sum"
+	case ConsolidateMin:
+		return "min"
+	case ConsolidateMax:
+		return "max"
+	case ConsolidateFirst:
+		return "first"
+	case ConsolidateLast:
+		return "last"
+	}
+
+	return ""
+}
+
+func (c Consolidation) apply(values []float64) float64 {
+	switch c {
+	case ConsolidateSum:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+
+	case ConsolidateMin:
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+
+	case ConsolidateMax:
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+
+	case ConsolidateFirst:
+		return values[0]
+
+	case ConsolidateLast:
+		return values[len(values)-1]
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// ConsolidationRule selects the consolidation function for metrics whose
+// name matches Pattern, in the spirit of carbon's storage-aggregation.conf.
+type ConsolidationRule struct {
+	Pattern       *regexp.Regexp
+	Consolidation Consolidation
+}
+
+var consolidationRules []ConsolidationRule
+
+// SetConsolidationRules sets the rules used to pick a consolidation function
+// for a metric. The first matching rule wins.
+func SetConsolidationRules(rules []ConsolidationRule) {
+	consolidationRules = rules
+}
+
+// consolidationFor resolves the consolidation function to use for a metric.
+// An explicit hint takes precedence over the rules, which take precedence
+// over the configured default.
+func consolidationFor(name string, hint Consolidation) Consolidation {
+	if hint != ConsolidateDefault {
+		return hint
+	}
+
+	for _, rule := range consolidationRules {
+		if rule.Pattern.MatchString(name) {
+			return rule.Consolidation
+		}
+	}
+
+	return stepConsolidation
+}
+
+// schemaStep returns the step the storage schemas give the series of a
+// metric starting at start, or 0 if unknown.
+var schemaStep func(name string, start int32) int32
+
+// SetSchemaSteps sets the function returning the step the storage schemas
+// give the series of a metric starting at a time, or 0 if unknown. Replicas
+// disagreeing on their step are consolidated to it, rather than to the least
+// common multiple of their steps, if it's a multiple of theirs.
+func SetSchemaSteps(f func(name string, start int32) int32) {
+	schemaStep = f
+}
+
+// authoritativeStep returns the step of the storage schema of the replicas
+// of a series, if they can all be consolidated to it, or 0.
+func authoritativeStep(metrics []Metric) int32 {
+	if schemaStep == nil || len(metrics) == 0 {
+		return 0
+	}
+
+	start := metrics[0].StartTime
+	for _, m := range metrics {
+		if m.StartTime < start {
+			start = m.StartTime
+		}
+	}
+
+	step := schemaStep(metrics[0].Name, start)
+	if step <= 0 {
+		return 0
+	}
+	for _, m := range metrics {
+		if m.StepTime <= 0 || step%m.StepTime != 0 {
+			return 0
+		}
+	}
+
+	return step
+}
+
+// Consolidate downsamples a metric to the given step, which must be a multiple
+// of the metric's step. Buckets are aligned to multiples of step, and a bucket
+// with no present points is absent.
+func Consolidate(m Metric, step int32, c Consolidation) Metric {
+	if m.StepTime <= 0 || step <= m.StepTime || step%m.StepTime != 0 || len(m.Values) == 0 {
+		return m
+	}
+
+	start := m.StartTime - m.StartTime%step
+	last := m.StartTime + int32(len(m.Values)-1)*m.StepTime
+	n := int((last-start)/step) + 1
+
+	out := Metric{
+		Name:      m.Name,
+		StartTime: start,
+		StopTime:  start + int32(n)*step,
+		StepTime:  step,
+		Values:    make([]float64, n),
+		IsAbsent:  make([]bool, n),
+		Host:      m.Host,
+	}
+
+	for i := range out.IsAbsent {
+		out.IsAbsent[i] = true
+	}
+
+	// Points are ordered by time, so each bucket is a contiguous run of them.
+	bucket := make([]float64, 0, step/m.StepTime)
+	idx := 0
+	for j := range m.Values {
+		t := m.StartTime + int32(j)*m.StepTime
+		if i := int((t - start) / step); i != idx {
+			flushBucket(&out, idx, bucket, c)
+			bucket, idx = bucket[:0], i
+		}
+
+		if !m.IsAbsent[j] {
+			bucket = append(bucket, m.Values[j])
+		}
+	}
+	flushBucket(&out, idx, bucket, c)
+
+	return out
+}
+
+func flushBucket(m *Metric, i int, bucket []float64, c Consolidation) {
+	if len(bucket) == 0 {
+		return
+	}
+
+	m.Values[i] = c.apply(bucket)
+	m.IsAbsent[i] = false
+}
+
+// commonStep returns the least common multiple of the steps of the given
+// metrics, or 0 if they all have the same step.
+func commonStep(metrics []Metric) int32 {
+	step := int32(0)
+	same := true
+	for _, m := range metrics {
+		if m.StepTime <= 0 {
+			return 0
+		}
+
+		if step == 0 {
+			step = m.StepTime
+			continue
+		}
+
+		if m.StepTime != step {
+			same = false
+		}
+		step = step / gcd(step, m.StepTime) * m.StepTime
+	}
+
+	if same {
+		return 0
+	}
+
+	return step
+}
+
+func gcd(a, b int32) int32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
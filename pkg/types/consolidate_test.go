@@ -0,0 +1,186 @@
+package types
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestConsolidate(t *testing.T) {
+	input := Metric{
+		Name:      "metric",
+		StartTime: 60,
+		StopTime:  300,
+		StepTime:  60,
+		Values:    []float64{1, 2, 3, 0},
+		IsAbsent:  []bool{false, false, false, true},
+	}
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 0,
+		StopTime:  360,
+		StepTime:  120,
+		Values:    []float64{1, 5, 0},
+		IsAbsent:  []bool{false, false, true},
+	}
+
+	got := Consolidate(input, 120, ConsolidateSum)
+	if !MetricsEqual(got, expected) {
+		t.Errorf("Consolidate failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestConsolidateNotMultiple(t *testing.T) {
+	input := Metric{
+		Name:     "metric",
+		StepTime: 60,
+		Values:   []float64{1, 2},
+		IsAbsent: []bool{false, false},
+	}
+
+	got := Consolidate(input, 90, ConsolidateAverage)
+	if !MetricsEqual(got, input) {
+		t.Errorf("Expected metric to be unchanged, got %+v", got)
+	}
+}
+
+func TestCommonStep(t *testing.T) {
+	metrics := []Metric{
+		Metric{StepTime: 60},
+		Metric{StepTime: 90},
+	}
+
+	if got := commonStep(metrics); got != 180 {
+		t.Errorf("Expected common step 180, got %d", got)
+	}
+
+	if got := commonStep(metrics[:1]); got != 0 {
+		t.Errorf("Expected common step 0, got %d", got)
+	}
+}
+
+func TestMergeMetricsNormalizeSteps(t *testing.T) {
+	defer SetStepNormalization(false, ConsolidateDefault)
+	SetStepNormalization(true, ConsolidateAverage)
+
+	input := [][]Metric{
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  240,
+				StepTime:  60,
+				Values:    []float64{1, 3, 0, 0},
+				IsAbsent:  []bool{false, false, true, true},
+			},
+		},
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  240,
+				StepTime:  120,
+				Values:    []float64{0, 4},
+				IsAbsent:  []bool{true, false},
+			},
+		},
+	}
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 0,
+		StopTime:  240,
+		StepTime:  120,
+		Values:    []float64{2, 4},
+		IsAbsent:  []bool{false, false},
+	}
+
+	got, err := MergeMetrics(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || !MetricsEqual(got[0], expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeMetricsSchemaStep(t *testing.T) {
+	defer SetStepNormalization(false, ConsolidateDefault)
+	defer SetSchemaSteps(nil)
+	SetStepNormalization(true, ConsolidateAverage)
+	SetSchemaSteps(func(name string, start int32) int32 {
+		return 240
+	})
+
+	input := [][]Metric{
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  240,
+				StepTime:  60,
+				Values:    []float64{1, 3, 0, 0},
+				IsAbsent:  []bool{false, false, true, true},
+			},
+		},
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  240,
+				StepTime:  120,
+				Values:    []float64{0, 4},
+				IsAbsent:  []bool{true, false},
+			},
+		},
+	}
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 0,
+		StopTime:  240,
+		StepTime:  240,
+		Values:    []float64{2},
+		IsAbsent:  []bool{false},
+	}
+
+	got, err := MergeMetrics(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || !MetricsEqual(got[0], expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+
+	// A schema step that isn't a multiple of the steps is ignored.
+	SetSchemaSteps(func(name string, start int32) int32 {
+		return 90
+	})
+	if step := authoritativeStep(input[1]); step != 0 {
+		t.Errorf("Expected no authoritative step, got %d", step)
+	}
+}
+
+func TestConsolidationFor(t *testing.T) {
+	defer SetConsolidationRules(nil)
+	SetConsolidationRules([]ConsolidationRule{
+		ConsolidationRule{
+			Pattern:       regexp.MustCompile(`\.count$`),
+			Consolidation: ConsolidateSum,
+		},
+	})
+
+	if got := consolidationFor("foo.count", ConsolidateDefault); got != ConsolidateSum {
+		t.Errorf("Expected sum from rules, got %v", got)
+	}
+
+	if got := consolidationFor("foo.count", ConsolidateMax); got != ConsolidateMax {
+		t.Errorf("Expected hint to override rules, got %v", got)
+	}
+
+	if got := consolidationFor("foo.gauge", ConsolidateDefault); got != ConsolidateDefault {
+		t.Errorf("Expected default consolidation, got %v", got)
+	}
+}
@@ -8,11 +8,14 @@ compatible with the original one.
 package carbonapi_v2
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/go-graphite/protocol/carbonapi_v2_pb"
 )
@@ -234,3 +237,94 @@ func RenderDecoder(blob []byte) ([]types.Metric, error) {
 
 	return metrics, nil
 }
+
+// RenderStreamDecoder decodes a MultiFetchResponse read from r one series at
+// a time, calling f with each series as soon as it is decoded. Only a single
+// series is held in memory, instead of the whole response.
+func RenderStreamDecoder(r io.Reader, f func(types.Metric) error) error {
+	br := bufio.NewReader(r)
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
+
+	for {
+		key, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		field, wire := key>>3, key&7
+		if wire != 2 {
+			if err := skipField(br, wire); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if field != 1 {
+			if _, err := br.Discard(int(size)); err != nil {
+				return unexpectedEOF(err)
+			}
+			continue
+		}
+
+		if uint64(cap(*buf)) < size {
+			*buf = make([]byte, size)
+		}
+		*buf = (*buf)[:size]
+		if _, err := io.ReadFull(br, *buf); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		// Unmarshal copies the name and values, so the buffer can be
+		// reused for the next series.
+		var m carbonapi_v2_pb.FetchResponse
+		if err := m.Unmarshal(*buf); err != nil {
+			return err
+		}
+
+		err = f(types.Metric{
+			Name:      m.Name,
+			StartTime: m.StartTime,
+			StopTime:  m.StopTime,
+			StepTime:  m.StepTime,
+			Values:    m.Values,
+			IsAbsent:  m.IsAbsent,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipField skips the value of a field that isn't length-delimited.
+func skipField(r *bufio.Reader, wire uint64) error {
+	var err error
+	switch wire {
+	case 0:
+		_, err = binary.ReadUvarint(r)
+	case 1:
+		_, err = r.Discard(8)
+	case 5:
+		_, err = r.Discard(4)
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wire)
+	}
+
+	return unexpectedEOF(err)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
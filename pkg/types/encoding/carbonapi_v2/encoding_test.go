@@ -1,6 +1,8 @@
 package carbonapi_v2
 
 import (
+	"bytes"
+	"io"
 	"testing"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
@@ -201,3 +203,72 @@ func TestResponseRenderUnmarshal(t *testing.T) {
 		t.Error("Metrics not equal")
 	}
 }
+
+func TestRenderStreamDecoder(t *testing.T) {
+	input := carbonapi_v2_pb.MultiFetchResponse{
+		Metrics: []carbonapi_v2_pb.FetchResponse{
+			carbonapi_v2_pb.FetchResponse{
+				Name:      "A",
+				StartTime: 1,
+				StopTime:  3,
+				StepTime:  1,
+				Values:    []float64{0, 1},
+				IsAbsent:  []bool{true, false},
+			},
+			carbonapi_v2_pb.FetchResponse{
+				Name:      "B",
+				StartTime: 1,
+				StopTime:  2,
+				StepTime:  1,
+				Values:    []float64{2},
+				IsAbsent:  []bool{false},
+			},
+		},
+	}
+
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []types.Metric
+	err = RenderStreamDecoder(bytes.NewReader(blob), func(m types.Metric) error {
+		got = append(got, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := RenderDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(exp) {
+		t.Fatalf("Expected %d metrics, got %d", len(exp), len(got))
+	}
+	for i := range exp {
+		if !types.MetricsEqual(exp[i], got[i]) {
+			t.Errorf("Metric %d mismatch\nExp: %v\nGot: %v", i, exp[i], got[i])
+		}
+	}
+}
+
+func TestRenderStreamDecoderTruncated(t *testing.T) {
+	input := carbonapi_v2_pb.MultiFetchResponse{
+		Metrics: []carbonapi_v2_pb.FetchResponse{
+			carbonapi_v2_pb.FetchResponse{Name: "A", Values: []float64{1}, IsAbsent: []bool{false}},
+		},
+	}
+
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RenderStreamDecoder(bytes.NewReader(blob[:len(blob)-2]), func(types.Metric) error { return nil })
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
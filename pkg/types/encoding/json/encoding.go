@@ -7,6 +7,7 @@ package json
 import (
 	"encoding/json"
 	"math"
+	"sort"
 	"strings"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
@@ -66,6 +67,45 @@ func matchesToJSONMatches(matches types.Matches) []jsonMatch {
 	return jms
 }
 
+type jsonCompleterMatch struct {
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	IsLeaf string `json:"is_leaf"`
+}
+
+// CompleterEncoder encodes a Find response in the format used by the
+// graphite-web composer's metric completer.
+func CompleterEncoder(matches types.Matches) ([]byte, error) {
+	jms := make([]jsonCompleterMatch, 0, len(matches.Matches))
+
+	for _, m := range matches.Matches {
+		jm := jsonCompleterMatch{
+			Path:   m.Path,
+			IsLeaf: "0",
+		}
+
+		if m.IsLeaf {
+			jm.IsLeaf = "1"
+		} else if !strings.HasSuffix(jm.Path, ".") {
+			jm.Path += "."
+		}
+
+		name := strings.TrimSuffix(jm.Path, ".")
+		if i := strings.LastIndex(name, "."); i != -1 {
+			name = name[i+1:]
+		}
+		jm.Name = name
+
+		jms = append(jms, jm)
+	}
+
+	return json.Marshal(struct {
+		Metrics []jsonCompleterMatch `json:"metrics"`
+	}{
+		Metrics: jms,
+	})
+}
+
 /*
 NOTE(gmagnusson): Not implemented because I'm not sure we can decode a JSON
 blob in such a way that the roundtrip 'matches -> decode(encode(matches))' is
@@ -176,6 +216,103 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 	return json.Marshal(jms)
 }
 
+func jsonValue(m types.Metric, i int) interface{} {
+	if i < 0 || i >= len(m.Values) || m.IsAbsent[i] || math.IsInf(m.Values[i], 0) || math.IsNaN(m.Values[i]) {
+		return nil
+	}
+
+	return m.Values[i]
+}
+
+// DygraphEncoder encodes a Render response in the format expected by dygraph.
+// Rows are keyed by timestamps in milliseconds, those of the points of all
+// the metrics, so that metrics with different start times or steps line up.
+func DygraphEncoder(metrics []types.Metric) ([]byte, error) {
+	if len(metrics) == 0 {
+		return []byte("{}"), nil
+	}
+
+	labels := make([]string, 0, len(metrics)+1)
+	labels = append(labels, "Time")
+	seen := make(map[int32]bool)
+	var times []int32
+	for _, metric := range metrics {
+		labels = append(labels, metric.Name)
+		for i := range metric.Values {
+			t := metric.StartTime + int32(i)*metric.StepTime
+			if !seen[t] {
+				seen[t] = true
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	data := make([][]interface{}, len(times))
+	for i, t := range times {
+		row := make([]interface{}, 0, len(metrics)+1)
+		row = append(row, int64(t)*1000)
+		for _, metric := range metrics {
+			row = append(row, jsonValue(metric, pointAt(metric, t)))
+		}
+		data[i] = row
+	}
+
+	return json.Marshal(struct {
+		Labels []string        `json:"labels"`
+		Data   [][]interface{} `json:"data"`
+	}{
+		Labels: labels,
+		Data:   data,
+	})
+}
+
+// pointAt returns the index of the point of m at t, or -1 if it has none
+// there.
+func pointAt(m types.Metric, t int32) int {
+	switch {
+	case t == m.StartTime:
+		return 0
+	case m.StepTime <= 0 || t < m.StartTime || (t-m.StartTime)%m.StepTime != 0:
+		return -1
+	}
+
+	return int((t - m.StartTime) / m.StepTime)
+}
+
+type rickshawPoint struct {
+	X int32       `json:"x"`
+	Y interface{} `json:"y"`
+}
+
+type rickshawMetric struct {
+	Target     string          `json:"target"`
+	Datapoints []rickshawPoint `json:"datapoints"`
+}
+
+// RickshawEncoder encodes a Render response in the format expected by
+// rickshaw.
+func RickshawEncoder(metrics []types.Metric) ([]byte, error) {
+	rms := make([]rickshawMetric, 0, len(metrics))
+
+	for _, metric := range metrics {
+		rm := rickshawMetric{
+			Target:     metric.Name,
+			Datapoints: make([]rickshawPoint, len(metric.Values)),
+		}
+
+		t := metric.StartTime
+		for i := range metric.Values {
+			rm.Datapoints[i] = rickshawPoint{X: t, Y: jsonValue(metric, i)}
+			t += metric.StepTime
+		}
+
+		rms = append(rms, rm)
+	}
+
+	return json.Marshal(rms)
+}
+
 func RenderDecoder(blob []byte) ([]types.Metric, error) {
 	jms := make([]jsonMetric, 0)
 	if err := json.Unmarshal(blob, &jms); err != nil {
@@ -231,3 +368,38 @@ func RenderDecoder(blob []byte) ([]types.Metric, error) {
 
 	return metrics, nil
 }
+
+// ValidCallback reports whether name is safe to use as a JSONP callback: a
+// dotted path of JavaScript identifiers, such as "jQuery123.cb".
+func ValidCallback(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, part := range strings.Split(name, ".") {
+		if part == "" {
+			return false
+		}
+
+		for i, c := range part {
+			switch {
+			case c == '_' || c == '$':
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+			case '0' <= c && c <= '9' && i > 0:
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// WrapJSONP wraps a JSON response in a call to callback.
+func WrapJSONP(blob []byte, callback string) []byte {
+	out := make([]byte, 0, len(callback)+len(blob)+2)
+	out = append(out, callback...)
+	out = append(out, '(')
+	out = append(out, blob...)
+	return append(out, ')')
+}
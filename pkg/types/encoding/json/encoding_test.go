@@ -37,3 +37,138 @@ func TestExpandable(t *testing.T) {
 		t.Error("Expected expandable")
 	}
 }
+
+func TestCompleterEncoder(t *testing.T) {
+	ms := types.Matches{
+		Name: "foo.b*",
+		Matches: []types.Match{
+			types.Match{
+				Path:   "foo.bar",
+				IsLeaf: false,
+			},
+			types.Match{
+				Path:   "foo.baz",
+				IsLeaf: true,
+			},
+		},
+	}
+
+	got, err := CompleterEncoder(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"metrics":[{"path":"foo.bar.","name":"bar","is_leaf":"0"},{"path":"foo.baz","name":"baz","is_leaf":"1"}]}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestValidCallback(t *testing.T) {
+	tests := map[string]bool{
+		"cb":              true,
+		"jQuery1234_5678": true,
+		"$.callbacks.cb0": true,
+		"":                false,
+		"0cb":             false,
+		"cb.":             false,
+		"alert(1);cb":     false,
+		"cb<script>":      false,
+		"window['cb']":    false,
+	}
+
+	for name, expected := range tests {
+		if got := ValidCallback(name); got != expected {
+			t.Errorf("ValidCallback(%q): expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestWrapJSONP(t *testing.T) {
+	got := WrapJSONP([]byte(`[1,2]`), "cb")
+
+	expected := `cb([1,2])`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestDygraphEncoder(t *testing.T) {
+	metrics := []types.Metric{
+		types.Metric{
+			Name:      "foo",
+			StartTime: 100,
+			StepTime:  10,
+			Values:    []float64{1, 0},
+			IsAbsent:  []bool{false, true},
+		},
+		types.Metric{
+			Name:      "bar",
+			StartTime: 100,
+			StepTime:  10,
+			Values:    []float64{2.5, 3, 4},
+			IsAbsent:  []bool{false, false, false},
+		},
+	}
+
+	got, err := DygraphEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"labels":["Time","foo","bar"],"data":[[100000,1,2.5],[110000,null,3],[120000,null,4]]}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestDygraphEncoderMisaligned(t *testing.T) {
+	metrics := []types.Metric{
+		types.Metric{
+			Name:      "foo",
+			StartTime: 100,
+			StepTime:  10,
+			Values:    []float64{1, 2, 3},
+			IsAbsent:  []bool{false, false, false},
+		},
+		types.Metric{
+			Name:      "bar",
+			StartTime: 105,
+			StepTime:  20,
+			Values:    []float64{4, 5},
+			IsAbsent:  []bool{false, false},
+		},
+	}
+
+	got, err := DygraphEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"labels":["Time","foo","bar"],"data":[[100000,1,null],[105000,null,4],[110000,2,null],[120000,3,null],[125000,null,5]]}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestRickshawEncoder(t *testing.T) {
+	metrics := []types.Metric{
+		types.Metric{
+			Name:      "foo",
+			StartTime: 100,
+			StepTime:  10,
+			Values:    []float64{1, 0},
+			IsAbsent:  []bool{false, true},
+		},
+	}
+
+	got, err := RickshawEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"target":"foo","datapoints":[{"x":100,"y":1},{"x":110,"y":null}]}]`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
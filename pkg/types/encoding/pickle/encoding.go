@@ -1,25 +1,25 @@
 /*
-Package pickle defines encoding methods for Find and Render responses.
+Package pickle defines encoding and decoding methods for Find and Render
+responses, the decoders letting the zipper talk to backends that only speak
+pickle, like graphite-web 0.9.x.
 
-The package does not define decoding methods or methods for handling Info
-responses:
-
-- The /info endpoint is a carbonapi invention. It's unlikely that any Python
-  stack will know about it.
-
-- For now, we do not expect to talk to Python stores. Patches to let us do that
-  are welcome.
+The package does not define methods for handling Info responses, as the /info
+endpoint is a carbonapi invention. It's unlikely that any Python stack will
+know about it.
 */
 package pickle
 
 import (
 	"bytes"
+	"io"
 	"time"
 
 	"github.com/bookingcom/carbonapi/intervalset"
 	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
 
 	pickle "github.com/lomik/og-rek"
+	"github.com/pkg/errors"
 )
 
 // FindEncoderV0_9 encodes a Find response in a format that graphite-web 0.9.x
@@ -35,11 +35,7 @@ func FindEncoderV0_9(matches types.Matches) ([]byte, error) {
 		result = append(result, mm)
 	}
 
-	var buf bytes.Buffer
-	penc := pickle.NewEncoder(&buf)
-	err := penc.Encode(result)
-
-	return buf.Bytes(), err
+	return encode(result)
 }
 
 // FindEncoderV1_0 encodes a Find response in a format that graphite-web 0.1
@@ -59,17 +55,38 @@ func FindEncoderV1_0(matches types.Matches) ([]byte, error) {
 		result = append(result, mm)
 	}
 
-	var buf bytes.Buffer
-	penc := pickle.NewEncoder(&buf)
-	err := penc.Encode(result)
-
-	return buf.Bytes(), err
+	return encode(result)
 }
 
-/* TODO(gmagnusson)
-func FindDecoder(blob []byte) ([]types.Match, error) {
+// FindDecoder decodes a Find response in the graphite-web 0.9.x format. The
+// 1.x format can't be decoded, as the pickle decoder doesn't build the
+// interval objects it carries.
+func FindDecoder(blob []byte) (types.Matches, error) {
+	var matches types.Matches
+
+	items, err := decodeList(blob)
+	if err != nil {
+		return matches, err
+	}
+
+	for _, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return matches, errors.Errorf("expected a dict, got %T", item)
+		}
+
+		var match types.Match
+		match.Path, ok = m["metric_path"].(string)
+		if !ok {
+			return matches, errors.New("match without a path")
+		}
+		match.IsLeaf, _ = m["isLeaf"].(bool)
+
+		matches.Matches = append(matches.Matches, match)
+	}
+
+	return matches, nil
 }
-*/
 
 // RenderEncoder encodes a Render response in a format graphite-web can understand.
 func RenderEncoder(metrics []types.Metric) ([]byte, error) {
@@ -95,17 +112,93 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 		})
 	}
 
-	var buf bytes.Buffer
-	penc := pickle.NewEncoder(&buf)
-	err := penc.Encode(p)
+	return encode(p)
+}
+
+// encode pickles v through a pooled buffer.
+func encode(v interface{}) ([]byte, error) {
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
+
+	err := util.WriteBuffer(buf, func(w io.Writer) error {
+		return pickle.NewEncoder(w).Encode(v)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return buf.Bytes(), err
+	return util.CopyBuffer(buf), nil
 }
 
-/* TODO(gmagnusson)
+// RenderDecoder decodes a Render response like the ones of RenderEncoder.
 func RenderDecoder(blob []byte) ([]types.Metric, error) {
+	items, err := decodeList(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]types.Metric, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return metrics, errors.Errorf("expected a dict, got %T", item)
+		}
+
+		var metric types.Metric
+		var ok1, ok2, ok3, ok4 bool
+		metric.Name, ok1 = m["name"].(string)
+		metric.StartTime, ok2 = toInt32(m["start"])
+		metric.StopTime, ok3 = toInt32(m["end"])
+		metric.StepTime, ok4 = toInt32(m["step"])
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return metrics, errors.Errorf("bad metric %v", m)
+		}
+
+		values, ok := m["values"].([]interface{})
+		if !ok {
+			return metrics, errors.Errorf("bad values of metric %s", metric.Name)
+		}
+
+		metric.Values = make([]float64, len(values))
+		metric.IsAbsent = make([]bool, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case float64:
+				metric.Values[i] = v
+			case int64:
+				metric.Values[i] = float64(v)
+			case pickle.None:
+				metric.IsAbsent[i] = true
+			default:
+				return metrics, errors.Errorf("bad value %v of metric %s", v, metric.Name)
+			}
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// decodeList unpickles blob, which must be a list.
+func decodeList(blob []byte) ([]interface{}, error) {
+	v, err := pickle.NewDecoder(bytes.NewReader(blob)).Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected a list, got %T", v)
+	}
+
+	return items, nil
+}
+
+func toInt32(v interface{}) (int32, bool) {
+	i, ok := v.(int64)
+	return int32(i), ok
 }
-*/
 
 /*
 NOT TODO(gmagnusson)
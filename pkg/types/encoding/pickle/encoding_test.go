@@ -0,0 +1,56 @@
+package pickle
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestRenderDecoder(t *testing.T) {
+	metrics := []types.Metric{{
+		Name:      "foo.bar",
+		StartTime: 100,
+		StopTime:  250,
+		StepTime:  50,
+		Values:    []float64{1.5, 0, 3},
+		IsAbsent:  []bool{false, true, false},
+	}}
+
+	blob, err := RenderEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := RenderDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, metrics) {
+		t.Errorf("Expected %+v, got %+v", metrics, got)
+	}
+}
+
+func TestFindDecoder(t *testing.T) {
+	matches := types.Matches{Matches: []types.Match{
+		{Path: "foo.bar", IsLeaf: true},
+		{Path: "foo.baz", IsLeaf: false},
+	}}
+
+	blob, err := FindEncoderV0_9(matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := FindDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, matches) {
+		t.Errorf("Expected %+v, got %+v", matches, got)
+	}
+
+	if _, err := FindDecoder([]byte("N.")); err == nil {
+		t.Error("Expected an error for a response that isn't a list")
+	}
+}
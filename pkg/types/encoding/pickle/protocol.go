@@ -0,0 +1,41 @@
+package pickle
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Protocol is a version of the pickle protocol.
+type Protocol int
+
+const (
+	// Protocol1 is what the encoders produce natively. It's understood by
+	// every Python version.
+	Protocol1 Protocol = 1
+	// Protocol2 is protocol 1 with a PROTO header, which some clients
+	// require.
+	Protocol2 Protocol = 2
+)
+
+// ParseProtocol parses a pickle protocol version. The empty string means
+// Protocol1.
+func ParseProtocol(s string) (Protocol, error) {
+	switch s {
+	case "", "1":
+		return Protocol1, nil
+	case "2":
+		return Protocol2, nil
+	}
+
+	return Protocol1, errors.Errorf("unsupported pickle protocol %s", s)
+}
+
+// WithProtocol converts a blob produced by the encoders to protocol p.
+func WithProtocol(blob []byte, p Protocol) []byte {
+	if p != Protocol2 || len(blob) == 0 {
+		return blob
+	}
+
+	out := make([]byte, 0, len(blob)+2)
+	out = append(out, 0x80, byte(p))
+	return append(out, blob...)
+}
@@ -0,0 +1,36 @@
+package pickle
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseProtocol(t *testing.T) {
+	for s, expected := range map[string]Protocol{"": Protocol1, "1": Protocol1, "2": Protocol2} {
+		got, err := ParseProtocol(s)
+		if err != nil {
+			t.Errorf("ParseProtocol(%q): %v", s, err)
+		} else if got != expected {
+			t.Errorf("ParseProtocol(%q): expected %d, got %d", s, expected, got)
+		}
+	}
+
+	for _, s := range []string{"0", "3", "x"} {
+		if _, err := ParseProtocol(s); err == nil {
+			t.Errorf("ParseProtocol(%q): expected an error", s)
+		}
+	}
+}
+
+func TestWithProtocol(t *testing.T) {
+	blob := []byte("N.")
+
+	if got := WithProtocol(blob, Protocol1); !bytes.Equal(got, blob) {
+		t.Errorf("Expected %q, got %q", blob, got)
+	}
+
+	expected := []byte("\x80\x02N.")
+	if got := WithProtocol(blob, Protocol2); !bytes.Equal(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
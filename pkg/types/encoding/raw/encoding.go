@@ -0,0 +1,70 @@
+/*
+Package raw defines encoding methods for Render responses in graphite-web's
+raw format.
+
+Each metric is written on its own line as
+
+	name,start,stop,step|v1,v2,...
+
+with absent values written as None.
+*/
+package raw
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// RenderEncoder encodes a Render response in graphite-web's raw format.
+func RenderEncoder(metrics []types.Metric) ([]byte, error) {
+	buf := util.GetBuffer()
+	defer util.PutBuffer(buf)
+
+	for _, m := range metrics {
+		*buf = appendMetric(*buf, m)
+	}
+
+	return util.CopyBuffer(buf), nil
+}
+
+// RenderWriter writes a Render response in graphite-web's raw format to w,
+// one metric per line.
+func RenderWriter(w io.Writer, metrics []types.Metric) error {
+	var line []byte
+	for _, m := range metrics {
+		line = appendMetric(line[:0], m)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendMetric(b []byte, m types.Metric) []byte {
+	b = append(b, m.Name...)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(m.StartTime), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(m.StopTime), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(m.StepTime), 10)
+	b = append(b, '|')
+
+	for i, v := range m.Values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+
+		if m.IsAbsent[i] {
+			b = append(b, "None"...)
+		} else {
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		}
+	}
+
+	return append(b, '\n')
+}
@@ -0,0 +1,38 @@
+package raw
+
+import (
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestRenderEncoder(t *testing.T) {
+	metrics := []types.Metric{
+		types.Metric{
+			Name:      "foo.bar",
+			StartTime: 100,
+			StopTime:  130,
+			StepTime:  10,
+			Values:    []float64{1, 0, 2.5},
+			IsAbsent:  []bool{false, true, false},
+		},
+		types.Metric{
+			Name:      "foo.baz",
+			StartTime: 100,
+			StopTime:  110,
+			StepTime:  10,
+			Values:    []float64{-3},
+			IsAbsent:  []bool{false},
+		},
+	}
+
+	got, err := RenderEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "foo.bar,100,130,10|1,None,2.5\nfoo.baz,100,110,10|-3\n"
+	if string(got) != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
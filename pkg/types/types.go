@@ -7,7 +7,9 @@ The definitions correspond to the types of responses to the /render, /info, and
 package types
 
 import (
+	"fmt"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,6 +20,15 @@ var (
 	corruptionThreshold = 1.0
 	corruptionLogger    = zap.New(nil)
 
+	mergePolicy = MergeFill
+	// mergePrimary holds the set of the primary hosts, as a map[string]bool.
+	mergePrimary atomic.Value
+
+	normalizeSteps    = false
+	stepConsolidation = ConsolidateDefault
+
+	skewTolerance int32
+
 	ErrMetricsNotFound = ErrNotFound("No metrics returned")
 	ErrMatchesNotFound = ErrNotFound("No matches found")
 	ErrInfoNotFound    = ErrNotFound("No information found")
@@ -37,11 +48,110 @@ func (err ErrTimeout) Error() string {
 	return err.Err.Error()
 }
 
+// ErrMergeConflict is returned when replicas of a series disagree and the
+// merge policy is MergeError. It holds the name of the offending metric.
+type ErrMergeConflict string
+
+func (err ErrMergeConflict) Error() string {
+	return fmt.Sprintf("Conflicting values for metric %s", string(err))
+}
+
+// ErrBackends is returned when a request failed on all the backends it was
+// sent to. It holds the addresses of the backends.
+type ErrBackends struct {
+	Backends []string
+	Err      error
+}
+
+func (err ErrBackends) Error() string {
+	return err.Err.Error()
+}
+
+// Cause returns the error of the backends, for errors.Cause.
+func (err ErrBackends) Cause() error {
+	return err.Err
+}
+
+// FailingBackends returns the addresses of the backends that made err happen,
+// if err or one of the errors it wraps is an ErrBackends.
+func FailingBackends(err error) []string {
+	for err != nil {
+		if e, ok := err.(ErrBackends); ok {
+			return e.Backends
+		}
+
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+
+	return nil
+}
+
 func SetCorruptionWatcher(threshold float64, logger *zap.Logger) {
 	corruptionThreshold = threshold
 	corruptionLogger = logger
 }
 
+// MergePolicy decides how replicas of the same series returned by different
+// backends are combined into one.
+type MergePolicy int
+
+const (
+	MergeFill        MergePolicy = iota // Fill gaps point by point from other replicas.
+	MergeFewestNulls                    // Use the replica with the fewest absent points.
+	MergePrimary                        // Use the replica from the primary backend if there is one.
+	MergeError                          // Fail if replicas have different values for a point.
+)
+
+var mergePolicyNames = map[string]MergePolicy{
+	"":            MergeFill,
+	"fill":        MergeFill,
+	"fewestNulls": MergeFewestNulls,
+	"primary":     MergePrimary,
+	"error":       MergeError,
+}
+
+// ParseMergePolicy returns the merge policy with the given configuration name.
+func ParseMergePolicy(name string) (MergePolicy, error) {
+	policy, ok := mergePolicyNames[name]
+	if !ok {
+		return MergeFill, fmt.Errorf("unknown merge policy '%s'", name)
+	}
+
+	return policy, nil
+}
+
+// SetMergePolicy sets the policy used by MergeMetrics. The primary hosts are
+// only used by MergePrimary, and are compared against Metric.Host.
+func SetMergePolicy(policy MergePolicy, primary ...string) {
+	mergePolicy = policy
+	SetMergePrimary(primary...)
+}
+
+// SetMergePrimary sets the primary hosts of MergePrimary, as when the
+// hostname of the primary backend resolves to other addresses. It's safe to
+// call while metrics are merged.
+func SetMergePrimary(primary ...string) {
+	hosts := make(map[string]bool, len(primary))
+	for _, host := range primary {
+		if host != "" {
+			hosts[host] = true
+		}
+	}
+	mergePrimary.Store(hosts)
+}
+
+// SetSkewTolerance sets how many seconds off the step grid the replicas of a
+// series may be and still be merged point by point, as after a clock
+// adjustment of a backend. Replicas that disagree on their start time are
+// snapped to the grid if they're off by no more than skew. 0 snaps none.
+func SetSkewTolerance(skew int32) {
+	skewTolerance = skew
+}
+
 type FindRequest struct {
 	Query string
 	Trace
@@ -70,6 +180,8 @@ type RenderRequest struct {
 	Targets []string
 	From    int32
 	Until   int32
+
+	ConsolidateBy Consolidation // Optional hint used when series are downsampled.
 	Trace
 }
 
@@ -84,11 +196,27 @@ func NewRenderRequest(targets []string, from int32, until int32) RenderRequest {
 
 type Trace struct {
 	callCount     *int64
+	childCalls    *int64
+	failureCount  *int64
 	inMarshalNS   *int64
 	inLimiterNS   *int64
 	inHTTPCallNS  *int64
 	inReadBodyNS  *int64
 	inUnmarshalNS *int64
+	backendCalls  *backendCalls
+}
+
+// BackendCall is a call made to a backend for a request.
+type BackendCall struct {
+	Address  string
+	Duration time.Duration
+	Items    int   // The number of series, matches or infos returned.
+	Err      error // The error of the call, if it failed.
+}
+
+type backendCalls struct {
+	mu    sync.Mutex
+	calls []BackendCall
 }
 
 func (t Trace) Report() []int64 {
@@ -112,6 +240,64 @@ func (t Trace) IncCall() {
 	atomic.AddInt64(t.callCount, 1)
 }
 
+// Calls returns the number of backends the request was sent to, counting
+// the backends of the child zippers in their place.
+func (t Trace) Calls() int64 {
+	return atomic.LoadInt64(t.callCount) + atomic.LoadInt64(t.childCalls)
+}
+
+// AddChildCalls counts the calls a child zipper made to its backends for the
+// request, and those of them that failed, in place of the call to the child.
+func (t Trace) AddChildCalls(calls, failures int64) {
+	atomic.AddInt64(t.childCalls, calls-1)
+	atomic.AddInt64(t.failureCount, failures)
+}
+
+// IncFailure counts a backend that failed to answer the request.
+func (t Trace) IncFailure() {
+	atomic.AddInt64(t.failureCount, 1)
+}
+
+// Failures returns the number of backends that failed to answer the request.
+func (t Trace) Failures() int64 {
+	return atomic.LoadInt64(t.failureCount)
+}
+
+// AddBackendCall records a call to the backend at address that started at
+// start, and returned items or failed with err.
+func (t Trace) AddBackendCall(address string, start time.Time, items int, err error) {
+	c := BackendCall{
+		Address:  address,
+		Duration: time.Since(start),
+		Items:    items,
+		Err:      err,
+	}
+
+	t.backendCalls.mu.Lock()
+	t.backendCalls.calls = append(t.backendCalls.calls, c)
+	t.backendCalls.mu.Unlock()
+}
+
+// AddChildCall records a call a child zipper made to one of its backends.
+func (t Trace) AddChildCall(c BackendCall) {
+	t.backendCalls.mu.Lock()
+	t.backendCalls.calls = append(t.backendCalls.calls, c)
+	t.backendCalls.mu.Unlock()
+}
+
+// BackendCalls returns the calls made to backends for the request, sorted by
+// address.
+func (t Trace) BackendCalls() []BackendCall {
+	t.backendCalls.mu.Lock()
+	calls := make([]BackendCall, len(t.backendCalls.calls))
+	copy(calls, t.backendCalls.calls)
+	t.backendCalls.mu.Unlock()
+
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Address < calls[j].Address })
+
+	return calls
+}
+
 func (t Trace) AddMarshal(start time.Time) {
 	d := time.Since(start)
 	atomic.AddInt64(t.inMarshalNS, int64(d))
@@ -140,11 +326,14 @@ func (t Trace) AddUnmarshal(start time.Time) {
 func NewTrace() Trace {
 	return Trace{
 		callCount:     new(int64),
+		childCalls:    new(int64),
+		failureCount:  new(int64),
 		inMarshalNS:   new(int64),
 		inLimiterNS:   new(int64),
 		inHTTPCallNS:  new(int64),
 		inReadBodyNS:  new(int64),
 		inUnmarshalNS: new(int64),
+		backendCalls:  new(backendCalls),
 	}
 }
 
@@ -174,6 +363,13 @@ with an implementation
 The interface would probably need to have a Merge(other) method as well.
 */
 
+// SetStepNormalization controls whether replicas of a series with different
+// steps are consolidated to a common step before they are merged.
+func SetStepNormalization(enabled bool, c Consolidation) {
+	normalizeSteps = enabled
+	stepConsolidation = c
+}
+
 // Metric represents a part of a time series.
 type Metric struct {
 	Name      string
@@ -182,16 +378,24 @@ type Metric struct {
 	StepTime  int32
 	Values    []float64
 	IsAbsent  []bool
+
+	Host string // The backend the metric was fetched from, if known.
+}
+
+// MergeMetrics merges metrics by name, using the configured merge policy.
+func MergeMetrics(metrics [][]Metric) ([]Metric, error) {
+	return MergeMetricsBy(metrics, ConsolidateDefault)
 }
 
-// MergeMetrics merges metrics by name.
-func MergeMetrics(metrics [][]Metric) []Metric {
+// MergeMetricsBy merges metrics by name like MergeMetrics, using the given
+// consolidation function if replicas have to be downsampled.
+func MergeMetricsBy(metrics [][]Metric, consolidateBy Consolidation) ([]Metric, error) {
 	if len(metrics) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	if len(metrics) == 1 {
-		return metrics[0]
+		return metrics[0], nil
 	}
 
 	names := make(map[string][]Metric)
@@ -204,10 +408,113 @@ func MergeMetrics(metrics [][]Metric) []Metric {
 
 	merged := make([]Metric, 0)
 	for _, ms := range names {
-		merged = append(merged, mergeMetrics(ms))
+		m, err := mergeReplicas(normalizeReplicas(alignReplicas(ms), consolidateBy))
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, m)
 	}
 
-	return merged
+	return merged, nil
+}
+
+// alignReplicas snaps the replicas of a series whose start times disagree to
+// the step grid, if they're off it by no more than the skew tolerance, so
+// that their points line up.
+func alignReplicas(metrics []Metric) []Metric {
+	if skewTolerance <= 0 {
+		return metrics
+	}
+
+	skewed := false
+	for _, m := range metrics[1:] {
+		if m.StartTime != metrics[0].StartTime {
+			skewed = true
+			break
+		}
+	}
+	if !skewed {
+		return metrics
+	}
+
+	for i, m := range metrics {
+		if m.StepTime <= 0 {
+			continue
+		}
+
+		shift := int32(0)
+		if off := m.StartTime % m.StepTime; off != 0 && off <= skewTolerance {
+			shift = -off
+		} else if off != 0 && m.StepTime-off <= skewTolerance {
+			shift = m.StepTime - off
+		}
+		if shift == 0 {
+			continue
+		}
+
+		corruptionLogger.Debug("metric timestamp skew",
+			zap.String("metric", m.Name),
+			zap.String("host", m.Host),
+			zap.Int32("shift", shift),
+		)
+		metrics[i].StartTime += shift
+		metrics[i].StopTime += shift
+	}
+
+	return metrics
+}
+
+// normalizeReplicas consolidates replicas of a series to the step of its
+// storage schema, or else to the least common multiple of their steps.
+// Replicas usually only disagree on step while retention configs are being
+// migrated.
+func normalizeReplicas(metrics []Metric, consolidateBy Consolidation) []Metric {
+	step := commonStep(metrics)
+	if step == 0 {
+		return metrics
+	}
+	if s := authoritativeStep(metrics); s != 0 {
+		step = s
+	}
+
+	steps := make([]int64, len(metrics))
+	for i, m := range metrics {
+		steps[i] = int64(m.StepTime)
+	}
+	corruptionLogger.Warn("metric step mismatch",
+		zap.String("metric", metrics[0].Name),
+		zap.Int64s("steps", steps),
+		zap.Bool("normalized", normalizeSteps),
+	)
+
+	if !normalizeSteps {
+		return metrics
+	}
+
+	c := consolidationFor(metrics[0].Name, consolidateBy)
+	normalized := make([]Metric, len(metrics))
+	for i, m := range metrics {
+		normalized[i] = Consolidate(m, step, c)
+	}
+
+	return normalized
+}
+
+func mergeReplicas(metrics []Metric) (Metric, error) {
+	switch mergePolicy {
+	case MergeFewestNulls:
+		return mergeFewestNulls(metrics), nil
+
+	case MergePrimary:
+		return mergePrimaryFirst(metrics), nil
+
+	case MergeError:
+		if err := checkConflicts(metrics); err != nil {
+			return Metric{}, err
+		}
+	}
+
+	return mergeMetrics(metrics), nil
 }
 
 type byStepTime []Metric
@@ -245,14 +552,20 @@ func mergeMetrics(metrics []Metric) Metric {
 		for j := 1; j < len(metrics); j++ {
 			m := metrics[j]
 
-			if m.StepTime != metric.StepTime || len(m.Values) != len(metric.Values) {
+			if m.StepTime != metric.StepTime {
 				break
 			}
 
+			// The point of m at the time of the point i of metric.
+			k, ok := pointIndex(metric, m, i)
+			if !ok {
+				continue
+			}
+
 			// found one
-			if !m.IsAbsent[i] {
-				metric.IsAbsent[i] = m.IsAbsent[i]
-				metric.Values[i] = m.Values[i]
+			if !m.IsAbsent[k] {
+				metric.IsAbsent[i] = m.IsAbsent[k]
+				metric.Values[i] = m.Values[k]
 				healed++
 				break
 			}
@@ -270,6 +583,87 @@ func mergeMetrics(metrics []Metric) Metric {
 	return metric
 }
 
+// pointIndex returns the index of the point of m at the time of the point i
+// of metric, which has the same step, if m has one.
+func pointIndex(metric, m Metric, i int) (int, bool) {
+	k := i
+	if d := metric.StartTime - m.StartTime; d != 0 {
+		if metric.StepTime <= 0 || d%metric.StepTime != 0 {
+			return 0, false
+		}
+		k += int(d / metric.StepTime)
+	}
+	if k < 0 || k >= len(m.Values) {
+		return 0, false
+	}
+
+	return k, true
+}
+
+// mergeFewestNulls picks, among the highest resolution replicas, the one with
+// the fewest absent points.
+func mergeFewestNulls(metrics []Metric) Metric {
+	if len(metrics) == 0 {
+		return Metric{}
+	}
+
+	sort.Stable(byStepTime(metrics))
+
+	best, bestAbsent := 0, countAbsent(metrics[0])
+	for i := 1; i < len(metrics) && metrics[i].StepTime == metrics[0].StepTime; i++ {
+		if c := countAbsent(metrics[i]); c < bestAbsent {
+			best, bestAbsent = i, c
+		}
+	}
+
+	return metrics[best]
+}
+
+// mergePrimaryFirst returns the replica fetched from a primary host, and
+// falls back to filling gaps if no primary returned the metric.
+func mergePrimaryFirst(metrics []Metric) Metric {
+	primary, _ := mergePrimary.Load().(map[string]bool)
+	for _, m := range metrics {
+		if primary[m.Host] {
+			return m
+		}
+	}
+
+	return mergeMetrics(metrics)
+}
+
+// checkConflicts reports an error if two replicas with the same resolution
+// have different values for the same point.
+func checkConflicts(metrics []Metric) error {
+	for i := 0; i < len(metrics); i++ {
+		for j := i + 1; j < len(metrics); j++ {
+			a, b := metrics[i], metrics[j]
+			if a.StepTime != b.StepTime || a.StartTime != b.StartTime || len(a.Values) != len(b.Values) {
+				continue
+			}
+
+			for k := range a.Values {
+				if !a.IsAbsent[k] && !b.IsAbsent[k] && a.Values[k] != b.Values[k] {
+					return ErrMergeConflict(a.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func countAbsent(m Metric) int {
+	n := 0
+	for _, absent := range m.IsAbsent {
+		if absent {
+			n++
+		}
+	}
+
+	return n
+}
+
 // Info contains metadata about a metric in Graphite.
 type Info struct {
 	Host              string
@@ -98,7 +98,10 @@ func TestMergeManyMetricsBasic(t *testing.T) {
 		IsAbsent: []bool{false},
 	}
 
-	got := MergeMetrics(input)
+	got, err := MergeMetrics(input)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(got) != 1 {
 		t.Errorf("Expected 1 metric, got %d", len(got))
 	}
@@ -126,7 +129,10 @@ func TestMergeManyMetricsDifferent(t *testing.T) {
 		},
 	}
 
-	got := MergeMetrics(input)
+	got, err := MergeMetrics(input)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(got) != 2 {
 		t.Errorf("Expected 2 metrics, got %d", len(got))
 	}
@@ -414,6 +420,250 @@ func TestMergeMetricsDifferingStepTimes6(t *testing.T) {
 	doTest(t, input, expected)
 }
 
+func TestMergeReplicasFewestNulls(t *testing.T) {
+	defer SetMergePolicy(MergeFill, "")
+	SetMergePolicy(MergeFewestNulls, "")
+
+	input := []Metric{
+		Metric{
+			Name:     "metric",
+			Values:   []float64{1, 0, 0},
+			IsAbsent: []bool{false, true, true},
+			StepTime: 1,
+		},
+		Metric{
+			Name:     "metric",
+			Values:   []float64{2, 2, 0},
+			IsAbsent: []bool{false, false, true},
+			StepTime: 1,
+		},
+	}
+
+	expected := Metric{
+		Name:     "metric",
+		Values:   []float64{2, 2, 0},
+		IsAbsent: []bool{false, false, true},
+		StepTime: 1,
+	}
+
+	got, err := mergeReplicas(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !MetricsEqual(got, expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeReplicasPrimary(t *testing.T) {
+	defer SetMergePolicy(MergeFill, "")
+	SetMergePolicy(MergePrimary, "primary:8080")
+
+	input := []Metric{
+		Metric{
+			Name:     "metric",
+			Values:   []float64{1, 1},
+			IsAbsent: []bool{false, false},
+			Host:     "other:8080",
+		},
+		Metric{
+			Name:     "metric",
+			Values:   []float64{2, 0},
+			IsAbsent: []bool{false, true},
+			Host:     "primary:8080",
+		},
+	}
+
+	expected := Metric{
+		Name:     "metric",
+		Values:   []float64{2, 0},
+		IsAbsent: []bool{false, true},
+	}
+
+	got, err := mergeReplicas(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !MetricsEqual(got, expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeReplicasPrimaryAddresses(t *testing.T) {
+	defer SetMergePolicy(MergeFill, "")
+	SetMergePolicy(MergePrimary, "10.0.0.1:8080", "10.0.0.2:8080")
+
+	input := []Metric{
+		Metric{
+			Name:     "metric",
+			Values:   []float64{1, 1},
+			IsAbsent: []bool{false, false},
+			Host:     "10.0.0.3:8080",
+		},
+		Metric{
+			Name:     "metric",
+			Values:   []float64{2, 0},
+			IsAbsent: []bool{false, true},
+			Host:     "10.0.0.2:8080",
+		},
+	}
+
+	expected := Metric{
+		Name:     "metric",
+		Values:   []float64{2, 0},
+		IsAbsent: []bool{false, true},
+	}
+
+	got, err := mergeReplicas(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !MetricsEqual(got, expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+
+	SetMergePrimary("10.0.0.4:8080")
+	got, err = mergeReplicas(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected.Values = []float64{1, 1}
+	expected.IsAbsent = []bool{false, false}
+	if !MetricsEqual(got, expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeReplicasError(t *testing.T) {
+	defer SetMergePolicy(MergeFill, "")
+	SetMergePolicy(MergeError, "")
+
+	input := [][]Metric{
+		[]Metric{
+			Metric{
+				Name:     "metric",
+				Values:   []float64{1, 0},
+				IsAbsent: []bool{false, true},
+			},
+		},
+		[]Metric{
+			Metric{
+				Name:     "metric",
+				Values:   []float64{2, 2},
+				IsAbsent: []bool{false, false},
+			},
+		},
+	}
+
+	_, err := MergeMetrics(input)
+	if _, ok := err.(ErrMergeConflict); !ok {
+		t.Errorf("Expected merge conflict, got %v", err)
+	}
+}
+
+func TestParseMergePolicy(t *testing.T) {
+	if _, err := ParseMergePolicy("bogus"); err == nil {
+		t.Error("Expected error for unknown merge policy")
+	}
+
+	got, err := ParseMergePolicy("")
+	if err != nil || got != MergeFill {
+		t.Errorf("Expected default policy, got %v (%v)", got, err)
+	}
+}
+
+func TestMergeMetricsSkew(t *testing.T) {
+	input := func() [][]Metric {
+		return [][]Metric{
+			[]Metric{
+				Metric{
+					Name:      "metric",
+					StartTime: 60,
+					StopTime:  240,
+					StepTime:  60,
+					Values:    []float64{1, 0, 3},
+					IsAbsent:  []bool{false, true, false},
+				},
+			},
+			[]Metric{
+				Metric{
+					Name:      "metric",
+					StartTime: 125,
+					StopTime:  245,
+					StepTime:  60,
+					Values:    []float64{2, 0},
+					IsAbsent:  []bool{false, true},
+				},
+			},
+		}
+	}
+
+	got, err := MergeMetrics(input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].IsAbsent[1] {
+		t.Errorf("Expected replicas off the grid not to be merged, got %+v", got)
+	}
+
+	defer SetSkewTolerance(0)
+	SetSkewTolerance(10)
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 60,
+		StopTime:  240,
+		StepTime:  60,
+		Values:    []float64{1, 2, 3},
+		IsAbsent:  []bool{false, false, false},
+	}
+	got, err = MergeMetrics(input())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !MetricsEqual(got[0], expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeMetricsShifted(t *testing.T) {
+	// Replicas on the grid but starting at different times are merged by
+	// the times of their points.
+	input := []Metric{
+		Metric{
+			Name:      "metric",
+			StartTime: 60,
+			StopTime:  240,
+			StepTime:  60,
+			Values:    []float64{1, 0, 0},
+			IsAbsent:  []bool{false, true, true},
+		},
+		Metric{
+			Name:      "metric",
+			StartTime: 180,
+			StopTime:  300,
+			StepTime:  60,
+			Values:    []float64{3, 4},
+			IsAbsent:  []bool{false, false},
+		},
+	}
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 60,
+		StopTime:  240,
+		StepTime:  60,
+		Values:    []float64{1, 0, 3},
+		IsAbsent:  []bool{false, true, false},
+	}
+
+	doTest(t, input, expected)
+}
+
 func doTest(t *testing.T, input []Metric, expected Metric) {
 	got := mergeMetrics(input)
 
@@ -0,0 +1,277 @@
+// Package websocket implements the server side of the WebSocket protocol of
+// RFC 6455, as much of it as carbonapi needs: the opening handshake, and
+// messages of text or binary frames, without extensions.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Status codes of close frames.
+const (
+	CloseNormal          = 1000
+	CloseProtocolError   = 1002
+	CloseMessageTooLarge = 1009
+)
+
+// acceptGUID is the GUID appended to the key of the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the largest message a Conn reads, unless set.
+const DefaultMaxMessageSize = 1 << 20
+
+var (
+	// ErrMessageTooLarge is returned by ReadMessage for messages over the
+	// MaxMessageSize of the Conn.
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	// ErrProtocol is returned by ReadMessage for frames breaking the
+	// protocol.
+	ErrProtocol = errors.New("websocket: protocol error")
+)
+
+// Conn is a WebSocket connection. Its messages are read by a single
+// goroutine, and may be written by several.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// MaxMessageSize is the largest message read, in bytes.
+	MaxMessageSize int
+
+	mu     sync.Mutex // Serializes the writes of frames.
+	closed bool
+}
+
+// IsUpgrade reports whether r asks for its connection to be upgraded to a
+// WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of the WebSocket connection of r.
+// If r isn't a valid handshake, Upgrade replies with an HTTP error and
+// returns an error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can't be hijacked")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The deadlines of the HTTP server don't apply to the WebSocket.
+	conn.SetDeadline(time.Time{})
+
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+AcceptKey(key)+"\r\n\r\n")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           conn,
+		br:             brw.Reader,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}, nil
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept of the Sec-WebSocket-Key key.
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// while waiting for it. It returns io.EOF once the client closed the
+// connection, after which c should be closed.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+		case opPong:
+		case opClose:
+			c.writeClose(CloseNormal)
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+			if started == (op != opContinuation) {
+				c.writeClose(CloseProtocolError)
+				return nil, ErrProtocol
+			}
+			started = true
+			if len(message)+len(payload) > c.MaxMessageSize {
+				c.writeClose(CloseMessageTooLarge)
+				return nil, ErrMessageTooLarge
+			}
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			c.writeClose(CloseProtocolError)
+			return nil, ErrProtocol
+		}
+	}
+}
+
+// readFrame reads a frame, which clients must mask.
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	op := header[0] & 0x0F
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		c.writeClose(CloseProtocolError)
+		return false, 0, nil, ErrProtocol
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		c.writeClose(CloseProtocolError)
+		return false, 0, nil, ErrProtocol
+	}
+	if length > uint64(c.MaxMessageSize) {
+		c.writeClose(CloseMessageTooLarge)
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, op, payload, nil
+}
+
+// WriteMessage writes a text message.
+func (c *Conn) WriteMessage(b []byte) error {
+	return c.writeFrame(opText, b)
+}
+
+// writeFrame writes an unmasked frame, as servers do.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	b := make([]byte, 0, 10+len(payload))
+	b = append(b, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= 0xFFFF:
+		b = append(b, 126, byte(n>>8), byte(n))
+	default:
+		b = append(b, 127)
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[2:], uint64(n))
+	}
+	b = append(b, payload...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	_, err := c.conn.Write(b)
+
+	return err
+}
+
+// writeClose writes a close frame with the status code, once.
+func (c *Conn) writeClose(code uint16) {
+	c.writeFrame(opClose, []byte{byte(code >> 8), byte(code)})
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
+
+// Close sends a close frame, unless sent already, and closes the
+// connection.
+func (c *Conn) Close() error {
+	c.writeClose(CloseNormal)
+
+	return c.conn.Close()
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455.
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Expected the accept key of the RFC, got %s", got)
+	}
+}
+
+// dial opens a WebSocket to the server at url, and returns it and a reader
+// of it positioned after the handshake.
+func dial(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected the accept key of the RFC, got %s", got)
+	}
+
+	return conn, br
+}
+
+// clientFrame returns a frame masked as clients send them.
+func clientFrame(fin bool, op byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	b := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	b = append(b, mask...)
+	for i := range payload {
+		b = append(b, payload[i]^mask[i%4])
+	}
+
+	return b
+}
+
+func readFrame(t *testing.T, br *bufio.Reader) (byte, string) {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return header[0] & 0x0F, string(payload)
+}
+
+func TestConn(t *testing.T) {
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer c.Close()
+
+		c.MaxMessageSize = 16
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.WriteMessage(m)
+		}
+	}))
+	defer server.Close()
+
+	conn, br := dial(t, server.URL)
+	defer conn.Close()
+
+	conn.Write(clientFrame(true, opText, "hello"))
+	if op, m := readFrame(t, br); op != opText || m != "hello" {
+		t.Errorf("Expected the message echoed, got %d %q", op, m)
+	}
+
+	// A ping in the middle of a fragmented message is answered.
+	conn.Write(clientFrame(false, opText, "hel"))
+	conn.Write(clientFrame(true, opPing, "p"))
+	conn.Write(clientFrame(true, opContinuation, "lo"))
+	if op, m := readFrame(t, br); op != opPong || m != "p" {
+		t.Errorf("Expected a pong, got %d %q", op, m)
+	}
+	if op, m := readFrame(t, br); op != opText || m != "hello" {
+		t.Errorf("Expected the fragmented message echoed, got %d %q", op, m)
+	}
+
+	conn.Write(clientFrame(true, opText, strings.Repeat("x", 17)))
+	if op, m := readFrame(t, br); op != opClose || m != "\x03\xf1" {
+		t.Errorf("Expected a close frame of status 1009, got %d %q", op, m)
+	}
+	if err := <-errs; err != ErrMessageTooLarge {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestUpgradeRefused(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := Upgrade(rr, req); err == nil || rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a plain request to be refused, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	if _, err := Upgrade(rr, req); err == nil || rr.Code != http.StatusUpgradeRequired {
+		t.Errorf("Expected an old version to be refused, got %d", rr.Code)
+	}
+}
@@ -69,6 +69,10 @@ func DeepClone(original map[parser.MetricRequest][]*types.MetricData) map[parser
 					Values:    make([]float64, len(originalMetric.Values)),
 					IsAbsent:  make([]bool, len(originalMetric.IsAbsent)),
 				},
+				XFilesFactor: originalMetric.XFilesFactor,
+				TimeZone:     originalMetric.TimeZone,
+				DateTimeZone: originalMetric.DateTimeZone,
+				Now:          originalMetric.Now,
 			}
 
 			copy(copiedMetric.Values, originalMetric.Values)
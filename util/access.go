@@ -0,0 +1,125 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AccessRule restricts the networks that may call the handlers whose path
+// starts with Path. Allow and Deny list CIDRs or single IP addresses. A
+// request is refused if its address is in Deny, or if Allow is not empty and
+// its address isn't in it.
+type AccessRule struct {
+	Path  string   `yaml:"path"`
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type accessRule struct {
+	path  string
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+type accessHandler struct {
+	handler http.Handler
+	rules   []accessRule
+}
+
+// AccessHandler is middleware that refuses with a 403 the requests not
+// allowed by the first rule matching their path. Requests matching no rule
+// are allowed. The address checked is the one of the connection, so the
+// middleware must wrap any handler trusting forwarded headers.
+func AccessHandler(h http.Handler, rules []AccessRule) (http.Handler, error) {
+	if len(rules) == 0 {
+		return h, nil
+	}
+
+	a := accessHandler{handler: h}
+	for _, r := range rules {
+		allow, err := parseNetworks(r.Allow)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad allow list for '%s'", r.Path)
+		}
+		deny, err := parseNetworks(r.Deny)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad deny list for '%s'", r.Path)
+		}
+		a.rules = append(a.rules, accessRule{path: r.Path, allow: allow, deny: deny})
+	}
+
+	return a, nil
+}
+
+func (a accessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.allowed(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	a.handler.ServeHTTP(w, r)
+}
+
+func (a accessHandler) allowed(r *http.Request) bool {
+	for _, rule := range a.rules {
+		if !strings.HasPrefix(r.URL.Path, rule.path) {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+
+		if contains(rule.deny, ip) {
+			return false
+		}
+
+		return len(rule.allow) == 0 || contains(rule.allow, ip)
+	}
+
+	return true
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address '%s'", cidr)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			bits := 8 * len(ip)
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
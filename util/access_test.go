@@ -0,0 +1,53 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h, err := AccessHandler(ok, []AccessRule{
+		{Path: "/info", Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.6.6.6"}},
+		{Path: "/render", Deny: []string{"192.168.0.0/16", "2001:db8::/32"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		code       int
+	}{
+		{"/info/", "10.1.2.3:1234", http.StatusOK},
+		{"/info/", "10.6.6.6:1234", http.StatusForbidden},
+		{"/info/", "172.16.0.1:1234", http.StatusForbidden},
+		{"/render/", "172.16.0.1:1234", http.StatusOK},
+		{"/render/", "192.168.1.1:1234", http.StatusForbidden},
+		{"/render/", "[2001:db8::1]:1234", http.StatusForbidden},
+		{"/metrics/find/", "192.168.1.1:1234", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("%s from %s: expected %d, got %d", tt.path, tt.remoteAddr, tt.code, rr.Code)
+		}
+	}
+}
+
+func TestAccessHandlerBadRule(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if _, err := AccessHandler(ok, []AccessRule{{Path: "/info", Allow: []string{"10.0.0.0/33"}}}); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+	if _, err := AccessHandler(ok, []AccessRule{{Path: "/info", Deny: []string{"not-an-ip"}}}); err == nil {
+		t.Error("Expected an error for an invalid IP address")
+	}
+}
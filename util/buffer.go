@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer kept in the pool, so that a single
+// huge response doesn't stay pinned in memory.
+const maxPooledBuffer = 16 << 20
+
+var buffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64<<10)
+		return &b
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool. Append to it, store the
+// result back in it and return it with PutBuffer once done.
+func GetBuffer() *[]byte {
+	b := buffers.Get().(*[]byte)
+	*b = (*b)[:0]
+
+	return b
+}
+
+// PutBuffer returns b to the pool. b must not be used afterwards.
+func PutBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+
+	buffers.Put(b)
+}
+
+// CopyBuffer returns a copy of the contents of b, which outlives it.
+func CopyBuffer(b *[]byte) []byte {
+	return append(make([]byte, 0, len(*b)), *b...)
+}
+
+// WriteBuffer writes to b through an io.Writer, for encoders that need one.
+func WriteBuffer(b *[]byte, write func(w io.Writer) error) error {
+	w := bytes.NewBuffer(*b)
+	err := write(w)
+	*b = w.Bytes()
+
+	return err
+}
+
+// ReadAll reads r until EOF like ioutil.ReadAll, but through a pooled buffer
+// so that only the returned slice is allocated.
+func ReadAll(r io.Reader) ([]byte, error) {
+	b := GetBuffer()
+	defer PutBuffer(b)
+
+	w := bytes.NewBuffer(*b)
+	_, err := w.ReadFrom(r)
+	*b = w.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return CopyBuffer(b), nil
+}
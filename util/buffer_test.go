@@ -0,0 +1,61 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadAll(t *testing.T) {
+	input := strings.Repeat("carbonapi", 100000)
+
+	got, err := ReadAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != input {
+		t.Errorf("Expected %d bytes, got %d", len(input), len(got))
+	}
+	if cap(got) != len(got) {
+		t.Errorf("Expected an exact size slice, got capacity %d for %d bytes", cap(got), len(got))
+	}
+}
+
+func TestBufferReuse(t *testing.T) {
+	b := GetBuffer()
+	*b = append(*b, "stale"...)
+	PutBuffer(b)
+
+	b = GetBuffer()
+	defer PutBuffer(b)
+	if len(*b) != 0 {
+		t.Errorf("Expected an empty buffer, got %q", *b)
+	}
+
+	err := WriteBuffer(b, func(w io.Writer) error {
+		_, err := io.WriteString(w, "fresh")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := CopyBuffer(b)
+	*b = append((*b)[:0], "other"...)
+	if !bytes.Equal(out, []byte("fresh")) {
+		t.Errorf("Expected the copy to be independent of the buffer, got %q", out)
+	}
+}
+
+func BenchmarkReadAll(b *testing.B) {
+	input := strings.Repeat("carbonapi", 100000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadAll(strings.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clientSweepInterval is how often the state of idle clients is dropped.
+const clientSweepInterval = time.Minute
+
+// ClientLimit limits the requests of each client address to Concurrency at
+// once, and to Rate per second with bursts of up to Burst. Zero disables a
+// limit. The addresses in Exempt, CIDRs or single IP addresses, such as those
+// of the carbonapi hosts, aren't limited.
+type ClientLimit struct {
+	Concurrency int      `yaml:"concurrency"`
+	Rate        float64  `yaml:"rate"`
+	Burst       int      `yaml:"burst"`
+	Exempt      []string `yaml:"exempt"`
+}
+
+type clientState struct {
+	inFlight int
+	tokens   float64
+	last     time.Time
+}
+
+type clientLimitHandler struct {
+	handler http.Handler
+	limit   ClientLimit
+	burst   float64
+	exempt  []*net.IPNet
+	limited func(*http.Request)
+
+	mu        sync.Mutex
+	clients   map[string]*clientState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// ClientLimitHandler is middleware that refuses with a 429 the requests of
+// clients over their limits, and calls limited with each of them. Like
+// AccessHandler, it checks the address of the connection.
+func ClientLimitHandler(h http.Handler, limit ClientLimit, limited func(*http.Request)) (http.Handler, error) {
+	if limit.Concurrency <= 0 && limit.Rate <= 0 {
+		return h, nil
+	}
+
+	exempt, err := parseNetworks(limit.Exempt)
+	if err != nil {
+		return nil, err
+	}
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &clientLimitHandler{
+		handler: h,
+		limit:   limit,
+		burst:   burst,
+		exempt:  exempt,
+		limited: limited,
+		clients: make(map[string]*clientState),
+		now:     time.Now,
+	}, nil
+}
+
+func (c *clientLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && contains(c.exempt, ip) {
+		c.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if !c.enter(host) {
+		if c.limited != nil {
+			c.limited(r)
+		}
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	defer c.leave(host)
+
+	c.handler.ServeHTTP(w, r)
+}
+
+// enter counts a request of host, unless it's over one of its limits.
+func (c *clientLimitHandler) enter(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+
+	s, ok := c.clients[host]
+	if !ok {
+		s = &clientState{tokens: c.burst, last: now}
+		c.clients[host] = s
+	}
+
+	if c.limit.Concurrency > 0 && s.inFlight >= c.limit.Concurrency {
+		return false
+	}
+
+	if c.limit.Rate > 0 {
+		s.tokens += now.Sub(s.last).Seconds() * c.limit.Rate
+		if s.tokens > c.burst {
+			s.tokens = c.burst
+		}
+		s.last = now
+		if s.tokens < 1 {
+			return false
+		}
+		s.tokens--
+	}
+
+	s.inFlight++
+	return true
+}
+
+func (c *clientLimitHandler) leave(host string) {
+	c.mu.Lock()
+	c.clients[host].inFlight--
+	c.mu.Unlock()
+}
+
+// sweep drops the state of the clients without requests in flight whose
+// rate limit is back to a full burst, as they would start from it anyway.
+func (c *clientLimitHandler) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < clientSweepInterval {
+		return
+	}
+	c.lastSweep = now
+
+	for host, s := range c.clients {
+		if s.inFlight > 0 {
+			continue
+		}
+		if c.limit.Rate > 0 && s.tokens+now.Sub(s.last).Seconds()*c.limit.Rate < c.burst {
+			continue
+		}
+		delete(c.clients, host)
+	}
+}
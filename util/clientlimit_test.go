@@ -0,0 +1,100 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientLimitHandlerRate(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limited := 0
+	h, err := ClientLimitHandler(ok, ClientLimit{Rate: 1, Burst: 2, Exempt: []string{"10.0.0.0/8"}}, func(*http.Request) {
+		limited++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+	h.(*clientLimitHandler).now = func() time.Time { return now }
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/render/", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serve("192.168.1.1:1234"); code != expected {
+			t.Errorf("Request %d: expected %d, got %d", i, expected, code)
+		}
+	}
+	if code := serve("192.168.1.2:1234"); code != http.StatusOK {
+		t.Errorf("Expected another client not to be limited, got %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := serve("10.1.2.3:1234"); code != http.StatusOK {
+			t.Errorf("Expected an exempt client not to be limited, got %d", code)
+		}
+	}
+	if limited != 1 {
+		t.Errorf("Expected 1 limited request, got %d", limited)
+	}
+
+	now = now.Add(time.Second)
+	if code := serve("192.168.1.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected a request after a second to be allowed, got %d", code)
+	}
+}
+
+func TestClientLimitHandlerConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	h, err := ClientLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}), ClientLimit{Concurrency: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "/render/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	<-entered
+
+	req := httptest.NewRequest("GET", "/render/", nil)
+	req.RemoteAddr = "192.168.1.1:4321"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	close(release)
+	<-done
+
+	go func() { <-entered }()
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected %d once the first request is done, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestClientLimitHandlerDisabled(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if _, err := ClientLimitHandler(ok, ClientLimit{Rate: 1, Exempt: []string{"not-an-ip"}}, nil); err == nil {
+		t.Error("Expected an error for an invalid IP address")
+	}
+	if _, err := ClientLimitHandler(ok, ClientLimit{Exempt: []string{"not-an-ip"}}, nil); err != nil {
+		t.Errorf("Expected no error without limits, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/handlers"
+)
+
+// CORSConfig lets browsers call the handlers from pages served by other
+// origins. No AllowedOrigins disables CORS; "*" allows any origin. Empty
+// AllowedMethods allow GET, HEAD and POST; AllowedHeaders are allowed on top
+// of the simple headers. MaxAge is how long browsers may cache a preflight
+// response, up to 10 minutes.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowedOrigins"`
+	AllowedMethods   []string      `yaml:"allowedMethods"`
+	AllowedHeaders   []string      `yaml:"allowedHeaders"`
+	MaxAge           time.Duration `yaml:"maxAge"`
+	AllowCredentials bool          `yaml:"allowCredentials"`
+}
+
+// CORSHandler is middleware that answers CORS preflight requests and adds
+// CORS headers to the responses of h, as configured by c.
+func CORSHandler(h http.Handler, c CORSConfig) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return h
+	}
+
+	opts := []handlers.CORSOption{
+		handlers.AllowedOrigins(c.AllowedOrigins),
+		handlers.AllowedHeaders(c.AllowedHeaders),
+		handlers.MaxAge(int(c.MaxAge / time.Second)),
+	}
+	if len(c.AllowedMethods) > 0 {
+		opts = append(opts, handlers.AllowedMethods(c.AllowedMethods))
+	}
+	if c.AllowCredentials {
+		opts = append(opts, handlers.AllowCredentials())
+	}
+
+	return handlers.CORS(opts...)(h)
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := CORSHandler(ok, CORSConfig{
+		AllowedOrigins:   []string{"https://dashboards.example.com"},
+		AllowedHeaders:   []string{"Authorization"},
+		MaxAge:           5 * time.Minute,
+		AllowCredentials: true,
+	})
+
+	req := httptest.NewRequest("OPTIONS", "/render/", nil)
+	req.Header.Set("Origin", "https://dashboards.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://dashboards.example.com",
+		"Access-Control-Allow-Headers":     "Authorization",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "300",
+	}
+	for header, value := range expected {
+		if got := rr.Header().Get(header); got != value {
+			t.Errorf("Expected %s '%s', got '%s'", header, value, got)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/render/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS headers for an unknown origin, got '%s'", got)
+	}
+}
+
+func TestCORSHandlerDisabled(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/render/", nil)
+	req.Header.Set("Origin", "https://dashboards.example.com")
+	rr := httptest.NewRecorder()
+	CORSHandler(ok, CORSConfig{}).ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS headers, got '%s'", got)
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// maxJSONBodySize is the largest JSON body ParseForm accepts, the same limit
+// net/http puts on url-encoded bodies.
+const maxJSONBodySize = 10 << 20
+
+// ParseForm populates r.Form like http.Request.ParseForm does, but also
+// accepts a JSON object as the body of a POST, as graphite-web does. Each key
+// of the object becomes a form value, and arrays become repeated values.
+func ParseForm(r *http.Request) error {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.Body == nil || r.PostForm != nil || ct != "application/json" ||
+		(r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch) {
+		return r.ParseForm()
+	}
+
+	var body map[string]interface{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxJSONBodySize))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+
+	post := make(url.Values)
+	for k, v := range body {
+		if err := addJSONValue(post, k, v); err != nil {
+			return err
+		}
+	}
+
+	// ParseForm merges PostForm with the query string, without reading the
+	// body again.
+	r.PostForm = post
+	return r.ParseForm()
+}
+
+func addJSONValue(values url.Values, key string, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+	case string:
+		values.Add(key, v)
+	case json.Number:
+		values.Add(key, v.String())
+	case bool:
+		values.Add(key, strconv.FormatBool(v))
+	case []interface{}:
+		for _, e := range v {
+			if _, ok := e.([]interface{}); ok {
+				return fmt.Errorf("invalid JSON body: nested array in %s", key)
+			}
+			if err := addJSONValue(values, key, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("invalid JSON body: unsupported value for %s", key)
+	}
+
+	return nil
+}
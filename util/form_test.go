@@ -0,0 +1,54 @@
+package util
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFormJSON(t *testing.T) {
+	r := httptest.NewRequest("POST", "/render?format=json", strings.NewReader(`{"target": ["foo", "bar"], "from": -3600, "noCache": true, "until": null}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := ParseForm(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Form["target"]; !reflect.DeepEqual(got, []string{"foo", "bar"}) {
+		t.Errorf("Expected targets [foo bar], got %v", got)
+	}
+
+	for k, expected := range map[string]string{"from": "-3600", "noCache": "true", "until": "", "format": "json"} {
+		if got := r.FormValue(k); got != expected {
+			t.Errorf("Expected %s=%q, got %q", k, expected, got)
+		}
+	}
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	r := httptest.NewRequest("POST", "/render?format=json", strings.NewReader("target=foo&target=bar"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err := ParseForm(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Form["target"]; !reflect.DeepEqual(got, []string{"foo", "bar"}) {
+		t.Errorf("Expected targets [foo bar], got %v", got)
+	}
+	if got := r.FormValue("format"); got != "json" {
+		t.Errorf("Expected format json, got %q", got)
+	}
+}
+
+func TestParseFormInvalidJSON(t *testing.T) {
+	for _, body := range []string{`[1, 2]`, `{"target": {"a": 1}}`, `{"target": [["a"]]}`, `{`} {
+		r := httptest.NewRequest("POST", "/render", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+
+		if err := ParseForm(r); err == nil {
+			t.Errorf("Expected an error for body %s", body)
+		}
+	}
+}
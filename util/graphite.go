@@ -0,0 +1,93 @@
+package util
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/g2g"
+)
+
+// Graphite sends expvars to graphite every interval, as g2g.Graphite does,
+// and can send them once more when shutting down, so that the last interval
+// isn't lost.
+type Graphite struct {
+	*g2g.Graphite
+	endpoint string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	vars map[string]expvar.Var
+}
+
+// NewGraphite returns a Graphite sending to endpoint, "host:port" or
+// "network://host:port", as g2g.NewGraphite.
+func NewGraphite(endpoint string, interval, timeout time.Duration) *Graphite {
+	return &Graphite{
+		Graphite: g2g.NewGraphite(endpoint, interval, timeout),
+		endpoint: endpoint,
+		timeout:  timeout,
+		vars:     make(map[string]expvar.Var),
+	}
+}
+
+// Register registers an expvar under the given name.
+func (g *Graphite) Register(name string, v expvar.Var) {
+	g.mu.Lock()
+	g.vars[name] = v
+	g.mu.Unlock()
+
+	g.Graphite.Register(name, v)
+}
+
+// Flush stops the periodic sends, and sends the current values of the
+// registered expvars.
+func (g *Graphite) Flush() error {
+	g.Graphite.Shutdown()
+
+	network, address := "tcp", g.endpoint
+	if i := strings.Index(address, "://"); i != -1 {
+		network, address = address[:i], address[i+3:]
+	}
+	conn, err := net.DialTimeout(network, address, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(g.timeout))
+
+	g.mu.Lock()
+	names := make([]string, 0, len(g.vars))
+	for name := range g.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(conn)
+	now := time.Now().Unix()
+	for _, name := range names {
+		fmt.Fprintf(w, "%s %s %d\n", name, graphiteValue(g.vars[name].String()), now)
+	}
+	g.mu.Unlock()
+
+	return w.Flush()
+}
+
+// graphiteValue rounds floats to 2 decimals, as g2g does.
+func graphiteValue(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"bufio"
+	"expvar"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphiteFlush(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			lines <- nil
+			return
+		}
+		defer conn.Close()
+
+		var got []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		lines <- got
+	}()
+
+	g := NewGraphite(l.Addr().String(), time.Hour, time.Second)
+	requests := new(expvar.Int)
+	requests.Add(42)
+	ratio := new(expvar.Float)
+	ratio.Set(0.5)
+	g.Register("carbon.api.requests", requests)
+	g.Register("carbon.api.ratio", ratio)
+
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", got)
+	}
+	for i, want := range []string{"carbon.api.ratio 0.50 ", "carbon.api.requests 42 "} {
+		if !strings.HasPrefix(got[i], want) {
+			t.Errorf("Expected line %d to start with %q, got %q", i, want, got[i])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// jsonError is the body of an error response to a client that asked for
+// JSON.
+type jsonError struct {
+	Code     int      `json:"code"`
+	Message  string   `json:"message"`
+	UUID     string   `json:"carbonzipper_uuid,omitempty"`
+	Backends []string `json:"backends,omitempty"`
+}
+
+// HTTPError replies to r with the error message and HTTP code, like
+// http.Error. Clients that asked for JSON, with a JSON format parameter or
+// Accept header, get a JSON object with the code, the message, the UUID of
+// the request and the addresses of the failing backends, if any, so that
+// e.g. Grafana can show what went wrong.
+func HTTPError(w http.ResponseWriter, r *http.Request, error string, code int, backends ...string) {
+	if !wantsJSON(r) {
+		http.Error(w, error, code)
+		return
+	}
+
+	b, err := json.Marshal(jsonError{
+		Code:     code,
+		Message:  error,
+		UUID:     GetUUID(r.Context()),
+		Backends: backends,
+	})
+	if err != nil {
+		http.Error(w, error, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// wantsJSON reports whether the client of r asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	switch r.FormValue("format") {
+	case "json", "treejson", "completer", "ndjson", "compactjson":
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
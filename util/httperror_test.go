@@ -0,0 +1,62 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/render/?format=json", nil)
+	req = req.WithContext(WithUUID(req.Context()))
+	rr := httptest.NewRecorder()
+	HTTPError(rr, req, "error fetching the data", http.StatusInternalServerError, "store1:8080", "store2:8080")
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected code %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON content type, got '%s'", ct)
+	}
+
+	var got jsonError
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := jsonError{
+		Code:     http.StatusInternalServerError,
+		Message:  "error fetching the data",
+		UUID:     GetUUID(req.Context()),
+		Backends: []string{"store1:8080", "store2:8080"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestHTTPErrorAccept(t *testing.T) {
+	req := httptest.NewRequest("GET", "/metrics/find/?query=a.*", nil)
+	req.Header.Set("Accept", "application/json, text/plain")
+	rr := httptest.NewRecorder()
+	HTTPError(rr, req, "missing parameter `query`", http.StatusBadRequest)
+
+	if !strings.HasPrefix(rr.Body.String(), `{"code":400,"message":"missing parameter `+"`query`"+`"}`) {
+		t.Errorf("Expected a JSON error, got '%s'", rr.Body.String())
+	}
+}
+
+func TestHTTPErrorPlain(t *testing.T) {
+	req := httptest.NewRequest("GET", "/render/?format=png", nil)
+	rr := httptest.NewRecorder()
+	HTTPError(rr, req, "invalid jsonp callback", http.StatusBadRequest, "store1:8080")
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if body := rr.Body.String(); body != "invalid jsonp callback\n" {
+		t.Errorf("Expected a plain text error, got '%s'", body)
+	}
+}
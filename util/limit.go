@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+	"io"
+)
+
+// ErrResponseTooLarge is returned by readers created by LimitReader when the
+// underlying reader has more bytes than allowed.
+type ErrResponseTooLarge int64
+
+func (e ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response larger than %d bytes", int64(e))
+}
+
+// LimitReader returns a reader that reads from r, and fails with
+// ErrResponseTooLarge if r has more than n bytes. Unlike io.LimitReader, a
+// reader that is too long is an error rather than being truncated.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{r: r, left: n, limit: n}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	left  int64
+	limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Reading one byte past the limit tells a reader of exactly n bytes
+	// from a longer one.
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ErrResponseTooLarge(l.limit)
+	}
+
+	return n, err
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLimitReader(t *testing.T) {
+	got, err := ioutil.ReadAll(LimitReader(strings.NewReader("12345"), 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "12345" {
+		t.Errorf("Expected %q, got %q", "12345", got)
+	}
+
+	_, err = ioutil.ReadAll(LimitReader(strings.NewReader("123456"), 5))
+	if err != ErrResponseTooLarge(5) {
+		t.Errorf("Expected %v, got %v", ErrResponseTooLarge(5), err)
+	}
+}
@@ -0,0 +1,193 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevels changes the levels of the zapwriter loggers at runtime, e.g. to
+// enable debug logs of the find logger during an incident without a restart.
+// Levels are changed by applying the logger configuration again, so loggers
+// must be looked up with zapwriter.Logger after a change to see it.
+type LogLevels struct {
+	mu         sync.Mutex
+	configured []zapwriter.Config
+	current    []zapwriter.Config
+	// beforeDebug is the configuration ToggleDebug restores, nil unless
+	// all the loggers were switched to debug.
+	beforeDebug []zapwriter.Config
+}
+
+// NewLogLevels returns the log levels of the logger configuration config.
+func NewLogLevels(config []zapwriter.Config) *LogLevels {
+	l := &LogLevels{configured: config}
+	l.current = cloneConfigs(config)
+
+	return l
+}
+
+func cloneConfigs(config []zapwriter.Config) []zapwriter.Config {
+	return append([]zapwriter.Config(nil), config...)
+}
+
+// Levels returns the level of each configured logger, "" being the default
+// logger, which the loggers without a configuration of their own log to.
+func (l *LogLevels) Levels() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	levels := make(map[string]string, len(l.current))
+	for _, c := range l.current {
+		levels[c.Logger] = c.Level
+	}
+
+	return levels
+}
+
+// Set sets the level of the logger name. A logger without a configuration of
+// its own gets a copy of the configuration of the default logger, with level.
+func (l *LogLevels) Set(name, level string) error {
+	var zl zapcore.Level
+	if err := zl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config := cloneConfigs(l.current)
+	found := false
+	for i := range config {
+		if config[i].Logger == name {
+			config[i].Level = level
+			found = true
+		}
+	}
+	if !found {
+		for _, c := range l.current {
+			if c.Logger == "" {
+				c.Logger = name
+				c.Level = level
+				config = append(config, c)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no configuration for logger %q", name)
+	}
+
+	return l.apply(config)
+}
+
+// Reset restores the configured levels.
+func (l *LogLevels) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.beforeDebug = nil
+	return l.apply(cloneConfigs(l.configured))
+}
+
+// ToggleDebug switches all the loggers to the debug level, or back to the
+// levels they had before.
+func (l *LogLevels) ToggleDebug() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.beforeDebug != nil {
+		if err := l.apply(l.beforeDebug); err != nil {
+			return err
+		}
+		l.beforeDebug = nil
+		return nil
+	}
+
+	before := l.current
+	config := cloneConfigs(l.current)
+	for i := range config {
+		config[i].Level = "debug"
+	}
+	if err := l.apply(config); err != nil {
+		return err
+	}
+	l.beforeDebug = before
+
+	return nil
+}
+
+// apply must be called with mu held.
+func (l *LogLevels) apply(config []zapwriter.Config) error {
+	if err := zapwriter.ApplyConfig(config); err != nil {
+		return err
+	}
+	l.current = config
+
+	return nil
+}
+
+// ToggleDebugOn calls ToggleDebug whenever the process receives sig, e.g.
+// SIGUSR2.
+func (l *LogLevels) ToggleDebugOn(sig os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+
+	go func() {
+		for range c {
+			if err := l.ToggleDebug(); err != nil {
+				zapwriter.Logger("main").Error("Failed to toggle the debug log level",
+					zap.Error(err),
+				)
+				continue
+			}
+			zapwriter.Logger("main").Info("Toggled the debug log level",
+				zap.Any("levels", l.Levels()),
+			)
+		}
+	}()
+}
+
+// ServeHTTP lists the levels of the loggers as JSON. A POST request sets the
+// level of the logger named by the logger parameter, "" being the default
+// logger, to the level parameter, or restores the configured levels with
+// reset=1.
+func (l *LogLevels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var err error
+		if r.FormValue("reset") == "1" {
+			err = l.Reset()
+		} else {
+			err = l.Set(r.FormValue("logger"), r.FormValue("level"))
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		zapwriter.Logger("main").Info("Changed the log levels",
+			zap.String("logger", r.FormValue("logger")),
+			zap.String("level", r.FormValue("level")),
+			zap.String("reset", r.FormValue("reset")),
+		)
+	} else if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := json.Marshal(l.Levels())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lomik/zapwriter"
+)
+
+func TestLogLevels(t *testing.T) {
+	c := zapwriter.NewConfig()
+	c.File = "none"
+	l := NewLogLevels([]zapwriter.Config{c})
+
+	if err := l.Set("find", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Levels(), map[string]string{"": "info", "find": "debug"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+	if err := l.Set("find", "loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+
+	if err := l.ToggleDebug(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Levels(), map[string]string{"": "debug", "find": "debug"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+	if err := l.ToggleDebug(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Levels(), map[string]string{"": "info", "find": "debug"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+
+	if err := l.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Levels(), map[string]string{"": "info"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+}
+
+func TestLogLevelsHandler(t *testing.T) {
+	c := zapwriter.NewConfig()
+	c.File = "none"
+	l := NewLogLevels([]zapwriter.Config{c})
+
+	form := url.Values{"logger": {"find"}, "level": {"warn"}}
+	req := httptest.NewRequest("POST", "/debug/log-level", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	if got, want := rr.Body.String(), `{"":"info","find":"warn"}`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	req = httptest.NewRequest("POST", "/debug/log-level?level=loud", nil)
+	rr = httptest.NewRecorder()
+	l.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rr.Code)
+	}
+}
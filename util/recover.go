@@ -0,0 +1,53 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type recoverHandler struct {
+	handler http.Handler
+	logger  *zap.Logger
+	onPanic func(*http.Request)
+}
+
+// RecoverHandler is middleware that recovers from the panics of h, so that
+// one bad request fails alone: its client gets a 500, and the panic is
+// logged with its stack and the Carbon UUID of the request, and reported to
+// onPanic, if not nil.
+func RecoverHandler(h http.Handler, logger *zap.Logger, onPanic func(*http.Request)) http.Handler {
+	return recoverHandler{
+		handler: h,
+		logger:  logger,
+		onPanic: onPanic,
+	}
+}
+
+func (h recoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		// net/http aborts the response quietly on ErrAbortHandler.
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+
+		h.logger.Error("Recovered from panic serving request",
+			zap.String("carbonapi_uuid", GetUUID(r.Context())),
+			zap.String("url", r.URL.RequestURI()),
+			zap.String("reason", fmt.Sprint(p)),
+			zap.Stack("stacktrace"),
+		)
+		if h.onPanic != nil {
+			h.onPanic(r)
+		}
+
+		HTTPError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}()
+
+	h.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	var logs bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&logs), zap.ErrorLevel)
+	panics := 0
+	h := UUIDHandler(RecoverHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/render/" {
+			panic("bad request")
+		}
+	}), zap.New(core), func(*http.Request) { panics++ }))
+
+	req := httptest.NewRequest("GET", "/render/?format=json", nil)
+	req.Header.Set(ctxHeaderUUID, "panicking-request")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected code %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"carbonzipper_uuid":"panicking-request"`) {
+		t.Errorf("Expected the UUID in the response, got %s", rr.Body.String())
+	}
+	if panics != 1 {
+		t.Errorf("Expected 1 panic, got %d", panics)
+	}
+
+	for _, want := range []string{`"carbonapi_uuid":"panicking-request"`, `"reason":"bad request"`, `"stacktrace":"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected %s in the log, got %s", want, logs.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/find/", nil))
+	if rr.Code != http.StatusOK || panics != 1 {
+		t.Errorf("Expected other requests to be served, got code %d and %d panics", rr.Code, panics)
+	}
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Serve serves s until SIGINT or SIGTERM. It then stops accepting
+// connections, waits up to drainTimeout for the requests in flight, closing
+// those left after it, and runs the hooks of onShutdown, e.g. to flush
+// statistics, before returning. A second signal exits right away.
+func Serve(s *http.Server, drainTimeout time.Duration, logger *zap.Logger, onShutdown ...func()) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("Serving",
+		zap.String("address", l.Addr().String()),
+		zap.Int("pid", os.Getpid()),
+	)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	return serveUntil(s, l, stop, drainTimeout, logger, onShutdown...)
+}
+
+func serveUntil(s *http.Server, l net.Listener, stop chan os.Signal, drainTimeout time.Duration, logger *zap.Logger, onShutdown ...func()) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		// A second signal gets the default behaviour of exiting.
+		signal.Stop(stop)
+		logger.Info("Shutting down",
+			zap.String("signal", sig.String()),
+			zap.Duration("drain_timeout", drainTimeout),
+		)
+	}
+
+	t0 := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Warn("Requests still in flight after the drain timeout, closing them",
+			zap.Duration("drain_timeout", drainTimeout),
+		)
+		s.Close()
+	}
+	logger.Info("Drained requests",
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+
+	for _, hook := range onShutdown {
+		hook()
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServeDrains(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+
+	stop := make(chan os.Signal, 1)
+	hooked := false
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil(s, l, stop, time.Minute, zap.NewNop(), func() { hooked = true })
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/render")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("Expected new connections to be refused while draining")
+	}
+	close(release)
+
+	if got := <-body; got != "done" {
+		t.Errorf("Expected the request in flight to complete, got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if !hooked {
+		t.Error("Expected the shutdown hooks to run")
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil(s, l, stop, 10*time.Millisecond, zap.NewNop())
+	}()
+	go http.Get("http://" + l.Addr().String() + "/render")
+
+	<-started
+	stop <- syscall.SIGINT
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request in flight to be closed after the drain timeout")
+	}
+}
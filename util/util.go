@@ -1,9 +1,13 @@
-// Package util provides UUIDs for CarbonAPI and CarbonZipper HTTP requests.
+// Package util provides helpers for CarbonAPI and CarbonZipper HTTP requests:
+// UUIDs, timeout budgets, form parsing, pooled buffers, response size limits,
+// network access lists, runtime log levels and error responses.
 package util
 
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/satori/go.uuid"
 )
@@ -12,10 +16,26 @@ type key int
 
 const (
 	ctxHeaderUUID = "X-CTX-CarbonAPI-UUID"
-
-	uuidKey key = 0
+	// ctxHeaderTimeout is the time left to answer a request, in
+	// milliseconds.
+	ctxHeaderTimeout = "X-CTX-CarbonAPI-Timeout"
+	// ctxHeaderStrict asks for all-or-nothing results.
+	ctxHeaderStrict = "X-CTX-CarbonAPI-Strict"
+
+	uuidKey   key = 0
+	strictKey key = 1
 )
 
+// StatusClientClosedRequest is the nginx status code of the requests whose
+// client went away before they were answered.
+const StatusClientClosedRequest = 499
+
+// ClientGone reports whether the client of r went away, e.g. after a
+// dashboard was closed, which cancels the context of r.
+func ClientGone(r *http.Request) bool {
+	return r.Context().Err() == context.Canceled
+}
+
 // GetUUID gets the Carbon UUID of a request.
 func GetUUID(ctx context.Context) string {
 	if id := ctx.Value(uuidKey); id != nil {
@@ -25,14 +45,52 @@ func GetUUID(ctx context.Context) string {
 	return ""
 }
 
-// MarshalCtx ensures that outgoing HTTP requests have a Carbon UUID.
+// MarshalCtx ensures that outgoing HTTP requests have a Carbon UUID, and
+// tells the server how long it has to answer if ctx has a deadline, and
+// whether the request is strict.
 func MarshalCtx(ctx context.Context, request *http.Request) *http.Request {
 	ctx = WithUUID(ctx)
 	request.Header.Add(ctxHeaderUUID, GetUUID(ctx))
+	if Strict(ctx) {
+		request.Header.Set(ctxHeaderStrict, "1")
+	}
+
+	if left, ok := TimeLeft(ctx); ok {
+		ms := int64(left / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+		request.Header.Set(ctxHeaderTimeout, strconv.FormatInt(ms, 10))
+	}
 
 	return request
 }
 
+// TimeLeft returns the time left before the deadline of ctx, if it has one.
+func TimeLeft(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	return time.Until(deadline), true
+}
+
+// Timeout returns the time budget to answer r: timeout, or less if the
+// client said it will give up sooner.
+func Timeout(r *http.Request, timeout time.Duration) time.Duration {
+	ms, err := strconv.ParseInt(r.Header.Get(ctxHeaderTimeout), 10, 64)
+	if err != nil || ms <= 0 {
+		return timeout
+	}
+
+	if left := time.Duration(ms) * time.Millisecond; left < timeout {
+		return left
+	}
+
+	return timeout
+}
+
 // WithUUID ensures that a context has a Carbon UUID.
 func WithUUID(ctx context.Context) context.Context {
 	if id := GetUUID(ctx); id != "" {
@@ -44,11 +102,25 @@ func WithUUID(ctx context.Context) context.Context {
 	return context.WithValue(ctx, uuidKey, id)
 }
 
+// WithStrict marks ctx as that of a strict request, which fails if any of
+// the backends it is sent to fails, instead of answering with the data of
+// the others.
+func WithStrict(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strictKey, true)
+}
+
+// Strict reports whether ctx is that of a strict request.
+func Strict(ctx context.Context) bool {
+	strict, _ := ctx.Value(strictKey).(bool)
+	return strict
+}
+
 type uuidHandler struct {
 	handler http.Handler
 }
 
-// UUIDHandler is middleware that adds a Carbon UUID to all HTTP requests.
+// UUIDHandler is middleware that adds a Carbon UUID to all HTTP requests,
+// and marks those a strict client sent as strict.
 func UUIDHandler(h http.Handler) http.Handler {
 	return uuidHandler{handler: h}
 }
@@ -60,6 +132,9 @@ func (h uuidHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := context.WithValue(r.Context(), uuidKey, id)
+	if r.Header.Get(ctxHeaderStrict) == "1" {
+		ctx = WithStrict(ctx)
+	}
 
 	h.handler.ServeHTTP(w, r.WithContext(ctx))
 }
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMarshalCtxTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", "http://localhost/render/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = MarshalCtx(ctx, req)
+
+	ms, err := strconv.Atoi(req.Header.Get(ctxHeaderTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms <= 9000 || ms > 10000 {
+		t.Errorf("Expected a timeout of about 10000ms, got %d", ms)
+	}
+}
+
+func TestMarshalCtxNoDeadline(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost/render/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = MarshalCtx(context.Background(), req)
+
+	if got := req.Header.Get(ctxHeaderTimeout); got != "" {
+		t.Errorf("Expected no timeout header, got %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 10 * time.Second},
+		{"junk", 10 * time.Second},
+		{"0", 10 * time.Second},
+		{"2500", 2500 * time.Millisecond},
+		{"60000", 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "http://localhost/render/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set(ctxHeaderTimeout, tt.header)
+		}
+
+		if got := Timeout(req, 10*time.Second); got != tt.expected {
+			t.Errorf("header %q: expected %s, got %s", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestClientGone(t *testing.T) {
+	req := httptest.NewRequest("GET", "/render/", nil)
+	if ClientGone(req) {
+		t.Error("Expected the client to be there")
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	if !ClientGone(req.WithContext(ctx)) {
+		t.Error("Expected the client to be gone")
+	}
+
+	ctx, cancel = context.WithTimeout(req.Context(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if ClientGone(req.WithContext(ctx)) {
+		t.Error("Expected a timeout not to be a client gone")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost/render/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = MarshalCtx(WithStrict(context.Background()), req)
+
+	var strict bool
+	UUIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strict = Strict(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if !strict {
+		t.Error("Expected the request to be strict")
+	}
+
+	req, err = http.NewRequest("GET", "http://localhost/render/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = MarshalCtx(context.Background(), req)
+	if got := req.Header.Get(ctxHeaderStrict); got != "" {
+		t.Errorf("Expected no strict header, got %q", got)
+	}
+}
@@ -57,13 +57,13 @@ func BenchmarkRender(b *testing.B) {
 	zipper := &Zipper{
 		storageClient: client,
 		backends:      backends,
-		pathCache:     pathcache.NewPathCache(60),
+		pathCache:     pathcache.NewPathCache(60, 0, 0),
 		logger:        zap.New(nil),
 	}
 
 	ctx := context.Background()
 	for i := 0; i < b.N; i++ {
-		_, _, err := zipper.Render(ctx, zipper.logger, "", 0, 0)
+		_, _, err := zipper.Render(ctx, zipper.logger, "", 0, 0, "")
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -111,7 +111,7 @@ func BenchmarkRenderStorm(b *testing.B) {
 	zipper := &Zipper{
 		storageClient: client,
 		backends:      backends,
-		pathCache:     pathcache.NewPathCache(60),
+		pathCache:     pathcache.NewPathCache(60, 0, 0),
 		logger:        zap.New(nil),
 		limiter:       limiter.NewServerLimiter(backends, 1),
 	}
@@ -123,7 +123,7 @@ func BenchmarkRenderStorm(b *testing.B) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, _, err := zipper.Render(ctx, zipper.logger, "", 0, 0)
+				_, _, err := zipper.Render(ctx, zipper.logger, "", 0, 0, "")
 				if err != nil {
 					b.Fatal(err)
 				}
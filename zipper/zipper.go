@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -16,6 +16,8 @@ import (
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/limiter"
 	"github.com/bookingcom/carbonapi/pathcache"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/util"
 	pb3 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	"github.com/pkg/errors"
@@ -25,6 +27,7 @@ import (
 // Zipper provides interface to Zipper-related functions
 type Zipper struct {
 	storageClient *http.Client
+	pools         *bnet.Pools
 	// Limiter limits our concurrency to a particular server
 	limiter     limiter.ServerLimiter
 	probeTicker *time.Ticker
@@ -42,6 +45,7 @@ type Zipper struct {
 	concurrencyLimitPerServer int
 	maxIdleConnsPerHost       int
 	corruptionThreshold       float64
+	maxResponseSize           int64
 
 	sendStats func(*Stats)
 
@@ -56,6 +60,11 @@ func (z Zipper) MaxLimiterUse() float64 {
 	return z.limiter.MaxLimiterUse()
 }
 
+// Pools returns the connection pool statistics of the storage client.
+func (z Zipper) Pools() *bnet.Pools {
+	return z.pools
+}
+
 // Stats provides zipper-related statistics
 type Stats struct {
 	Timeouts     int64
@@ -63,6 +72,8 @@ type Stats struct {
 	RenderErrors int64
 	InfoErrors   int64
 
+	ResponsesTooLarge int64
+
 	MemoryUsage int64
 
 	CacheMisses int64
@@ -86,14 +97,16 @@ func NewZipper(sender func(*Stats), config cfg.Zipper, logger *zap.Logger) *Zipp
 		pathCache: config.PathCache,
 
 		storageClient:             &http.Client{},
+		pools:                     bnet.NewPools(),
 		backends:                  config.Common.Backends,
 		concurrencyLimitPerServer: config.ConcurrencyLimitPerServer,
-		maxIdleConnsPerHost:       config.MaxIdleConnsPerHost,
+		maxIdleConnsPerHost:       config.Transport.MaxIdleConnsPerHost,
 		keepAliveInterval:         config.KeepAliveInterval,
 		timeoutAfterAllStarted:    config.Timeouts.AfterStarted,
 		timeout:                   config.Timeouts.Global,
 		timeoutConnect:            config.Timeouts.Connect,
 		corruptionThreshold:       config.CorruptionThreshold,
+		maxResponseSize:           config.MaxResponseSize,
 
 		logger: logger,
 	}
@@ -108,14 +121,23 @@ func NewZipper(sender func(*Stats), config cfg.Zipper, logger *zap.Logger) *Zipp
 	}
 
 	// configure the storage client
-	z.storageClient.Transport = &http.Transport{
-		MaxIdleConnsPerHost: z.maxIdleConnsPerHost,
-		DialContext: (&net.Dialer{
-			Timeout:   z.timeoutConnect,
-			KeepAlive: z.keepAliveInterval,
-			DualStack: true,
-		}).DialContext,
+	transport, err := bnet.NewTransport(bnet.TransportConfig{
+		HTTP2:                 config.Transport.HTTP2,
+		ConnectTimeout:        z.timeoutConnect,
+		KeepAliveInterval:     z.keepAliveInterval,
+		MaxIdleConnsPerHost:   z.maxIdleConnsPerHost,
+		TLSHandshakeTimeout:   config.Transport.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.Transport.ResponseHeaderTimeout,
+		IdleConnTimeout:       config.Transport.IdleConnTimeout,
+		DNSRefreshInterval:    config.Transport.DNSRefreshInterval,
+		Pools:                 z.pools,
+	})
+	if err != nil {
+		logger.Fatal("failed to create the storage transport",
+			zap.Error(err),
+		)
 	}
+	z.storageClient.Transport = transport
 
 	go z.probeTlds()
 
@@ -408,6 +430,25 @@ func (z *Zipper) doProbe() {
 	}
 }
 
+// refreshPath finds path again, to refresh its servers in the path cache
+// before they expire, so that the requests for a popular path don't all miss
+// the cache and go to all the servers at once when it does.
+func (z *Zipper) refreshPath(path string) {
+	logger := z.logger.With(zap.String("function", "refreshPath"))
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), z.timeout)
+	defer cancel()
+
+	_, stats, err := z.Find(ctx, logger, path)
+	z.sendStats(stats)
+	if err != nil {
+		logger.Warn("Path refresh failed",
+			zap.String("path", path),
+			zap.String("carbonzipper_uuid", util.GetUUID(ctx)),
+			zap.Error(err),
+		)
+	}
+}
+
 func (z *Zipper) probeTlds() {
 	for {
 		select {
@@ -451,8 +492,11 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 
 	logger = logger.With(zap.String("query", server+"/"+uri))
 
+	req, done := z.pools.Trace(req.WithContext(ctx))
+	defer done()
+
 	z.limiter.Enter(server)
-	resp, err := z.storageClient.Do(req.WithContext(ctx))
+	resp, err := z.storageClient.Do(req)
 	z.limiter.Leave(server)
 
 	if err != nil {
@@ -485,7 +529,12 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 		return
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var r io.Reader = resp.Body
+	if z.maxResponseSize > 0 {
+		r = util.LimitReader(r, z.maxResponseSize)
+	}
+
+	body, err := util.ReadAll(r)
 	if err != nil {
 		if ce := logger.Check(zap.DebugLevel, "error reading body"); ce != nil {
 			ce.Write(zap.Error(err))
@@ -505,6 +554,13 @@ func (z *Zipper) multiGet(ctx context.Context, logger *zap.Logger, servers []str
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
+	// A request that ran out of time before the servers were queried, e.g.
+	// the renders after a slow find, is not a timeout of the servers.
+	if left, ok := util.TimeLeft(ctx); ok && left <= 0 {
+		logger.Warn("no time left to query servers")
+		return nil
+	}
+
 	if ce := logger.Check(zap.DebugLevel, "querying servers"); ce != nil {
 		ce.Write(
 			zap.Strings("servers", servers),
@@ -532,7 +588,8 @@ GATHER:
 		}
 	}
 
-	if ctx.Err() != nil {
+	// A client that went away canceled the requests, which is no timeout.
+	if ctx.Err() == context.DeadlineExceeded {
 		stats.Timeouts++
 	}
 
@@ -544,6 +601,10 @@ GATHER:
 		case nil:
 			respOK = append(respOK, r)
 
+		case util.ErrResponseTooLarge:
+			stats.ResponsesTooLarge++
+			errs[t.Error()] = append(errs[t.Error()], r.server)
+
 		case *net.OpError:
 			msg := netOpErrorMessage(t)
 			errs[msg] = append(errs[msg], r.server)
@@ -573,9 +634,22 @@ GATHER:
 		logger.With(es...).Warn("Errors in responses")
 	}
 
+	// A strict request fails unless all the servers answered.
+	if util.Strict(ctx) && len(respOK) < len(servers) {
+		return nil
+	}
+
 	return respOK
 }
 
+// errNoResponsesFrom is the error of a request none of servers answered.
+func errNoResponsesFrom(servers []string) error {
+	return types.ErrBackends{
+		Backends: servers,
+		Err:      errors.New(errNoResponses),
+	}
+}
+
 func netOpErrorMessage(err *net.OpError) string {
 	if err.Timeout() {
 		return "timeout"
@@ -602,7 +676,26 @@ func netOpErrorMessage(err *net.OpError) string {
 	}
 }
 
-func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string, from, until int32) (*pb3.MultiFetchResponse, *Stats, error) {
+// Backends returns the servers a render request for target is sent to: the
+// ones known to have it, or all of them if it's unknown.
+func (z *Zipper) Backends(target string) []string {
+	if servers, ok := z.pathCache.Get(target); ok && len(servers) > 0 {
+		return servers
+	}
+
+	return z.backends
+}
+
+// HasPath reports whether the path cache knows the servers of target, which
+// a render request for it is then sent to without a find.
+func (z *Zipper) HasPath(target string) bool {
+	servers, ok := z.pathCache.Get(target)
+	return ok && len(servers) > 0
+}
+
+// Render fetches the series of target from the backends. consolidateBy, if
+// set, is the consolidation function the backends downsample them with.
+func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string, from, until int32, consolidateBy string) (*pb3.MultiFetchResponse, *Stats, error) {
 	stats := &Stats{}
 
 	rewrite, _ := url.Parse("http://127.0.0.1/render/")
@@ -613,6 +706,9 @@ func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string,
 		"from":   []string{strconv.Itoa(int(from))},
 		"until":  []string{strconv.Itoa(int(until))},
 	}
+	if consolidateBy != "" {
+		v.Set("consolidateBy", consolidateBy)
+	}
 	rewrite.RawQuery = v.Encode()
 
 	var serverList []string
@@ -622,12 +718,16 @@ func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string,
 	rewrite.RawQuery = v.Encode()
 
 	// lookup the server list for this metric, or use all the servers if it's unknown
-	if serverList, ok = z.pathCache.Get(target); !ok || serverList == nil || len(serverList) == 0 {
+	var refresh bool
+	if serverList, ok, refresh = z.pathCache.GetRefresh(target); !ok || serverList == nil || len(serverList) == 0 {
 		stats.CacheMisses++
 		serverList = z.backends
 	} else {
 		stats.CacheHits++
 	}
+	if refresh {
+		go z.refreshPath(target)
+	}
 
 	responses = z.multiGet(ctx, logger, serverList, rewrite.RequestURI(), stats)
 
@@ -636,7 +736,7 @@ func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string,
 	}
 
 	if len(responses) == 0 {
-		return nil, stats, errors.New(errNoResponses)
+		return nil, stats, errNoResponsesFrom(serverList)
 	}
 
 	servers, metrics := z.mergeResponses(responses, stats)
@@ -675,7 +775,7 @@ func (z *Zipper) Info(ctx context.Context, logger *zap.Logger, target string) (m
 
 	if len(responses) == 0 {
 		stats.InfoErrors++
-		return nil, stats, errors.New(errNoResponses)
+		return nil, stats, errNoResponsesFrom(serverList)
 	}
 
 	infos := z.infoUnpackPB(responses, stats)
@@ -711,18 +811,21 @@ func (z *Zipper) Find(ctx context.Context, logger *zap.Logger, query string) ([]
 		// lookup tld in our map of where they live to reduce the set of
 		// servers we bug with our find
 		var backends []string
-		var ok bool
-		if backends, ok = z.pathCache.Get(tld); !ok || backends == nil || len(backends) == 0 {
+		var ok, refresh bool
+		if backends, ok, refresh = z.pathCache.GetRefresh(tld); !ok || backends == nil || len(backends) == 0 {
 			stats.CacheMisses++
 			backends = z.backends
 		} else {
 			stats.CacheHits++
 		}
+		if refresh {
+			go z.refreshPath(tld)
+		}
 
 		responses := z.multiGet(ctx, logger, backends, rewrite.RequestURI(), stats)
 
 		if len(responses) == 0 {
-			return nil, stats, errors.New(errNoResponses)
+			return nil, stats, errNoResponsesFrom(backends)
 		}
 
 		m, paths := z.findUnpackPB(responses, stats)
@@ -1,8 +1,14 @@
 package zipper
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/util"
 
 	pb3 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	"go.uber.org/zap"
@@ -355,3 +361,48 @@ func getTestResponse(z *Zipper, stats *Stats, input []pb3.MultiFetchResponse) (*
 
 	return got, nil
 }
+
+func TestMultiGetNoTimeLeft(t *testing.T) {
+	z := &Zipper{
+		logger: zap.New(nil),
+	}
+	stats := &Stats{}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	responses := z.multiGet(ctx, z.logger, []string{"http://127.0.0.1:1"}, "/render/", stats)
+	if len(responses) != 0 {
+		t.Errorf("Expected no responses, got %d", len(responses))
+	}
+	if stats.Timeouts != 0 {
+		t.Errorf("Expected no timeouts, got %d", stats.Timeouts)
+	}
+}
+
+func TestMultiGetStrict(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	z := &Zipper{
+		storageClient: &http.Client{},
+		logger:        zap.New(nil),
+	}
+	servers := []string{ok.URL, failing.URL}
+
+	responses := z.multiGet(context.Background(), z.logger, servers, "/render/", &Stats{})
+	if len(responses) != 1 {
+		t.Errorf("Expected 1 response, got %d", len(responses))
+	}
+
+	responses = z.multiGet(util.WithStrict(context.Background()), z.logger, servers, "/render/", &Stats{})
+	if len(responses) != 0 {
+		t.Errorf("Expected no responses to a strict request, got %d", len(responses))
+	}
+}